@@ -21,11 +21,14 @@ import (
 	"github.com/streamsets/datacollector-edge/api/fieldtype"
 	"math/big"
 	"reflect"
+	"sort"
 )
 
 type Field struct {
 	Type  string
 	Value interface{}
+	// keys of a LIST_MAP field in insertion order
+	keys []string
 }
 
 func (f *Field) Clone() *Field {
@@ -43,7 +46,7 @@ func (f *Field) Clone() *Field {
 		for k, v := range mapField {
 			returnMap[k] = v.Clone()
 		}
-		return &Field{Type: f.Type, Value: returnMap}
+		return &Field{Type: f.Type, Value: returnMap, keys: f.GetListMapKeys()}
 	case fieldtype.LIST:
 		listField := f.Value.([](*Field))
 		returnList := make([](*Field), len(listField))
@@ -99,6 +102,8 @@ func CreateField(value interface{}) (*Field, error) {
 		return CreateMapField(value.(map[string]interface{}))
 	case []interface{}:
 		return CreateListField(value.([]interface{}))
+	case FileRef:
+		return CreateFileRefField(value.(FileRef))
 	default:
 		err = errors.New(fmt.Sprintf("Unsupported Field Type %s", reflect.TypeOf(value)))
 	}
@@ -165,6 +170,10 @@ func CreateStringField(value string) (*Field, error) {
 	return &Field{Type: fieldtype.STRING, Value: value}, nil
 }
 
+func CreateFileRefField(value FileRef) (*Field, error) {
+	return &Field{Type: fieldtype.FILE_REF, Value: value}, nil
+}
+
 func CreateStringListField(listStringValue []string) (*Field, error) {
 	listFieldValue := []*Field{}
 	for _, value := range listStringValue {
@@ -211,3 +220,43 @@ func CreateListFieldWithListOfFields(listFields []*Field) *Field {
 func CreateMapFieldWithMapOfFields(mapFields map[string]*Field) *Field {
 	return &Field{Type: fieldtype.MAP, Value: mapFields}
 }
+
+// CreateListMapFieldWithMapOfFields returns a LIST_MAP field holding the fields, keys gives the order of its
+// entries
+func CreateListMapFieldWithMapOfFields(keys []string, mapFields map[string]*Field) *Field {
+	return &Field{Type: fieldtype.LIST_MAP, Value: mapFields, keys: keys}
+}
+
+// GetListMapKeys returns the keys of a LIST_MAP field in insertion order, keys of entries added without an order
+// follow sorted
+func (f *Field) GetListMapKeys() []string {
+	mapFields, _ := f.Value.(map[string]*Field)
+	keys := make([]string, 0, len(mapFields))
+	orderedKeys := make(map[string]bool, len(mapFields))
+	for _, key := range f.keys {
+		if _, ok := mapFields[key]; ok && !orderedKeys[key] {
+			orderedKeys[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) < len(mapFields) {
+		unorderedKeys := make([]string, 0, len(mapFields)-len(keys))
+		for key := range mapFields {
+			if !orderedKeys[key] {
+				unorderedKeys = append(unorderedKeys, key)
+			}
+		}
+		sort.Strings(unorderedKeys)
+		keys = append(keys, unorderedKeys...)
+	}
+	return keys
+}
+
+// SetListMapField sets the field of the key of a LIST_MAP field, a new key is added after the existing ones
+func (f *Field) SetListMapField(key string, field *Field) {
+	mapFields := f.Value.(map[string]*Field)
+	if _, ok := mapFields[key]; !ok {
+		f.keys = append(f.GetListMapKeys(), key)
+	}
+	mapFields[key] = field
+}
@@ -30,4 +30,5 @@ const (
 	MAP        = "MAP"
 	LIST       = "LIST"
 	LIST_MAP   = "LIST_MAP"
+	FILE_REF   = "FILE_REF"
 )
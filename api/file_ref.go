@@ -0,0 +1,26 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package api
+
+import "io"
+
+// FileRef is a reference to the content of a file, records of the whole file data format carry it
+// instead of the content so that destinations stream the file without loading it in memory.
+//
+// GetReader method returns a new reader of the file content, the caller closes it.
+type FileRef interface {
+	GetReader() (io.ReadCloser, error)
+}
@@ -37,3 +37,25 @@ package api
 type Origin interface {
 	Produce(lastSourceOffset string, maxBatchSize int, batchMaker BatchMaker) (string, error)
 }
+
+// OffsetCommitter is implemented by origins that acknowledge the data they consume to the external system only once
+// the pipeline has processed it.
+//
+// Commit method - Called with the offset returned by Produce once the batch produced with it has been processed
+// and its offset committed. Batches are committed in the order they were produced, every batch produced before
+// the given offset has already been committed or rejected.
+// Return error if the origin failed to acknowledge the data.
+type OffsetCommitter interface {
+	Commit(offset string) error
+}
+
+// OffsetRejecter is implemented by origins that acknowledge the data they consume on commit and have to release the
+// data of the batches whose offset is never committed.
+//
+// Reject method - Called with the offset returned by Produce once the batch produced with it has been processed
+// without committing its offset, because a destination failed to write it or the destination buffer could not
+// deliver it. Batches are rejected in the same order they are committed.
+// Return error if the origin failed to release the data.
+type OffsetRejecter interface {
+	Reject(offset string) error
+}
@@ -24,10 +24,15 @@ type StageContext interface {
 	// If we plan to support ELs later, we should remove and provide in build support for this
 	GetResolvedValue(configValue interface{}) (interface{}, error)
 	CreateRecord(recordSourceId string, value interface{}) (Record, error)
+	CreateEventRecord(recordSourceId string, value interface{}, eventType string, eventVersion int) (Record, error)
 	GetMetrics() metrics.Registry
 	ToError(err error, record Record)
+	ToEvent(record Record)
 	ReportError(err error)
 	GetOutputLanes() []string
 	Evaluate(value string, configName string, ctx context.Context) (interface{}, error)
 	IsErrorStage() bool
+	// GetStopChannel returns the channel closed once the pipeline is being stopped, so that the stage can
+	// interrupt waits happening while it processes a batch
+	GetStopChannel() <-chan struct{}
 }
@@ -23,4 +23,8 @@ const (
 	APPLICATION_JSON          = "application/json"
 	HEADER_X_REST_CALL_VALUE  = "true"
 	HTTP_POST                 = "POST"
+
+	EVENT_TYPE_ATTRIBUTE               = "sdc.event.type"
+	EVENT_VERSION_ATTRIBUTE            = "sdc.event.version"
+	EVENT_CREATION_TIMESTAMP_ATTRIBUTE = "sdc.event.creation_timestamp"
 )
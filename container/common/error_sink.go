@@ -40,6 +40,14 @@ func (e *ErrorSink) ClearErrorRecordsAndMesssages() {
 	e.stageErrorRecords = make(map[string][]api.Record)
 }
 
+// ClearStageErrorRecordsAndMessages drops the error messages/records the given stage reported in the current batch
+func (e *ErrorSink) ClearStageErrorRecordsAndMessages(stageIns string) {
+	e.totalErrorMessages -= int64(len(e.stageErrorMessages[stageIns]))
+	e.totalErrorRecords -= int64(len(e.stageErrorRecords[stageIns]))
+	delete(e.stageErrorMessages, stageIns)
+	delete(e.stageErrorRecords, stageIns)
+}
+
 func (e *ErrorSink) GetStageErrorMessages(stageIns string) []error {
 	return e.stageErrorMessages[stageIns]
 }
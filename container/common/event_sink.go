@@ -0,0 +1,48 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"github.com/streamsets/datacollector-edge/api"
+	"sync"
+)
+
+// EventSink keeps the event records produced by the stages until the pipeline routes them to the event lanes
+// of the stages. Stages may produce events outside of a batch, like when a file is closed by a timer, those
+// events are routed with the next batch.
+type EventSink struct {
+	stageEvents map[string][]api.Record
+	mutex       sync.Mutex
+}
+
+func NewEventSink() *EventSink {
+	return &EventSink{stageEvents: make(map[string][]api.Record)}
+}
+
+func (e *EventSink) AddEvent(stageIns string, record api.Record) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.stageEvents[stageIns] = append(e.stageEvents[stageIns], record)
+}
+
+// DrainStageEvents returns the events produced by the stage and removes them from the sink
+func (e *EventSink) DrainStageEvents(stageIns string) []api.Record {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	events := e.stageEvents[stageIns]
+	delete(e.stageEvents, stageIns)
+	return events
+}
@@ -40,4 +40,5 @@ type PipelineState struct {
 	TimeStamp  time.Time              `json:"timeStamp"`
 	Attributes map[string]interface{} `json:"attributes"`
 	Metrics    string                 `json:"metrics"`
+	Alerts     []*AlertInfo           `json:"alerts,omitempty"`
 }
@@ -170,7 +170,11 @@ func (r *RecordImpl) doSet(fieldPos int, newField *api.Field, pathElements []Pat
 		case MAP:
 			parent := fields[fieldPos-1].Value.(map[string]*api.Field)
 			fieldToReplace, _ = parent[elem.Name]
-			parent[elem.Name] = newField
+			if fields[fieldPos-1].Type == fieldtype.LIST_MAP {
+				fields[fieldPos-1].SetListMapField(elem.Name, newField)
+			} else {
+				parent[elem.Name] = newField
+			}
 		case LIST:
 			parent := fields[fieldPos-1].Value.([]*api.Field)
 			if elem.Idx > len(parent) {
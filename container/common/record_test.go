@@ -18,6 +18,7 @@ package common
 import (
 	"fmt"
 	"github.com/streamsets/datacollector-edge/api"
+	"strings"
 	"testing"
 )
 
@@ -303,6 +304,20 @@ func TestRecordImpl_Set(t *testing.T) {
 	if err != nil || getF.Value.(string) != "newField" {
 		t.Error("Error getting set field /mapField/c")
 	}
+
+	//Setting LIST_MAP fields keeps the insertion order
+	zField, _ := api.CreateStringField("z")
+	record.SetField("/listMapField", api.CreateListMapFieldWithMapOfFields(
+		[]string{"z"},
+		map[string]*api.Field{"z": zField},
+	))
+	record.SetField("/listMapField/b", f)
+	record.SetField("/listMapField/a", f)
+	record.SetField("/listMapField/z", f)
+	getF, err = record.Get("/listMapField")
+	if keys := getF.GetListMapKeys(); err != nil || strings.Join(keys, ",") != "z,b,a" {
+		t.Errorf("Expected LIST_MAP keys in insertion order, but got %v", keys)
+	}
 }
 
 func TestRecordImpl_GetFieldPaths(t *testing.T) {
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package common
+
+import (
+	"time"
+)
+
+const (
+	RULE_DEFINITIONS_SCHEMA_VERSION = 2
+	RULE_DEFINITIONS_VERSION        = 2
+
+	METRIC_RULE = "METRIC"
+	DATA_RULE   = "DATA"
+
+	THRESHOLD_TYPE_COUNT      = "COUNT"
+	THRESHOLD_TYPE_PERCENTAGE = "PERCENTAGE"
+
+	WEBHOOK_CONFIGS = "webhookConfigs"
+)
+
+type RuleDefinitions struct {
+	SchemaVersion          int                     `json:"schemaVersion"`
+	Version                int                     `json:"version"`
+	MetricsRuleDefinitions []MetricsRuleDefinition `json:"metricsRuleDefinitions"`
+	DataRuleDefinitions    []DataRuleDefinition    `json:"dataRuleDefinitions"`
+	DriftRuleDefinitions   []interface{}           `json:"driftRuleDefinitions"`
+	EmailIds               []string                `json:"emailIds"`
+	UUID                   string                  `json:"uuid"`
+	Configuration          []Config                `json:"configuration"`
+}
+
+func (r RuleDefinitions) GetConfigurationMap() map[string]Config {
+	configurationMap := make(map[string]Config)
+	for _, config := range r.Configuration {
+		configurationMap[config.Name] = config
+	}
+	return configurationMap
+}
+
+type MetricsRuleDefinition struct {
+	Id            string `json:"id"`
+	AlertText     string `json:"alertText"`
+	MetricId      string `json:"metricId"`
+	MetricType    string `json:"metricType"`
+	MetricElement string `json:"metricElement"`
+	Condition     string `json:"condition"`
+	SendEmail     bool   `json:"sendEmail"`
+	Enabled       bool   `json:"enabled"`
+	Valid         bool   `json:"valid"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+type DataRuleDefinition struct {
+	Id                      string  `json:"id"`
+	Label                   string  `json:"label"`
+	Lane                    string  `json:"lane"`
+	SamplingPercentage      float64 `json:"samplingPercentage"`
+	SamplingRecordsToRetain int     `json:"samplingRecordsToRetain"`
+	Condition               string  `json:"condition"`
+	AlertEnabled            bool    `json:"alertEnabled"`
+	AlertText               string  `json:"alertText"`
+	ThresholdType           string  `json:"thresholdType"`
+	ThresholdValue          string  `json:"thresholdValue"`
+	MinVolume               int64   `json:"minVolume"`
+	MeterEnabled            bool    `json:"meterEnabled"`
+	SendEmail               bool    `json:"sendEmail"`
+	Enabled                 bool    `json:"enabled"`
+	Valid                   bool    `json:"valid"`
+	Timestamp               int64   `json:"timestamp"`
+}
+
+type AlertInfo struct {
+	PipelineId string      `json:"pipelineId"`
+	RuleId     string      `json:"ruleId"`
+	RuleType   string      `json:"ruleType"`
+	AlertText  string      `json:"alertText"`
+	Condition  string      `json:"condition"`
+	Value      interface{} `json:"value"`
+	TimeStamp  time.Time   `json:"timeStamp"`
+}
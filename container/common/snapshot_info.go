@@ -13,7 +13,17 @@
  * See the License for the specific language governing permissions and
  * limitations under the License.
  */
-package runner
+package common
 
-type EventSink struct {
+import (
+	"time"
+)
+
+type SnapshotInfo struct {
+	PipelineId      string    `json:"pipelineId"`
+	Name            string    `json:"name"`
+	TimeStamp       time.Time `json:"timeStamp"`
+	Batches         int       `json:"batches"`
+	CapturedBatches int       `json:"capturedBatches"`
+	InProgress      bool      `json:"inProgress"`
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/el"
 	"github.com/streamsets/datacollector-edge/container/util"
+	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -31,7 +32,9 @@ type StageContextImpl struct {
 	Parameters  map[string]interface{}
 	Metrics     metrics.Registry
 	ErrorSink   *ErrorSink
+	EventSink   *EventSink
 	ErrorStage  bool
+	Stopped     <-chan struct{}
 }
 
 func (s *StageContextImpl) GetResolvedValue(configValue interface{}) (interface{}, error) {
@@ -93,6 +96,36 @@ func (s *StageContextImpl) CreateRecord(recordSourceId string, value interface{}
 	return record, err
 }
 
+func (s *StageContextImpl) CreateEventRecord(
+	recordSourceId string,
+	value interface{},
+	eventType string,
+	eventVersion int,
+) (api.Record, error) {
+	record, err := s.CreateRecord(recordSourceId, value)
+	if err != nil {
+		return nil, err
+	}
+	header := record.GetHeader()
+	header.SetAttribute(EVENT_TYPE_ATTRIBUTE, eventType)
+	header.SetAttribute(EVENT_VERSION_ATTRIBUTE, strconv.Itoa(eventVersion))
+	header.SetAttribute(EVENT_CREATION_TIMESTAMP_ATTRIBUTE, strconv.FormatInt(util.ConvertTimeToLong(time.Now()), 10))
+	return record, nil
+}
+
+// ToEvent hands over an event record produced by the stage to the event sink, the pipeline routes it to the
+// event lane of the stage. Events of stages without event lane are dropped.
+func (s *StageContextImpl) ToEvent(record api.Record) {
+	log.Printf(
+		"[DEBUG] Stage '%s' produced event '%s'",
+		s.StageConfig.InstanceName,
+		record.GetHeader().GetAttributes()[EVENT_TYPE_ATTRIBUTE],
+	)
+	if s.EventSink != nil && len(s.StageConfig.EventLanes) > 0 {
+		s.EventSink.AddEvent(s.StageConfig.InstanceName, record)
+	}
+}
+
 func (s *StageContextImpl) ToError(err error, record api.Record) {
 	errorRecord := constructErrorRecord(s.StageConfig.InstanceName, err, record)
 	s.ErrorSink.ToError(s.StageConfig.InstanceName, errorRecord)
@@ -117,7 +150,7 @@ func (s *StageContextImpl) Evaluate(
 	evaluator, _ := el.NewEvaluator(
 		configName,
 		s.Parameters,
-		[]el.Definitions{&el.StringEL{}, &el.MathEL{}, &el.RecordEL{Context: ctx}},
+		[]el.Definitions{&el.StringEL{}, &el.MathEL{}, &el.RecordEL{Context: ctx}, &el.TimeEL{Context: ctx}},
 	)
 	return evaluator.Evaluate(value)
 }
@@ -126,6 +159,10 @@ func (s *StageContextImpl) IsErrorStage() bool {
 	return s.ErrorStage
 }
 
+func (s *StageContextImpl) GetStopChannel() <-chan struct{} {
+	return s.Stopped
+}
+
 func constructErrorRecord(instanceName string, err error, record api.Record) api.Record {
 	// TODO: revisit this if we support processors
 	// no need to clone the record, look for original record to be added to error lane
@@ -97,6 +97,13 @@ type PipelineConfigurationAndRules struct {
 	PipelineRules  string `json:"pipelineRules"`
 }
 
+type PipelineSaveRulesEvent struct {
+	Name            string `json:"name"`
+	Rev             string `json:"rev"`
+	User            string `json:"user"`
+	RuleDefinitions string `json:"ruleDefinitions"`
+}
+
 type PipelineStatusEvent struct {
 	Name                  string      `json:"name"`
 	Title                 string      `json:"title"`
@@ -233,6 +233,21 @@ func (m *MessageEventHandler) createPipelineStatusEvent(
 	return pipelineStatusEvent
 }
 
+// storeRules replaces the rules of the pipeline with the rules sent by Control Hub
+func (m *MessageEventHandler) storeRules(pipelineId string, ruleDefinitionsJson string) error {
+	var ruleDefinitions common.RuleDefinitions
+	if err := json.Unmarshal([]byte(ruleDefinitionsJson), &ruleDefinitions); err != nil {
+		return err
+	}
+	savedRuleDefinitions, err := m.pipelineStoreTask.RetrieveRules(pipelineId)
+	if err != nil {
+		return err
+	}
+	ruleDefinitions.UUID = savedRuleDefinitions.UUID
+	_, err = m.pipelineStoreTask.StoreRules(pipelineId, ruleDefinitions)
+	return err
+}
+
 func (m *MessageEventHandler) handleDPMEvent(serverEvent ServerEvent) *ClientEvent {
 	log.Printf("[DEBUG] Handling DPM Events: %d", serverEvent.EventTypeId)
 
@@ -281,6 +296,16 @@ func (m *MessageEventHandler) handleDPMEvent(serverEvent ServerEvent) *ClientEve
 			break
 		}
 
+		if len(pipelineSaveEvent.PipelineConfigurationAndRules.PipelineRules) > 0 {
+			err = m.storeRules(pipelineSaveEvent.Name, pipelineSaveEvent.PipelineConfigurationAndRules.PipelineRules)
+			if err != nil {
+				ackEventMessage = err.Error()
+				ackEventStatus = ACK_EVENT_ERROR
+				log.Println("[Error] Error during handling DPM SAVE Pipeline Event:", err)
+				break
+			}
+		}
+
 		// Update offset
 		runner := m.manager.GetRunner(pipelineSaveEvent.Name)
 		if runner != nil && len(pipelineSaveEvent.Offset) > 0 {
@@ -297,6 +322,22 @@ func (m *MessageEventHandler) handleDPMEvent(serverEvent ServerEvent) *ClientEve
 				}
 			}
 		}
+	case SAVE_RULES_PIPELINE:
+		var pipelineSaveRulesEvent PipelineSaveRulesEvent
+		if err := json.Unmarshal([]byte(serverEvent.Payload), &pipelineSaveRulesEvent); err != nil {
+			ackEventMessage = err.Error()
+			ackEventStatus = ACK_EVENT_ERROR
+			log.Println("[Error] Error during handling DPM SAVE Rules Event:", err)
+			break
+		}
+
+		err := m.storeRules(pipelineSaveRulesEvent.Name, pipelineSaveRulesEvent.RuleDefinitions)
+		if err != nil {
+			ackEventMessage = err.Error()
+			ackEventStatus = ACK_EVENT_ERROR
+			log.Println("[Error] Error during handling DPM SAVE Rules Event:", err)
+			break
+		}
 	case START_PIPELINE:
 		var pipelineBaseEvent PipelineBaseEvent
 		if err := json.Unmarshal([]byte(serverEvent.Payload), &pipelineBaseEvent); err != nil {
@@ -19,26 +19,64 @@ import (
 	"github.com/streamsets/datacollector-edge/container/common"
 )
 
+const (
+	ON_RECORD_ERROR_DISCARD       = "DISCARD"
+	ON_RECORD_ERROR_TO_ERROR      = "TO_ERROR"
+	ON_RECORD_ERROR_STOP_PIPELINE = "STOP_PIPELINE"
+
+	DEFAULT_STAGE_RETRY_INITIAL_BACKOFF = 1000
+	DEFAULT_STAGE_RETRY_MAX_BACKOFF     = 60000
+)
+
 type StageConfigBean struct {
 	StageOnRecordError       string
+	StageOnFailure           string
 	StageRequiredFields      []interface{}
 	StageRecordPreconditions []interface{}
+	StageRetryAttempts       float64
+	StageRetryInitialBackoff float64
+	StageRetryMaxBackoff     float64
+	StageRetryJitter         bool
 }
 
 func NewStageConfigBean(pipelineConfig common.StageConfiguration) StageConfigBean {
-	stageConfigBean := StageConfigBean{}
+	stageConfigBean := StageConfigBean{
+		StageOnRecordError:       ON_RECORD_ERROR_TO_ERROR,
+		StageOnFailure:           ON_RECORD_ERROR_STOP_PIPELINE,
+		StageRetryInitialBackoff: DEFAULT_STAGE_RETRY_INITIAL_BACKOFF,
+		StageRetryMaxBackoff:     DEFAULT_STAGE_RETRY_MAX_BACKOFF,
+		StageRetryJitter:         true,
+	}
 
 	for _, config := range pipelineConfig.Configuration {
+		if config.Value == nil {
+			continue
+		}
 		switch config.Name {
 		case "stageOnRecordError":
 			stageConfigBean.StageOnRecordError = config.Value.(string)
 			break
+		case "stageOnFailure":
+			stageConfigBean.StageOnFailure = config.Value.(string)
+			break
 		case "stageRequiredFields":
 			stageConfigBean.StageRequiredFields = config.Value.([]interface{})
 			break
 		case "stageRecordPreconditions":
 			stageConfigBean.StageRecordPreconditions = config.Value.([]interface{})
 			break
+		case "stageRetryAttempts":
+			stageConfigBean.StageRetryAttempts = config.Value.(float64)
+			break
+		case "stageRetryInitialBackoff":
+			stageConfigBean.StageRetryInitialBackoff = config.Value.(float64)
+			break
+		case "stageRetryMaxBackoff":
+			stageConfigBean.StageRetryMaxBackoff = config.Value.(float64)
+			break
+		case "stageRetryJitter":
+			stageConfigBean.StageRetryJitter = config.Value.(bool)
+			break
 		}
 	}
 	return stageConfigBean
@@ -21,6 +21,7 @@ import (
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/controlhub"
 	"github.com/streamsets/datacollector-edge/container/execution/manager"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
 	"github.com/streamsets/datacollector-edge/container/http"
 	"github.com/streamsets/datacollector-edge/container/process"
 	"github.com/streamsets/datacollector-edge/container/store"
@@ -72,8 +73,8 @@ func DoMain(
 
 		fmt.Println("Starting Pipeline: ", startFlag)
 		state, err := dataCollectorEdge.Manager.GetRunner(startFlag).GetStatus()
-		if state != nil && state.Status == common.RUNNING {
-			// If status is running, change it back to stopped
+		if state != nil && util.Contains(runner.RESTORE_STATUSES, state.Status) {
+			// If status is left active by the previous process, change it back to stopped
 			dataCollectorEdge.Manager.StopPipeline(startFlag)
 		}
 
@@ -85,6 +86,11 @@ func DoMain(
 		fmt.Println(string(stateJson))
 	}
 
+	// pipelines started above with the start flag are skipped
+	if err := dataCollectorEdge.Manager.RestorePipelines(); err != nil {
+		log.Printf("[ERROR] Failed to restore pipelines: %s", err)
+	}
+
 	return dataCollectorEdge, nil
 }
 
@@ -104,6 +110,9 @@ func newDataCollectorEdge(baseDir string, debugFlag bool, logToConsoleFlag bool)
 
 	hostName, _ := os.Hostname()
 	var httpUrl = "http://" + hostName + config.Http.BindAddress
+	if config.Http.TLS.Enabled {
+		httpUrl = "https://" + hostName + config.Http.BindAddress
+	}
 
 	buildInfo, _ := common.NewBuildInfo()
 	runtimeInfo, _ := common.NewRuntimeInfo(httpUrl, baseDir)
@@ -116,7 +125,10 @@ func newDataCollectorEdge(baseDir string, debugFlag bool, logToConsoleFlag bool)
 		return nil, err
 	}
 
-	webServerTask, _ := http.NewWebServerTask(config.Http, buildInfo, pipelineManager, pipelineStoreTask, processManager)
+	webServerTask, err := http.NewWebServerTask(config.Http, buildInfo, pipelineManager, pipelineStoreTask, processManager)
+	if err != nil {
+		return nil, err
+	}
 	controlhub.RegisterWithDPM(config.SCH, buildInfo, runtimeInfo)
 
 	var messagingEventHandler *controlhub.MessageEventHandler
@@ -15,7 +15,11 @@
  */
 package el
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 const (
 	NAMESPACE_FN_SEPARATOR = ":"
@@ -36,3 +40,28 @@ func Evaluate(value string, configName string, parameters map[string]interface{}
 	)
 	return evaluator.Evaluate(value)
 }
+
+// EvaluateTemplate evaluates the expressions of a template like /data/${YYYY()}/${record:attribute('sensor')}
+// and replaces them with their values
+func EvaluateTemplate(template string, evaluate func(expression string) (interface{}, error)) (string, error) {
+	result := make([]string, 0)
+	for {
+		start := strings.Index(template, PARAMETER_PREFIX)
+		if start < 0 {
+			break
+		}
+		end := strings.Index(template[start:], PARAMETER_SUFFIX)
+		if end < 0 {
+			return "", errors.New(fmt.Sprintf("Unterminated expression in template '%s'", template))
+		}
+		end += start + len(PARAMETER_SUFFIX)
+		value, err := evaluate(template[start:end])
+		if err != nil {
+			return "", err
+		}
+		result = append(result, template[:start], fmt.Sprint(value))
+		template = template[end:]
+	}
+	result = append(result, template)
+	return strings.Join(result, ""), nil
+}
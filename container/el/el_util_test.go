@@ -0,0 +1,57 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package el
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvaluateTemplate(test *testing.T) {
+	evaluate := func(expression string) (interface{}, error) {
+		switch expression {
+		case "${YYYY()}":
+			return "2017", nil
+		case "${record:attribute('sensor')}":
+			return "sensor1", nil
+		case "${1 + 1}":
+			return float64(2), nil
+		}
+		return nil, errors.New("unexpected expression " + expression)
+	}
+
+	templateTests := map[string]string{
+		"/data":           "/data",
+		"/data/${YYYY()}": "/data/2017",
+		"/data/${YYYY()}/${record:attribute('sensor')}/x": "/data/2017/sensor1/x",
+		"${1 + 1}": "2",
+		"":         "",
+	}
+	for template, expected := range templateTests {
+		result, err := EvaluateTemplate(template, evaluate)
+		if err != nil {
+			test.Errorf("Template '%s' failed: %s", template, err)
+		} else if result != expected {
+			test.Errorf("Template '%s' evaluated to '%s', expected '%s'", template, result, expected)
+		}
+	}
+
+	for _, template := range []string{"/data/${YYYY()", "/data/${unknown()}"} {
+		if _, err := EvaluateTemplate(template, evaluate); err == nil {
+			test.Errorf("Expected an error for template '%s'", template)
+		}
+	}
+}
@@ -50,6 +50,9 @@ func (r *RecordEL) GetType(args ...interface{}) (interface{}, error) {
 		return nil, err
 	}
 
+	if field == nil {
+		return nil, nil
+	}
 	return field.Type, nil
 }
 
@@ -72,6 +75,10 @@ func (r *RecordEL) GetValue(args ...interface{}) (interface{}, error) {
 		return nil, err
 	}
 
+	// missing fields evaluate to null
+	if field == nil {
+		return nil, nil
+	}
 	return field.Value, nil
 }
 
@@ -97,7 +104,7 @@ func (r *RecordEL) GetValueOrDefault(args ...interface{}) (interface{}, error) {
 		return nil, err
 	}
 
-	if field.Value != nil {
+	if field != nil && field.Value != nil {
 		return field.Value, nil
 	}
 	return defaultValue, nil
@@ -121,12 +128,47 @@ func (r *RecordEL) Exists(args ...interface{}) (interface{}, error) {
 
 	field, err := record.Get(fieldPath)
 
-	if len(field.Type) > 0 {
+	if field != nil && len(field.Type) > 0 {
 		return true, nil
 	}
 	return false, nil
 }
 
+func (r *RecordEL) GetAttribute(args ...interface{}) (interface{}, error) {
+	if len(args) < 1 {
+		return "", errors.New(
+			fmt.Sprintf("The function 'record:attribute' requires 1 arguments but was passed %d", len(args)),
+		)
+	}
+
+	record, err := r.getRecordInContext()
+	if err != nil {
+		return nil, err
+	}
+
+	return record.GetHeader().GetAttributes()[fmt.Sprint(args[0])], nil
+}
+
+func (r *RecordEL) GetAttributeOrDefault(args ...interface{}) (interface{}, error) {
+	if len(args) < 2 {
+		return "", errors.New(
+			fmt.Sprintf("The function 'record:attributeOrDefault' requires 2 arguments but was passed %d",
+				len(args),
+			),
+		)
+	}
+
+	record, err := r.getRecordInContext()
+	if err != nil {
+		return nil, err
+	}
+
+	if value, ok := record.GetHeader().GetAttributes()[fmt.Sprint(args[0])]; ok {
+		return value, nil
+	}
+	return args[1], nil
+}
+
 func (r *RecordEL) getRecordInContext() (api.Record, error) {
 	if r.Context != nil {
 		record := r.Context.Value(RECORD_CONTEXT_VAR).(api.Record)
@@ -139,10 +181,12 @@ func (r *RecordEL) getRecordInContext() (api.Record, error) {
 
 func (r *RecordEL) GetELFunctionDefinitions() map[string]govaluate.ExpressionFunction {
 	functions := map[string]govaluate.ExpressionFunction{
-		"record:type":           r.GetType,
-		"record:value":          r.GetValue,
-		"record:valueOrDefault": r.GetValueOrDefault,
-		"record:exists":         r.Exists,
+		"record:type":               r.GetType,
+		"record:value":              r.GetValue,
+		"record:valueOrDefault":     r.GetValueOrDefault,
+		"record:exists":             r.Exists,
+		"record:attribute":          r.GetAttribute,
+		"record:attributeOrDefault": r.GetAttributeOrDefault,
 		// TODO: SDCE-63 Add remaining record el functions
 	}
 	return functions
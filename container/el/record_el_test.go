@@ -26,8 +26,25 @@ import (
 type MockRecord struct {
 }
 
+type MockHeader struct {
+}
+
+func (h *MockHeader) GetStageCreator() string                { return "" }
+func (h *MockHeader) GetSourceId() string                    { return "" }
+func (h *MockHeader) GetTrackingId() string                  { return "" }
+func (h *MockHeader) GetPreviousTrackingId() string          { return "" }
+func (h *MockHeader) GetStagesPath() string                  { return "" }
+func (h *MockHeader) GetErrorDataCollectorId() string        { return "" }
+func (h *MockHeader) GetErrorPipelineName() string           { return "" }
+func (h *MockHeader) GetErrorMessage() string                { return "" }
+func (h *MockHeader) GetErrorStage() string                  { return "" }
+func (h *MockHeader) GetErrorTimestamp() int64               { return 0 }
+func (h *MockHeader) GetAttributeNames() []string            { return []string{"sensor"} }
+func (h *MockHeader) GetAttributes() map[string]string       { return map[string]string{"sensor": "sensor1"} }
+func (h *MockHeader) SetAttribute(name string, value string) {}
+
 func (r *MockRecord) GetHeader() api.Header {
-	return nil
+	return &MockHeader{}
 }
 
 func (r *MockRecord) Get(fieldPath ...string) (*api.Field, error) {
@@ -45,6 +62,8 @@ func (r *MockRecord) Get(fieldPath ...string) (*api.Field, error) {
 			}, nil
 		case "/inValid":
 			return &api.Field{}, errors.New("invalid fieldPath '/inValid'")
+		case "/missing":
+			return nil, nil
 		default:
 			return &api.Field{}, nil
 		}
@@ -96,6 +115,11 @@ func TestRecordEL(test *testing.T) {
 			Expression: "${record:value('/a/b')}",
 			Expected:   "Test Value",
 		},
+		{
+			Name:       "Test function record:value - Missing field",
+			Expression: "${record:value('/missing')}",
+			Expected:   nil,
+		},
 		{
 			Name:       "Test function record:value - Error 1",
 			Expression: "${record:value()}",
@@ -146,12 +170,49 @@ func TestRecordEL(test *testing.T) {
 			Expression: "${record:exists('/inValid')}",
 			Expected:   false,
 		},
+		{
+			Name:       "Test function record:exists - Missing field",
+			Expression: "${record:exists('/missing')}",
+			Expected:   false,
+		},
 		{
 			Name:       "Test function record:exists - Error 1",
 			Expression: "${record:exists()}",
 			Expected:   "The function 'record:exists' requires 1 arguments but was passed 0",
 			ErrorCase:  true,
 		},
+		{
+			Name:       "Test function record:attribute",
+			Expression: "${record:attribute('sensor')}",
+			Expected:   "sensor1",
+		},
+		{
+			Name:       "Test function record:attribute - Missing attribute",
+			Expression: "${record:attribute('missing')}",
+			Expected:   "",
+		},
+		{
+			Name:       "Test function record:attribute - Error 1",
+			Expression: "${record:attribute()}",
+			Expected:   "The function 'record:attribute' requires 1 arguments but was passed 0",
+			ErrorCase:  true,
+		},
+		{
+			Name:       "Test function record:attributeOrDefault",
+			Expression: "${record:attributeOrDefault('sensor', 'unknown')}",
+			Expected:   "sensor1",
+		},
+		{
+			Name:       "Test function record:attributeOrDefault",
+			Expression: "${record:attributeOrDefault('missing', 'unknown')}",
+			Expected:   "unknown",
+		},
+		{
+			Name:       "Test function record:attributeOrDefault - Error 1",
+			Expression: "${record:attributeOrDefault('sensor')}",
+			Expected:   "The function 'record:attributeOrDefault' requires 2 arguments but was passed 1",
+			ErrorCase:  true,
+		},
 	}
 
 	record := &MockRecord{}
@@ -185,6 +246,12 @@ func TestRecordEL_withOutContext(test *testing.T) {
 			Expected:   "record context is not set",
 			ErrorCase:  true,
 		},
+		{
+			Name:       "Test function record:attribute",
+			Expression: "${record:attribute('sensor')}",
+			Expected:   "record context is not set",
+			ErrorCase:  true,
+		},
 	}
 	RunEvaluationTests(evaluationTests, []Definitions{&RecordEL{}}, test)
 }
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package el
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/madhukard/govaluate"
+	"time"
+)
+
+const (
+	TIME_CONTEXT_VAR = "time"
+)
+
+// TimeEL holds the functions returning the parts of the time set in the context, like ${YYYY()}/${MM()}/${DD()}
+// in a directory template. The current time is used when the context holds no time.
+type TimeEL struct {
+	Context context.Context
+}
+
+func (t *TimeEL) Now(args ...interface{}) (interface{}, error) {
+	return time.Now(), nil
+}
+
+func (t *TimeEL) Year(args ...interface{}) (interface{}, error) {
+	return t.format("YYYY", "2006", args...)
+}
+
+func (t *TimeEL) ShortYear(args ...interface{}) (interface{}, error) {
+	return t.format("YY", "06", args...)
+}
+
+func (t *TimeEL) Month(args ...interface{}) (interface{}, error) {
+	return t.format("MM", "01", args...)
+}
+
+func (t *TimeEL) Day(args ...interface{}) (interface{}, error) {
+	return t.format("DD", "02", args...)
+}
+
+func (t *TimeEL) Hour(args ...interface{}) (interface{}, error) {
+	return t.format("hh", "15", args...)
+}
+
+func (t *TimeEL) Minute(args ...interface{}) (interface{}, error) {
+	return t.format("mm", "04", args...)
+}
+
+func (t *TimeEL) Second(args ...interface{}) (interface{}, error) {
+	return t.format("ss", "05", args...)
+}
+
+func (t *TimeEL) format(function string, layout string, args ...interface{}) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, errors.New(
+			fmt.Sprintf("The function '%s' requires 0 arguments but was passed %d", function, len(args)),
+		)
+	}
+	return t.getTimeInContext().Format(layout), nil
+}
+
+func (t *TimeEL) getTimeInContext() time.Time {
+	if t.Context != nil {
+		if contextTime, ok := t.Context.Value(TIME_CONTEXT_VAR).(time.Time); ok {
+			return contextTime
+		}
+	}
+	return time.Now()
+}
+
+func (t *TimeEL) GetELFunctionDefinitions() map[string]govaluate.ExpressionFunction {
+	functions := map[string]govaluate.ExpressionFunction{
+		"time:now": t.Now,
+		"YYYY":     t.Year,
+		"YY":       t.ShortYear,
+		"MM":       t.Month,
+		"DD":       t.Day,
+		"hh":       t.Hour,
+		"mm":       t.Minute,
+		"ss":       t.Second,
+	}
+	return functions
+}
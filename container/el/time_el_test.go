@@ -0,0 +1,95 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package el
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimeEL(test *testing.T) {
+	evaluationTests := []EvaluationTest{
+		{
+			Name:       "Test function YYYY",
+			Expression: "${YYYY()}",
+			Expected:   "2017",
+		},
+		{
+			Name:       "Test function YY",
+			Expression: "${YY()}",
+			Expected:   "17",
+		},
+		{
+			Name:       "Test function MM",
+			Expression: "${MM()}",
+			Expected:   "03",
+		},
+		{
+			Name:       "Test function DD",
+			Expression: "${DD()}",
+			Expected:   "09",
+		},
+		{
+			Name:       "Test function hh",
+			Expression: "${hh()}",
+			Expected:   "14",
+		},
+		{
+			Name:       "Test function mm",
+			Expression: "${mm()}",
+			Expected:   "05",
+		},
+		{
+			Name:       "Test function ss",
+			Expression: "${ss()}",
+			Expected:   "07",
+		},
+		{
+			Name:       "Test function YYYY - Error 1",
+			Expression: "${YYYY(1)}",
+			Expected:   "The function 'YYYY' requires 0 arguments but was passed 1",
+			ErrorCase:  true,
+		},
+	}
+
+	timeContext := context.WithValue(
+		context.Background(),
+		TIME_CONTEXT_VAR,
+		time.Date(2017, time.March, 9, 14, 5, 7, 0, time.UTC),
+	)
+	RunEvaluationTests(evaluationTests, []Definitions{&TimeEL{Context: timeContext}}, test)
+}
+
+func TestTimeEL_withOutContext(test *testing.T) {
+	evaluationTests := []EvaluationTest{
+		{
+			Name:       "Test function YYYY",
+			Expression: "${YYYY()}",
+			Expected:   time.Now().Format("2006"),
+		},
+	}
+	RunEvaluationTests(evaluationTests, []Definitions{&TimeEL{}}, test)
+
+	evaluator, _ := NewEvaluator("now", nil, []Definitions{&TimeEL{}})
+	result, err := evaluator.Evaluate("${time:now()}")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if now, ok := result.(time.Time); !ok || time.Since(now) > time.Minute {
+		test.Errorf("Expected the current time, but got %v", result)
+	}
+}
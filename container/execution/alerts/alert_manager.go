@@ -0,0 +1,99 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package alerts
+
+import (
+	"errors"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlertManager keeps the alerts raised by the rules of a running pipeline. An alert stays active until it
+// is deleted, raising it again only updates its value.
+type AlertManager struct {
+	pipelineId     string
+	webhookConfigs []WebhookConfig
+	mutex          sync.Mutex
+	alerts         map[string]*common.AlertInfo
+}
+
+func (a *AlertManager) Alert(ruleType string, ruleId string, alertText string, condition string, value interface{}) {
+	a.mutex.Lock()
+	alertInfo, exists := a.alerts[ruleId]
+	if exists {
+		alertInfo.Value = value
+		a.mutex.Unlock()
+		return
+	}
+	alertInfo = &common.AlertInfo{
+		PipelineId: a.pipelineId,
+		RuleId:     ruleId,
+		RuleType:   ruleType,
+		AlertText:  alertText,
+		Condition:  condition,
+		Value:      value,
+		TimeStamp:  time.Now().UTC(),
+	}
+	a.alerts[ruleId] = alertInfo
+	notifiedAlert := *alertInfo
+	a.mutex.Unlock()
+
+	log.Printf("[WARN] Pipeline '%s' alert '%s': %s", a.pipelineId, ruleId, alertText)
+	for _, webhookConfig := range a.webhookConfigs {
+		go func(webhookConfig WebhookConfig) {
+			if err := webhookConfig.send(notifiedAlert); err != nil {
+				log.Printf("[ERROR] Failed to send alert '%s' to webhook '%s': %s",
+					ruleId, webhookConfig.WebhookUrl, err.Error())
+			}
+		}(webhookConfig)
+	}
+}
+
+// GetAlerts returns a copy of the active alerts sorted by the time they were raised
+func (a *AlertManager) GetAlerts() []*common.AlertInfo {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	alerts := make([]*common.AlertInfo, 0, len(a.alerts))
+	for _, alertInfo := range a.alerts {
+		alertInfoCopy := *alertInfo
+		alerts = append(alerts, &alertInfoCopy)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].TimeStamp.Before(alerts[j].TimeStamp)
+	})
+	return alerts
+}
+
+func (a *AlertManager) DeleteAlert(ruleId string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, exists := a.alerts[ruleId]; !exists {
+		return errors.New("Alert '" + ruleId + "' does not exist")
+	}
+	delete(a.alerts, ruleId)
+	return nil
+}
+
+func NewAlertManager(pipelineId string, webhookConfigs []WebhookConfig) *AlertManager {
+	return &AlertManager{
+		pipelineId:     pipelineId,
+		webhookConfigs: webhookConfigs,
+		alerts:         make(map[string]*common.AlertInfo),
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/el"
+	"github.com/streamsets/datacollector-edge/container/util"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+const (
+	USER_METRIC_PREFIX = "user."
+)
+
+// dataRuleEvaluator evaluates the condition of a data rule against a sample of the records flowing on a lane
+// and raises an alert once the matching records exceed the rule threshold.
+type dataRuleEvaluator struct {
+	ruleDefinition   common.DataRuleDefinition
+	recordEL         *el.RecordEL
+	evaluator        *el.Evaluator
+	alertManager     *AlertManager
+	thresholdValue   float64
+	mutex            sync.Mutex
+	evaluatedRecords int64
+	matchedRecords   int64
+	matchedMeter     metrics.Meter
+}
+
+func (d *dataRuleEvaluator) evaluate(records []api.Record) error {
+	// the evaluator is shared by all the records, the record EL context is switched under the lock
+	d.mutex.Lock()
+	var evaluated, matched int64
+	for _, record := range records {
+		if rand.Float64()*100 >= d.ruleDefinition.SamplingPercentage {
+			continue
+		}
+		d.recordEL.Context = context.WithValue(context.Background(), el.RECORD_CONTEXT_VAR, record)
+		result, err := d.evaluator.Evaluate(d.ruleDefinition.Condition)
+		if err != nil {
+			d.recordEL.Context = nil
+			d.mutex.Unlock()
+			return err
+		}
+		evaluated++
+		if result == true {
+			matched++
+		}
+	}
+	d.recordEL.Context = nil
+	if evaluated == 0 {
+		d.mutex.Unlock()
+		return nil
+	}
+	if d.matchedMeter != nil {
+		d.matchedMeter.Mark(matched)
+	}
+
+	d.evaluatedRecords += evaluated
+	d.matchedRecords += matched
+	evaluatedRecords := d.evaluatedRecords
+	matchedRecords := d.matchedRecords
+	d.mutex.Unlock()
+
+	if !d.ruleDefinition.AlertEnabled {
+		return nil
+	}
+	switch d.ruleDefinition.ThresholdType {
+	case common.THRESHOLD_TYPE_PERCENTAGE:
+		if evaluatedRecords < d.ruleDefinition.MinVolume {
+			return nil
+		}
+		percentage := float64(matchedRecords) * 100 / float64(evaluatedRecords)
+		if percentage > d.thresholdValue {
+			d.alert(percentage)
+		}
+	default:
+		if float64(matchedRecords) > d.thresholdValue {
+			d.alert(matchedRecords)
+		}
+	}
+	return nil
+}
+
+func (d *dataRuleEvaluator) alert(value interface{}) {
+	d.alertManager.Alert(
+		common.DATA_RULE,
+		d.ruleDefinition.Id,
+		d.ruleDefinition.AlertText,
+		d.ruleDefinition.Condition,
+		value,
+	)
+}
+
+func newDataRuleEvaluator(
+	ruleDefinition common.DataRuleDefinition,
+	parameters map[string]interface{},
+	metricRegistry metrics.Registry,
+	alertManager *AlertManager,
+) (*dataRuleEvaluator, error) {
+	thresholdValue := float64(0)
+	if len(ruleDefinition.ThresholdValue) > 0 {
+		var err error
+		if thresholdValue, err = strconv.ParseFloat(ruleDefinition.ThresholdValue, 64); err != nil {
+			return nil, errors.New(fmt.Sprintf(
+				"Invalid threshold value '%s' for data rule '%s'",
+				ruleDefinition.ThresholdValue,
+				ruleDefinition.Id,
+			))
+		}
+	}
+	recordEL := &el.RecordEL{}
+	evaluator, err := el.NewEvaluator(
+		ruleDefinition.Id,
+		copyParameters(parameters),
+		[]el.Definitions{&el.StringEL{}, &el.MathEL{}, recordEL},
+	)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"Failed to create the evaluator of data rule '%s': %s",
+			ruleDefinition.Id,
+			err.Error(),
+		))
+	}
+	dataRuleEvaluator := &dataRuleEvaluator{
+		ruleDefinition: ruleDefinition,
+		recordEL:       recordEL,
+		evaluator:      evaluator,
+		alertManager:   alertManager,
+		thresholdValue: thresholdValue,
+	}
+	if ruleDefinition.MeterEnabled {
+		dataRuleEvaluator.matchedMeter = util.CreateMeter(metricRegistry, USER_METRIC_PREFIX+ruleDefinition.Id)
+	}
+	return dataRuleEvaluator, nil
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package alerts
+
+import (
+	"errors"
+	"fmt"
+	"github.com/madhukard/govaluate"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/el"
+	"sync"
+	"time"
+)
+
+// Metric elements of the metric rules, timer durations are evaluated in milliseconds
+const (
+	COUNTER_COUNT = "COUNTER_COUNT"
+
+	GAUGE_VALUE = "GAUGE_VALUE"
+
+	HISTOGRAM_COUNT   = "HISTOGRAM_COUNT"
+	HISTOGRAM_MAX     = "HISTOGRAM_MAX"
+	HISTOGRAM_MIN     = "HISTOGRAM_MIN"
+	HISTOGRAM_MEAN    = "HISTOGRAM_MEAN"
+	HISTOGRAM_MEDIAN  = "HISTOGRAM_MEDIAN"
+	HISTOGRAM_P50     = "HISTOGRAM_P50"
+	HISTOGRAM_P75     = "HISTOGRAM_P75"
+	HISTOGRAM_P95     = "HISTOGRAM_P95"
+	HISTOGRAM_P98     = "HISTOGRAM_P98"
+	HISTOGRAM_P99     = "HISTOGRAM_P99"
+	HISTOGRAM_P999    = "HISTOGRAM_P999"
+	HISTOGRAM_STD_DEV = "HISTOGRAM_STD_DEV"
+
+	METER_COUNT     = "METER_COUNT"
+	METER_M1_RATE   = "METER_M1_RATE"
+	METER_M5_RATE   = "METER_M5_RATE"
+	METER_M15_RATE  = "METER_M15_RATE"
+	METER_MEAN_RATE = "METER_MEAN_RATE"
+
+	TIMER_COUNT     = "TIMER_COUNT"
+	TIMER_MAX       = "TIMER_MAX"
+	TIMER_MIN       = "TIMER_MIN"
+	TIMER_MEAN      = "TIMER_MEAN"
+	TIMER_P50       = "TIMER_P50"
+	TIMER_P75       = "TIMER_P75"
+	TIMER_P95       = "TIMER_P95"
+	TIMER_P98       = "TIMER_P98"
+	TIMER_P99       = "TIMER_P99"
+	TIMER_P999      = "TIMER_P999"
+	TIMER_STD_DEV   = "TIMER_STD_DEV"
+	TIMER_M1_RATE   = "TIMER_M1_RATE"
+	TIMER_M5_RATE   = "TIMER_M5_RATE"
+	TIMER_M15_RATE  = "TIMER_M15_RATE"
+	TIMER_MEAN_RATE = "TIMER_MEAN_RATE"
+)
+
+var histogramPercentiles = map[string]float64{
+	HISTOGRAM_MEDIAN: 0.5,
+	HISTOGRAM_P50:    0.5,
+	HISTOGRAM_P75:    0.75,
+	HISTOGRAM_P95:    0.95,
+	HISTOGRAM_P98:    0.98,
+	HISTOGRAM_P99:    0.99,
+	HISTOGRAM_P999:   0.999,
+}
+
+var timerPercentiles = map[string]float64{
+	TIMER_P50:  0.5,
+	TIMER_P75:  0.75,
+	TIMER_P95:  0.95,
+	TIMER_P98:  0.98,
+	TIMER_P99:  0.99,
+	TIMER_P999: 0.999,
+}
+
+// valueEL provides the value() function used by the metric rule conditions
+type valueEL struct {
+	value float64
+}
+
+func (v *valueEL) Value(args ...interface{}) (interface{}, error) {
+	return v.value, nil
+}
+
+func (v *valueEL) GetELFunctionDefinitions() map[string]govaluate.ExpressionFunction {
+	return map[string]govaluate.ExpressionFunction{
+		"value": v.Value,
+	}
+}
+
+type metricRuleEvaluator struct {
+	ruleDefinition common.MetricsRuleDefinition
+	metricRegistry metrics.Registry
+	valueEL        *valueEL
+	evaluator      *el.Evaluator
+	alertManager   *AlertManager
+	mutex          sync.Mutex
+}
+
+func (m *metricRuleEvaluator) evaluate() error {
+	metric := m.metricRegistry.Get(m.ruleDefinition.MetricId)
+	if metric == nil {
+		// the metric is only registered once the stage reporting it runs
+		return nil
+	}
+	value, err := getMetricValue(metric, m.ruleDefinition.MetricElement)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.valueEL.value = value
+	result, err := m.evaluator.Evaluate(m.ruleDefinition.Condition)
+	m.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	matched, ok := result.(bool)
+	if !ok {
+		return errors.New(fmt.Sprintf("Condition '%s' does not evaluate to a boolean", m.ruleDefinition.Condition))
+	}
+	if matched {
+		m.alertManager.Alert(
+			common.METRIC_RULE,
+			m.ruleDefinition.Id,
+			m.ruleDefinition.AlertText,
+			m.ruleDefinition.Condition,
+			value,
+		)
+	}
+	return nil
+}
+
+func newMetricRuleEvaluator(
+	ruleDefinition common.MetricsRuleDefinition,
+	parameters map[string]interface{},
+	metricRegistry metrics.Registry,
+	alertManager *AlertManager,
+) (*metricRuleEvaluator, error) {
+	valueEL := &valueEL{}
+	evaluator, err := el.NewEvaluator(
+		ruleDefinition.Id,
+		copyParameters(parameters),
+		[]el.Definitions{&el.StringEL{}, &el.MathEL{}, valueEL},
+	)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"Failed to create the evaluator of metric rule '%s': %s",
+			ruleDefinition.Id,
+			err.Error(),
+		))
+	}
+	return &metricRuleEvaluator{
+		ruleDefinition: ruleDefinition,
+		metricRegistry: metricRegistry,
+		valueEL:        valueEL,
+		evaluator:      evaluator,
+		alertManager:   alertManager,
+	}, nil
+}
+
+func getMetricValue(metric interface{}, metricElement string) (float64, error) {
+	switch t := metric.(type) {
+	case metrics.Counter:
+		if metricElement == COUNTER_COUNT {
+			return float64(t.Count()), nil
+		}
+	case metrics.Gauge:
+		if metricElement == GAUGE_VALUE {
+			return float64(t.Value()), nil
+		}
+	case metrics.Histogram:
+		h := t.Snapshot()
+		if percentile, ok := histogramPercentiles[metricElement]; ok {
+			return h.Percentile(percentile), nil
+		}
+		switch metricElement {
+		case HISTOGRAM_COUNT:
+			return float64(h.Count()), nil
+		case HISTOGRAM_MAX:
+			return float64(h.Max()), nil
+		case HISTOGRAM_MIN:
+			return float64(h.Min()), nil
+		case HISTOGRAM_MEAN:
+			return h.Mean(), nil
+		case HISTOGRAM_STD_DEV:
+			return h.StdDev(), nil
+		}
+	case metrics.Meter:
+		m := t.Snapshot()
+		switch metricElement {
+		case METER_COUNT:
+			return float64(m.Count()), nil
+		case METER_M1_RATE:
+			return m.Rate1(), nil
+		case METER_M5_RATE:
+			return m.Rate5(), nil
+		case METER_M15_RATE:
+			return m.Rate15(), nil
+		case METER_MEAN_RATE:
+			return m.RateMean(), nil
+		}
+	case metrics.Timer:
+		s := t.Snapshot()
+		if percentile, ok := timerPercentiles[metricElement]; ok {
+			return toMillis(s.Percentile(percentile)), nil
+		}
+		switch metricElement {
+		case TIMER_COUNT:
+			return float64(s.Count()), nil
+		case TIMER_MAX:
+			return toMillis(float64(s.Max())), nil
+		case TIMER_MIN:
+			return toMillis(float64(s.Min())), nil
+		case TIMER_MEAN:
+			return toMillis(s.Mean()), nil
+		case TIMER_STD_DEV:
+			return toMillis(s.StdDev()), nil
+		case TIMER_M1_RATE:
+			return s.Rate1(), nil
+		case TIMER_M5_RATE:
+			return s.Rate5(), nil
+		case TIMER_M15_RATE:
+			return s.Rate15(), nil
+		case TIMER_MEAN_RATE:
+			return s.RateMean(), nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Metric element '%s' is not supported for the metric", metricElement))
+}
+
+func toMillis(nanos float64) float64 {
+	return nanos / float64(time.Millisecond)
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package alerts
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"log"
+)
+
+// RulesEvaluator evaluates the enabled metric and data rules of a running pipeline. Data rules are evaluated
+// as the records are produced on the lanes, metric rules once a batch is complete.
+type RulesEvaluator struct {
+	pipelineId   string
+	alertManager *AlertManager
+	metricRules  []*metricRuleEvaluator
+	dataRules    map[string][]*dataRuleEvaluator
+}
+
+func (r *RulesEvaluator) ObserveLane(lane string, records []api.Record) {
+	if len(records) == 0 {
+		return
+	}
+	for _, dataRule := range r.dataRules[lane] {
+		if err := dataRule.evaluate(records); err != nil {
+			log.Printf(
+				"[WARN] Failed to evaluate data rule '%s' of pipeline '%s': %s",
+				dataRule.ruleDefinition.Id,
+				r.pipelineId,
+				err.Error(),
+			)
+		}
+	}
+}
+
+func (r *RulesEvaluator) EvaluateMetricRules() {
+	for _, metricRule := range r.metricRules {
+		if err := metricRule.evaluate(); err != nil {
+			log.Printf(
+				"[WARN] Failed to evaluate metric rule '%s' of pipeline '%s': %s",
+				metricRule.ruleDefinition.Id,
+				r.pipelineId,
+				err.Error(),
+			)
+		}
+	}
+}
+
+func (r *RulesEvaluator) GetAlerts() []*common.AlertInfo {
+	return r.alertManager.GetAlerts()
+}
+
+func (r *RulesEvaluator) DeleteAlert(ruleId string) error {
+	return r.alertManager.DeleteAlert(ruleId)
+}
+
+func NewRulesEvaluator(
+	pipelineId string,
+	ruleDefinitions common.RuleDefinitions,
+	parameters map[string]interface{},
+	metricRegistry metrics.Registry,
+) (*RulesEvaluator, error) {
+	webhookConfigs, err := getWebhookConfigs(ruleDefinitions)
+	if err != nil {
+		return nil, err
+	}
+
+	rulesEvaluator := &RulesEvaluator{
+		pipelineId:   pipelineId,
+		alertManager: NewAlertManager(pipelineId, webhookConfigs),
+		metricRules:  make([]*metricRuleEvaluator, 0),
+		dataRules:    make(map[string][]*dataRuleEvaluator),
+	}
+
+	for _, ruleDefinition := range ruleDefinitions.MetricsRuleDefinitions {
+		if !ruleDefinition.Enabled {
+			continue
+		}
+		metricRule, err := newMetricRuleEvaluator(
+			ruleDefinition,
+			parameters,
+			metricRegistry,
+			rulesEvaluator.alertManager,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rulesEvaluator.metricRules = append(rulesEvaluator.metricRules, metricRule)
+	}
+
+	for _, ruleDefinition := range ruleDefinitions.DataRuleDefinitions {
+		if !ruleDefinition.Enabled {
+			continue
+		}
+		dataRule, err := newDataRuleEvaluator(ruleDefinition, parameters, metricRegistry, rulesEvaluator.alertManager)
+		if err != nil {
+			return nil, err
+		}
+		rulesEvaluator.dataRules[ruleDefinition.Lane] = append(rulesEvaluator.dataRules[ruleDefinition.Lane], dataRule)
+	}
+
+	return rulesEvaluator, nil
+}
+
+// copyParameters returns a copy of the pipeline parameters for a rule evaluator, the evaluator adds its own
+func copyParameters(parameters map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{})
+	for k, v := range parameters {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package alerts
+
+import (
+	"encoding/json"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/util"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func createRecords(t *testing.T, values ...interface{}) []api.Record {
+	stageContext := &common.StageContextImpl{
+		StageConfig: common.StageConfiguration{InstanceName: "origin"},
+	}
+	records := make([]api.Record, 0, len(values))
+	for _, value := range values {
+		record, err := stageContext.CreateRecord("recordSourceId", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRulesEvaluator_MetricRules(t *testing.T) {
+	metricRegistry := metrics.NewRegistry()
+	errorRecordsCounter := util.CreateCounter(metricRegistry, "pipeline.batchErrorRecords")
+
+	ruleDefinitions := common.RuleDefinitions{
+		MetricsRuleDefinitions: []common.MetricsRuleDefinition{
+			{
+				Id:            "errorRecordsRule",
+				AlertText:     "Too many error records",
+				MetricId:      "pipeline.batchErrorRecords.counter",
+				MetricType:    "COUNTER",
+				MetricElement: COUNTER_COUNT,
+				Condition:     "${value() > 10}",
+				Enabled:       true,
+			},
+			{
+				Id:            "disabledRule",
+				MetricId:      "pipeline.batchErrorRecords.counter",
+				MetricType:    "COUNTER",
+				MetricElement: COUNTER_COUNT,
+				Condition:     "${value() > 0}",
+				Enabled:       false,
+			},
+		},
+	}
+	rulesEvaluator, err := NewRulesEvaluator("pipeline1", ruleDefinitions, nil, metricRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errorRecordsCounter.Inc(5)
+	rulesEvaluator.EvaluateMetricRules()
+	if len(rulesEvaluator.GetAlerts()) != 0 {
+		t.Fatalf("Expected no alerts, but got %d", len(rulesEvaluator.GetAlerts()))
+	}
+
+	errorRecordsCounter.Inc(10)
+	rulesEvaluator.EvaluateMetricRules()
+	alerts := rulesEvaluator.GetAlerts()
+	if len(alerts) != 1 {
+		t.Fatalf("Expected 1 alert, but got %d", len(alerts))
+	}
+	if alerts[0].RuleId != "errorRecordsRule" || alerts[0].RuleType != common.METRIC_RULE ||
+		alerts[0].Value != float64(15) {
+		t.Errorf("Unexpected alert: %+v", alerts[0])
+	}
+
+	if err = rulesEvaluator.DeleteAlert("errorRecordsRule"); err != nil {
+		t.Error(err)
+	}
+	if len(rulesEvaluator.GetAlerts()) != 0 {
+		t.Error("Expected no alerts after deleting the alert")
+	}
+	if err = rulesEvaluator.DeleteAlert("errorRecordsRule"); err == nil {
+		t.Error("Expected error when deleting a missing alert")
+	}
+}
+
+func TestRulesEvaluator_DataRules(t *testing.T) {
+	metricRegistry := metrics.NewRegistry()
+	ruleDefinitions := common.RuleDefinitions{
+		DataRuleDefinitions: []common.DataRuleDefinition{
+			{
+				Id:                 "countRule",
+				AlertText:          "Large values",
+				Lane:               "lane1",
+				SamplingPercentage: 100,
+				Condition:          "${record:value('/a') > 5}",
+				AlertEnabled:       true,
+				ThresholdType:      common.THRESHOLD_TYPE_COUNT,
+				ThresholdValue:     "2",
+				MeterEnabled:       true,
+				Enabled:            true,
+			},
+			{
+				Id:                 "percentageRule",
+				Lane:               "lane1",
+				SamplingPercentage: 100,
+				Condition:          "${record:value('/a') > 5}",
+				AlertEnabled:       true,
+				ThresholdType:      common.THRESHOLD_TYPE_PERCENTAGE,
+				ThresholdValue:     "50",
+				MinVolume:          10,
+				Enabled:            true,
+			},
+		},
+	}
+	rulesEvaluator, err := NewRulesEvaluator("pipeline1", ruleDefinitions, nil, metricRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rulesEvaluator.ObserveLane("lane2", createRecords(t,
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+	))
+	if len(rulesEvaluator.GetAlerts()) != 0 {
+		t.Fatal("Expected no alerts for records on other lanes")
+	}
+
+	rulesEvaluator.ObserveLane("lane1", createRecords(t,
+		map[string]interface{}{"a": 1.0},
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+	))
+	if len(rulesEvaluator.GetAlerts()) != 0 {
+		t.Fatal("Expected no alerts before reaching the threshold")
+	}
+
+	rulesEvaluator.ObserveLane("lane1", createRecords(t, map[string]interface{}{"a": 10.0}))
+	alerts := rulesEvaluator.GetAlerts()
+	if len(alerts) != 1 || alerts[0].RuleId != "countRule" || alerts[0].Value != int64(3) {
+		t.Fatalf("Expected alert of the count rule, but got %+v", alerts)
+	}
+
+	meter := metricRegistry.Get("user.countRule.meter").(metrics.Meter)
+	if meter.Count() != 3 {
+		t.Errorf("Expected 3 matched records in the meter, but got %d", meter.Count())
+	}
+
+	// 9 out of 10 records matched, above the 50% threshold
+	rulesEvaluator.ObserveLane("lane1", createRecords(t,
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+		map[string]interface{}{"a": 10.0},
+	))
+	if len(rulesEvaluator.GetAlerts()) != 2 {
+		t.Fatalf("Expected 2 alerts, but got %d", len(rulesEvaluator.GetAlerts()))
+	}
+}
+
+func TestRulesEvaluator_Webhook(t *testing.T) {
+	payloads := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		payloads <- string(body)
+	}))
+	defer server.Close()
+
+	var webhookConfigs []interface{}
+	json.Unmarshal([]byte(`[{"webhookUrl": "`+server.URL+`", "payload": "{{ALERT_NAME}}: {{ALERT_TEXT}}"}]`),
+		&webhookConfigs)
+
+	metricRegistry := metrics.NewRegistry()
+	util.CreateCounter(metricRegistry, "pipeline.batchCount").Inc(1)
+	ruleDefinitions := common.RuleDefinitions{
+		MetricsRuleDefinitions: []common.MetricsRuleDefinition{
+			{
+				Id:            "batchCountRule",
+				AlertText:     "Batch processed",
+				MetricId:      "pipeline.batchCount.counter",
+				MetricElement: COUNTER_COUNT,
+				Condition:     "${value() > 0}",
+				Enabled:       true,
+			},
+		},
+		Configuration: []common.Config{{Name: common.WEBHOOK_CONFIGS, Value: webhookConfigs}},
+	}
+	rulesEvaluator, err := NewRulesEvaluator("pipeline1", ruleDefinitions, nil, metricRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rulesEvaluator.EvaluateMetricRules()
+	select {
+	case payload := <-payloads:
+		if payload != "batchCountRule: Batch processed" {
+			t.Errorf("Unexpected webhook payload: %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Webhook was not called")
+	}
+}
+
+func TestRulesEvaluator_Parameters(t *testing.T) {
+	metricRegistry := metrics.NewRegistry()
+	errorRecordsCounter := util.CreateCounter(metricRegistry, "pipeline.batchErrorRecords")
+
+	ruleDefinitions := common.RuleDefinitions{
+		MetricsRuleDefinitions: []common.MetricsRuleDefinition{
+			{
+				Id:            "errorRecordsRule",
+				MetricId:      "pipeline.batchErrorRecords.counter",
+				MetricType:    "COUNTER",
+				MetricElement: COUNTER_COUNT,
+				Condition:     "${value() > MAX_ERRORS}",
+				Enabled:       true,
+			},
+		},
+	}
+	parameters := map[string]interface{}{"MAX_ERRORS": float64(3)}
+	rulesEvaluator, err := NewRulesEvaluator("pipeline1", ruleDefinitions, parameters, metricRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parameters) != 1 {
+		t.Errorf("Expected the pipeline parameters to be left unchanged, but got %v", parameters)
+	}
+
+	errorRecordsCounter.Inc(2)
+	rulesEvaluator.EvaluateMetricRules()
+	if len(rulesEvaluator.GetAlerts()) != 0 {
+		t.Fatalf("Expected no alerts, but got %d", len(rulesEvaluator.GetAlerts()))
+	}
+
+	errorRecordsCounter.Inc(2)
+	rulesEvaluator.EvaluateMetricRules()
+	if len(rulesEvaluator.GetAlerts()) != 1 {
+		t.Fatalf("Expected 1 alert, but got %d", len(rulesEvaluator.GetAlerts()))
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ALERT_TEXT_PLACEHOLDER      = "{{ALERT_TEXT}}"
+	ALERT_NAME_PLACEHOLDER      = "{{ALERT_NAME}}"
+	ALERT_CONDITION_PLACEHOLDER = "{{ALERT_CONDITION}}"
+	ALERT_VALUE_PLACEHOLDER     = "{{ALERT_VALUE}}"
+	PIPELINE_NAME_PLACEHOLDER   = "{{PIPELINE_NAME}}"
+	TIME_PLACEHOLDER            = "{{TIME}}"
+
+	webhookTimeout = 30 * time.Second
+)
+
+// WebhookConfig describes the HTTP request sent when an alert is raised, placeholders in the payload are
+// replaced with the alert details. Without a payload the alert is sent as JSON.
+type WebhookConfig struct {
+	WebhookUrl  string            `json:"webhookUrl"`
+	Headers     map[string]string `json:"headers"`
+	HttpMethod  string            `json:"httpMethod"`
+	Payload     string            `json:"payload"`
+	ContentType string            `json:"contentType"`
+}
+
+func (w WebhookConfig) send(alertInfo common.AlertInfo) error {
+	var payload []byte
+	if len(w.Payload) > 0 {
+		replacer := strings.NewReplacer(
+			ALERT_TEXT_PLACEHOLDER, alertInfo.AlertText,
+			ALERT_NAME_PLACEHOLDER, alertInfo.RuleId,
+			ALERT_CONDITION_PLACEHOLDER, alertInfo.Condition,
+			ALERT_VALUE_PLACEHOLDER, fmt.Sprint(alertInfo.Value),
+			PIPELINE_NAME_PLACEHOLDER, alertInfo.PipelineId,
+			TIME_PLACEHOLDER, alertInfo.TimeStamp.Format(time.RFC3339),
+		)
+		payload = []byte(replacer.Replace(w.Payload))
+	} else {
+		var err error
+		if payload, err = json.Marshal(alertInfo); err != nil {
+			return err
+		}
+	}
+
+	httpMethod := w.HttpMethod
+	if len(httpMethod) == 0 {
+		httpMethod = common.HTTP_POST
+	}
+	req, err := http.NewRequest(httpMethod, w.WebhookUrl, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	contentType := w.ContentType
+	if len(contentType) == 0 {
+		contentType = common.APPLICATION_JSON
+	}
+	req.Header.Set(common.HEADER_CONTENT_TYPE, contentType)
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		responseData, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(fmt.Sprintf("Webhook returned status %s - %s", resp.Status, string(responseData)))
+	}
+	return nil
+}
+
+func getWebhookConfigs(ruleDefinitions common.RuleDefinitions) ([]WebhookConfig, error) {
+	webhookConfigs := make([]WebhookConfig, 0)
+	config, ok := ruleDefinitions.GetConfigurationMap()[common.WEBHOOK_CONFIGS]
+	if !ok || config.Value == nil {
+		return webhookConfigs, nil
+	}
+	// the configuration value is decoded as generic JSON, convert it to the webhook configs
+	value, err := json.Marshal(config.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(value, &webhookConfigs); err != nil {
+		return nil, errors.New("Invalid webhook configuration: " + err.Error())
+	}
+	validWebhookConfigs := make([]WebhookConfig, 0, len(webhookConfigs))
+	for _, webhookConfig := range webhookConfigs {
+		if len(webhookConfig.WebhookUrl) > 0 {
+			validWebhookConfigs = append(validWebhookConfigs, webhookConfig)
+		}
+	}
+	return validWebhookConfigs, nil
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package buffer
+
+const (
+	DefaultMaxSize = 100 * 1024 * 1024
+	DefaultMaxAge  = 24 * 60 * 60 * 1000
+)
+
+// Config controls the store-and-forward buffer placed in front of pipeline destinations.
+type Config struct {
+	Enabled bool  `toml:"enabled"`
+	MaxSize int64 `toml:"max-size"`
+	MaxAge  int64 `toml:"max-age"`
+}
+
+// NewConfig returns a new Config with default settings.
+func NewConfig() Config {
+	return Config{
+		Enabled: false,
+		MaxSize: DefaultMaxSize,
+		MaxAge:  DefaultMaxAge,
+	}
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package buffer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"github.com/streamsets/datacollector-edge/container/recordio/sdcrecord"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BATCH_FILE_SUFFIX = ".json"
+	TMP_FILE_PREFIX   = "_tmp_"
+)
+
+type batchEnvelope struct {
+	SourceOffset string                 `json:"sourceOffset"`
+	Records      []*sdcrecord.SDCRecord `json:"records"`
+}
+
+type entryFile struct {
+	seq     int64
+	created time.Time
+	size    int64
+}
+
+// DiskQueue is a Queue persisting every batch as a SDC JSON file in a directory,
+// the file name carries the sequence number and the creation time of the batch.
+type DiskQueue struct {
+	dir          string
+	config       Config
+	stageContext api.StageContext
+	entries      []entryFile
+	totalSize    int64
+	nextSeq      int64
+}
+
+func (q *DiskQueue) Enqueue(sourceOffset string, records []api.Record) error {
+	envelope := batchEnvelope{SourceOffset: sourceOffset, Records: make([]*sdcrecord.SDCRecord, len(records))}
+	for i, record := range records {
+		rootField, err := record.Get()
+		if err != nil {
+			return err
+		}
+		if hasFileRef(rootField) {
+			// the file a whole file record refers to is not part of the SDC JSON written to disk
+			return errors.New(fmt.Sprintf(
+				"Buffer '%s' can't hold whole file record '%s'",
+				q.dir,
+				record.GetHeader().GetSourceId(),
+			))
+		}
+		sdcRecord, err := sdcrecord.NewSdcRecordFromRecord(record)
+		if err != nil {
+			return err
+		}
+		envelope.Records[i] = sdcRecord
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	entry := entryFile{seq: q.nextSeq, created: time.Now(), size: int64(len(data))}
+	if q.config.MaxSize > 0 && len(q.entries) > 0 && q.totalSize+entry.size > q.config.MaxSize {
+		return errors.New(fmt.Sprintf("Buffer '%s' is full, it holds %d bytes already", q.dir, q.totalSize))
+	}
+	tmpPath := filepath.Join(q.dir, TMP_FILE_PREFIX+q.fileName(entry))
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, q.filePath(entry)); err != nil {
+		return err
+	}
+
+	q.nextSeq++
+	q.entries = append(q.entries, entry)
+	q.totalSize += entry.size
+	return nil
+}
+
+func (q *DiskQueue) Peek() (*Entry, error) {
+	if len(q.entries) == 0 {
+		return nil, nil
+	}
+	entry, err := q.read(q.entries[0])
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"Unreadable buffered batch '%s': %s",
+			q.filePath(q.entries[0]),
+			err.Error(),
+		))
+	}
+	return entry, nil
+}
+
+func (q *DiskQueue) Remove() error {
+	if len(q.entries) == 0 {
+		return nil
+	}
+	if err := os.Remove(q.filePath(q.entries[0])); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	q.totalSize -= q.entries[0].size
+	q.entries = q.entries[1:]
+	return nil
+}
+
+func (q *DiskQueue) Size() int {
+	return len(q.entries)
+}
+
+func (q *DiskQueue) OldestEntryAge() time.Duration {
+	if len(q.entries) == 0 {
+		return 0
+	}
+	return time.Since(q.entries[0].created)
+}
+
+func (q *DiskQueue) Close() error {
+	q.entries = nil
+	return nil
+}
+
+func (q *DiskQueue) read(entry entryFile) (*Entry, error) {
+	data, err := ioutil.ReadFile(q.filePath(entry))
+	if err != nil {
+		return nil, err
+	}
+	var envelope batchEnvelope
+	if err = json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	records := make([]api.Record, len(envelope.Records))
+	for i, sdcRecord := range envelope.Records {
+		if records[i], err = sdcrecord.NewRecordFromSDCRecord(q.stageContext, sdcRecord); err != nil {
+			return nil, err
+		}
+	}
+	return &Entry{SourceOffset: envelope.SourceOffset, Records: records, Created: entry.created}, nil
+}
+
+func (q *DiskQueue) load() error {
+	fileInfos, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range fileInfos {
+		name := fileInfo.Name()
+		if strings.HasPrefix(name, TMP_FILE_PREFIX) {
+			// batch was not completely written before a crash
+			os.Remove(filepath.Join(q.dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, BATCH_FILE_SUFFIX) {
+			continue
+		}
+		nameParts := strings.Split(strings.TrimSuffix(name, BATCH_FILE_SUFFIX), "_")
+		if len(nameParts) != 2 {
+			continue
+		}
+		seq, err := strconv.ParseInt(nameParts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		createdMillis, err := strconv.ParseInt(nameParts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		q.entries = append(q.entries, entryFile{
+			seq:     seq,
+			created: time.Unix(0, createdMillis*int64(time.Millisecond)),
+			size:    fileInfo.Size(),
+		})
+		q.totalSize += fileInfo.Size()
+	}
+	sort.Slice(q.entries, func(i, j int) bool {
+		return q.entries[i].seq < q.entries[j].seq
+	})
+	if len(q.entries) > 0 {
+		q.nextSeq = q.entries[len(q.entries)-1].seq + 1
+	}
+	return nil
+}
+
+// hasFileRef reports whether the field or any of its nested fields is a file reference
+func hasFileRef(field *api.Field) bool {
+	if field == nil {
+		return false
+	}
+	switch field.Type {
+	case fieldtype.FILE_REF:
+		return true
+	case fieldtype.MAP, fieldtype.LIST_MAP:
+		for _, value := range field.Value.(map[string]*api.Field) {
+			if hasFileRef(value) {
+				return true
+			}
+		}
+	case fieldtype.LIST:
+		for _, value := range field.Value.([]*api.Field) {
+			if hasFileRef(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (q *DiskQueue) fileName(entry entryFile) string {
+	return fmt.Sprintf("%020d_%d%s", entry.seq, entry.created.UnixNano()/int64(time.Millisecond), BATCH_FILE_SUFFIX)
+}
+
+func (q *DiskQueue) filePath(entry entryFile) string {
+	return filepath.Join(q.dir, q.fileName(entry))
+}
+
+// NewDiskQueue opens the queue stored in dir, creating the directory if needed and
+// picking up the batches left over by a previous run.
+func NewDiskQueue(dir string, config Config, stageContext api.StageContext) (*DiskQueue, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	q := &DiskQueue{
+		dir:          dir,
+		config:       config,
+		stageContext: stageContext,
+		entries:      make([]entryFile, 0),
+	}
+	return q, q.load()
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package buffer
+
+import (
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func createRecords(t *testing.T, stageContext api.StageContext, values ...string) []api.Record {
+	records := make([]api.Record, len(values))
+	for i, value := range values {
+		record, err := stageContext.CreateRecord("sourceId", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[i] = record
+	}
+	return records
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDiskQueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stageContext := &common.StageContextImpl{}
+	queue, err := NewDiskQueue(dir, NewConfig(), stageContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = queue.Enqueue("offset1", createRecords(t, stageContext, "a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err = queue.Enqueue("offset2", createRecords(t, stageContext, "c")); err != nil {
+		t.Fatal(err)
+	}
+	queue.Close()
+
+	// reopen to make sure the batches survive a restart
+	queue, err = NewDiskQueue(dir, NewConfig(), stageContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue.Size() != 2 {
+		t.Fatalf("Expected 2 buffered batches, but got %d", queue.Size())
+	}
+
+	entry, err := queue.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.SourceOffset != "offset1" || len(entry.Records) != 2 {
+		t.Errorf("Expected 'offset1' with 2 records, but got '%s' with %d", entry.SourceOffset, len(entry.Records))
+	}
+	value, _ := entry.Records[0].Get()
+	if value.Value != "a" {
+		t.Errorf("Expected value 'a', but got %v", value.Value)
+	}
+
+	if err = queue.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	entry, err = queue.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.SourceOffset != "offset2" || len(entry.Records) != 1 {
+		t.Errorf("Expected 'offset2' with 1 record, but got '%s' with %d", entry.SourceOffset, len(entry.Records))
+	}
+
+	queue.Remove()
+	if entry, _ = queue.Peek(); entry != nil || queue.Size() != 0 {
+		t.Error("Expected empty queue")
+	}
+}
+
+func TestDiskQueue_MaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDiskQueue_MaxSize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stageContext := &common.StageContextImpl{}
+	config := NewConfig()
+	config.MaxSize = 1
+	queue, err := NewDiskQueue(dir, config, stageContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = queue.Enqueue("offset1", createRecords(t, stageContext, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err = queue.Enqueue("offset2", createRecords(t, stageContext, "b")); err == nil {
+		t.Error("Expected full buffer to reject the batch")
+	}
+
+	if queue.Size() != 1 {
+		t.Fatalf("Expected 1 buffered batch, but got %d", queue.Size())
+	}
+	entry, _ := queue.Peek()
+	if entry.SourceOffset != "offset1" {
+		t.Errorf("Expected oldest batch to be kept, but got '%s'", entry.SourceOffset)
+	}
+}
+
+func TestDiskQueue_WholeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDiskQueue_WholeFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stageContext := &common.StageContextImpl{}
+	queue, err := NewDiskQueue(dir, NewConfig(), stageContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := createRecords(t, stageContext, "a")
+	fileRefField, _ := api.CreateFileRefField(nil)
+	records[0].Set(api.CreateMapFieldWithMapOfFields(map[string]*api.Field{"fileRef": fileRefField}))
+	if err = queue.Enqueue("offset1", records); err == nil {
+		t.Error("Expected whole file record to be rejected")
+	}
+	if queue.Size() != 0 {
+		t.Errorf("Expected empty queue, but got %d", queue.Size())
+	}
+}
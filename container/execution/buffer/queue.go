@@ -0,0 +1,50 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package buffer
+
+import (
+	"github.com/streamsets/datacollector-edge/api"
+	"time"
+)
+
+// Entry is a batch of records held by a Queue until the destination accepts it.
+type Entry struct {
+	SourceOffset string
+	Records      []api.Record
+	Created      time.Time
+}
+
+// Queue is a FIFO of batches waiting to be delivered to a destination.
+//
+// Enqueue appends a batch at the tail of the queue, it fails when the queue is full or can't hold the records.
+//
+// Peek returns the oldest batch in the queue without removing it, or nil if the queue is empty. It fails when
+// the oldest batch can't be read back, which should then be removed.
+//
+// Remove discards the oldest batch, it should be called only once the batch returned by Peek
+// has been delivered.
+//
+// Size returns the number of batches in the queue.
+//
+// OldestEntryAge returns how long the oldest batch has been waiting, zero if the queue is empty.
+type Queue interface {
+	Enqueue(sourceOffset string, records []api.Record) error
+	Peek() (*Entry, error)
+	Remove() error
+	Size() int
+	OldestEntryAge() time.Duration
+	Close() error
+}
@@ -15,17 +15,31 @@
  */
 package execution
 
+import (
+	"github.com/streamsets/datacollector-edge/container/execution/buffer"
+)
+
 const (
-	DefaultMaxBatchSize = 1000
+	DefaultMaxBatchSize      = 1000
+	DefaultRetryBaseInterval = 15 * 1000
+	DefaultRetryMaxInterval  = 5 * 60 * 1000
 )
 
 type Config struct {
-	MaxBatchSize int `toml:"max-batch-size"`
+	MaxBatchSize      int           `toml:"max-batch-size"`
+	RestorePipelines  bool          `toml:"restore-pipelines"`
+	RetryBaseInterval int64         `toml:"retry-base-interval"`
+	RetryMaxInterval  int64         `toml:"retry-max-interval"`
+	DestinationBuffer buffer.Config `toml:"destination-buffer"`
 }
 
 // NewConfig returns a new Config with default settings.
 func NewConfig() Config {
 	return Config{
-		MaxBatchSize: DefaultMaxBatchSize,
+		MaxBatchSize:      DefaultMaxBatchSize,
+		RestorePipelines:  true,
+		RetryBaseInterval: DefaultRetryBaseInterval,
+		RetryMaxInterval:  DefaultRetryMaxInterval,
+		DestinationBuffer: buffer.NewConfig(),
 	}
 }
@@ -22,10 +22,13 @@ import (
 
 type Manager interface {
 	GetRunner(pipelineId string) *runner.StandaloneRunner
+	GetRunners() []*runner.StandaloneRunner
 	StartPipeline(
 		pipelineId string,
 		runtimeParameters map[string]interface{},
 	) (*common.PipelineState, error)
 	StopPipeline(pipelineId string) (*common.PipelineState, error)
 	ResetOffset(pipelineId string) error
+	RestorePipelines() error
+	GetPreviewRunner(pipelineId string) (*runner.PreviewRunner, error)
 }
@@ -16,10 +16,13 @@
 package manager
 
 import (
+	"errors"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/execution"
 	"github.com/streamsets/datacollector-edge/container/execution/runner"
 	"github.com/streamsets/datacollector-edge/container/store"
+	"github.com/streamsets/datacollector-edge/container/util"
+	"log"
 )
 
 type PipelineManager struct {
@@ -40,6 +43,14 @@ func (p *PipelineManager) GetRunner(pipelineId string) *runner.StandaloneRunner
 	return p.runnerMap[pipelineId]
 }
 
+func (p *PipelineManager) GetRunners() []*runner.StandaloneRunner {
+	runners := make([]*runner.StandaloneRunner, 0, len(p.runnerMap))
+	for _, pRunner := range p.runnerMap {
+		runners = append(runners, pRunner)
+	}
+	return runners
+}
+
 func (p *PipelineManager) StartPipeline(
 	pipelineId string,
 	runtimeParameters map[string]interface{},
@@ -55,6 +66,35 @@ func (p *PipelineManager) ResetOffset(pipelineId string) error {
 	return p.GetRunner(pipelineId).ResetOffset()
 }
 
+// RestorePipelines restarts the pipelines the previous edge process left running, starting or waiting for a
+// retry, unless restoring pipelines is disabled.
+func (p *PipelineManager) RestorePipelines() error {
+	if !p.config.RestorePipelines {
+		return nil
+	}
+	pipelineInfoList, err := p.pipelineStoreTask.GetPipelines()
+	if err != nil {
+		return err
+	}
+	for _, pipelineInfo := range pipelineInfoList {
+		if _, err := p.GetRunner(pipelineInfo.PipelineId).RestorePipeline(); err != nil {
+			log.Printf("[ERROR] Failed to restore pipeline %s: %s", pipelineInfo.PipelineId, err)
+		}
+	}
+	return nil
+}
+
+func (p *PipelineManager) GetPreviewRunner(pipelineId string) (*runner.PreviewRunner, error) {
+	state, err := p.GetRunner(pipelineId).GetStatus()
+	if err != nil {
+		return nil, err
+	}
+	if util.Contains(runner.RESET_OFFSET_DISALLOWED_STATUSES, state.Status) {
+		return nil, errors.New("Cannot preview the pipeline when the pipeline is running")
+	}
+	return runner.NewPreviewRunner(pipelineId, p.config, p.pipelineStoreTask), nil
+}
+
 func NewManager(
 	config execution.Config,
 	runtimeInfo *common.RuntimeInfo,
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/container/execution/buffer"
+	"github.com/streamsets/datacollector-edge/container/util"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	BUFFER_QUEUE_DEPTH      = ".bufferQueueDepth"
+	BUFFER_OLDEST_ENTRY_AGE = ".bufferOldestEntryAge"
+	DESTINATION_BUFFER_DIR  = "buffer/"
+	BUFFER_RETRY_INTERVAL   = time.Second
+)
+
+// bufferedWriteTracker holds the offsets of the batches a destination buffer spooled, so that they are committed
+// only once the batches are delivered
+type bufferedWriteTracker interface {
+	bufferWrite(batchOffset *batchOffset)
+	completeBufferedWrite(batchOffset *batchOffset, delivered bool)
+}
+
+// DestinationBuffer sits between the last processor and a destination stage. Batches the destination
+// fails to write are spooled to the queue and delivered in order in the background once the destination
+// accepts data again, their offsets are committed on delivery.
+type DestinationBuffer struct {
+	stage    StageRuntime
+	runnerId int
+	config   buffer.Config
+	queue    buffer.Queue
+	tracker  bufferedWriteTracker
+	mutex    sync.Mutex
+	// offsets of the batches spooled in this run in queue order, behind the batches left over by a previous run
+	batchOffsets    []*batchOffset
+	leftoverBatches int
+	// batch that could not be delivered, reported by the next write
+	deliveryErr         error
+	queueDepthGauge     metrics.Gauge
+	oldestEntryAgeGauge metrics.Gauge
+	stop                chan struct{}
+	done                chan struct{}
+}
+
+func (d *DestinationBuffer) Init(metricRegistry metrics.Registry, metricsKey string) {
+	if d.runnerId > 0 {
+		// every runner has its own buffer, the runner id is reported as a label of the Prometheus metrics
+		metricsKey += util.RUNNER_SEPARATOR + strconv.Itoa(d.runnerId)
+	}
+	d.queueDepthGauge = util.CreateGauge(metricRegistry, metricsKey+BUFFER_QUEUE_DEPTH)
+	d.oldestEntryAgeGauge = util.CreateGauge(metricRegistry, metricsKey+BUFFER_OLDEST_ENTRY_AGE)
+	d.updateMetrics()
+	d.done = make(chan struct{})
+	go d.drain()
+}
+
+// Write writes the batch to the destination, or spools it when the destination fails or earlier batches are
+// still spooled. It fails when the batch can't be spooled or a spooled batch could not be delivered.
+func (d *DestinationBuffer) Write(batch *BatchImpl, batchOffset *batchOffset) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	defer d.updateMetrics()
+
+	if d.deliveryErr != nil {
+		err := d.deliveryErr
+		d.deliveryErr = nil
+		return err
+	}
+
+	if d.queue.Size() == 0 {
+		_, err := d.stage.Execute(batch.sourceOffset, -1, batch, nil)
+		if err == nil {
+			return nil
+		}
+		log.Printf(
+			"[WARN] Destination '%s' failed to write batch, buffering it: %s",
+			d.stage.config.InstanceName,
+			err.Error(),
+		)
+		d.stage.stageContext.ReportError(err)
+	}
+
+	if len(batch.records) == 0 {
+		return nil
+	}
+	if err := d.queue.Enqueue(batch.sourceOffset, batch.records); err != nil {
+		return err
+	}
+	d.batchOffsets = append(d.batchOffsets, batchOffset)
+	if batchOffset != nil {
+		d.tracker.bufferWrite(batchOffset)
+	}
+	return nil
+}
+
+// drain keeps delivering the spooled batches until the buffer is destroyed
+func (d *DestinationBuffer) drain() {
+	defer close(d.done)
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(BUFFER_RETRY_INTERVAL):
+		}
+		d.mutex.Lock()
+		if err := d.forward(); err != nil {
+			log.Printf("[ERROR] Error in buffer of destination '%s': %s", d.stage.config.InstanceName, err.Error())
+		}
+		d.updateMetrics()
+		d.mutex.Unlock()
+	}
+}
+
+// forward delivers the spooled batches in order until the destination fails or the queue is empty. Batches
+// that expired or can't be read back are removed without being delivered, the next write reports them.
+func (d *DestinationBuffer) forward() error {
+	maxAge := time.Duration(d.config.MaxAge) * time.Millisecond
+	for {
+		entry, err := d.queue.Peek()
+		if err != nil {
+			d.deliveryErr = err
+			if err = d.remove(false); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry == nil {
+			return nil
+		}
+
+		if maxAge > 0 && time.Since(entry.Created) > maxAge {
+			d.deliveryErr = errors.New(fmt.Sprintf(
+				"Batch buffered for destination '%s' was not delivered within %s",
+				d.stage.config.InstanceName,
+				maxAge,
+			))
+			if err = d.remove(false); err != nil {
+				return err
+			}
+			continue
+		}
+
+		batch := NewBatchImpl(d.stage.config.InstanceName, entry.Records, entry.SourceOffset)
+		if _, err = d.stage.Execute(entry.SourceOffset, -1, batch, nil); err != nil {
+			log.Printf(
+				"[DEBUG] Destination '%s' still unavailable, %d batch(es) buffered: %s",
+				d.stage.config.InstanceName,
+				d.queue.Size(),
+				err.Error(),
+			)
+			return nil
+		}
+		if err = d.remove(true); err != nil {
+			return err
+		}
+	}
+}
+
+// remove drops the oldest spooled batch and releases its offset
+func (d *DestinationBuffer) remove(delivered bool) error {
+	if !delivered {
+		log.Printf("[ERROR] Dropping batch buffered for destination '%s'", d.stage.config.InstanceName)
+	}
+	if err := d.queue.Remove(); err != nil {
+		return err
+	}
+	if d.leftoverBatches > 0 {
+		// spooled by a previous run, its offset was never committed
+		d.leftoverBatches--
+		return nil
+	}
+	if len(d.batchOffsets) > 0 {
+		batchOffset := d.batchOffsets[0]
+		d.batchOffsets = d.batchOffsets[1:]
+		if batchOffset != nil {
+			d.tracker.completeBufferedWrite(batchOffset, delivered)
+		}
+	}
+	return nil
+}
+
+func (d *DestinationBuffer) updateMetrics() {
+	if d.queueDepthGauge != nil {
+		d.queueDepthGauge.Update(int64(d.queue.Size()))
+		d.oldestEntryAgeGauge.Update(int64(d.queue.OldestEntryAge() / time.Millisecond))
+	}
+}
+
+func (d *DestinationBuffer) Destroy() {
+	close(d.stop)
+	if d.done != nil {
+		<-d.done
+	}
+	if err := d.queue.Close(); err != nil {
+		log.Printf("[ERROR] Error closing buffer for destination '%s': %s", d.stage.config.InstanceName, err.Error())
+	}
+}
+
+func NewDestinationBuffer(
+	stage StageRuntime,
+	runnerId int,
+	config buffer.Config,
+	dir string,
+	tracker bufferedWriteTracker,
+) (*DestinationBuffer, error) {
+	queue, err := buffer.NewDiskQueue(dir, config, stage.stageContext)
+	if err != nil {
+		return nil, err
+	}
+	return &DestinationBuffer{
+		stage:           stage,
+		runnerId:        runnerId,
+		config:          config,
+		queue:           queue,
+		tracker:         tracker,
+		leftoverBatches: queue.Size(),
+		stop:            make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"errors"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/buffer"
+	"github.com/streamsets/datacollector-edge/container/util"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testDestination struct {
+	mutex   sync.Mutex
+	failing bool
+	written int
+}
+
+func (d *testDestination) Init(stageContext api.StageContext) error {
+	return nil
+}
+
+func (d *testDestination) Write(batch api.Batch) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.failing {
+		return errors.New("destination unavailable")
+	}
+	d.written += len(batch.GetRecords())
+	return nil
+}
+
+func (d *testDestination) Destroy() error {
+	return nil
+}
+
+func (d *testDestination) setFailing(failing bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.failing = failing
+}
+
+func (d *testDestination) getWritten() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.written
+}
+
+type testWriteTracker struct {
+	mutex     sync.Mutex
+	buffered  int
+	delivered int
+	dropped   int
+}
+
+func (t *testWriteTracker) bufferWrite(batchOffset *batchOffset) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.buffered++
+}
+
+func (t *testWriteTracker) completeBufferedWrite(batchOffset *batchOffset, delivered bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if delivered {
+		t.delivered++
+	} else {
+		t.dropped++
+	}
+}
+
+func (t *testWriteTracker) get() (int, int, int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.buffered, t.delivered, t.dropped
+}
+
+func createTestDestinationBuffer(
+	t *testing.T,
+	runnerId int,
+	config buffer.Config,
+	destination *testDestination,
+	tracker bufferedWriteTracker,
+) (*DestinationBuffer, *common.StageContextImpl, string) {
+	dir, err := ioutil.TempDir("", "TestDestinationBuffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageConfig := common.StageConfiguration{
+		InstanceName: "destination",
+		UiInfo:       map[string]interface{}{creation.STAGE_TYPE: creation.TARGET},
+	}
+	stageContext := &common.StageContextImpl{StageConfig: stageConfig, ErrorSink: common.NewErrorSink()}
+	stageRuntime := NewStageRuntime(
+		creation.PipelineBean{},
+		creation.StageBean{Config: stageConfig, Stage: destination},
+		stageContext,
+	)
+	destinationBuffer, err := NewDestinationBuffer(stageRuntime, runnerId, config, dir, tracker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return destinationBuffer, stageContext, dir
+}
+
+func createTestBatch(t *testing.T, stageContext api.StageContext) *BatchImpl {
+	record, err := stageContext.CreateRecord("sourceId", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewBatchImpl("destination", []api.Record{record}, "offset")
+}
+
+// waitFor polls the condition for a few drain intervals
+func waitFor(condition func() bool) bool {
+	for i := 0; i < 40; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
+func TestDestinationBuffer_Write(t *testing.T) {
+	destination := &testDestination{failing: true}
+	tracker := &testWriteTracker{}
+	destinationBuffer, stageContext, dir := createTestDestinationBuffer(t, 0, buffer.NewConfig(), destination, tracker)
+	defer os.RemoveAll(dir)
+	destinationBuffer.Init(metrics.NewRegistry(), "stage.destination")
+	defer destinationBuffer.Destroy()
+
+	if err := destinationBuffer.Write(createTestBatch(t, stageContext), &batchOffset{}); err != nil {
+		t.Fatal(err)
+	}
+	if buffered, delivered, _ := tracker.get(); buffered != 1 || delivered != 0 {
+		t.Errorf("Expected the batch to be buffered, but got %d buffered and %d delivered", buffered, delivered)
+	}
+	if len(stageContext.ErrorSink.GetStageErrorMessages("destination")) != 1 {
+		t.Error("Expected the destination failure to be reported")
+	}
+
+	// delivered in the background once the destination is back
+	destination.setFailing(false)
+	if !waitFor(func() bool { _, delivered, _ := tracker.get(); return delivered == 1 }) {
+		t.Fatal("Expected the buffered batch to be delivered")
+	}
+	if destination.getWritten() != 1 {
+		t.Errorf("Expected 1 record written, but got %d", destination.getWritten())
+	}
+}
+
+func TestDestinationBuffer_MaxAge(t *testing.T) {
+	destination := &testDestination{failing: true}
+	tracker := &testWriteTracker{}
+	config := buffer.NewConfig()
+	config.MaxAge = 10
+	destinationBuffer, stageContext, dir := createTestDestinationBuffer(t, 0, config, destination, tracker)
+	defer os.RemoveAll(dir)
+	destinationBuffer.Init(metrics.NewRegistry(), "stage.destination")
+	defer destinationBuffer.Destroy()
+
+	if err := destinationBuffer.Write(createTestBatch(t, stageContext), &batchOffset{}); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(func() bool { _, _, dropped := tracker.get(); return dropped == 1 }) {
+		t.Fatal("Expected the expired batch to be dropped")
+	}
+	if err := destinationBuffer.Write(createTestBatch(t, stageContext), &batchOffset{}); err == nil {
+		t.Error("Expected the next write to fail for the expired batch")
+	}
+}
+
+func TestDestinationBuffer_RunnerMetrics(t *testing.T) {
+	metricRegistry := metrics.NewRegistry()
+	for runnerId := 0; runnerId < 2; runnerId++ {
+		destinationBuffer, _, dir := createTestDestinationBuffer(
+			t,
+			runnerId,
+			buffer.NewConfig(),
+			&testDestination{},
+			&testWriteTracker{},
+		)
+		defer os.RemoveAll(dir)
+		destinationBuffer.Init(metricRegistry, "stage.destination")
+		defer destinationBuffer.Destroy()
+	}
+
+	for _, name := range []string{"stage.destination.bufferQueueDepth", "stage.destination" + util.RUNNER_SEPARATOR + "1.bufferQueueDepth"} {
+		if metricRegistry.Get(name+".gauge") == nil {
+			t.Errorf("Expected gauge '%s' to be registered", name)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package runner
 
 import (
 	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/container/creation"
 	"github.com/streamsets/datacollector-edge/container/execution"
 	"github.com/streamsets/datacollector-edge/container/util"
 	"github.com/streamsets/datacollector-edge/container/validation"
@@ -35,7 +36,13 @@ const (
 type Pipe interface {
 	Init() []validation.Issue
 	Process(pipeBatch *FullPipeBatch) error
+	Commit(offset string) error
+	Reject(offset string) error
 	Destroy()
+	GetInstanceName() string
+	GetSystemConfigs() creation.StageConfigBean
+	MarkRetry()
+	ToError(pipeBatch *FullPipeBatch, err error)
 	IsSource() bool
 	IsProcessor() bool
 	IsTarget() bool
@@ -60,8 +67,11 @@ type StagePipe struct {
 	errorRecordsHistogram       metrics.Histogram
 	stageErrorsHistogram        metrics.Histogram
 	processingTimer             metrics.Timer
+	stageRetriesCounter         metrics.Counter
+	stageRetriesMeter           metrics.Meter
 	outputRecordsPerLaneCounter map[string]metrics.Counter
 	outputRecordsPerLaneMeter   map[string]metrics.Meter
+	destinationBuffer           *DestinationBuffer
 }
 
 func (s *StagePipe) Init() []validation.Issue {
@@ -87,6 +97,13 @@ func (s *StagePipe) Init() []validation.Issue {
 
 		s.processingTimer = util.CreateTimer(metricRegistry, metricsKey+BATCH_PROCESSING)
 
+		s.stageRetriesCounter = util.CreateCounter(metricRegistry, metricsKey+STAGE_RETRIES)
+		s.stageRetriesMeter = util.CreateMeter(metricRegistry, metricsKey+STAGE_RETRIES)
+
+		if s.destinationBuffer != nil {
+			s.destinationBuffer.Init(metricRegistry, metricsKey)
+		}
+
 		if len(s.Stage.config.OutputLanes) > 0 {
 			s.outputRecordsPerLaneCounter = make(map[string]metrics.Counter)
 			s.outputRecordsPerLaneMeter = make(map[string]metrics.Meter)
@@ -107,7 +124,15 @@ func (s *StagePipe) Process(pipeBatch *FullPipeBatch) error {
 	start := time.Now()
 	batchMaker := pipeBatch.StartStage(*s)
 	batchImpl := pipeBatch.GetBatch(*s)
-	newOffset, err := s.Stage.Execute(pipeBatch.GetPreviousOffset(), s.config.MaxBatchSize, batchImpl, batchMaker)
+	var newOffset string
+	var err error
+	if s.IsTarget() && pipeBatch.skipTargets {
+		log.Println("[DEBUG] Skipping destination write - " + s.Stage.config.InstanceName)
+	} else if s.destinationBuffer != nil {
+		err = s.destinationBuffer.Write(batchImpl, pipeBatch.batchOffset)
+	} else {
+		newOffset, err = s.Stage.Execute(pipeBatch.GetPreviousOffset(), s.config.MaxBatchSize, batchImpl, batchMaker)
+	}
 
 	if err != nil {
 		return err
@@ -162,10 +187,43 @@ func (s *StagePipe) Process(pipeBatch *FullPipeBatch) error {
 	return nil
 }
 
+func (s *StagePipe) Commit(offset string) error {
+	return s.Stage.Commit(offset)
+}
+
+func (s *StagePipe) Reject(offset string) error {
+	return s.Stage.Reject(offset)
+}
+
 func (s *StagePipe) Destroy() {
+	if s.destinationBuffer != nil {
+		// stops delivering the buffered batches before the destination goes away
+		s.destinationBuffer.Destroy()
+	}
 	s.Stage.Destroy()
 }
 
+func (s *StagePipe) GetInstanceName() string {
+	return s.Stage.config.InstanceName
+}
+
+func (s *StagePipe) GetSystemConfigs() creation.StageConfigBean {
+	return s.Stage.stageBean.SystemConfigs
+}
+
+func (s *StagePipe) MarkRetry() {
+	s.stageRetriesCounter.Inc(1)
+	s.stageRetriesMeter.Mark(1)
+}
+
+// ToError reports the stage error and sends the input records of the stage to the error sink
+func (s *StagePipe) ToError(pipeBatch *FullPipeBatch, err error) {
+	s.Stage.stageContext.ReportError(err)
+	for _, record := range pipeBatch.getStageInputRecords(*s) {
+		s.Stage.stageContext.ToError(err, record)
+	}
+}
+
 func (s *StagePipe) IsSource() bool {
 	return s.Stage.stageBean.IsSource()
 }
@@ -178,10 +236,11 @@ func (s *StagePipe) IsTarget() bool {
 	return s.Stage.stageBean.IsTarget()
 }
 
-func NewStagePipe(stage StageRuntime, config execution.Config) Pipe {
+func NewStagePipe(stage StageRuntime, config execution.Config, destinationBuffer *DestinationBuffer) Pipe {
 	stagePipe := &StagePipe{}
 	stagePipe.config = config
 	stagePipe.Stage = stage
+	stagePipe.destinationBuffer = destinationBuffer
 	stagePipe.InputLanes = stage.config.InputLanes
 	stagePipe.OutputLanes = stage.config.OutputLanes
 	stagePipe.EventLanes = stage.config.EventLanes
@@ -18,6 +18,8 @@ package runner
 import (
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/execution/alerts"
+	"time"
 )
 
 type PipeBatch interface {
@@ -33,7 +35,7 @@ type PipeBatch interface {
 	OverrideStageOutput(pipe StagePipe, stageOutput StageOutput)
 	GetSnapshotsOfAllStagesOutput() []StageOutput
 	GetErrorSink() *common.ErrorSink
-	GetEventSink() EventSink
+	GetEventSink() *common.EventSink
 	MoveLane(inputLane string, outputLane string)
 	MoveLaneCopying(inputLane string, outputLanes []string)
 	CombineLanes(lanes []string, to string)
@@ -44,12 +46,30 @@ type PipeBatch interface {
 }
 
 type FullPipeBatch struct {
-	offsetTracker SourceOffsetTracker
-	batchSize     int
-	fullPayload   map[string][]api.Record
+	previousOffset string
+	newOffset      string
+	batchSize      int
+	fullPayload    map[string][]api.Record
+	// event records by event lane, kept for the whole batch as the stages reading them may come later
+	eventPayload  map[string][]api.Record
 	inputRecords  int64
 	outputRecords int64
 	errorSink     *common.ErrorSink
+	eventSink     *common.EventSink
+	startTime     time.Time
+	// errors reported by the origin, handed over to the error sink of the pipe runner processing the batch
+	sourceErrorRecords  []api.Record
+	sourceErrorMessages []error
+	// preview and snapshot support
+	captureStageOutputs bool
+	snapshotCapture     *SnapshotCapture
+	skipTargets         bool
+	stageInput          []api.Record
+	stageOutputs        []StageOutput
+	// data rules observing the records produced on the lanes
+	rulesEvaluator *alerts.RulesEvaluator
+	// tells when the offset of the batch can be committed
+	batchOffset *batchOffset
 }
 
 func (b *FullPipeBatch) GetBatchSize() int {
@@ -57,14 +77,30 @@ func (b *FullPipeBatch) GetBatchSize() int {
 }
 
 func (b *FullPipeBatch) GetPreviousOffset() string {
-	return b.offsetTracker.GetOffset()
+	return b.previousOffset
 }
 
 func (b *FullPipeBatch) SetNewOffset(newOffset string) {
-	b.offsetTracker.SetOffset(newOffset)
+	b.newOffset = newOffset
+}
+
+func (b *FullPipeBatch) GetNewOffset() string {
+	return b.newOffset
 }
 
 func (b *FullPipeBatch) GetBatch(pipe StagePipe) *BatchImpl {
+	records := b.getStageInputRecords(pipe)
+	if pipe.IsTarget() && b.fullPayload != nil {
+		b.outputRecords += int64(len(records))
+	}
+	if b.captureStageOutputs {
+		// processors may update the input records in place, so keep a copy of them
+		b.stageInput = cloneRecords(records)
+	}
+	return NewBatchImpl(pipe.Stage.config.InstanceName, records, b.previousOffset)
+}
+
+func (b *FullPipeBatch) getStageInputRecords(pipe StagePipe) []api.Record {
 	records := make([]api.Record, 0)
 	for _, inputLane := range pipe.InputLanes {
 		if len(b.fullPayload[inputLane]) > 0 {
@@ -72,12 +108,9 @@ func (b *FullPipeBatch) GetBatch(pipe StagePipe) *BatchImpl {
 				records = append(records, record)
 			}
 		}
+		records = append(records, b.eventPayload[inputLane]...)
 	}
-
-	if pipe.IsTarget() && b.fullPayload != nil {
-		b.outputRecords += int64(len(records))
-	}
-	return NewBatchImpl(pipe.Stage.config.InstanceName, records, b.offsetTracker.GetOffset())
+	return records
 }
 
 func (b *FullPipeBatch) StartStage(pipe StagePipe) *BatchMakerImpl {
@@ -85,6 +118,33 @@ func (b *FullPipeBatch) StartStage(pipe StagePipe) *BatchMakerImpl {
 }
 
 func (b *FullPipeBatch) CompleteStage(batchMaker *BatchMakerImpl) {
+	events := b.routeEvents(batchMaker.stagePipe)
+	if b.captureStageOutputs {
+		// next stages may update the records in place as well
+		instanceName := batchMaker.stagePipe.Stage.config.InstanceName
+		output := make(map[string][]api.Record)
+		for lane, records := range batchMaker.stageOutput {
+			output[lane] = cloneRecords(records)
+		}
+		for lane, records := range events {
+			output[lane] = cloneRecords(records)
+		}
+		b.stageOutputs = append(b.stageOutputs, NewStageOutput(
+			instanceName,
+			b.stageInput,
+			output,
+			cloneRecords(b.errorSink.GetStageErrorRecords(instanceName)),
+			b.errorSink.GetStageErrorMessages(instanceName),
+		))
+	}
+	if b.rulesEvaluator != nil {
+		for lane, records := range batchMaker.stageOutput {
+			b.rulesEvaluator.ObserveLane(lane, records)
+		}
+		for lane, records := range events {
+			b.rulesEvaluator.ObserveLane(lane, records)
+		}
+	}
 	if batchMaker.stagePipe.IsSource() {
 		b.inputRecords += batchMaker.GetSize() +
 			int64(len(b.errorSink.GetStageErrorRecords(batchMaker.stagePipe.Stage.config.InstanceName)))
@@ -98,10 +158,54 @@ func (b *FullPipeBatch) CompleteStage(batchMaker *BatchMakerImpl) {
 	}
 }
 
+// routeEvents moves the events produced by the stage from the event sink to the event lanes of the stage
+func (b *FullPipeBatch) routeEvents(pipe StagePipe) map[string][]api.Record {
+	events := make(map[string][]api.Record)
+	if b.eventSink == nil {
+		return events
+	}
+	stageEvents := b.eventSink.DrainStageEvents(pipe.Stage.config.InstanceName)
+	if len(stageEvents) == 0 {
+		return events
+	}
+	if b.eventPayload == nil {
+		b.eventPayload = make(map[string][]api.Record)
+	}
+	for i, eventLane := range pipe.EventLanes {
+		laneEvents := stageEvents
+		if i > 0 {
+			laneEvents = cloneRecords(stageEvents)
+		}
+		b.eventPayload[eventLane] = append(b.eventPayload[eventLane], laneEvents...)
+		events[eventLane] = laneEvents
+	}
+	return events
+}
+
+// resetStage drops the output records, errors and events a failed attempt of the stage added to the batch, so
+// that retrying the stage or applying its on failure policy starts over from the input of the stage
+func (b *FullPipeBatch) resetStage(instanceName string, outputRecords int64) {
+	b.outputRecords = outputRecords
+	if b.errorSink != nil {
+		b.errorSink.ClearStageErrorRecordsAndMessages(instanceName)
+	}
+	if b.eventSink != nil {
+		b.eventSink.DrainStageEvents(instanceName)
+	}
+}
+
+func (b *FullPipeBatch) GetSnapshotsOfAllStagesOutput() []StageOutput {
+	return b.stageOutputs
+}
+
 func (b *FullPipeBatch) GetErrorSink() *common.ErrorSink {
 	return b.errorSink
 }
 
+func (b *FullPipeBatch) GetEventSink() *common.EventSink {
+	return b.eventSink
+}
+
 func (b *FullPipeBatch) GetInputRecords() int64 {
 	return b.inputRecords
 }
@@ -118,10 +222,18 @@ func (b *FullPipeBatch) GetErrorMessages() int64 {
 	return b.errorSink.GetTotalErrorMessages()
 }
 
-func NewFullPipeBatch(tracker SourceOffsetTracker, batchSize int, errorSink *common.ErrorSink) *FullPipeBatch {
+func NewFullPipeBatch(
+	previousOffset string,
+	batchSize int,
+	errorSink *common.ErrorSink,
+	eventSink *common.EventSink,
+) *FullPipeBatch {
 	return &FullPipeBatch{
-		offsetTracker: tracker,
-		batchSize:     batchSize,
-		errorSink:     errorSink,
+		previousOffset: previousOffset,
+		newOffset:      previousOffset,
+		batchSize:      batchSize,
+		errorSink:      errorSink,
+		eventSink:      eventSink,
+		startTime:      time.Now(),
 	}
 }
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution"
+	"testing"
+)
+
+func createTestStagePipe(stageConfig common.StageConfiguration, stageType string) StagePipe {
+	stageConfig.UiInfo = map[string]interface{}{creation.STAGE_TYPE: stageType}
+	stageRuntime := StageRuntime{config: stageConfig, stageBean: creation.StageBean{Config: stageConfig}}
+	return *NewStagePipe(stageRuntime, execution.Config{}, nil).(*StagePipe)
+}
+
+func TestFullPipeBatch_EventLanes(t *testing.T) {
+	errorSink := common.NewErrorSink()
+	eventSink := common.NewEventSink()
+
+	originPipe := createTestStagePipe(common.StageConfiguration{
+		InstanceName: "origin",
+		OutputLanes:  []string{"originOutput"},
+		EventLanes:   []string{"originEvents"},
+	}, creation.SOURCE)
+	processorPipe := createTestStagePipe(common.StageConfiguration{
+		InstanceName: "processor",
+		InputLanes:   []string{"originOutput"},
+		OutputLanes:  []string{"processorOutput"},
+	}, creation.PROCESSOR)
+	eventTargetPipe := createTestStagePipe(common.StageConfiguration{
+		InstanceName: "eventTarget",
+		InputLanes:   []string{"originEvents"},
+	}, creation.TARGET)
+
+	originContext := &common.StageContextImpl{
+		StageConfig: originPipe.Stage.config,
+		ErrorSink:   errorSink,
+		EventSink:   eventSink,
+	}
+	processorContext := &common.StageContextImpl{
+		StageConfig: processorPipe.Stage.config,
+		ErrorSink:   errorSink,
+		EventSink:   eventSink,
+	}
+
+	pipeBatch := NewFullPipeBatch("", 1, errorSink, eventSink)
+	pipeBatch.captureStageOutputs = true
+
+	batchMaker := pipeBatch.StartStage(originPipe)
+	pipeBatch.GetBatch(originPipe)
+	record, _ := originContext.CreateRecord("record", "value")
+	batchMaker.AddRecord(record)
+	event, _ := originContext.CreateEventRecord("event", map[string]interface{}{"a": "b"}, "new-file", 1)
+	originContext.ToEvent(event)
+	pipeBatch.CompleteStage(batchMaker)
+
+	batchMaker = pipeBatch.StartStage(processorPipe)
+	if records := pipeBatch.GetBatch(processorPipe).GetRecords(); len(records) != 1 || records[0] == event {
+		t.Errorf("Expected the processor to only read the origin output, but got %v", records)
+	}
+	// events of stages without event lane are dropped
+	processorEvent, _ := processorContext.CreateEventRecord("event", "value", "processed", 1)
+	processorContext.ToEvent(processorEvent)
+	pipeBatch.CompleteStage(batchMaker)
+
+	batchMaker = pipeBatch.StartStage(eventTargetPipe)
+	records := pipeBatch.GetBatch(eventTargetPipe).GetRecords()
+	if len(records) != 1 || records[0] != event {
+		t.Errorf("Expected the event target to read the origin event, but got %v", records)
+	}
+	pipeBatch.CompleteStage(batchMaker)
+
+	if events := eventSink.DrainStageEvents("processor"); len(events) != 0 {
+		t.Errorf("Expected the processor event to be dropped, but got %v", events)
+	}
+	stageOutputs := pipeBatch.GetSnapshotsOfAllStagesOutput()
+	if len(stageOutputs) != 3 || len(stageOutputs[0].Output["originEvents"]) != 1 {
+		t.Errorf("Expected the origin events in the snapshot, but got %v", stageOutputs)
+	}
+}
+
+func TestEventSink_EventsOutsideOfBatch(t *testing.T) {
+	eventSink := common.NewEventSink()
+	targetPipe := createTestStagePipe(common.StageConfiguration{
+		InstanceName: "target",
+		EventLanes:   []string{"targetEvents1", "targetEvents2"},
+	}, creation.TARGET)
+	targetContext := &common.StageContextImpl{StageConfig: targetPipe.Stage.config, EventSink: eventSink}
+
+	// like a file closed by a timer between two batches
+	event, _ := targetContext.CreateEventRecord("event", "value", "file-closed", 1)
+	targetContext.ToEvent(event)
+
+	pipeBatch := NewFullPipeBatch("", 1, common.NewErrorSink(), eventSink)
+	pipeBatch.CompleteStage(pipeBatch.StartStage(targetPipe))
+
+	for _, lane := range []string{"targetEvents1", "targetEvents2"} {
+		if events := pipeBatch.eventPayload[lane]; len(events) != 1 {
+			t.Errorf("Expected 1 event on lane '%s', but got %d", lane, len(events))
+		}
+	}
+	if pipeBatch.eventPayload["targetEvents1"][0] == pipeBatch.eventPayload["targetEvents2"][0] {
+		t.Error("Expected the events to be cloned for every event lane")
+	}
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution"
+	"github.com/streamsets/datacollector-edge/container/execution/store"
+	"github.com/streamsets/datacollector-edge/container/validation"
+	"log"
+)
+
+// PipeRunner runs the processors, destinations and error stage of the pipeline for the batches produced by
+// the origin. In multithreaded mode every runner has its own stage instances and error sink, the offsets of the
+// batches are committed by the pipeline in the order they were produced.
+type PipeRunner struct {
+	runnerId          int
+	pipeline          *Pipeline
+	pipelineBean      creation.PipelineBean
+	pipes             []Pipe
+	errorStageRuntime StageRuntime
+	errorSink         *common.ErrorSink
+	eventSink         *common.EventSink
+}
+
+func (r *PipeRunner) Init() []validation.Issue {
+	var issues []validation.Issue
+	for _, stagePipe := range r.pipes {
+		stageIssues := stagePipe.Init()
+		issues = append(issues, stageIssues...)
+	}
+
+	errorStageissues := r.errorStageRuntime.Init()
+	issues = append(issues, errorStageissues...)
+
+	return issues
+}
+
+func (r *PipeRunner) runBatch(pipeBatch *FullPipeBatch) error {
+	committed := false
+	skipCommit := false
+	p := r.pipeline
+
+	r.errorSink.ClearErrorRecordsAndMesssages()
+	sourceInstanceName := p.sourcePipe.GetInstanceName()
+	for _, err := range pipeBatch.sourceErrorMessages {
+		r.errorSink.ReportError(sourceInstanceName, err)
+	}
+	for _, record := range pipeBatch.sourceErrorRecords {
+		r.errorSink.ToError(sourceInstanceName, record)
+	}
+	pipeBatch.errorSink = r.errorSink
+	pipeBatch.eventSink = r.eventSink
+
+	for _, pipe := range r.pipes {
+		if r.pipelineBean.Config.DeliveryGuarantee == AT_MOST_ONCE &&
+			pipe.IsTarget() && // if destination
+			!committed {
+			if err := p.commitBatch(pipeBatch, true); err != nil {
+				return err
+			}
+			committed = true
+		}
+
+		failed, err := p.processPipe(pipe, pipeBatch)
+		if err != nil {
+			return err
+		}
+		if failed && p.isStopped() {
+			// the batch was interrupted, it is produced again from its previous offset on the next run
+			return nil
+		}
+		if failed && pipe.IsTarget() {
+			// the destination did not write the batch, so its offset must not be committed
+			skipCommit = true
+		}
+	}
+
+	errorRecords := make([]api.Record, 0)
+	for _, stageBean := range r.pipelineBean.Stages {
+		errorRecordsForThisStage := r.errorSink.GetStageErrorRecords(stageBean.Config.InstanceName)
+		if errorRecordsForThisStage != nil && len(errorRecordsForThisStage) > 0 {
+			switch stageBean.SystemConfigs.StageOnRecordError {
+			case creation.ON_RECORD_ERROR_DISCARD:
+				log.Printf(
+					"[DEBUG] Discarding %d error records from stage '%s'",
+					len(errorRecordsForThisStage),
+					stageBean.Config.InstanceName,
+				)
+			case creation.ON_RECORD_ERROR_STOP_PIPELINE:
+				return errors.New(fmt.Sprintf(
+					"Stage '%s' produced %d error records",
+					stageBean.Config.InstanceName,
+					len(errorRecordsForThisStage),
+				))
+			default:
+				errorRecords = append(errorRecords, errorRecordsForThisStage...)
+			}
+		}
+	}
+	if len(errorRecords) > 0 && !pipeBatch.skipTargets {
+		previousOffset := pipeBatch.GetPreviousOffset()
+		batch := NewBatchImpl(r.errorStageRuntime.config.InstanceName, errorRecords, previousOffset)
+		_, err := r.errorStageRuntime.Execute(previousOffset, -1, batch, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !committed {
+		if err := p.commitBatch(pipeBatch, !skipCommit); err != nil {
+			return err
+		}
+	}
+
+	p.batchProcessingTimer.UpdateSince(pipeBatch.startTime)
+	p.batchCountCounter.Inc(1)
+	p.batchCountMeter.Mark(1)
+
+	p.batchInputRecordsCounter.Inc(pipeBatch.GetInputRecords())
+	p.batchOutputRecordsCounter.Inc(pipeBatch.GetOutputRecords())
+	p.batchErrorMessagesCounter.Inc(pipeBatch.GetErrorMessages())
+	p.batchErrorRecordsCounter.Inc(pipeBatch.GetErrorRecords())
+
+	p.batchInputRecordsMeter.Mark(pipeBatch.GetInputRecords())
+	p.batchOutputRecordsMeter.Mark(pipeBatch.GetOutputRecords())
+	p.batchErrorMessagesMeter.Mark(pipeBatch.GetErrorMessages())
+	p.batchErrorRecordsMeter.Mark(pipeBatch.GetErrorRecords())
+
+	p.batchInputRecordsHistogram.Update(pipeBatch.GetInputRecords())
+	p.batchOutputRecordsHistogram.Update(pipeBatch.GetOutputRecords())
+	p.batchErrorMessagesHistogram.Update(pipeBatch.GetErrorMessages())
+	p.batchErrorRecordsHistogram.Update(pipeBatch.GetErrorRecords())
+
+	return nil
+}
+
+func (r *PipeRunner) Destroy() {
+	for _, stagePipe := range r.pipes {
+		stagePipe.Destroy()
+	}
+	r.errorStageRuntime.Destroy()
+}
+
+func NewPipeRunner(
+	runnerId int,
+	pipeline *Pipeline,
+	config execution.Config,
+	pipelineBean creation.PipelineBean,
+	resolvedParameters map[string]interface{},
+	metricRegistry metrics.Registry,
+) (*PipeRunner, error) {
+	var err error
+	errorSink := common.NewErrorSink()
+	eventSink := common.NewEventSink()
+	pipes := make([]Pipe, 0)
+
+	for _, stageBean := range pipelineBean.Stages {
+		if stageBean.IsSource() {
+			// the origin is shared by all the runners
+			continue
+		}
+		stageContext := &common.StageContextImpl{
+			StageConfig: stageBean.Config,
+			Parameters:  resolvedParameters,
+			Metrics:     metricRegistry,
+			ErrorSink:   errorSink,
+			EventSink:   eventSink,
+			ErrorStage:  false,
+			Stopped:     pipeline.stopped,
+		}
+		stageRuntime := NewStageRuntime(pipelineBean, stageBean, stageContext)
+
+		var destinationBuffer *DestinationBuffer
+		if stageBean.IsTarget() && config.DestinationBuffer.Enabled {
+			bufferDir := store.GetRunInfoDir(pipeline.pipelineId) + DESTINATION_BUFFER_DIR +
+				stageBean.Config.InstanceName
+			if runnerId > 0 {
+				bufferDir += fmt.Sprintf(".%d", runnerId)
+			}
+			destinationBuffer, err = NewDestinationBuffer(
+				stageRuntime,
+				runnerId,
+				config.DestinationBuffer,
+				bufferDir,
+				pipeline,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pipes = append(pipes, NewStagePipe(stageRuntime, config, destinationBuffer))
+	}
+
+	log.Println("[DEBUG] Error Stage:", pipelineBean.ErrorStage.Config.InstanceName)
+	errorStageContext := &common.StageContextImpl{
+		StageConfig: pipelineBean.ErrorStage.Config,
+		Parameters:  resolvedParameters,
+		Metrics:     metricRegistry,
+		ErrorSink:   errorSink,
+		ErrorStage:  true,
+		Stopped:     pipeline.stopped,
+	}
+
+	return &PipeRunner{
+		runnerId:          runnerId,
+		pipeline:          pipeline,
+		pipelineBean:      pipelineBean,
+		pipes:             pipes,
+		errorStageRuntime: NewStageRuntime(pipelineBean, pipelineBean.ErrorStage, errorStageContext),
+		errorSink:         errorSink,
+		eventSink:         eventSink,
+	}, nil
+}
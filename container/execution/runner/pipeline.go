@@ -16,28 +16,52 @@
 package runner
 
 import (
+	"errors"
+	"fmt"
 	"github.com/rcrowley/go-metrics"
-	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/creation"
 	"github.com/streamsets/datacollector-edge/container/execution"
+	"github.com/streamsets/datacollector-edge/container/execution/alerts"
 	"github.com/streamsets/datacollector-edge/container/util"
 	"github.com/streamsets/datacollector-edge/container/validation"
 	"log"
+	"sync"
 	"time"
 )
 
 type Pipeline struct {
-	name              string
-	config            execution.Config
-	standaloneRunner  *StandaloneRunner
-	pipelineConf      common.PipelineConfiguration
-	pipelineBean      creation.PipelineBean
-	pipes             []Pipe
-	errorStageRuntime StageRuntime
-	offsetTracker     SourceOffsetTracker
-	stop              bool
-	errorSink         *common.ErrorSink
+	name          string
+	config        execution.Config
+	pipelineId    string
+	pipelineConf  common.PipelineConfiguration
+	pipelineBean  creation.PipelineBean
+	sourcePipe    Pipe
+	pipeRunners   []*PipeRunner
+	offsetTracker SourceOffsetTracker
+	lastOffset    string
+	stopped       chan struct{}
+	stopOnce      sync.Once
+	destroyOnce   sync.Once
+	errorSink     *common.ErrorSink
+	eventSink     *common.EventSink
+	parameters    map[string]interface{}
+	// held by the pipe runners while they process a batch, so that the stages are not destroyed under them
+	batchMutex sync.RWMutex
+	// batches handed over to the pipe runners whose offset is not committed yet, in the order they were produced
+	pendingBatches []*batchOffset
+	commitMutex    sync.Mutex
+	// called once the offset of the first batch has been committed
+	onFirstCommit   func()
+	firstCommitOnce sync.Once
+	// metric and data rules, not evaluated in preview
+	rulesEvaluator *alerts.RulesEvaluator
+	// used by preview
+	preview             bool
+	captureStageOutputs bool
+	skipTargets         bool
+	snapshotCapture     *SnapshotCapture
+	snapshotMutex       sync.Mutex
 
 	MetricRegistry              metrics.Registry
 	batchProcessingTimer        metrics.Timer
@@ -57,6 +81,22 @@ type Pipeline struct {
 	batchErrorMessagesHistogram metrics.Histogram
 }
 
+// batchOffset tells when the offset of a batch handed over to the pipe runners can be committed
+type batchOffset struct {
+	offset string
+	// whether the pipe runner is done with the batch and its offset is to be committed
+	done   bool
+	commit bool
+	// writes of the batch held by destination buffers, and whether one of them could not be delivered
+	bufferedWrites int
+	undelivered    bool
+}
+
+// isCommitted reports whether the offset of the batch is to be committed once the batch is done
+func (b *batchOffset) isCommitted() bool {
+	return b.commit && !b.undelivered
+}
+
 const (
 	AT_MOST_ONCE                      = "AT_MOST_ONCE"
 	AT_LEAST_ONCE                     = "AT_LEAST_ONCE"
@@ -73,121 +113,400 @@ const (
 )
 
 func (p *Pipeline) Init() []validation.Issue {
-	var issues []validation.Issue
-	for _, stagePipe := range p.pipes {
-		stageIssues := stagePipe.Init()
-		issues = append(issues, stageIssues...)
+	issues := p.sourcePipe.Init()
+	for _, pipeRunner := range p.pipeRunners {
+		runnerIssues := pipeRunner.Init()
+		issues = append(issues, runnerIssues...)
 	}
-
-	errorStageissues := p.errorStageRuntime.Init()
-	issues = append(issues, errorStageissues...)
-
 	return issues
 }
 
-func (p *Pipeline) Run() {
+// Run keeps running batches until the origin is done or the pipeline is stopped, and returns the error
+// that made the pipeline stop when processing a batch failed.
+func (p *Pipeline) Run() error {
 	log.Println("[DEBUG] Pipeline Run()")
 
-	for !p.offsetTracker.IsFinished() && !p.stop {
+	if len(p.pipeRunners) > 1 {
+		return p.runMultithreaded()
+	}
+
+	for !p.offsetTracker.IsFinished() && !p.isStopped() {
 		err := p.runBatch()
 		if err != nil {
 			log.Println("[Error] Error happened when processing batch", err)
 			log.Println("[Error] Stopping Pipeline")
 			p.Stop()
+			return err
 		}
 	}
+	return nil
 }
 
 func (p *Pipeline) runBatch() error {
-	committed := false
-	start := time.Now()
+	pipeBatch, err := p.produce()
+	if err != nil || pipeBatch == nil {
+		return err
+	}
+	return p.runPipeBatch(p.pipeRunners[0], pipeBatch)
+}
+
+// runPipeBatch processes the batch with the given pipe runner, unless the pipeline is being stopped
+func (p *Pipeline) runPipeBatch(pipeRunner *PipeRunner, pipeBatch *FullPipeBatch) error {
+	p.batchMutex.RLock()
+	defer p.batchMutex.RUnlock()
+	if p.isStopped() {
+		return nil
+	}
+	err := pipeRunner.runBatch(pipeBatch)
+	p.completeBatch(pipeBatch)
+	return err
+}
+
+// runMultithreaded keeps producing batches from the origin and hands over each one of them to the first
+// idle pipe runner. The first error reported by a runner or the origin stops the pipeline once the batches
+// being processed by the other runners are done, and is returned.
+func (p *Pipeline) runMultithreaded() error {
+	log.Printf("[DEBUG] Running pipeline with %d runners", len(p.pipeRunners))
+	pipeBatches := make(chan *FullPipeBatch)
+	var waitGroup sync.WaitGroup
+	var runErrMutex sync.Mutex
+	var runErr error
+	setRunErr := func(err error) {
+		runErrMutex.Lock()
+		if runErr == nil {
+			runErr = err
+		}
+		runErrMutex.Unlock()
+	}
+
+	for _, pipeRunner := range p.pipeRunners {
+		waitGroup.Add(1)
+		go func(pipeRunner *PipeRunner) {
+			defer waitGroup.Done()
+			for pipeBatch := range pipeBatches {
+				if err := p.runPipeBatch(pipeRunner, pipeBatch); err != nil {
+					log.Printf("[Error] Error happened when processing batch in runner %d: %s", pipeRunner.runnerId, err)
+					setRunErr(err)
+					p.signalStop()
+				}
+			}
+		}(pipeRunner)
+	}
+
+	for !p.offsetTracker.IsFinished() && !p.isStopped() {
+		pipeBatch, err := p.produce()
+		if err != nil {
+			log.Println("[Error] Error happened when producing batch", err)
+			setRunErr(err)
+			break
+		}
+		if pipeBatch == nil {
+			continue
+		}
+		pipeBatches <- pipeBatch
+	}
+
+	close(pipeBatches)
+	waitGroup.Wait()
+	if runErr != nil {
+		log.Println("[Error] Stopping Pipeline")
+		p.Stop()
+	}
+	return runErr
+}
 
+// produce runs the origin and returns the new batch along with the errors reported by the origin, or no batch
+// when the pipeline got stopped while the origin was failing
+func (p *Pipeline) produce() (*FullPipeBatch, error) {
 	p.errorSink.ClearErrorRecordsAndMesssages()
 
-	previousOffset := p.offsetTracker.GetOffset()
+	pipeBatch := NewFullPipeBatch(p.lastOffset, 1, p.errorSink, p.eventSink)
+	pipeBatch.snapshotCapture = p.getSnapshotCaptureForBatch()
+	pipeBatch.captureStageOutputs = p.captureStageOutputs || pipeBatch.snapshotCapture != nil
+	pipeBatch.skipTargets = p.skipTargets
+	pipeBatch.rulesEvaluator = p.rulesEvaluator
+	failed, err := p.processPipe(p.sourcePipe, pipeBatch)
+	if err != nil {
+		return nil, err
+	}
+	if failed {
+		// hold off producing the next batch, so that a failing origin doesn't keep the pipeline spinning
+		backoff := time.Duration(p.sourcePipe.GetSystemConfigs().StageRetryInitialBackoff) * time.Millisecond
+		if !p.wait(backoff) {
+			return nil, nil
+		}
+	}
+	p.lastOffset = pipeBatch.GetNewOffset()
+
+	pipeBatch.batchOffset = &batchOffset{offset: pipeBatch.GetNewOffset()}
+	p.commitMutex.Lock()
+	p.pendingBatches = append(p.pendingBatches, pipeBatch.batchOffset)
+	p.commitMutex.Unlock()
 
-	pipeBatch := NewFullPipeBatch(p.offsetTracker, 1, p.errorSink)
+	sourceInstanceName := p.sourcePipe.GetInstanceName()
+	pipeBatch.sourceErrorRecords = p.errorSink.GetStageErrorRecords(sourceInstanceName)
+	pipeBatch.sourceErrorMessages = p.errorSink.GetStageErrorMessages(sourceInstanceName)
+	return pipeBatch, nil
+}
 
-	for _, pipe := range p.pipes {
-		if p.pipelineBean.Config.DeliveryGuarantee == AT_MOST_ONCE &&
-			pipe.IsTarget() && // if destination
-			!committed {
-			if err := p.offsetTracker.CommitOffset(); err != nil {
-				return err
-			}
-			committed = true
+// processPipe runs the stage retrying it as configured, once the retries are exhausted the stage on failure
+// policy decides whether the batch of the stage is discarded, sent to error or the pipeline is stopped.
+// It reports whether the stage failed, which is also the case when the pipeline got stopped while retrying.
+func (p *Pipeline) processPipe(pipe Pipe, pipeBatch *FullPipeBatch) (bool, error) {
+	systemConfigs := pipe.GetSystemConfigs()
+	outputRecords := pipeBatch.outputRecords
+	err := pipe.Process(pipeBatch)
+	for attempt := 0; err != nil && attempt < int(systemConfigs.StageRetryAttempts); attempt++ {
+		backoff := getRetryBackoff(systemConfigs, attempt)
+		log.Printf(
+			"[WARN] Stage '%s' failed, retrying in %s (attempt %d of %d): %s",
+			pipe.GetInstanceName(),
+			backoff,
+			attempt+1,
+			int(systemConfigs.StageRetryAttempts),
+			err.Error(),
+		)
+		pipeBatch.resetStage(pipe.GetInstanceName(), outputRecords)
+		if !p.wait(backoff) {
+			log.Printf("[WARN] Pipeline stopped while retrying stage '%s'", pipe.GetInstanceName())
+			return true, nil
 		}
+		pipe.MarkRetry()
+		err = pipe.Process(pipeBatch)
+	}
 
-		err := pipe.Process(pipeBatch)
-		if err != nil {
-			log.Println("[ERROR] ", err)
+	if err == nil {
+		return false, nil
+	}
+
+	pipeBatch.resetStage(pipe.GetInstanceName(), outputRecords)
+	switch systemConfigs.StageOnFailure {
+	case creation.ON_RECORD_ERROR_DISCARD:
+		log.Printf("[WARN] Discarding batch of stage '%s': %s", pipe.GetInstanceName(), err.Error())
+	case creation.ON_RECORD_ERROR_TO_ERROR:
+		log.Printf("[ERROR] Stage '%s' failed, sending batch to error: %s", pipe.GetInstanceName(), err.Error())
+		pipe.ToError(pipeBatch, err)
+	default:
+		return true, errors.New(fmt.Sprintf("Stage '%s' failed: %s", pipe.GetInstanceName(), err.Error()))
+	}
+	return true, nil
+}
+
+// commitBatch marks the batch as done and commits the offsets that are ready, a batch that must not be
+// committed is skipped.
+func (p *Pipeline) commitBatch(pipeBatch *FullPipeBatch, commit bool) error {
+	p.commitMutex.Lock()
+	defer p.commitMutex.Unlock()
+	pipeBatch.batchOffset.done = true
+	pipeBatch.batchOffset.commit = commit
+	return p.commitOffsets()
+}
+
+// bufferWrite holds the offset of the batch until the destination buffer delivers it
+func (p *Pipeline) bufferWrite(batchOffset *batchOffset) {
+	p.commitMutex.Lock()
+	defer p.commitMutex.Unlock()
+	batchOffset.bufferedWrites++
+}
+
+// completeBufferedWrite releases the offset of the batch once the destination buffer is done with it, the offset
+// of a batch the buffer could not deliver is not committed
+func (p *Pipeline) completeBufferedWrite(batchOffset *batchOffset, delivered bool) {
+	p.commitMutex.Lock()
+	defer p.commitMutex.Unlock()
+	batchOffset.bufferedWrites--
+	batchOffset.undelivered = batchOffset.undelivered || !delivered
+	if err := p.commitOffsets(); err != nil {
+		log.Printf("[ERROR] Error committing offset of pipeline '%s': %s", p.pipelineId, err.Error())
+	}
+}
+
+// commitOffsets saves the offset of the last one of the done batches that precede any batch still being
+// processed or buffered, and lets the origin acknowledge the data of every one of them that was committed and
+// release the data of the others. Offsets are committed in the order the batches were produced, so no batch being
+// processed is ever acknowledged by a later one.
+func (p *Pipeline) commitOffsets() error {
+	readyBatches := make([]*batchOffset, 0)
+	newOffset := ""
+	commitOffset := false
+	for len(p.pendingBatches) > 0 && p.pendingBatches[0].done && p.pendingBatches[0].bufferedWrites == 0 {
+		if p.pendingBatches[0].isCommitted() {
+			newOffset = p.pendingBatches[0].offset
+			commitOffset = true
 		}
+		readyBatches = append(readyBatches, p.pendingBatches[0])
+		p.pendingBatches = p.pendingBatches[1:]
 	}
 
-	errorRecords := make([]api.Record, 0)
-	for _, stageBean := range p.pipelineBean.Stages {
-		errorRecordsForThisStage := p.errorSink.GetStageErrorRecords(stageBean.Config.InstanceName)
-		if errorRecordsForThisStage != nil && len(errorRecordsForThisStage) > 0 {
-			errorRecords = append(errorRecords, errorRecordsForThisStage...)
+	if commitOffset {
+		// save the offset first, so that the data is never acknowledged when its offset could not be saved
+		p.offsetTracker.SetOffset(newOffset)
+		if err := p.offsetTracker.CommitOffset(); err != nil {
+			return err
+		}
+		if p.onFirstCommit != nil {
+			p.firstCommitOnce.Do(p.onFirstCommit)
 		}
 	}
-	if len(errorRecords) > 0 {
-		batch := NewBatchImpl(p.errorStageRuntime.config.InstanceName, errorRecords, previousOffset)
-		_, err := p.errorStageRuntime.Execute(previousOffset, -1, batch, nil)
+	if p.preview {
+		// a preview must leave the data to the pipeline, so the origin never acknowledges it
+		return nil
+	}
+	for _, readyBatch := range readyBatches {
+		var err error
+		if readyBatch.isCommitted() {
+			err = p.sourcePipe.Commit(readyBatch.offset)
+		} else {
+			err = p.sourcePipe.Reject(readyBatch.offset)
+		}
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	if p.pipelineBean.Config.DeliveryGuarantee == AT_LEAST_ONCE {
-		p.offsetTracker.CommitOffset()
+// wait sleeps for the given duration unless the pipeline gets stopped, in which case it returns false right away
+func (p *Pipeline) wait(duration time.Duration) bool {
+	select {
+	case <-p.stopped:
+		return false
+	case <-time.After(duration):
+		return true
 	}
+}
 
-	p.batchProcessingTimer.UpdateSince(start)
-	p.batchCountCounter.Inc(1)
-	p.batchCountMeter.Mark(1)
+func (p *Pipeline) signalStop() {
+	p.stopOnce.Do(func() {
+		close(p.stopped)
+	})
+}
 
-	p.batchInputRecordsCounter.Inc(pipeBatch.GetInputRecords())
-	p.batchOutputRecordsCounter.Inc(pipeBatch.GetOutputRecords())
-	p.batchErrorMessagesCounter.Inc(pipeBatch.GetErrorMessages())
-	p.batchErrorRecordsCounter.Inc(pipeBatch.GetErrorRecords())
+func (p *Pipeline) isStopped() bool {
+	select {
+	case <-p.stopped:
+		return true
+	default:
+		return false
+	}
+}
 
-	p.batchInputRecordsMeter.Mark(pipeBatch.GetInputRecords())
-	p.batchOutputRecordsMeter.Mark(pipeBatch.GetOutputRecords())
-	p.batchErrorMessagesMeter.Mark(pipeBatch.GetErrorMessages())
-	p.batchErrorRecordsMeter.Mark(pipeBatch.GetErrorRecords())
+// CaptureSnapshot starts capturing the stage outputs of the next batches into the snapshot with the given name
+func (p *Pipeline) CaptureSnapshot(snapshotName string, batches int) (*common.SnapshotInfo, error) {
+	p.snapshotMutex.Lock()
+	defer p.snapshotMutex.Unlock()
+	if p.snapshotCapture != nil && p.snapshotCapture.IsInProgress() {
+		return nil, errors.New(fmt.Sprintf(
+			"Snapshot '%s' is already being captured",
+			p.snapshotCapture.info.Name,
+		))
+	}
+	snapshotCapture, err := NewSnapshotCapture(p.pipelineId, snapshotName, batches)
+	if err != nil {
+		return nil, err
+	}
+	p.snapshotCapture = snapshotCapture
+	snapshotInfo := *snapshotCapture.info
+	return &snapshotInfo, nil
+}
 
-	p.batchInputRecordsHistogram.Update(pipeBatch.GetInputRecords())
-	p.batchOutputRecordsHistogram.Update(pipeBatch.GetOutputRecords())
-	p.batchErrorMessagesHistogram.Update(pipeBatch.GetErrorMessages())
-	p.batchErrorRecordsHistogram.Update(pipeBatch.GetErrorRecords())
+// IsCapturingSnapshot reports whether the snapshot with the given name is still being captured
+func (p *Pipeline) IsCapturingSnapshot(snapshotName string) bool {
+	p.snapshotMutex.Lock()
+	defer p.snapshotMutex.Unlock()
+	return p.snapshotCapture != nil && p.snapshotCapture.info.Name == snapshotName &&
+		p.snapshotCapture.IsInProgress()
+}
 
+func (p *Pipeline) getSnapshotCaptureForBatch() *SnapshotCapture {
+	p.snapshotMutex.Lock()
+	defer p.snapshotMutex.Unlock()
+	if p.snapshotCapture != nil && p.snapshotCapture.requestBatch() {
+		return p.snapshotCapture
+	}
 	return nil
 }
 
+// completeBatch captures the batch for the snapshot in progress and evaluates the metric rules
+func (p *Pipeline) completeBatch(pipeBatch *FullPipeBatch) {
+	if pipeBatch.snapshotCapture != nil {
+		pipeBatch.snapshotCapture.addBatch(pipeBatch.GetSnapshotsOfAllStagesOutput())
+	}
+	if p.rulesEvaluator != nil {
+		p.rulesEvaluator.EvaluateMetricRules()
+	}
+}
+
+// SetRuleDefinitions enables the evaluation of the given metric and data rules while the pipeline runs
+func (p *Pipeline) SetRuleDefinitions(ruleDefinitions common.RuleDefinitions) error {
+	rulesEvaluator, err := alerts.NewRulesEvaluator(p.pipelineId, ruleDefinitions, p.parameters, p.MetricRegistry)
+	if err != nil {
+		return err
+	}
+	p.rulesEvaluator = rulesEvaluator
+	return nil
+}
+
+func (p *Pipeline) GetAlerts() []*common.AlertInfo {
+	if p.rulesEvaluator == nil {
+		return []*common.AlertInfo{}
+	}
+	return p.rulesEvaluator.GetAlerts()
+}
+
+func (p *Pipeline) DeleteAlert(ruleId string) error {
+	if p.rulesEvaluator == nil {
+		return errors.New("Alert '" + ruleId + "' does not exist")
+	}
+	return p.rulesEvaluator.DeleteAlert(ruleId)
+}
+
+// Stop makes the pipeline stop producing batches and destroys its stages once the batches being processed are done
 func (p *Pipeline) Stop() {
 	log.Println("[DEBUG] Pipeline Stop()")
-	for _, stagePipe := range p.pipes {
-		stagePipe.Destroy()
+	p.signalStop()
+
+	p.snapshotMutex.Lock()
+	if p.snapshotCapture != nil {
+		p.snapshotCapture.Finish()
 	}
-	p.errorStageRuntime.Destroy()
-	p.stop = true
+	p.snapshotMutex.Unlock()
+
+	p.batchMutex.Lock()
+	defer p.batchMutex.Unlock()
+	p.destroyOnce.Do(func() {
+		// runners first, their destination buffers may still commit delivered batches to the origin
+		for _, pipeRunner := range p.pipeRunners {
+			pipeRunner.Destroy()
+		}
+		p.sourcePipe.Destroy()
+	})
 }
 
 func NewPipeline(
 	config execution.Config,
-	standaloneRunner *StandaloneRunner,
+	pipelineId string,
+	pipelineConfig common.PipelineConfiguration,
 	sourceOffsetTracker SourceOffsetTracker,
 	runtimeParameters map[string]interface{},
 	metricRegistry metrics.Registry,
 ) (*Pipeline, error) {
+	return newPipeline(config, pipelineId, pipelineConfig, sourceOffsetTracker, runtimeParameters, metricRegistry, false)
+}
 
-	pipelineConfigForParam := creation.NewPipelineConfigBean(standaloneRunner.GetPipelineConfig())
-	stageRuntimeList := make([]StageRuntime, len(standaloneRunner.pipelineConfig.Stages))
-	pipes := make([]Pipe, len(standaloneRunner.pipelineConfig.Stages))
-	errorSink := common.NewErrorSink()
+// newPipeline creates the pipeline, a preview pipeline always runs in a single runner and never commits the origin
+func newPipeline(
+	config execution.Config,
+	pipelineId string,
+	pipelineConfig common.PipelineConfiguration,
+	sourceOffsetTracker SourceOffsetTracker,
+	runtimeParameters map[string]interface{},
+	metricRegistry metrics.Registry,
+	preview bool,
+) (*Pipeline, error) {
 
-	var errorStageRuntime StageRuntime
+	pipelineConfigForParam := creation.NewPipelineConfigBean(pipelineConfig)
+	errorSink := common.NewErrorSink()
 
 	var resolvedParameters = make(map[string]interface{})
 	for k, v := range pipelineConfigForParam.Constants {
@@ -198,62 +517,94 @@ func NewPipeline(
 		}
 	}
 
-	pipelineBean, err := creation.NewPipelineBean(standaloneRunner.GetPipelineConfig(), resolvedParameters)
+	pipelineBean, err := creation.NewPipelineBean(pipelineConfig, resolvedParameters)
 	if err != nil {
 		return nil, err
 	}
 
-	for i, stageBean := range pipelineBean.Stages {
-		stageContext := &common.StageContextImpl{
-			StageConfig: stageBean.Config,
-			Parameters:  resolvedParameters,
-			Metrics:     metricRegistry,
-			ErrorSink:   errorSink,
-			ErrorStage:  false,
+	p := &Pipeline{
+		config:         config,
+		pipelineId:     pipelineId,
+		pipelineConf:   pipelineConfig,
+		pipelineBean:   pipelineBean,
+		errorSink:      errorSink,
+		eventSink:      common.NewEventSink(),
+		offsetTracker:  sourceOffsetTracker,
+		lastOffset:     sourceOffsetTracker.GetOffset(),
+		stopped:        make(chan struct{}),
+		preview:        preview,
+		parameters:     resolvedParameters,
+		MetricRegistry: metricRegistry,
+	}
+
+	for _, stageBean := range pipelineBean.Stages {
+		if stageBean.IsSource() {
+			stageContext := &common.StageContextImpl{
+				StageConfig: stageBean.Config,
+				Parameters:  resolvedParameters,
+				Metrics:     metricRegistry,
+				ErrorSink:   errorSink,
+				EventSink:   p.eventSink,
+				ErrorStage:  false,
+				Stopped:     p.stopped,
+			}
+			p.sourcePipe = NewStagePipe(NewStageRuntime(pipelineBean, stageBean, stageContext), config, nil)
+			break
 		}
-		stageRuntimeList[i] = NewStageRuntime(pipelineBean, stageBean, stageContext)
-		pipes[i] = NewStagePipe(stageRuntimeList[i], config)
+	}
+	if p.sourcePipe == nil {
+		return nil, errors.New("Pipeline has no origin stage")
 	}
 
-	log.Println("[DEBUG] Error Stage:", pipelineBean.ErrorStage.Config.InstanceName)
-	errorStageContext := &common.StageContextImpl{
-		StageConfig: pipelineBean.ErrorStage.Config,
-		Parameters:  resolvedParameters,
-		Metrics:     metricRegistry,
-		ErrorSink:   errorSink,
-		ErrorStage:  true,
+	runnerCount := int(pipelineBean.Config.MaxRunners)
+	if runnerCount < 1 || preview {
+		runnerCount = 1
+	}
+	p.pipeRunners = make([]*PipeRunner, runnerCount)
+	for runnerId := 0; runnerId < runnerCount; runnerId++ {
+		runnerPipelineBean := pipelineBean
+		if runnerId > 0 {
+			// every runner gets its own processor and destination stage instances
+			runnerPipelineBean, err = creation.NewPipelineBean(pipelineConfig, resolvedParameters)
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.pipeRunners[runnerId], err = NewPipeRunner(
+			runnerId,
+			p,
+			config,
+			runnerPipelineBean,
+			resolvedParameters,
+			metricRegistry,
+		)
+		if err != nil {
+			return nil, err
+		}
 	}
-	errorStageRuntime = NewStageRuntime(pipelineBean, pipelineBean.ErrorStage, errorStageContext)
 
-	p := &Pipeline{
-		standaloneRunner:  standaloneRunner,
-		pipelineConf:      standaloneRunner.GetPipelineConfig(),
-		pipelineBean:      pipelineBean,
-		pipes:             pipes,
-		errorStageRuntime: errorStageRuntime,
-		errorSink:         errorSink,
-		offsetTracker:     sourceOffsetTracker,
-		MetricRegistry:    metricRegistry,
-	}
-
-	p.batchProcessingTimer = util.CreateTimer(metricRegistry, PIPELINE_BATCH_PROCESSING)
-
-	p.batchCountCounter = util.CreateCounter(metricRegistry, PIPELINE_BATCH_COUNT)
-	p.batchInputRecordsCounter = util.CreateCounter(metricRegistry, PIPELINE_BATCH_INPUT_RECORDS)
-	p.batchOutputRecordsCounter = util.CreateCounter(metricRegistry, PIPELINE_BATCH_OUTPUT_RECORDS)
-	p.batchErrorRecordsCounter = util.CreateCounter(metricRegistry, PIPELINE_BATCH_ERROR_RECORDS)
-	p.batchErrorMessagesCounter = util.CreateCounter(metricRegistry, PIPELINE_BATCH_ERROR_MESSAGES)
-
-	p.batchCountMeter = util.CreateMeter(metricRegistry, PIPELINE_BATCH_COUNT)
-	p.batchInputRecordsMeter = util.CreateMeter(metricRegistry, PIPELINE_BATCH_INPUT_RECORDS)
-	p.batchOutputRecordsMeter = util.CreateMeter(metricRegistry, PIPELINE_BATCH_OUTPUT_RECORDS)
-	p.batchErrorRecordsMeter = util.CreateMeter(metricRegistry, PIPELINE_BATCH_ERROR_RECORDS)
-	p.batchErrorMessagesMeter = util.CreateMeter(metricRegistry, PIPELINE_BATCH_ERROR_MESSAGES)
-
-	p.batchInputRecordsHistogram = util.CreateHistogram5Min(metricRegistry, PIPELINE_INPUT_RECORDS_PER_BATCH)
-	p.batchOutputRecordsHistogram = util.CreateHistogram5Min(metricRegistry, PIPELINE_OUTPUT_RECORDS_PER_BATCH)
-	p.batchErrorRecordsHistogram = util.CreateHistogram5Min(metricRegistry, PIPELINE_ERROR_RECORDS_PER_BATCH)
-	p.batchErrorMessagesHistogram = util.CreateHistogram5Min(metricRegistry, PIPELINE_ERRORS_PER_BATCH)
+	p.initMetrics()
 
 	return p, nil
 }
+
+func (p *Pipeline) initMetrics() {
+	p.batchProcessingTimer = util.CreateTimer(p.MetricRegistry, PIPELINE_BATCH_PROCESSING)
+
+	p.batchCountCounter = util.CreateCounter(p.MetricRegistry, PIPELINE_BATCH_COUNT)
+	p.batchInputRecordsCounter = util.CreateCounter(p.MetricRegistry, PIPELINE_BATCH_INPUT_RECORDS)
+	p.batchOutputRecordsCounter = util.CreateCounter(p.MetricRegistry, PIPELINE_BATCH_OUTPUT_RECORDS)
+	p.batchErrorRecordsCounter = util.CreateCounter(p.MetricRegistry, PIPELINE_BATCH_ERROR_RECORDS)
+	p.batchErrorMessagesCounter = util.CreateCounter(p.MetricRegistry, PIPELINE_BATCH_ERROR_MESSAGES)
+
+	p.batchCountMeter = util.CreateMeter(p.MetricRegistry, PIPELINE_BATCH_COUNT)
+	p.batchInputRecordsMeter = util.CreateMeter(p.MetricRegistry, PIPELINE_BATCH_INPUT_RECORDS)
+	p.batchOutputRecordsMeter = util.CreateMeter(p.MetricRegistry, PIPELINE_BATCH_OUTPUT_RECORDS)
+	p.batchErrorRecordsMeter = util.CreateMeter(p.MetricRegistry, PIPELINE_BATCH_ERROR_RECORDS)
+	p.batchErrorMessagesMeter = util.CreateMeter(p.MetricRegistry, PIPELINE_BATCH_ERROR_MESSAGES)
+
+	p.batchInputRecordsHistogram = util.CreateHistogram5Min(p.MetricRegistry, PIPELINE_INPUT_RECORDS_PER_BATCH)
+	p.batchOutputRecordsHistogram = util.CreateHistogram5Min(p.MetricRegistry, PIPELINE_OUTPUT_RECORDS_PER_BATCH)
+	p.batchErrorRecordsHistogram = util.CreateHistogram5Min(p.MetricRegistry, PIPELINE_ERROR_RECORDS_PER_BATCH)
+	p.batchErrorMessagesHistogram = util.CreateHistogram5Min(p.MetricRegistry, PIPELINE_ERRORS_PER_BATCH)
+}
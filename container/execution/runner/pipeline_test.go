@@ -0,0 +1,467 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"errors"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/validation"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPipe is a pipe whose stage fails as many times as configured before processing the batch, the origin
+// moves the offset forward by one on every batch
+type testPipe struct {
+	instanceName  string
+	stageType     string
+	systemConfigs creation.StageConfigBean
+	failures      int
+	// time the stage takes to process every batch, and batches by new offset it takes longer to process
+	delay      time.Duration
+	delays     map[string]time.Duration
+	failOffset string
+	issues     []validation.Issue
+	mutex      sync.Mutex
+	processed  int
+	retries    int
+	toError    int
+	commits    []string
+	rejects    []string
+	destroyed  int32
+	// set when the stage was still processing a batch after it got destroyed
+	usedAfterDestroy int32
+}
+
+func (t *testPipe) Init() []validation.Issue {
+	return t.issues
+}
+
+func (t *testPipe) Process(pipeBatch *FullPipeBatch) error {
+	t.mutex.Lock()
+	t.processed++
+	processed := t.processed
+	t.mutex.Unlock()
+
+	time.Sleep(t.delay + t.delays[pipeBatch.GetNewOffset()])
+	if atomic.LoadInt32(&t.destroyed) == 1 {
+		atomic.StoreInt32(&t.usedAfterDestroy, 1)
+	}
+
+	if t.stageType == "TARGET" {
+		pipeBatch.outputRecords++
+	}
+	if processed <= t.failures || (t.failOffset != "" && pipeBatch.GetNewOffset() == t.failOffset) {
+		// partial work of the failed attempt
+		pipeBatch.errorSink.ReportError(t.instanceName, errors.New("attempt failed"))
+		return errors.New("stage failed")
+	}
+	if t.stageType == "SOURCE" {
+		offset, _ := strconv.Atoi(pipeBatch.GetPreviousOffset())
+		pipeBatch.SetNewOffset(strconv.Itoa(offset + 1))
+	}
+	return nil
+}
+
+func (t *testPipe) Commit(offset string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.commits = append(t.commits, offset)
+	return nil
+}
+
+func (t *testPipe) Reject(offset string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rejects = append(t.rejects, offset)
+	return nil
+}
+
+func (t *testPipe) Destroy() {
+	atomic.StoreInt32(&t.destroyed, 1)
+}
+
+func (t *testPipe) GetInstanceName() string {
+	return t.instanceName
+}
+
+func (t *testPipe) GetSystemConfigs() creation.StageConfigBean {
+	return t.systemConfigs
+}
+
+func (t *testPipe) MarkRetry() {
+	t.retries++
+}
+
+func (t *testPipe) ToError(pipeBatch *FullPipeBatch, err error) {
+	t.toError++
+	pipeBatch.errorSink.ReportError(t.instanceName, err)
+}
+
+func (t *testPipe) IsSource() bool {
+	return t.stageType == "SOURCE"
+}
+
+func (t *testPipe) IsProcessor() bool {
+	return t.stageType == "PROCESSOR"
+}
+
+func (t *testPipe) IsTarget() bool {
+	return t.stageType == "TARGET"
+}
+
+func (t *testPipe) getCommits() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]string{}, t.commits...)
+}
+
+func (t *testPipe) getRejects() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]string{}, t.rejects...)
+}
+
+func newTestPipe(instanceName string, stageType string, onFailure string, failures int) *testPipe {
+	systemConfigs := creation.NewStageConfigBean(common.StageConfiguration{})
+	systemConfigs.StageOnFailure = onFailure
+	systemConfigs.StageRetryInitialBackoff = 1
+	systemConfigs.StageRetryJitter = false
+	return &testPipe{
+		instanceName:  instanceName,
+		stageType:     stageType,
+		systemConfigs: systemConfigs,
+		failures:      failures,
+	}
+}
+
+// testOffsetTracker keeps the committed offsets in memory
+type testOffsetTracker struct {
+	mutex     sync.Mutex
+	offset    string
+	committed []string
+}
+
+func (o *testOffsetTracker) IsFinished() bool {
+	return false
+}
+
+func (o *testOffsetTracker) SetOffset(newOffset string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.offset = newOffset
+}
+
+func (o *testOffsetTracker) CommitOffset() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.committed = append(o.committed, o.offset)
+	return nil
+}
+
+func (o *testOffsetTracker) GetOffset() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.offset
+}
+
+func (o *testOffsetTracker) GetLastBatchTime() time.Time {
+	return time.Now()
+}
+
+func (o *testOffsetTracker) getCommitted() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return append([]string{}, o.committed...)
+}
+
+type testErrorStage struct {
+}
+
+func (t *testErrorStage) Init(stageContext api.StageContext) error {
+	return nil
+}
+
+func (t *testErrorStage) Destroy() error {
+	return nil
+}
+
+func newTestPipeRunner(runnerId int, p *Pipeline, pipes ...Pipe) *PipeRunner {
+	return &PipeRunner{
+		runnerId:          runnerId,
+		pipeline:          p,
+		pipes:             pipes,
+		errorStageRuntime: StageRuntime{stageBean: creation.StageBean{Stage: &testErrorStage{}}},
+		errorSink:         common.NewErrorSink(),
+		eventSink:         common.NewEventSink(),
+	}
+}
+
+func newTestPipeline(sourcePipe Pipe, pipes ...Pipe) (*Pipeline, *testOffsetTracker) {
+	offsetTracker := &testOffsetTracker{offset: "0"}
+	p := &Pipeline{
+		sourcePipe:     sourcePipe,
+		offsetTracker:  offsetTracker,
+		lastOffset:     offsetTracker.GetOffset(),
+		stopped:        make(chan struct{}),
+		errorSink:      common.NewErrorSink(),
+		eventSink:      common.NewEventSink(),
+		MetricRegistry: metrics.NewRegistry(),
+	}
+	p.pipeRunners = []*PipeRunner{newTestPipeRunner(0, p, pipes...)}
+	p.initMetrics()
+	return p, offsetTracker
+}
+
+func TestPipeline_RunBatch(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	processorPipe := newTestPipe("processor", "PROCESSOR", creation.ON_RECORD_ERROR_DISCARD, 1)
+	targetPipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	p, offsetTracker := newTestPipeline(sourcePipe, processorPipe, targetPipe)
+	firstCommits := 0
+	p.onFirstCommit = func() {
+		firstCommits++
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.runBatch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if firstCommits != 1 {
+		t.Errorf("Expected the first commit to be reported once, but got %d", firstCommits)
+	}
+
+	// the batch discarded by the processor is done with, so its offset is committed
+	expected := []string{"1", "2"}
+	if committed := offsetTracker.getCommitted(); len(committed) != 2 || committed[0] != "1" || committed[1] != "2" {
+		t.Errorf("Expected committed offsets %v, but got %v", expected, committed)
+	}
+	if commits := sourcePipe.getCommits(); len(commits) != 2 || commits[0] != "1" || commits[1] != "2" {
+		t.Errorf("Expected origin commits %v, but got %v", expected, commits)
+	}
+}
+
+func TestPipeline_RunBatchDestinationFailure(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	targetPipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_TO_ERROR, 1)
+	p, offsetTracker := newTestPipeline(sourcePipe, targetPipe)
+
+	for i := 0; i < 2; i++ {
+		if err := p.runBatch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if targetPipe.toError != 1 {
+		t.Errorf("Expected the failed batch to be sent to error once, but got %d", targetPipe.toError)
+	}
+	// the batch the destination failed to write is never committed
+	if committed := offsetTracker.getCommitted(); len(committed) != 1 || committed[0] != "2" {
+		t.Errorf("Expected committed offsets [2], but got %v", committed)
+	}
+	if commits := sourcePipe.getCommits(); len(commits) != 1 || commits[0] != "2" {
+		t.Errorf("Expected origin commits [2], but got %v", commits)
+	}
+	// the origin releases the data of the batch that was not committed
+	if rejects := sourcePipe.getRejects(); len(rejects) != 1 || rejects[0] != "1" {
+		t.Errorf("Expected origin rejects [1], but got %v", rejects)
+	}
+}
+
+func TestPipeline_RunBatchOriginFailure(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_DISCARD, 1)
+	sourcePipe.systemConfigs.StageRetryInitialBackoff = 100
+	targetPipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	p, _ := newTestPipeline(sourcePipe, targetPipe)
+
+	start := time.Now()
+	if err := p.runBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the pipeline to wait after the origin failed, but it went on after %s", elapsed)
+	}
+	if p.lastOffset != "0" {
+		t.Errorf("Expected offset to stay at '0' after the origin failed, but got '%s'", p.lastOffset)
+	}
+
+	if err := p.runBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if p.lastOffset != "1" {
+		t.Errorf("Expected offset '1', but got '%s'", p.lastOffset)
+	}
+}
+
+func TestPipeline_RunBatchOriginFailureStopPipeline(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 1)
+	targetPipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	p, offsetTracker := newTestPipeline(sourcePipe, targetPipe)
+
+	if err := p.runBatch(); err == nil {
+		t.Error("Expected the failing origin to stop the pipeline")
+	}
+	if targetPipe.processed != 0 || len(offsetTracker.getCommitted()) != 0 {
+		t.Error("Expected no batch to be processed or committed after the origin failed")
+	}
+}
+
+// offsetsInOrder checks that the offsets only move forward
+func offsetsInOrder(offsets []string) bool {
+	last := 0
+	for _, offset := range offsets {
+		value, _ := strconv.Atoi(offset)
+		if value <= last {
+			return false
+		}
+		last = value
+	}
+	return true
+}
+
+func TestPipeline_RunMultithreaded(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	sourcePipe.delay = time.Millisecond
+	p, offsetTracker := newTestPipeline(sourcePipe)
+	targetPipes := make([]*testPipe, 2)
+	p.pipeRunners = make([]*PipeRunner, len(targetPipes))
+	for runnerId := range targetPipes {
+		// the first batch is done long after the ones following it
+		targetPipes[runnerId] = newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+		targetPipes[runnerId].delays = map[string]time.Duration{"1": 200 * time.Millisecond}
+		p.pipeRunners[runnerId] = newTestPipeRunner(runnerId, p, targetPipes[runnerId])
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- p.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if committed := offsetTracker.getCommitted(); len(committed) != 0 {
+		t.Errorf("Expected no offset to be committed while the first batch is processed, but got %v", committed)
+	}
+	time.Sleep(200 * time.Millisecond)
+	p.Stop()
+	if err := <-runErr; err != nil {
+		t.Fatal(err)
+	}
+
+	committed := offsetTracker.getCommitted()
+	if len(committed) == 0 {
+		t.Error("Expected offsets to be committed once the first batch is done")
+	}
+	if !offsetsInOrder(committed) {
+		t.Errorf("Expected offsets to be committed in order, but got %v", committed)
+	}
+	// the origin acknowledges every batch, also the ones committed along with the first one
+	commits := sourcePipe.getCommits()
+	for i, offset := range commits {
+		if offset != strconv.Itoa(i+1) {
+			t.Errorf("Expected the origin to be committed for every batch in order, but got %v", commits)
+			break
+		}
+	}
+}
+
+func TestPipeline_RunMultithreadedRunnerFailure(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	sourcePipe.delay = time.Millisecond
+	p, offsetTracker := newTestPipeline(sourcePipe)
+	targetPipes := make([]*testPipe, 2)
+	p.pipeRunners = make([]*PipeRunner, len(targetPipes))
+	for runnerId := range targetPipes {
+		// the second batch is still being processed when the third one fails
+		targetPipes[runnerId] = newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+		targetPipes[runnerId].delays = map[string]time.Duration{"2": 200 * time.Millisecond}
+		targetPipes[runnerId].failOffset = "3"
+		p.pipeRunners[runnerId] = newTestPipeRunner(runnerId, p, targetPipes[runnerId])
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- p.Run()
+	}()
+	select {
+	case err := <-runErr:
+		if err == nil {
+			t.Error("Expected the failing runner to stop the pipeline with an error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the failing runner to stop the pipeline")
+	}
+
+	for runnerId, targetPipe := range targetPipes {
+		if atomic.LoadInt32(&targetPipe.usedAfterDestroy) == 1 {
+			t.Errorf("Expected the stages of runner %d to be destroyed once its batch was done", runnerId)
+		}
+		if atomic.LoadInt32(&targetPipe.destroyed) == 0 {
+			t.Errorf("Expected the stages of runner %d to be destroyed", runnerId)
+		}
+	}
+	// nothing past the failed batch is committed
+	for _, offset := range offsetTracker.getCommitted() {
+		if value, _ := strconv.Atoi(offset); value >= 3 {
+			t.Errorf("Expected no offset past the failed batch to be committed, but got %v", offsetTracker.getCommitted())
+			break
+		}
+	}
+}
+
+func TestPipeline_RunBatchPreview(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	targetPipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	p, offsetTracker := newTestPipeline(sourcePipe, targetPipe)
+	p.preview = true
+
+	if err := p.runBatch(); err != nil {
+		t.Fatal(err)
+	}
+	if committed := offsetTracker.getCommitted(); len(committed) != 1 || committed[0] != "1" {
+		t.Errorf("Expected the preview to move on to offset '1', but got %v", committed)
+	}
+	if commits := sourcePipe.getCommits(); len(commits) != 0 {
+		t.Errorf("Expected the origin not to be committed in preview, but got %v", commits)
+	}
+}
+
+func TestPipeline_CommitBufferedBatch(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	p, offsetTracker := newTestPipeline(sourcePipe)
+
+	pipeBatch, err := p.produce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.bufferWrite(pipeBatch.batchOffset)
+	if err = p.commitBatch(pipeBatch, true); err != nil {
+		t.Fatal(err)
+	}
+	if committed := offsetTracker.getCommitted(); len(committed) != 0 {
+		t.Errorf("Expected the buffered batch not to be committed before delivery, but got %v", committed)
+	}
+
+	p.completeBufferedWrite(pipeBatch.batchOffset, true)
+	if committed := offsetTracker.getCommitted(); len(committed) != 1 || committed[0] != "1" {
+		t.Errorf("Expected offset '1' to be committed on delivery, but got %v", committed)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/container/execution"
+	pipelineStore "github.com/streamsets/datacollector-edge/container/store"
+	"github.com/streamsets/datacollector-edge/container/validation"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	PREVIEW_FINISHED           = "FINISHED"
+	PREVIEW_INVALID            = "INVALID"
+	PREVIEW_RUN_ERROR          = "RUN_ERROR"
+	PREVIEW_TIMED_OUT          = "TIMED_OUT"
+	DEFAULT_PREVIEW_BATCHES    = 1
+	DEFAULT_PREVIEW_BATCH_SIZE = 10
+	DEFAULT_PREVIEW_TIMEOUT    = 10 * time.Second
+)
+
+type PreviewOutput struct {
+	Status        string             `json:"status"`
+	Message       string             `json:"message,omitempty"`
+	Issues        []validation.Issue `json:"issues"`
+	BatchesOutput [][]StageOutput    `json:"batchesOutput"`
+}
+
+// PreviewRunner runs the origin of a pipeline for a few batches capturing the input and output records of every
+// stage. Offsets are never committed and destination writes can be skipped.
+type PreviewRunner struct {
+	pipelineId        string
+	config            execution.Config
+	pipelineStoreTask pipelineStore.PipelineStoreTask
+}
+
+func (p *PreviewRunner) Preview(
+	batches int,
+	batchSize int,
+	skipTargets bool,
+	timeout time.Duration,
+	runtimeParameters map[string]interface{},
+) (*PreviewOutput, error) {
+	log.Printf("[INFO] Previewing pipeline %s", p.pipelineId)
+	pipelineConfig, err := p.pipelineStoreTask.LoadPipelineConfig(p.pipelineId)
+	if err != nil {
+		return nil, err
+	}
+
+	offsetTracker, err := NewPreviewSourceOffsetTracker(p.pipelineId)
+	if err != nil {
+		return nil, err
+	}
+
+	config := p.config
+	config.MaxBatchSize = batchSize
+	config.DestinationBuffer.Enabled = false
+
+	pipeline, err := newPipeline(
+		config,
+		p.pipelineId,
+		pipelineConfig,
+		offsetTracker,
+		runtimeParameters,
+		metrics.NewRegistry(),
+		true,
+	)
+	if err != nil {
+		return nil, err
+	}
+	pipeline.captureStageOutputs = true
+	pipeline.skipTargets = skipTargets
+
+	previewOutput := &PreviewOutput{
+		Issues:        make([]validation.Issue, 0),
+		BatchesOutput: make([][]StageOutput, 0),
+	}
+
+	if issues := pipeline.Init(); len(issues) > 0 {
+		pipeline.Stop()
+		previewOutput.Status = PREVIEW_INVALID
+		previewOutput.Issues = issues
+		return previewOutput, nil
+	}
+
+	var mutex sync.Mutex
+	batchesOutput := make([][]StageOutput, 0)
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < batches; i++ {
+			pipeBatch, err := pipeline.produce()
+			if err == nil && pipeBatch != nil {
+				err = pipeline.runPipeBatch(pipeline.pipeRunners[0], pipeBatch)
+			}
+			if pipeBatch != nil {
+				mutex.Lock()
+				batchesOutput = append(batchesOutput, pipeBatch.GetSnapshotsOfAllStagesOutput())
+				mutex.Unlock()
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			previewOutput.Status = PREVIEW_RUN_ERROR
+			previewOutput.Message = err.Error()
+		} else {
+			previewOutput.Status = PREVIEW_FINISHED
+		}
+	case <-time.After(timeout):
+		log.Printf("[WARN] Preview of pipeline %s timed out after %s", p.pipelineId, timeout)
+		previewOutput.Status = PREVIEW_TIMED_OUT
+	}
+	pipeline.Stop()
+
+	// a timed out preview may still complete a batch in the background
+	mutex.Lock()
+	previewOutput.BatchesOutput = append(previewOutput.BatchesOutput, batchesOutput...)
+	mutex.Unlock()
+	return previewOutput, nil
+}
+
+func NewPreviewRunner(
+	pipelineId string,
+	config execution.Config,
+	pipelineStoreTask pipelineStore.PipelineStoreTask,
+) *PreviewRunner {
+	return &PreviewRunner{
+		pipelineId:        pipelineId,
+		config:            config,
+		pipelineStoreTask: pipelineStoreTask,
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/execution/store"
+	"time"
+)
+
+// PreviewSourceOffsetTracker starts from the committed offset of the pipeline and keeps the offsets of the
+// previewed batches in memory, so a preview never moves the offset of the pipeline.
+type PreviewSourceOffsetTracker struct {
+	currentOffset string
+	newOffset     string
+	lastBatchTime time.Time
+}
+
+func (o *PreviewSourceOffsetTracker) IsFinished() bool {
+	return false
+}
+
+func (o *PreviewSourceOffsetTracker) SetOffset(newOffset string) {
+	o.newOffset = newOffset
+}
+
+func (o *PreviewSourceOffsetTracker) CommitOffset() error {
+	o.currentOffset = o.newOffset
+	o.newOffset = ""
+	o.lastBatchTime = time.Now()
+	return nil
+}
+
+func (o *PreviewSourceOffsetTracker) GetOffset() string {
+	return o.currentOffset
+}
+
+func (o *PreviewSourceOffsetTracker) GetLastBatchTime() time.Time {
+	return o.lastBatchTime
+}
+
+func NewPreviewSourceOffsetTracker(pipelineId string) (*PreviewSourceOffsetTracker, error) {
+	if sourceOffset, err := store.GetOffset(pipelineId); err == nil {
+		return &PreviewSourceOffsetTracker{
+			currentOffset: sourceOffset.Offset[common.POLL_SOURCE_OFFSET_KEY],
+		}, nil
+	} else {
+		return nil, err
+	}
+}
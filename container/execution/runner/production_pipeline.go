@@ -16,9 +16,11 @@
 package runner
 
 import (
+	"fmt"
 	"github.com/rcrowley/go-metrics"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/execution"
+	"github.com/streamsets/datacollector-edge/container/validation"
 	"log"
 )
 
@@ -26,22 +28,34 @@ const (
 	IssueErrorTemplate = "Initialization Error '%s' on Instance : '%s' "
 )
 
+// InitError is returned by Run when the stages of the pipeline failed to initialize, restarting the pipeline
+// doesn't help until its configuration is fixed.
+type InitError struct {
+	Issues []validation.Issue
+}
+
+func (e *InitError) Error() string {
+	return fmt.Sprintf(IssueErrorTemplate, e.Issues[0].Message, e.Issues[0].InstanceName)
+}
+
 type ProductionPipeline struct {
 	PipelineConfig common.PipelineConfiguration
 	Pipeline       *Pipeline
 	MetricRegistry metrics.Registry
 }
 
-func (p *ProductionPipeline) Run() {
+func (p *ProductionPipeline) Run() error {
 	log.Println("[DEBUG] Production Pipeline Run")
 	issues := p.Pipeline.Init()
 	if len(issues) == 0 {
-		p.Pipeline.Run()
-	} else {
-		for _, issue := range issues {
-			log.Printf("[ERROR] "+IssueErrorTemplate, issue.Message, issue.InstanceName)
-		}
+		return p.Pipeline.Run()
+	}
+	for _, issue := range issues {
+		log.Printf("[ERROR] "+IssueErrorTemplate, issue.Message, issue.InstanceName)
 	}
+	// release what the stages got during Init, like listening ports and connections
+	p.Pipeline.Stop()
+	return &InitError{Issues: issues}
 }
 
 func (p *ProductionPipeline) Stop() {
@@ -62,7 +76,21 @@ func NewProductionPipeline(
 ) (*ProductionPipeline, error) {
 	if sourceOffsetTracker, err := NewProductionSourceOffsetTracker(pipelineId); err == nil {
 		metricRegistry := metrics.NewRegistry()
-		pipeline, err := NewPipeline(config, standaloneRunner, sourceOffsetTracker, runtimeParameters, metricRegistry)
+		pipeline, err := NewPipeline(
+			config,
+			pipelineId,
+			pipelineConfiguration,
+			sourceOffsetTracker,
+			runtimeParameters,
+			metricRegistry,
+		)
+		if err == nil {
+			var ruleDefinitions common.RuleDefinitions
+			ruleDefinitions, err = standaloneRunner.pipelineStoreTask.RetrieveRules(pipelineId)
+			if err == nil {
+				err = pipeline.SetRuleDefinitions(ruleDefinitions)
+			}
+		}
 		return &ProductionPipeline{
 			PipelineConfig: pipelineConfiguration,
 			Pipeline:       pipeline,
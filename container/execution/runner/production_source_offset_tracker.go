@@ -18,6 +18,7 @@ package runner
 import (
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/execution/store"
+	"sync"
 	"time"
 )
 
@@ -27,6 +28,7 @@ type ProductionSourceOffsetTracker struct {
 	newOffset     string
 	finished      bool
 	lastBatchTime time.Time
+	mutex         sync.Mutex
 }
 
 func (o *ProductionSourceOffsetTracker) IsFinished() bool {
@@ -38,13 +40,18 @@ func (o *ProductionSourceOffsetTracker) SetOffset(newOffset string) {
 }
 
 func (o *ProductionSourceOffsetTracker) CommitOffset() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
 	o.currentOffset.Offset[common.POLL_SOURCE_OFFSET_KEY] = o.newOffset
 	o.finished = o.currentOffset.Offset[common.POLL_SOURCE_OFFSET_KEY] == ""
 	o.newOffset = ""
+	o.lastBatchTime = time.Now()
 	return store.SaveOffset(o.pipelineId, o.currentOffset)
 }
 
 func (o *ProductionSourceOffsetTracker) GetOffset() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
 	return o.currentOffset.Offset[common.POLL_SOURCE_OFFSET_KEY]
 }
 
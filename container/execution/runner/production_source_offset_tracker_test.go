@@ -0,0 +1,56 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"github.com/streamsets/datacollector-edge/container/execution/store"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestProductionSourceOffsetTracker_CommitOffset(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "TestProductionSourceOffsetTracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+	store.BaseDir = baseDir
+	defer func() { store.BaseDir = "." }()
+
+	pipelineId := "multithreaded"
+	if err = os.MkdirAll(store.GetRunInfoDir(pipelineId), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	offsetTracker, err := NewProductionSourceOffsetTracker(pipelineId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsetTracker.SetOffset("offset-1")
+	if err = offsetTracker.CommitOffset(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the committed offset is where the pipeline resumes from, whatever the number of runners
+	offsetTracker, err = NewProductionSourceOffsetTracker(pipelineId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offsetTracker.GetOffset() != "offset-1" {
+		t.Errorf("Expected offset 'offset-1', but got '%s'", offsetTracker.GetOffset())
+	}
+}
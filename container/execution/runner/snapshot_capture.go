@@ -0,0 +1,111 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"encoding/json"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/execution/store"
+	"log"
+	"sync"
+	"time"
+)
+
+type snapshotData struct {
+	SnapshotBatches [][]StageOutput `json:"snapshotBatches"`
+}
+
+// SnapshotCapture collects the stage outputs of the next batches of a running pipeline and saves them under
+// the run info directory of the pipeline once all the requested batches are captured or the pipeline stops.
+type SnapshotCapture struct {
+	mutex            sync.Mutex
+	pipelineId       string
+	info             *common.SnapshotInfo
+	requestedBatches int
+	batchesOutput    [][]StageOutput
+}
+
+// requestBatch reports whether the next produced batch has to be captured
+func (s *SnapshotCapture) requestBatch() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.info.InProgress || s.requestedBatches >= s.info.Batches {
+		return false
+	}
+	s.requestedBatches++
+	return true
+}
+
+func (s *SnapshotCapture) addBatch(stageOutputs []StageOutput) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.info.InProgress {
+		return
+	}
+	s.batchesOutput = append(s.batchesOutput, stageOutputs)
+	s.info.CapturedBatches = len(s.batchesOutput)
+	if s.info.CapturedBatches >= s.info.Batches {
+		s.save()
+	} else if err := store.SaveSnapshotInfo(s.pipelineId, s.info); err != nil {
+		log.Printf("[ERROR] Failed to save snapshot '%s' info: %s", s.info.Name, err.Error())
+	}
+}
+
+func (s *SnapshotCapture) IsInProgress() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.info.InProgress
+}
+
+// Finish saves the batches captured so far
+func (s *SnapshotCapture) Finish() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.info.InProgress {
+		s.save()
+	}
+}
+
+func (s *SnapshotCapture) save() {
+	log.Printf("[INFO] Saving snapshot '%s' with %d batches", s.info.Name, len(s.batchesOutput))
+	s.info.InProgress = false
+	data, err := json.Marshal(snapshotData{SnapshotBatches: s.batchesOutput})
+	if err == nil {
+		err = store.SaveSnapshotData(s.pipelineId, s.info.Name, data)
+	}
+	if err == nil {
+		err = store.SaveSnapshotInfo(s.pipelineId, s.info)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to save snapshot '%s': %s", s.info.Name, err.Error())
+	}
+	s.batchesOutput = nil
+}
+
+func NewSnapshotCapture(pipelineId string, snapshotName string, batches int) (*SnapshotCapture, error) {
+	snapshotCapture := &SnapshotCapture{
+		pipelineId: pipelineId,
+		info: &common.SnapshotInfo{
+			PipelineId: pipelineId,
+			Name:       snapshotName,
+			TimeStamp:  time.Now().UTC(),
+			Batches:    batches,
+			InProgress: true,
+		},
+		batchesOutput: make([][]StageOutput, 0),
+	}
+	return snapshotCapture, store.SaveSnapshotInfo(pipelineId, snapshotCapture.info)
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"encoding/json"
+	"github.com/streamsets/datacollector-edge/container/execution/store"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSnapshotCapture(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "snapshot_capture_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(baseDir)
+	originalBaseDir := store.BaseDir
+	store.BaseDir = baseDir
+	defer func() { store.BaseDir = originalBaseDir }()
+
+	snapshotCapture, err := NewSnapshotCapture("pipeline1", "snapshot1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if snapshotCapture.requestBatch() != (i < 2) {
+			t.Errorf("Unexpected batch request result for batch %d", i)
+		}
+	}
+
+	snapshotCapture.addBatch([]StageOutput{{InstanceName: "stage1"}})
+	if !snapshotCapture.IsInProgress() {
+		t.Error("Expected snapshot to be in progress after the first batch")
+	}
+	snapshotCapture.addBatch([]StageOutput{{InstanceName: "stage1"}})
+	if snapshotCapture.IsInProgress() {
+		t.Error("Expected snapshot to be finished after the second batch")
+	}
+
+	snapshotInfo, err := store.GetSnapshotInfo("pipeline1", "snapshot1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshotInfo.InProgress || snapshotInfo.CapturedBatches != 2 {
+		t.Errorf("Unexpected snapshot info: %+v", snapshotInfo)
+	}
+
+	snapshotInfos, err := store.GetSnapshotInfos("pipeline1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshotInfos) != 1 {
+		t.Errorf("Expected 1 snapshot, but got %d", len(snapshotInfos))
+	}
+
+	data, err := store.GetSnapshotData("pipeline1", "snapshot1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot map[string][]interface{}
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot["snapshotBatches"]) != 2 {
+		t.Errorf("Expected 2 captured batches, but got %d", len(snapshot["snapshotBatches"]))
+	}
+
+	if err = store.DeleteSnapshot("pipeline1", "snapshot1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.GetSnapshotInfo("pipeline1", "snapshot1"); err == nil {
+		t.Error("Expected error when reading a deleted snapshot")
+	}
+
+	if _, err = NewSnapshotCapture("pipeline1", "../snapshot", 1); err == nil {
+		t.Error("Expected error for invalid snapshot name")
+	}
+}
@@ -15,5 +15,86 @@
  */
 package runner
 
+import (
+	"encoding/json"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/recordio/sdcrecord"
+)
+
+// StageOutput holds the input, output and error records of a stage for one batch, captured for preview
+// and snapshots. Records are marshalled to JSON in the SDC record format.
 type StageOutput struct {
+	InstanceName string
+	Input        []api.Record
+	Output       map[string][]api.Record
+	ErrorRecords []api.Record
+	StageErrors  []error
+}
+
+type stageOutputJson struct {
+	InstanceName string                            `json:"instanceName"`
+	Input        []*sdcrecord.SDCRecord            `json:"input"`
+	Output       map[string][]*sdcrecord.SDCRecord `json:"output"`
+	ErrorRecords []*sdcrecord.SDCRecord            `json:"errorRecords"`
+	StageErrors  []string                          `json:"stageErrors"`
+}
+
+func (s StageOutput) MarshalJSON() ([]byte, error) {
+	var err error
+	stageOutput := stageOutputJson{
+		InstanceName: s.InstanceName,
+		Output:       make(map[string][]*sdcrecord.SDCRecord),
+		StageErrors:  make([]string, len(s.StageErrors)),
+	}
+	if stageOutput.Input, err = toSdcRecords(s.Input); err != nil {
+		return nil, err
+	}
+	for lane, records := range s.Output {
+		if stageOutput.Output[lane], err = toSdcRecords(records); err != nil {
+			return nil, err
+		}
+	}
+	if stageOutput.ErrorRecords, err = toSdcRecords(s.ErrorRecords); err != nil {
+		return nil, err
+	}
+	for i, stageError := range s.StageErrors {
+		stageOutput.StageErrors[i] = stageError.Error()
+	}
+	return json.Marshal(stageOutput)
+}
+
+func NewStageOutput(
+	instanceName string,
+	input []api.Record,
+	output map[string][]api.Record,
+	errorRecords []api.Record,
+	stageErrors []error,
+) StageOutput {
+	return StageOutput{
+		InstanceName: instanceName,
+		Input:        input,
+		Output:       output,
+		ErrorRecords: errorRecords,
+		StageErrors:  stageErrors,
+	}
+}
+
+func cloneRecords(records []api.Record) []api.Record {
+	clonedRecords := make([]api.Record, len(records))
+	for i, record := range records {
+		clonedRecords[i] = record.Clone()
+	}
+	return clonedRecords
+}
+
+func toSdcRecords(records []api.Record) ([]*sdcrecord.SDCRecord, error) {
+	sdcRecords := make([]*sdcrecord.SDCRecord, len(records))
+	for i, record := range records {
+		sdcRecord, err := sdcrecord.NewSdcRecordFromRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		sdcRecords[i] = sdcRecord
+	}
+	return sdcRecords, nil
 }
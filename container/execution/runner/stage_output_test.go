@@ -0,0 +1,64 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"testing"
+)
+
+func TestStageOutput_MarshalJSON(t *testing.T) {
+	stageContext := &common.StageContextImpl{}
+	record, err := stageContext.CreateRecord("sourceId", "sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stageOutput := NewStageOutput(
+		"stage_01",
+		[]api.Record{record},
+		map[string][]api.Record{"lane1": {record}},
+		[]api.Record{},
+		[]error{errors.New("stage error")},
+	)
+
+	data, err := json.Marshal(stageOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stageOutputJson map[string]interface{}
+	if err = json.Unmarshal(data, &stageOutputJson); err != nil {
+		t.Fatal(err)
+	}
+	if stageOutputJson["instanceName"] != "stage_01" {
+		t.Errorf("Expected instance name 'stage_01', but got %v", stageOutputJson["instanceName"])
+	}
+	if len(stageOutputJson["input"].([]interface{})) != 1 {
+		t.Errorf("Expected 1 input record, but got %v", stageOutputJson["input"])
+	}
+	laneRecords := stageOutputJson["output"].(map[string]interface{})["lane1"].([]interface{})
+	sdcRecordValue := laneRecords[0].(map[string]interface{})["value"].(map[string]interface{})
+	if sdcRecordValue["value"] != "sample" {
+		t.Errorf("Expected output record value 'sample', but got %v", sdcRecordValue["value"])
+	}
+	if stageOutputJson["stageErrors"].([]interface{})[0] != "stage error" {
+		t.Errorf("Expected stage error 'stage error', but got %v", stageOutputJson["stageErrors"])
+	}
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	STAGE_RETRIES = ".stageRetries"
+)
+
+// getRetryBackoff returns how long to wait before the given retry attempt (starting at 0), the interval
+// doubles on every attempt up to the max backoff and when jitter is enabled a random interval up to
+// the computed one is used instead.
+func getRetryBackoff(stageConfigBean creation.StageConfigBean, attempt int) time.Duration {
+	backoff := stageConfigBean.StageRetryInitialBackoff * math.Pow(2, float64(attempt))
+	if stageConfigBean.StageRetryMaxBackoff > 0 && backoff > stageConfigBean.StageRetryMaxBackoff {
+		backoff = stageConfigBean.StageRetryMaxBackoff
+	}
+	if stageConfigBean.StageRetryJitter && backoff > 0 {
+		backoff = rand.Float64() * backoff
+	}
+	return time.Duration(backoff) * time.Millisecond
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"testing"
+	"time"
+)
+
+func TestGetRetryBackoff(t *testing.T) {
+	stageConfigBean := creation.StageConfigBean{
+		StageRetryInitialBackoff: 100,
+		StageRetryMaxBackoff:     1000,
+		StageRetryJitter:         false,
+	}
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1000 * time.Millisecond,
+		1000 * time.Millisecond,
+	}
+	for attempt, expectedBackoff := range expected {
+		backoff := getRetryBackoff(stageConfigBean, attempt)
+		if backoff != expectedBackoff {
+			t.Errorf("Expected backoff %s for attempt %d, but got %s", expectedBackoff, attempt, backoff)
+		}
+	}
+
+	stageConfigBean.StageRetryJitter = true
+	for attempt := 0; attempt < 10; attempt++ {
+		backoff := getRetryBackoff(stageConfigBean, attempt)
+		if backoff < 0 || backoff > 1000*time.Millisecond {
+			t.Errorf("Expected backoff with jitter between 0 and 1s, but got %s", backoff)
+		}
+	}
+}
+
+func TestStageConfigBean_OnFailureDefault(t *testing.T) {
+	stageConfigBean := creation.NewStageConfigBean(common.StageConfiguration{})
+	if stageConfigBean.StageOnFailure != creation.ON_RECORD_ERROR_STOP_PIPELINE {
+		t.Errorf("Expected stage failures to stop the pipeline by default, but got '%s'", stageConfigBean.StageOnFailure)
+	}
+}
+
+func processTestPipe(pipe *testPipe) (*FullPipeBatch, bool, error) {
+	p := &Pipeline{stopped: make(chan struct{})}
+	pipeBatch := NewFullPipeBatch("0", 1, common.NewErrorSink(), common.NewEventSink())
+	failed, err := p.processPipe(pipe, pipeBatch)
+	return pipeBatch, failed, err
+}
+
+func TestProcessPipe_Retry(t *testing.T) {
+	pipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 2)
+	pipe.systemConfigs.StageRetryAttempts = 2
+
+	pipeBatch, failed, err := processTestPipe(pipe)
+	if failed || err != nil {
+		t.Fatalf("Expected the stage to succeed on the last retry, but got failed: %t, error: %v", failed, err)
+	}
+	if pipe.processed != 3 || pipe.retries != 2 {
+		t.Errorf("Expected 3 attempts and 2 retries, but got %d attempts and %d retries", pipe.processed, pipe.retries)
+	}
+	// the records and errors of the failed attempts are not counted
+	if pipeBatch.GetOutputRecords() != 1 {
+		t.Errorf("Expected 1 output record, but got %d", pipeBatch.GetOutputRecords())
+	}
+	if pipeBatch.GetErrorMessages() != 0 {
+		t.Errorf("Expected no error messages, but got %d", pipeBatch.GetErrorMessages())
+	}
+}
+
+func TestProcessPipe_StopPipeline(t *testing.T) {
+	pipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 2)
+	pipe.systemConfigs.StageRetryAttempts = 1
+
+	_, failed, err := processTestPipe(pipe)
+	if !failed || err == nil {
+		t.Errorf("Expected the stage failure to stop the pipeline, but got failed: %t, error: %v", failed, err)
+	}
+	if pipe.processed != 2 {
+		t.Errorf("Expected 2 attempts, but got %d", pipe.processed)
+	}
+}
+
+func TestProcessPipe_Discard(t *testing.T) {
+	pipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_DISCARD, 1)
+
+	pipeBatch, failed, err := processTestPipe(pipe)
+	if !failed || err != nil {
+		t.Fatalf("Expected the stage to fail without error, but got failed: %t, error: %v", failed, err)
+	}
+	if pipe.toError != 0 {
+		t.Error("Expected the batch to be discarded, but it was sent to error")
+	}
+	if pipeBatch.GetOutputRecords() != 0 || pipeBatch.GetErrorMessages() != 0 {
+		t.Errorf(
+			"Expected no output records or error messages, but got %d and %d",
+			pipeBatch.GetOutputRecords(),
+			pipeBatch.GetErrorMessages(),
+		)
+	}
+}
+
+func TestProcessPipe_ToError(t *testing.T) {
+	pipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_TO_ERROR, 1)
+
+	pipeBatch, failed, err := processTestPipe(pipe)
+	if !failed || err != nil {
+		t.Fatalf("Expected the stage to fail without error, but got failed: %t, error: %v", failed, err)
+	}
+	if pipe.toError != 1 {
+		t.Errorf("Expected the batch to be sent to error once, but got %d", pipe.toError)
+	}
+	if pipeBatch.GetOutputRecords() != 0 {
+		t.Errorf("Expected no output records, but got %d", pipeBatch.GetOutputRecords())
+	}
+	if messages := pipeBatch.GetErrorSink().GetStageErrorMessages("destination"); len(messages) != 1 ||
+		messages[0].Error() != "stage failed" {
+		t.Errorf("Expected the stage failure as only error message, but got %v", messages)
+	}
+}
+
+func TestProcessPipe_StopWhileRetrying(t *testing.T) {
+	pipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 1)
+	pipe.systemConfigs.StageRetryAttempts = 1
+	pipe.systemConfigs.StageRetryInitialBackoff = 60000
+
+	p := &Pipeline{stopped: make(chan struct{})}
+	pipeBatch := NewFullPipeBatch("0", 1, common.NewErrorSink(), common.NewEventSink())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(p.stopped)
+	}()
+
+	start := time.Now()
+	failed, err := p.processPipe(pipe, pipeBatch)
+	if !failed || err != nil {
+		t.Errorf("Expected the stage to fail without error, but got failed: %t, error: %v", failed, err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected stopping the pipeline to interrupt the retry backoff, but it took %s", elapsed)
+	}
+	if pipe.processed != 1 || pipe.retries != 0 {
+		t.Errorf("Expected no retry after stopping, but got %d attempts", pipe.processed)
+	}
+}
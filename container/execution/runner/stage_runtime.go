@@ -58,6 +58,24 @@ func (s *StageRuntime) Execute(
 	return newOffset, err
 }
 
+// Commit lets the origin acknowledge the data of the batch produced with the given offset, for origins
+// implementing api.OffsetCommitter.
+func (s *StageRuntime) Commit(offset string) error {
+	if offsetCommitter, ok := s.stageBean.Stage.(api.OffsetCommitter); ok {
+		return offsetCommitter.Commit(offset)
+	}
+	return nil
+}
+
+// Reject lets the origin release the data of the batch produced with the given offset that was not committed, for
+// origins implementing api.OffsetRejecter.
+func (s *StageRuntime) Reject(offset string) error {
+	if offsetRejecter, ok := s.stageBean.Stage.(api.OffsetRejecter); ok {
+		return offsetRejecter.Reject(offset)
+	}
+	return nil
+}
+
 func (s *StageRuntime) Destroy() {
 	s.stageBean.Stage.Destroy()
 }
@@ -24,6 +24,7 @@ import (
 	pipelineStore "github.com/streamsets/datacollector-edge/container/store"
 	"github.com/streamsets/datacollector-edge/container/util"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -41,6 +42,13 @@ var (
 		common.FINISHED,
 		common.STOPPED,
 	}
+
+	// pipelines left in these statuses by the previous edge process are restarted on startup
+	RESTORE_STATUSES = []string{
+		common.RETRY,
+		common.RUNNING,
+		common.STARTING,
+	}
 )
 
 type StandaloneRunner struct {
@@ -53,6 +61,9 @@ type StandaloneRunner struct {
 	prodPipeline         *ProductionPipeline
 	metricsEventRunnable *MetricsEventRunnable
 	pipelineStoreTask    pipelineStore.PipelineStoreTask
+	runtimeParameters    map[string]interface{}
+	retryTimer           *time.Timer
+	stateMutex           sync.Mutex
 }
 
 func (standaloneRunner *StandaloneRunner) init() error {
@@ -60,7 +71,13 @@ func (standaloneRunner *StandaloneRunner) init() error {
 	standaloneRunner.validTransitions[common.EDITED] = []string{common.STARTING}
 	standaloneRunner.validTransitions[common.STARTING] = []string{common.START_ERROR, common.RUNNING, common.STOPPING}
 	standaloneRunner.validTransitions[common.START_ERROR] = []string{common.STARTING}
-	standaloneRunner.validTransitions[common.RUNNING] = []string{common.RUNNING_ERROR, common.FINISHING, common.STOPPING}
+	// the stages are initialized once the pipeline is running
+	standaloneRunner.validTransitions[common.RUNNING] = []string{
+		common.START_ERROR,
+		common.RUNNING_ERROR,
+		common.FINISHING,
+		common.STOPPING,
+	}
 	standaloneRunner.validTransitions[common.RUNNING_ERROR] = []string{common.RETRY, common.RUN_ERROR}
 	standaloneRunner.validTransitions[common.RETRY] = []string{common.STARTING, common.STOPPING}
 	standaloneRunner.validTransitions[common.RUN_ERROR] = []string{common.STARTING}
@@ -74,12 +91,22 @@ func (standaloneRunner *StandaloneRunner) init() error {
 	return err
 }
 
+func (standaloneRunner *StandaloneRunner) GetPipelineId() string {
+	return standaloneRunner.pipelineId
+}
+
 func (standaloneRunner *StandaloneRunner) GetPipelineConfig() common.PipelineConfiguration {
 	return standaloneRunner.pipelineConfig
 }
 
 func (standaloneRunner *StandaloneRunner) GetStatus() (*common.PipelineState, error) {
-	return standaloneRunner.pipelineState, nil
+	if standaloneRunner.prodPipeline == nil || standaloneRunner.pipelineState.Status != common.RUNNING {
+		return standaloneRunner.pipelineState, nil
+	}
+	// alerts are only kept in memory while the pipeline runs, don't persist them with the state
+	pipelineState := *standaloneRunner.pipelineState
+	pipelineState.Alerts = standaloneRunner.prodPipeline.Pipeline.GetAlerts()
+	return &pipelineState, nil
 }
 
 func (standaloneRunner *StandaloneRunner) GetHistory() ([]*common.PipelineState, error) {
@@ -95,11 +122,53 @@ func (standaloneRunner *StandaloneRunner) GetMetrics() (metrics.Registry, error)
 
 func (standaloneRunner *StandaloneRunner) StartPipeline(
 	runtimeParameters map[string]interface{},
+) (*common.PipelineState, error) {
+	standaloneRunner.stateMutex.Lock()
+	defer standaloneRunner.stateMutex.Unlock()
+	err := standaloneRunner.checkState(common.STARTING)
+	if err != nil {
+		return nil, err
+	}
+	// a manual start begins a new series of retries
+	standaloneRunner.cancelRetry()
+	return standaloneRunner.startPipeline(runtimeParameters)
+}
+
+// RestorePipeline restarts the pipeline from its committed offset with its last runtime parameters when the
+// previous edge process left it running, starting or waiting for a retry. Pipelines in any other status, or
+// already started by this process, are left alone and nil is returned.
+func (standaloneRunner *StandaloneRunner) RestorePipeline() (*common.PipelineState, error) {
+	standaloneRunner.stateMutex.Lock()
+	defer standaloneRunner.stateMutex.Unlock()
+	if standaloneRunner.prodPipeline != nil ||
+		!util.Contains(RESTORE_STATUSES, standaloneRunner.pipelineState.Status) {
+		return nil, nil
+	}
+	log.Printf(
+		"[INFO] Restoring pipeline %s left in %s state",
+		standaloneRunner.pipelineId,
+		standaloneRunner.pipelineState.Status,
+	)
+	var runtimeParameters map[string]interface{}
+	if standaloneRunner.pipelineState.Attributes != nil {
+		runtimeParameters, _ = standaloneRunner.pipelineState.Attributes[store.RUNTIME_PARAMETERS].(map[string]interface{})
+	}
+	return standaloneRunner.startPipeline(runtimeParameters)
+}
+
+// startPipeline starts the pipeline, callers are responsible for checking the current status allows it.
+func (standaloneRunner *StandaloneRunner) startPipeline(
+	runtimeParameters map[string]interface{},
 ) (*common.PipelineState, error) {
 	log.Printf("[INFO] Starting pipeline %s", standaloneRunner.pipelineId)
 	var err error
-	err = standaloneRunner.checkState(common.STARTING)
-	if err != nil {
+	standaloneRunner.runtimeParameters = runtimeParameters
+	if standaloneRunner.pipelineState.Attributes == nil {
+		standaloneRunner.pipelineState.Attributes = make(map[string]interface{})
+	}
+	// saved along with the state, so that the pipeline can be restored with the same parameters
+	standaloneRunner.pipelineState.Attributes[store.RUNTIME_PARAMETERS] = runtimeParameters
+	if err = standaloneRunner.setState(common.STARTING, ""); err != nil {
 		return nil, err
 	}
 
@@ -107,6 +176,7 @@ func (standaloneRunner *StandaloneRunner) StartPipeline(
 		standaloneRunner.pipelineId,
 	)
 	if err != nil {
+		standaloneRunner.startError(err)
 		return nil, err
 	}
 
@@ -117,10 +187,16 @@ func (standaloneRunner *StandaloneRunner) StartPipeline(
 		standaloneRunner.pipelineConfig,
 		runtimeParameters,
 	); err != nil {
+		standaloneRunner.startError(err)
 		return nil, err
 	}
+	prodPipeline := standaloneRunner.prodPipeline
+	prodPipeline.Pipeline.onFirstCommit = func() {
+		// a stop holds the state lock while it waits for the batch being committed
+		go standaloneRunner.resetRetryAttempt(prodPipeline)
+	}
 
-	go standaloneRunner.prodPipeline.Run()
+	go standaloneRunner.runPipeline(standaloneRunner.prodPipeline)
 
 	if standaloneRunner.runtimeInfo.DPMEnabled && standaloneRunner.IsRemotePipeline() {
 		standaloneRunner.metricsEventRunnable = NewMetricsEventRunnable(
@@ -133,10 +209,7 @@ func (standaloneRunner *StandaloneRunner) StartPipeline(
 		go standaloneRunner.metricsEventRunnable.Run()
 	}
 
-	standaloneRunner.pipelineState.Status = common.RUNNING
-	standaloneRunner.pipelineState.TimeStamp = time.Now().UTC()
-
-	if err = store.SaveState(standaloneRunner.pipelineId, standaloneRunner.pipelineState); err != nil {
+	if err = standaloneRunner.setState(common.RUNNING, ""); err != nil {
 		return nil, err
 	}
 
@@ -145,13 +218,16 @@ func (standaloneRunner *StandaloneRunner) StartPipeline(
 
 func (standaloneRunner *StandaloneRunner) StopPipeline() (*common.PipelineState, error) {
 	log.Printf("[INFO] Stopping pipeline %s", standaloneRunner.pipelineId)
+	standaloneRunner.stateMutex.Lock()
+	defer standaloneRunner.stateMutex.Unlock()
 	var err error
 	err = standaloneRunner.checkState(common.STOPPING)
 	if err != nil {
 		return nil, err
 	}
 
-	if standaloneRunner.prodPipeline != nil {
+	// a pipeline waiting for a retry has already been stopped when it failed
+	if standaloneRunner.prodPipeline != nil && standaloneRunner.pipelineState.Status != common.RETRY {
 		standaloneRunner.prodPipeline.Stop()
 	}
 
@@ -159,16 +235,164 @@ func (standaloneRunner *StandaloneRunner) StopPipeline() (*common.PipelineState,
 		standaloneRunner.metricsEventRunnable.Stop()
 	}
 
-	standaloneRunner.pipelineState.Status = common.STOPPED
-	standaloneRunner.pipelineState.TimeStamp = time.Now().UTC()
-	err = store.SaveState(standaloneRunner.pipelineId, standaloneRunner.pipelineState)
-	if err != nil {
+	standaloneRunner.cancelRetry()
+	if err = standaloneRunner.setState(common.STOPPED, ""); err != nil {
 		return nil, err
 	}
 
 	return standaloneRunner.pipelineState, nil
 }
 
+// runPipeline runs the given pipeline until it is done and handles the error that made it stop, if any.
+func (standaloneRunner *StandaloneRunner) runPipeline(prodPipeline *ProductionPipeline) {
+	if err := prodPipeline.Run(); err != nil {
+		standaloneRunner.handleRunError(prodPipeline, err)
+	}
+}
+
+// handleRunError moves a pipeline that failed while running to RUNNING_ERROR, then either schedules its
+// restart in RETRY state, or leaves it in RUN_ERROR once the pipeline's retry attempts are exhausted.
+// A pipeline whose stages failed to initialize goes to START_ERROR and is not restarted.
+func (standaloneRunner *StandaloneRunner) handleRunError(prodPipeline *ProductionPipeline, runErr error) {
+	standaloneRunner.stateMutex.Lock()
+	defer standaloneRunner.stateMutex.Unlock()
+
+	_, isInitError := runErr.(*InitError)
+	errorStatus := common.RUNNING_ERROR
+	if isInitError {
+		errorStatus = common.START_ERROR
+	}
+	if standaloneRunner.prodPipeline != prodPipeline || standaloneRunner.checkState(errorStatus) != nil {
+		// pipeline was stopped or restarted in the meantime
+		return
+	}
+
+	log.Printf("[ERROR] Pipeline %s failed: %s", standaloneRunner.pipelineId, runErr)
+
+	if standaloneRunner.metricsEventRunnable != nil {
+		standaloneRunner.metricsEventRunnable.Stop()
+		standaloneRunner.metricsEventRunnable = nil
+	}
+
+	if isInitError {
+		standaloneRunner.cancelRetry()
+		standaloneRunner.startError(runErr)
+		return
+	}
+
+	if err := standaloneRunner.setState(common.RUNNING_ERROR, runErr.Error()); err != nil {
+		log.Printf("[ERROR] Failed to save state of pipeline %s: %s", standaloneRunner.pipelineId, err)
+	}
+
+	pipelineConfigBean := prodPipeline.Pipeline.pipelineBean.Config
+	retryAttempt := standaloneRunner.getRetryAttempt()
+	if !pipelineConfigBean.ShouldRetry ||
+		(pipelineConfigBean.RetryAttempts >= 0 && retryAttempt >= int(pipelineConfigBean.RetryAttempts)) {
+		if err := standaloneRunner.setState(common.RUN_ERROR, runErr.Error()); err != nil {
+			log.Printf("[ERROR] Failed to save state of pipeline %s: %s", standaloneRunner.pipelineId, err)
+		}
+		return
+	}
+
+	retryAttempt++
+	retryDelay := standaloneRunner.getRetryDelay(retryAttempt)
+	nextRetryTime := time.Now().Add(retryDelay)
+	standaloneRunner.pipelineState.Attributes[store.RETRY_ATTEMPT] = retryAttempt
+	standaloneRunner.pipelineState.Attributes[store.NEXT_RETRY_TIME_STAMP] = util.ConvertTimeToLong(nextRetryTime)
+	log.Printf(
+		"[INFO] Retrying pipeline %s in %s, attempt %d",
+		standaloneRunner.pipelineId,
+		retryDelay,
+		retryAttempt,
+	)
+	if err := standaloneRunner.setState(common.RETRY, runErr.Error()); err != nil {
+		log.Printf("[ERROR] Failed to save state of pipeline %s: %s", standaloneRunner.pipelineId, err)
+	}
+	standaloneRunner.retryTimer = time.AfterFunc(retryDelay, standaloneRunner.retryPipeline)
+}
+
+func (standaloneRunner *StandaloneRunner) retryPipeline() {
+	standaloneRunner.stateMutex.Lock()
+	defer standaloneRunner.stateMutex.Unlock()
+	if standaloneRunner.pipelineState.Status != common.RETRY {
+		return
+	}
+	standaloneRunner.retryTimer = nil
+	if _, err := standaloneRunner.startPipeline(standaloneRunner.runtimeParameters); err != nil {
+		log.Printf("[ERROR] Failed to restart pipeline %s: %s", standaloneRunner.pipelineId, err)
+	}
+}
+
+// resetRetryAttempt resets the retry attempts of a restarted pipeline once it committed its first batch, so that
+// only consecutive failures use up the pipeline's retry attempts.
+func (standaloneRunner *StandaloneRunner) resetRetryAttempt(prodPipeline *ProductionPipeline) {
+	standaloneRunner.stateMutex.Lock()
+	defer standaloneRunner.stateMutex.Unlock()
+	if standaloneRunner.prodPipeline != prodPipeline || standaloneRunner.pipelineState.Status != common.RUNNING ||
+		standaloneRunner.getRetryAttempt() == 0 {
+		return
+	}
+	log.Printf("[INFO] Pipeline %s recovered, resetting its retry attempts", standaloneRunner.pipelineId)
+	delete(standaloneRunner.pipelineState.Attributes, store.RETRY_ATTEMPT)
+	delete(standaloneRunner.pipelineState.Attributes, store.NEXT_RETRY_TIME_STAMP)
+	if err := standaloneRunner.setState(common.RUNNING, ""); err != nil {
+		log.Printf("[ERROR] Failed to save state of pipeline %s: %s", standaloneRunner.pipelineId, err)
+	}
+}
+
+// cancelRetry cancels the scheduled restart of the pipeline and resets the retry attempts.
+func (standaloneRunner *StandaloneRunner) cancelRetry() {
+	if standaloneRunner.retryTimer != nil {
+		standaloneRunner.retryTimer.Stop()
+		standaloneRunner.retryTimer = nil
+	}
+	if standaloneRunner.pipelineState.Attributes != nil {
+		delete(standaloneRunner.pipelineState.Attributes, store.RETRY_ATTEMPT)
+		delete(standaloneRunner.pipelineState.Attributes, store.NEXT_RETRY_TIME_STAMP)
+	}
+}
+
+func (standaloneRunner *StandaloneRunner) getRetryAttempt() int {
+	switch retryAttempt := standaloneRunner.pipelineState.Attributes[store.RETRY_ATTEMPT].(type) {
+	case int:
+		return retryAttempt
+	case float64:
+		// attributes read back from the state file
+		return int(retryAttempt)
+	}
+	return 0
+}
+
+// getRetryDelay returns the wait before the given retry attempt, doubling the base interval on every
+// attempt up to the max interval.
+func (standaloneRunner *StandaloneRunner) getRetryDelay(retryAttempt int) time.Duration {
+	delay := standaloneRunner.config.RetryBaseInterval
+	for i := 1; i < retryAttempt && delay < standaloneRunner.config.RetryMaxInterval; i++ {
+		delay *= 2
+	}
+	if delay > standaloneRunner.config.RetryMaxInterval {
+		delay = standaloneRunner.config.RetryMaxInterval
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+func (standaloneRunner *StandaloneRunner) startError(err error) {
+	if saveErr := standaloneRunner.setState(common.START_ERROR, err.Error()); saveErr != nil {
+		log.Printf("[ERROR] Failed to save state of pipeline %s: %s", standaloneRunner.pipelineId, saveErr)
+	}
+}
+
+// setState changes the status of the pipeline and records the new state in the pipeline state history.
+func (standaloneRunner *StandaloneRunner) setState(status string, message string) error {
+	if standaloneRunner.pipelineState.Attributes == nil {
+		standaloneRunner.pipelineState.Attributes = make(map[string]interface{})
+	}
+	standaloneRunner.pipelineState.Status = status
+	standaloneRunner.pipelineState.Message = message
+	standaloneRunner.pipelineState.TimeStamp = time.Now().UTC()
+	return store.SaveState(standaloneRunner.pipelineId, standaloneRunner.pipelineState)
+}
+
 func (standaloneRunner *StandaloneRunner) ResetOffset() error {
 	if util.Contains(RESET_OFFSET_DISALLOWED_STATUSES, standaloneRunner.pipelineState.Status) {
 		return errors.New("Cannot reset the source offset when the pipeline is running")
@@ -189,6 +413,52 @@ func (standaloneRunner *StandaloneRunner) GetOffset() (common.SourceOffset, erro
 	return store.GetOffset(standaloneRunner.pipelineId)
 }
 
+func (standaloneRunner *StandaloneRunner) CaptureSnapshot(
+	snapshotName string,
+	batches int,
+) (*common.SnapshotInfo, error) {
+	if standaloneRunner.prodPipeline == nil || standaloneRunner.pipelineState.Status != common.RUNNING {
+		return nil, errors.New("Cannot capture a snapshot when the pipeline is not running")
+	}
+	if batches < 1 {
+		return nil, errors.New("Number of batches to capture must be greater than 0")
+	}
+	return standaloneRunner.prodPipeline.Pipeline.CaptureSnapshot(snapshotName, batches)
+}
+
+func (standaloneRunner *StandaloneRunner) GetSnapshotsInfo() ([]*common.SnapshotInfo, error) {
+	return store.GetSnapshotInfos(standaloneRunner.pipelineId)
+}
+
+func (standaloneRunner *StandaloneRunner) GetSnapshotInfo(snapshotName string) (*common.SnapshotInfo, error) {
+	return store.GetSnapshotInfo(standaloneRunner.pipelineId, snapshotName)
+}
+
+func (standaloneRunner *StandaloneRunner) GetSnapshot(snapshotName string) ([]byte, error) {
+	return store.GetSnapshotData(standaloneRunner.pipelineId, snapshotName)
+}
+
+func (standaloneRunner *StandaloneRunner) DeleteSnapshot(snapshotName string) error {
+	if standaloneRunner.prodPipeline != nil && standaloneRunner.prodPipeline.Pipeline.IsCapturingSnapshot(snapshotName) {
+		return errors.New("Cannot delete snapshot '" + snapshotName + "' while it is being captured")
+	}
+	return store.DeleteSnapshot(standaloneRunner.pipelineId, snapshotName)
+}
+
+func (standaloneRunner *StandaloneRunner) GetAlerts() ([]*common.AlertInfo, error) {
+	if standaloneRunner.prodPipeline == nil {
+		return []*common.AlertInfo{}, nil
+	}
+	return standaloneRunner.prodPipeline.Pipeline.GetAlerts(), nil
+}
+
+func (standaloneRunner *StandaloneRunner) DeleteAlert(ruleId string) error {
+	if standaloneRunner.prodPipeline == nil {
+		return errors.New("Pipeline is not running")
+	}
+	return standaloneRunner.prodPipeline.Pipeline.DeleteAlert(ruleId)
+}
+
 func (standaloneRunner *StandaloneRunner) checkState(toState string) error {
 	supportedList := standaloneRunner.validTransitions[standaloneRunner.pipelineState.Status]
 	if !util.Contains(supportedList, toState) {
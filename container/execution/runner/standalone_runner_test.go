@@ -0,0 +1,311 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package runner
+
+import (
+	"errors"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution"
+	"github.com/streamsets/datacollector-edge/container/execution/store"
+	pipelineStore "github.com/streamsets/datacollector-edge/container/store"
+	"github.com/streamsets/datacollector-edge/container/validation"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type failingPipelineStoreTask struct {
+	pipelineStore.PipelineStoreTask
+}
+
+func (f *failingPipelineStoreTask) LoadPipelineConfig(pipelineId string) (common.PipelineConfiguration, error) {
+	return common.PipelineConfiguration{}, errors.New("pipeline " + pipelineId + " not found")
+}
+
+func createStandaloneRunner(t *testing.T, config execution.Config) *StandaloneRunner {
+	baseDir, err := ioutil.TempDir("", "TestStandaloneRunner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	standaloneRunner, err := NewStandaloneRunner(
+		"testPipeline",
+		config,
+		&common.RuntimeInfo{BaseDir: baseDir},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return standaloneRunner
+}
+
+func createFailedPipeline(
+	t *testing.T,
+	standaloneRunner *StandaloneRunner,
+	shouldRetry bool,
+	retryAttempts float64,
+) *ProductionPipeline {
+	prodPipeline := &ProductionPipeline{
+		Pipeline: &Pipeline{
+			pipelineBean: creation.PipelineBean{
+				Config: creation.PipelineConfigBean{ShouldRetry: shouldRetry, RetryAttempts: retryAttempts},
+			},
+		},
+	}
+	standaloneRunner.prodPipeline = prodPipeline
+	if err := standaloneRunner.setState(common.RUNNING, ""); err != nil {
+		t.Fatal(err)
+	}
+	return prodPipeline
+}
+
+func TestStandaloneRunner_GetRetryDelay(t *testing.T) {
+	config := execution.NewConfig()
+	config.RetryBaseInterval = 100
+	config.RetryMaxInterval = 1000
+	standaloneRunner := createStandaloneRunner(t, config)
+	defer os.RemoveAll(standaloneRunner.runtimeInfo.BaseDir)
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		1000 * time.Millisecond,
+		1000 * time.Millisecond,
+	}
+	for i, expectedDelay := range expected {
+		delay := standaloneRunner.getRetryDelay(i + 1)
+		if delay != expectedDelay {
+			t.Errorf("Expected delay %s for attempt %d, but got %s", expectedDelay, i+1, delay)
+		}
+	}
+}
+
+func TestStandaloneRunner_Retry(t *testing.T) {
+	config := execution.NewConfig()
+	config.RetryBaseInterval = 60 * 1000
+	standaloneRunner := createStandaloneRunner(t, config)
+	defer os.RemoveAll(standaloneRunner.runtimeInfo.BaseDir)
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		prodPipeline := createFailedPipeline(t, standaloneRunner, true, 2)
+		standaloneRunner.handleRunError(prodPipeline, errors.New("batch failed"))
+
+		pipelineState, _ := standaloneRunner.GetStatus()
+		if pipelineState.Status != common.RETRY {
+			t.Fatalf("Expected status %s, but got %s", common.RETRY, pipelineState.Status)
+		}
+		if pipelineState.Message != "batch failed" {
+			t.Errorf("Expected the run error as message, but got '%s'", pipelineState.Message)
+		}
+		if pipelineState.Attributes[store.RETRY_ATTEMPT] != attempt {
+			t.Errorf("Expected retry attempt %d, but got %v", attempt, pipelineState.Attributes[store.RETRY_ATTEMPT])
+		}
+		if _, ok := pipelineState.Attributes[store.NEXT_RETRY_TIME_STAMP]; !ok {
+			t.Error("Expected next retry time stamp in the state attributes")
+		}
+		if standaloneRunner.retryTimer == nil {
+			t.Fatal("Expected the pipeline restart to be scheduled")
+		}
+		standaloneRunner.retryTimer.Stop()
+	}
+
+	// attempts exhausted
+	prodPipeline := createFailedPipeline(t, standaloneRunner, true, 2)
+	standaloneRunner.handleRunError(prodPipeline, errors.New("batch failed"))
+	pipelineState, _ := standaloneRunner.GetStatus()
+	if pipelineState.Status != common.RUN_ERROR {
+		t.Errorf("Expected status %s, but got %s", common.RUN_ERROR, pipelineState.Status)
+	}
+
+	history, err := standaloneRunner.GetHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, state := range history {
+		statuses = append(statuses, state.Status)
+	}
+	expectedStatuses := []string{
+		common.EDITED,
+		common.RUNNING, common.RUNNING_ERROR, common.RETRY,
+		common.RUNNING, common.RUNNING_ERROR, common.RETRY,
+		common.RUNNING, common.RUNNING_ERROR, common.RUN_ERROR,
+	}
+	if len(statuses) != len(expectedStatuses) {
+		t.Fatalf("Expected history %v, but got %v", expectedStatuses, statuses)
+	}
+	for i := range expectedStatuses {
+		if statuses[i] != expectedStatuses[i] {
+			t.Fatalf("Expected history %v, but got %v", expectedStatuses, statuses)
+		}
+	}
+	if history[3].Attributes[store.RETRY_ATTEMPT] != float64(1) {
+		t.Errorf("Expected retry attempt 1 in history, but got %v", history[3].Attributes[store.RETRY_ATTEMPT])
+	}
+}
+
+func TestStandaloneRunner_StopWhileRetrying(t *testing.T) {
+	config := execution.NewConfig()
+	config.RetryBaseInterval = 60 * 1000
+	standaloneRunner := createStandaloneRunner(t, config)
+	defer os.RemoveAll(standaloneRunner.runtimeInfo.BaseDir)
+
+	prodPipeline := createFailedPipeline(t, standaloneRunner, true, -1)
+	standaloneRunner.handleRunError(prodPipeline, errors.New("batch failed"))
+	if standaloneRunner.pipelineState.Status != common.RETRY {
+		t.Fatalf("Expected status %s, but got %s", common.RETRY, standaloneRunner.pipelineState.Status)
+	}
+
+	pipelineState, err := standaloneRunner.StopPipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pipelineState.Status != common.STOPPED {
+		t.Errorf("Expected status %s, but got %s", common.STOPPED, pipelineState.Status)
+	}
+	if standaloneRunner.retryTimer != nil {
+		t.Error("Expected the scheduled restart to be cancelled")
+	}
+	if _, ok := pipelineState.Attributes[store.RETRY_ATTEMPT]; ok {
+		t.Error("Expected retry attempt to be reset")
+	}
+}
+
+func TestStandaloneRunner_ResetRetryAttempt(t *testing.T) {
+	config := execution.NewConfig()
+	config.RetryBaseInterval = 60 * 1000
+	standaloneRunner := createStandaloneRunner(t, config)
+	defer os.RemoveAll(standaloneRunner.runtimeInfo.BaseDir)
+
+	for i := 0; i < 2; i++ {
+		prodPipeline := createFailedPipeline(t, standaloneRunner, true, 1)
+		standaloneRunner.handleRunError(prodPipeline, errors.New("batch failed"))
+		if standaloneRunner.pipelineState.Status != common.RETRY {
+			t.Fatalf("Expected status %s, but got %s", common.RETRY, standaloneRunner.pipelineState.Status)
+		}
+		if retryAttempt := standaloneRunner.pipelineState.Attributes[store.RETRY_ATTEMPT]; retryAttempt != 1 {
+			t.Errorf("Expected retry attempt 1, but got %v", retryAttempt)
+		}
+		standaloneRunner.retryTimer.Stop()
+
+		// the restarted pipeline committed a batch, so the next failure starts over with the first attempt
+		prodPipeline = createFailedPipeline(t, standaloneRunner, true, 1)
+		standaloneRunner.resetRetryAttempt(prodPipeline)
+		if _, ok := standaloneRunner.pipelineState.Attributes[store.RETRY_ATTEMPT]; ok {
+			t.Fatal("Expected retry attempt to be reset")
+		}
+	}
+}
+
+func TestStandaloneRunner_NoRetry(t *testing.T) {
+	standaloneRunner := createStandaloneRunner(t, execution.NewConfig())
+	defer os.RemoveAll(standaloneRunner.runtimeInfo.BaseDir)
+
+	prodPipeline := createFailedPipeline(t, standaloneRunner, false, -1)
+	standaloneRunner.handleRunError(prodPipeline, errors.New("batch failed"))
+	if standaloneRunner.pipelineState.Status != common.RUN_ERROR {
+		t.Errorf("Expected status %s, but got %s", common.RUN_ERROR, standaloneRunner.pipelineState.Status)
+	}
+	if standaloneRunner.retryTimer != nil {
+		t.Error("Expected no pipeline restart to be scheduled")
+	}
+}
+
+func TestStandaloneRunner_InitError(t *testing.T) {
+	standaloneRunner := createStandaloneRunner(t, execution.NewConfig())
+	defer os.RemoveAll(standaloneRunner.runtimeInfo.BaseDir)
+
+	prodPipeline := createFailedPipeline(t, standaloneRunner, true, -1)
+	initErr := &InitError{Issues: []validation.Issue{{InstanceName: "origin", Message: "invalid port"}}}
+	standaloneRunner.handleRunError(prodPipeline, initErr)
+	if standaloneRunner.pipelineState.Status != common.START_ERROR {
+		t.Errorf("Expected status %s, but got %s", common.START_ERROR, standaloneRunner.pipelineState.Status)
+	}
+	if standaloneRunner.retryTimer != nil {
+		t.Error("Expected no pipeline restart to be scheduled")
+	}
+}
+
+func TestProductionPipeline_RunInitError(t *testing.T) {
+	sourcePipe := newTestPipe("origin", "SOURCE", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	targetPipe := newTestPipe("destination", "TARGET", creation.ON_RECORD_ERROR_STOP_PIPELINE, 0)
+	targetPipe.issues = []validation.Issue{{InstanceName: "destination", Message: "invalid URL"}}
+	p, _ := newTestPipeline(sourcePipe, targetPipe)
+
+	err := (&ProductionPipeline{Pipeline: p}).Run()
+	if _, ok := err.(*InitError); !ok {
+		t.Fatalf("Expected an init error, but got %v", err)
+	}
+	if atomic.LoadInt32(&sourcePipe.destroyed) == 0 || atomic.LoadInt32(&targetPipe.destroyed) == 0 {
+		t.Error("Expected the stages to be destroyed when the pipeline failed to initialize")
+	}
+	if sourcePipe.processed != 0 {
+		t.Error("Expected the pipeline not to run")
+	}
+}
+
+func TestStandaloneRunner_RestorePipeline(t *testing.T) {
+	standaloneRunner := createStandaloneRunner(t, execution.NewConfig())
+	defer os.RemoveAll(standaloneRunner.runtimeInfo.BaseDir)
+	standaloneRunner.pipelineStoreTask = &failingPipelineStoreTask{}
+
+	for _, status := range []string{common.EDITED, common.STOPPED, common.RUN_ERROR} {
+		standaloneRunner.setState(status, "")
+		pipelineState, err := standaloneRunner.RestorePipeline()
+		if err != nil || pipelineState != nil {
+			t.Errorf("Expected pipeline in %s state not to be restored", status)
+		}
+		if standaloneRunner.pipelineState.Status != status {
+			t.Errorf("Expected status %s, but got %s", status, standaloneRunner.pipelineState.Status)
+		}
+	}
+
+	// state left by the previous process
+	runtimeParameters := map[string]interface{}{"param1": "value1"}
+	standaloneRunner.pipelineState.Attributes[store.RUNTIME_PARAMETERS] = runtimeParameters
+	standaloneRunner.setState(common.RUNNING, "")
+	standaloneRunner, err := NewStandaloneRunner(
+		standaloneRunner.pipelineId,
+		standaloneRunner.config,
+		standaloneRunner.runtimeInfo,
+		&failingPipelineStoreTask{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = standaloneRunner.RestorePipeline()
+	if err == nil {
+		t.Fatal("Expected the restore to fail loading the pipeline configuration")
+	}
+	if standaloneRunner.runtimeParameters["param1"] != "value1" {
+		t.Errorf("Expected the last runtime parameters to be restored, but got %v", standaloneRunner.runtimeParameters)
+	}
+
+	history, err := standaloneRunner.GetHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{history[len(history)-2].Status, history[len(history)-1].Status}
+	if statuses[0] != common.STARTING || statuses[1] != common.START_ERROR {
+		t.Errorf("Expected the pipeline to go through STARTING to START_ERROR, but got %v", statuses)
+	}
+}
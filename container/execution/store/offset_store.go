@@ -59,9 +59,9 @@ func ResetOffset(pipelineId string) error {
 }
 
 func getPipelineOffsetFile(pipelineId string) string {
-	return getRunInfoDir(pipelineId) + OFFSET_FILE
+	return GetRunInfoDir(pipelineId) + OFFSET_FILE
 }
 
-func getRunInfoDir(pipelineId string) string {
+func GetRunInfoDir(pipelineId string) string {
 	return BaseDir + "/data/runInfo/" + pipelineId + "/"
 }
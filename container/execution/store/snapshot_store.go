@@ -0,0 +1,119 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	SNAPSHOTS_DIR      = "snapshots/"
+	SNAPSHOT_INFO_FILE = "info.json"
+	SNAPSHOT_DATA_FILE = "output.json"
+)
+
+func SaveSnapshotInfo(pipelineId string, snapshotInfo *common.SnapshotInfo) error {
+	snapshotDir, err := getSnapshotDir(pipelineId, snapshotInfo.Name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(snapshotDir, os.ModePerm); err != nil {
+		return err
+	}
+	var snapshotInfoJson []byte
+	if snapshotInfoJson, err = json.Marshal(snapshotInfo); err == nil {
+		err = ioutil.WriteFile(snapshotDir+SNAPSHOT_INFO_FILE, snapshotInfoJson, 0644)
+	}
+	return err
+}
+
+func GetSnapshotInfo(pipelineId string, snapshotName string) (*common.SnapshotInfo, error) {
+	snapshotDir, err := getSnapshotDir(pipelineId, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.ReadFile(snapshotDir + SNAPSHOT_INFO_FILE)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("Snapshot '" + snapshotName + "' does not exist")
+		}
+		return nil, err
+	}
+	var snapshotInfo common.SnapshotInfo
+	err = json.Unmarshal(file, &snapshotInfo)
+	return &snapshotInfo, err
+}
+
+func GetSnapshotInfos(pipelineId string) ([]*common.SnapshotInfo, error) {
+	snapshotInfos := make([]*common.SnapshotInfo, 0)
+	fileInfos, err := ioutil.ReadDir(GetRunInfoDir(pipelineId) + SNAPSHOTS_DIR)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snapshotInfos, nil
+		}
+		return nil, err
+	}
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() {
+			snapshotInfo, err := GetSnapshotInfo(pipelineId, fileInfo.Name())
+			if err != nil {
+				return nil, err
+			}
+			snapshotInfos = append(snapshotInfos, snapshotInfo)
+		}
+	}
+	return snapshotInfos, nil
+}
+
+func SaveSnapshotData(pipelineId string, snapshotName string, snapshotData []byte) error {
+	snapshotDir, err := getSnapshotDir(pipelineId, snapshotName)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(snapshotDir+SNAPSHOT_DATA_FILE, snapshotData, 0644)
+}
+
+func GetSnapshotData(pipelineId string, snapshotName string) ([]byte, error) {
+	snapshotDir, err := getSnapshotDir(pipelineId, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	snapshotData, err := ioutil.ReadFile(snapshotDir + SNAPSHOT_DATA_FILE)
+	if os.IsNotExist(err) {
+		return nil, errors.New("Snapshot '" + snapshotName + "' is not available")
+	}
+	return snapshotData, err
+}
+
+func DeleteSnapshot(pipelineId string, snapshotName string) error {
+	snapshotDir, err := getSnapshotDir(pipelineId, snapshotName)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(snapshotDir)
+}
+
+func getSnapshotDir(pipelineId string, snapshotName string) (string, error) {
+	if snapshotName == "" || snapshotName == "." || snapshotName == ".." || strings.ContainsAny(snapshotName, "/\\") {
+		return "", errors.New("Invalid snapshot name '" + snapshotName + "'")
+	}
+	return GetRunInfoDir(pipelineId) + SNAPSHOTS_DIR + snapshotName + "/", nil
+}
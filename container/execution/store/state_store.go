@@ -29,6 +29,9 @@ const (
 	PIPELINE_STATE_FILE         = "pipelineState.json"
 	PIPELINE_STATE_HISTORY_FILE = "pipelineStateHistory.json"
 	IS_REMOTE_PIPELINE          = "IS_REMOTE_PIPELINE"
+	RETRY_ATTEMPT               = "RETRY_ATTEMPT"
+	NEXT_RETRY_TIME_STAMP       = "NEXT_RETRY_TIME_STAMP"
+	RUNTIME_PARAMETERS          = "RUNTIME_PARAMETERS"
 )
 
 func checkFileExists(filePath string) (bool, error) {
@@ -56,7 +59,7 @@ func GetState(pipelineId string) (*common.PipelineState, error) {
 		}
 		pipelineState.Attributes = make(map[string]interface{})
 		pipelineState.Attributes[IS_REMOTE_PIPELINE] = false
-		err = os.MkdirAll(getRunInfoDir(pipelineId), os.ModePerm)
+		err = os.MkdirAll(GetRunInfoDir(pipelineId), os.ModePerm)
 		if err == nil {
 			err = SaveState(pipelineId, pipelineState)
 		}
@@ -142,9 +145,9 @@ func GetHistory(pipelineId string) ([]*common.PipelineState, error) {
 }
 
 func getPipelineStateFile(pipelineId string) string {
-	return getRunInfoDir(pipelineId) + PIPELINE_STATE_FILE
+	return GetRunInfoDir(pipelineId) + PIPELINE_STATE_FILE
 }
 
 func getPipelineStateHistoryFile(pipelineId string) string {
-	return getRunInfoDir(pipelineId) + PIPELINE_STATE_HISTORY_FILE
+	return GetRunInfoDir(pipelineId) + PIPELINE_STATE_HISTORY_FILE
 }
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	ROLE_ADMIN   = "admin"
+	ROLE_CREATOR = "creator"
+	ROLE_MANAGER = "manager"
+	ROLE_GUEST   = "guest"
+
+	HEADER_AUTHORIZATION    = "Authorization"
+	HEADER_WWW_AUTHENTICATE = "WWW-Authenticate"
+	BEARER_PREFIX           = "Bearer "
+)
+
+var (
+	// roles allowed to use the routes, admin is allowed to use all of them
+	READ_ROLES    = []string{ROLE_GUEST, ROLE_MANAGER, ROLE_CREATOR}
+	MANAGER_ROLES = []string{ROLE_MANAGER}
+	CREATOR_ROLES = []string{ROLE_CREATOR}
+	PREVIEW_ROLES = []string{ROLE_MANAGER, ROLE_CREATOR}
+	ADMIN_ROLES   = []string{}
+
+	validRoles = []string{ROLE_ADMIN, ROLE_CREATOR, ROLE_MANAGER, ROLE_GUEST}
+)
+
+// authenticator checks the credentials of the requests against the users or tokens configured in edge.conf
+type authenticator struct {
+	config AuthConfig
+}
+
+func (a *authenticator) isEnabled() bool {
+	return a.config.Type != AUTH_TYPE_NONE
+}
+
+// authenticate returns the name and roles of the principal of the request
+func (a *authenticator) authenticate(r *http.Request) (string, []string, bool) {
+	switch a.config.Type {
+	case AUTH_TYPE_BASIC:
+		userName, password, ok := r.BasicAuth()
+		if !ok {
+			return "", nil, false
+		}
+		for _, user := range a.config.Users {
+			if secureCompare(user.Name, userName) && secureCompare(user.Password, password) {
+				return user.Name, user.Roles, true
+			}
+		}
+	case AUTH_TYPE_TOKEN:
+		authorization := r.Header.Get(HEADER_AUTHORIZATION)
+		if !strings.HasPrefix(authorization, BEARER_PREFIX) {
+			return "", nil, false
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(authorization, BEARER_PREFIX))
+		for _, tokenConfig := range a.config.Tokens {
+			if secureCompare(tokenConfig.Token, token) {
+				return tokenConfig.Name, tokenConfig.Roles, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// authorize writes the error response and returns false when the request is not allowed for the given roles
+func (a *authenticator) authorize(w http.ResponseWriter, r *http.Request, roles []string) bool {
+	if !a.isEnabled() {
+		return true
+	}
+	principal, principalRoles, ok := a.authenticate(r)
+	if !ok {
+		if a.config.Type == AUTH_TYPE_BASIC {
+			w.Header().Set(HEADER_WWW_AUTHENTICATE, fmt.Sprintf("Basic realm=%q", a.config.Realm))
+		} else {
+			w.Header().Set(HEADER_WWW_AUTHENTICATE, fmt.Sprintf("Bearer realm=%q", a.config.Realm))
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if !hasAnyRole(principalRoles, roles) {
+		log.Printf("[WARN] '%s' is not allowed to access %s %s", principal, r.Method, r.URL.Path)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (a *authenticator) secure(handle httprouter.Handle, roles []string) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if a.authorize(w, r, roles) {
+			handle(w, r, ps)
+		}
+	}
+}
+
+func (a *authenticator) secureHandler(handler http.Handler, roles []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.authorize(w, r, roles) {
+			handler.ServeHTTP(w, r)
+		}
+	})
+}
+
+func hasAnyRole(principalRoles []string, roles []string) bool {
+	for _, principalRole := range principalRoles {
+		if principalRole == ROLE_ADMIN {
+			return true
+		}
+		for _, role := range roles {
+			if principalRole == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func secureCompare(expected string, actual string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
+
+func validateRoles(principal string, roles []string) error {
+	for _, role := range roles {
+		valid := false
+		for _, validRole := range validRoles {
+			if role == validRole {
+				valid = true
+			}
+		}
+		if !valid {
+			return errors.New(fmt.Sprintf("Invalid role '%s' for '%s'", role, principal))
+		}
+	}
+	return nil
+}
+
+func newAuthenticator(config AuthConfig) (*authenticator, error) {
+	switch config.Type {
+	case "", AUTH_TYPE_NONE:
+		config.Type = AUTH_TYPE_NONE
+	case AUTH_TYPE_BASIC:
+		if len(config.Users) == 0 {
+			return nil, errors.New("Basic authentication requires at least one user")
+		}
+		for _, user := range config.Users {
+			if len(user.Name) == 0 || len(user.Password) == 0 {
+				return nil, errors.New("Basic authentication users require a name and a password")
+			}
+			if err := validateRoles(user.Name, user.Roles); err != nil {
+				return nil, err
+			}
+		}
+	case AUTH_TYPE_TOKEN:
+		if len(config.Tokens) == 0 {
+			return nil, errors.New("Token authentication requires at least one token")
+		}
+		for _, token := range config.Tokens {
+			if len(token.Token) == 0 {
+				return nil, errors.New(fmt.Sprintf("Token '%s' is empty", token.Name))
+			}
+			if err := validateRoles(token.Name, token.Roles); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("Invalid authentication type '%s'", config.Type))
+	}
+	return &authenticator{config: config}, nil
+}
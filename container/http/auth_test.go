@@ -0,0 +1,133 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package http
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestAuthenticator_Basic(t *testing.T) {
+	auth, err := newAuthenticator(AuthConfig{
+		Type: AUTH_TYPE_BASIC,
+		Users: []UserConfig{
+			{Name: "admin", Password: "adminPassword", Roles: []string{ROLE_ADMIN}},
+			{Name: "guest", Password: "guestPassword", Roles: []string{ROLE_GUEST}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user           string
+		password       string
+		roles          []string
+		expectedStatus int
+	}{
+		{"", "", READ_ROLES, http.StatusUnauthorized},
+		{"guest", "wrongPassword", READ_ROLES, http.StatusUnauthorized},
+		{"guest", "guestPassword", READ_ROLES, http.StatusOK},
+		{"guest", "guestPassword", MANAGER_ROLES, http.StatusForbidden},
+		{"admin", "adminPassword", MANAGER_ROLES, http.StatusOK},
+		{"admin", "adminPassword", ADMIN_ROLES, http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/rest/v1/pipelines", nil)
+		if len(test.user) > 0 {
+			req.SetBasicAuth(test.user, test.password)
+		}
+		w := httptest.NewRecorder()
+		auth.secure(okHandler, test.roles)(w, req, nil)
+		if w.Code != test.expectedStatus {
+			t.Errorf("Expected status %d for user '%s' and roles %v, but got %d",
+				test.expectedStatus, test.user, test.roles, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get(HEADER_WWW_AUTHENTICATE) == "" {
+			t.Error("Expected WWW-Authenticate header in unauthorized response")
+		}
+	}
+}
+
+func TestAuthenticator_Token(t *testing.T) {
+	auth, err := newAuthenticator(AuthConfig{
+		Type: AUTH_TYPE_TOKEN,
+		Tokens: []TokenConfig{
+			{Name: "operator", Token: "operatorToken", Roles: []string{ROLE_MANAGER}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		authorization  string
+		roles          []string
+		expectedStatus int
+	}{
+		{"", READ_ROLES, http.StatusUnauthorized},
+		{"Bearer wrongToken", READ_ROLES, http.StatusUnauthorized},
+		{"Basic b3BlcmF0b3JUb2tlbg==", READ_ROLES, http.StatusUnauthorized},
+		{"Bearer operatorToken", MANAGER_ROLES, http.StatusOK},
+		{"Bearer operatorToken", CREATOR_ROLES, http.StatusForbidden},
+		{"Bearer operatorToken", ADMIN_ROLES, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/rest/v1/pipeline/test/start", nil)
+		if len(test.authorization) > 0 {
+			req.Header.Set(HEADER_AUTHORIZATION, test.authorization)
+		}
+		w := httptest.NewRecorder()
+		auth.secure(okHandler, test.roles)(w, req, nil)
+		if w.Code != test.expectedStatus {
+			t.Errorf("Expected status %d for '%s' and roles %v, but got %d",
+				test.expectedStatus, test.authorization, test.roles, w.Code)
+		}
+	}
+}
+
+func TestAuthenticator_None(t *testing.T) {
+	auth, err := newAuthenticator(NewConfig().Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	auth.secure(okHandler, ADMIN_ROLES)(w, httptest.NewRequest("GET", "/", nil), nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d without authentication, but got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestNewAuthenticator_InvalidConfig(t *testing.T) {
+	invalidConfigs := []AuthConfig{
+		{Type: "kerberos"},
+		{Type: AUTH_TYPE_BASIC},
+		{Type: AUTH_TYPE_BASIC, Users: []UserConfig{{Name: "admin", Password: "", Roles: []string{ROLE_ADMIN}}}},
+		{Type: AUTH_TYPE_BASIC, Users: []UserConfig{{Name: "admin", Password: "admin", Roles: []string{"root"}}}},
+		{Type: AUTH_TYPE_TOKEN, Tokens: []TokenConfig{{Name: "empty", Token: ""}}},
+	}
+	for _, config := range invalidConfigs {
+		if _, err := newAuthenticator(config); err == nil {
+			t.Errorf("Expected error for auth config %+v", config)
+		}
+	}
+}
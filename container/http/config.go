@@ -17,15 +17,57 @@ package http
 
 const (
 	DefaultBindAddress = ":18633"
+
+	AUTH_TYPE_NONE  = "none"
+	AUTH_TYPE_BASIC = "basic"
+	AUTH_TYPE_TOKEN = "token"
 )
 
 type Config struct {
-	BindAddress string `toml:"bind-address"`
+	BindAddress string     `toml:"bind-address"`
+	EnablePprof bool       `toml:"enable-pprof"`
+	TLS         TLSConfig  `toml:"tls"`
+	Auth        AuthConfig `toml:"auth"`
+}
+
+type TLSConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	CertFile string `toml:"cert-file"`
+	KeyFile  string `toml:"key-file"`
+	// clients have to present a certificate signed by one of these CAs when set
+	ClientCAFile string `toml:"client-ca-file"`
+}
+
+type AuthConfig struct {
+	Type   string        `toml:"type"`
+	Realm  string        `toml:"realm"`
+	Users  []UserConfig  `toml:"users"`
+	Tokens []TokenConfig `toml:"tokens"`
+}
+
+type UserConfig struct {
+	Name     string   `toml:"name"`
+	Password string   `toml:"password"`
+	Roles    []string `toml:"roles"`
+}
+
+type TokenConfig struct {
+	Name  string   `toml:"name"`
+	Token string   `toml:"token"`
+	Roles []string `toml:"roles"`
 }
 
 // NewConfig returns a new Config with default settings.
 func NewConfig() Config {
 	return Config{
 		BindAddress: DefaultBindAddress,
+		EnablePprof: false,
+		TLS: TLSConfig{
+			Enabled: false,
+		},
+		Auth: AuthConfig{
+			Type:  AUTH_TYPE_NONE,
+			Realm: "Data Collector Edge",
+		},
 	}
 }
@@ -20,9 +20,12 @@ import (
 	"fmt"
 	"github.com/julienschmidt/httprouter"
 	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
 	"github.com/streamsets/datacollector-edge/container/util"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (webServerTask *WebServerTask) startHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -141,3 +144,168 @@ func (webServerTask *WebServerTask) metricsHandler(w http.ResponseWriter, r *htt
 		fmt.Fprintf(w, "Failed to get metrics:  %s! ", err)
 	}
 }
+
+func (webServerTask *WebServerTask) previewHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	pipelineId := ps.ByName("pipelineId")
+	decoder := json.NewDecoder(r.Body)
+	var runtimeParameters map[string]interface{}
+	err := decoder.Decode(&runtimeParameters)
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(w, "Failed to Preview: %s", err)
+		return
+	}
+	defer r.Body.Close()
+
+	query := r.URL.Query()
+	batches := runner.DEFAULT_PREVIEW_BATCHES
+	batchSize := runner.DEFAULT_PREVIEW_BATCH_SIZE
+	skipTargets := true
+	timeout := runner.DEFAULT_PREVIEW_TIMEOUT
+	if value := query.Get("batches"); value != "" {
+		if batches, err = strconv.Atoi(value); err != nil {
+			fmt.Fprintf(w, "Failed to Preview: invalid batches '%s'", value)
+			return
+		}
+	}
+	if value := query.Get("batchSize"); value != "" {
+		if batchSize, err = strconv.Atoi(value); err != nil {
+			fmt.Fprintf(w, "Failed to Preview: invalid batchSize '%s'", value)
+			return
+		}
+	}
+	if value := query.Get("skipTargets"); value != "" {
+		if skipTargets, err = strconv.ParseBool(value); err != nil {
+			fmt.Fprintf(w, "Failed to Preview: invalid skipTargets '%s'", value)
+			return
+		}
+	}
+	if value := query.Get("timeout"); value != "" {
+		timeoutMillis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			fmt.Fprintf(w, "Failed to Preview: invalid timeout '%s'", value)
+			return
+		}
+		timeout = time.Duration(timeoutMillis) * time.Millisecond
+	}
+
+	previewRunner, err := webServerTask.manager.GetPreviewRunner(pipelineId)
+	if err == nil {
+		var previewOutput *runner.PreviewOutput
+		previewOutput, err = previewRunner.Preview(batches, batchSize, skipTargets, timeout, runtimeParameters)
+		if err == nil {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "\t")
+			encoder.Encode(previewOutput)
+			return
+		}
+	}
+	fmt.Fprintf(w, "Failed to Preview:  %s! ", err)
+}
+
+func (webServerTask *WebServerTask) captureSnapshotHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+) {
+	pipelineId := ps.ByName("pipelineId")
+	snapshotName := ps.ByName("snapshotName")
+	batches := 1
+	if value := r.URL.Query().Get("batches"); value != "" {
+		var err error
+		if batches, err = strconv.Atoi(value); err != nil {
+			fmt.Fprintf(w, "Failed to capture snapshot: invalid batches '%s'", value)
+			return
+		}
+	}
+	snapshotInfo, err := webServerTask.manager.GetRunner(pipelineId).CaptureSnapshot(snapshotName, batches)
+	if err == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(snapshotInfo)
+	} else {
+		fmt.Fprintf(w, "Failed to capture snapshot:  %s! ", err)
+	}
+}
+
+func (webServerTask *WebServerTask) getSnapshotsInfoHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+) {
+	pipelineId := ps.ByName("pipelineId")
+	snapshotInfos, err := webServerTask.manager.GetRunner(pipelineId).GetSnapshotsInfo()
+	if err == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(snapshotInfos)
+	} else {
+		fmt.Fprintf(w, "Failed to get snapshots:  %s! ", err)
+	}
+}
+
+func (webServerTask *WebServerTask) getSnapshotStatusHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+) {
+	pipelineId := ps.ByName("pipelineId")
+	snapshotName := ps.ByName("snapshotName")
+	snapshotInfo, err := webServerTask.manager.GetRunner(pipelineId).GetSnapshotInfo(snapshotName)
+	if err == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(snapshotInfo)
+	} else {
+		fmt.Fprintf(w, "Failed to get snapshot status:  %s! ", err)
+	}
+}
+
+func (webServerTask *WebServerTask) getSnapshotHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	pipelineId := ps.ByName("pipelineId")
+	snapshotName := ps.ByName("snapshotName")
+	snapshotData, err := webServerTask.manager.GetRunner(pipelineId).GetSnapshot(snapshotName)
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(snapshotData)
+	} else {
+		fmt.Fprintf(w, "Failed to get snapshot:  %s! ", err)
+	}
+}
+
+func (webServerTask *WebServerTask) deleteSnapshotHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+) {
+	pipelineId := ps.ByName("pipelineId")
+	snapshotName := ps.ByName("snapshotName")
+	err := webServerTask.manager.GetRunner(pipelineId).DeleteSnapshot(snapshotName)
+	if err == nil {
+		fmt.Fprint(w, "Delete snapshot is successful.")
+	} else {
+		fmt.Fprint(w, "Delete snapshot failed: ", err)
+	}
+}
+
+func (webServerTask *WebServerTask) getAlertsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	pipelineId := ps.ByName("pipelineId")
+	alerts, err := webServerTask.manager.GetRunner(pipelineId).GetAlerts()
+	if err == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(alerts)
+	} else {
+		fmt.Fprintf(w, "Failed to get alerts:  %s! ", err)
+	}
+}
+
+func (webServerTask *WebServerTask) deleteAlertHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	pipelineId := ps.ByName("pipelineId")
+	alertId := r.URL.Query().Get("alertId")
+	err := webServerTask.manager.GetRunner(pipelineId).DeleteAlert(alertId)
+	if err == nil {
+		fmt.Fprint(w, "Delete alert is successful.")
+	} else {
+		fmt.Fprint(w, "Delete alert failed: ", err)
+	}
+}
@@ -91,3 +91,36 @@ func (webServerTask *WebServerTask) savePipeline(w http.ResponseWriter, r *http.
 		fmt.Fprintf(w, "Failed to create pipeline:  %s! ", err)
 	}
 }
+
+// Path - GET /rest/v1/pipeline/:pipelineId/rules
+func (webServerTask *WebServerTask) getPipelineRules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	pipelineId := ps.ByName("pipelineId")
+	ruleDefinitions, err := webServerTask.pipelineStoreTask.RetrieveRules(pipelineId)
+	if err == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(ruleDefinitions)
+	} else {
+		fmt.Fprintf(w, "Failed to get pipeline rules:  %s! ", err)
+	}
+}
+
+// Path - POST /rest/v1/pipeline/:pipelineId/rules
+func (webServerTask *WebServerTask) savePipelineRules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	pipelineId := ps.ByName("pipelineId")
+
+	decoder := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+	var ruleDefinitions common.RuleDefinitions
+	err := decoder.Decode(&ruleDefinitions)
+	if err == nil {
+		ruleDefinitions, err = webServerTask.pipelineStoreTask.StoreRules(pipelineId, ruleDefinitions)
+	}
+	if err == nil {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "\t")
+		encoder.Encode(ruleDefinitions)
+	} else {
+		fmt.Fprintf(w, "Failed to save pipeline rules:  %s! ", err)
+	}
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+func newTLSConfig(config TLSConfig) (*tls.Config, error) {
+	if len(config.CertFile) == 0 || len(config.KeyFile) == 0 {
+		return nil, errors.New("TLS requires a certificate file and a key file")
+	}
+	// fail on startup rather than on the first connection when the key pair is not valid
+	if _, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(config.ClientCAFile) > 0 {
+		clientCAs, err := ioutil.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		clientCAPool := x509.NewCertPool()
+		if !clientCAPool.AppendCertsFromPEM(clientCAs) {
+			return nil, errors.New("No valid certificates found in client CA file " + config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = clientCAPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
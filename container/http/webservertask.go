@@ -40,42 +40,101 @@ type WebServerTask struct {
 }
 
 func (webServerTask *WebServerTask) Init() error {
-	fmt.Println("Running on URI : http://localhost" + webServerTask.config.BindAddress)
-	log.Println("[INFO] Running on URI : http://localhost" + webServerTask.config.BindAddress)
+	auth, err := newAuthenticator(webServerTask.config.Auth)
+	if err != nil {
+		return err
+	}
+
+	scheme := "http"
+	if webServerTask.config.TLS.Enabled {
+		scheme = "https"
+	}
+	fmt.Println("Running on URI : " + scheme + "://localhost" + webServerTask.config.BindAddress)
+	log.Println("[INFO] Running on URI : " + scheme + "://localhost" + webServerTask.config.BindAddress)
 
 	router := httprouter.New()
-	router.GET("/", webServerTask.homeHandler)
+	router.GET("/", auth.secure(webServerTask.homeHandler, READ_ROLES))
 
 	// Manager APIs
-	router.POST("/rest/v1/pipeline/:pipelineId/start", webServerTask.startHandler)
-	router.POST("/rest/v1/pipeline/:pipelineId/stop", webServerTask.stopHandler)
-	router.POST("/rest/v1/pipeline/:pipelineId/resetOffset", webServerTask.resetOffsetHandler)
-	router.POST("/rest/v1/pipeline/:pipelineId/committedOffsets", webServerTask.updateOffsetHandler)
+	router.POST("/rest/v1/pipeline/:pipelineId/start", auth.secure(webServerTask.startHandler, MANAGER_ROLES))
+	router.POST("/rest/v1/pipeline/:pipelineId/stop", auth.secure(webServerTask.stopHandler, MANAGER_ROLES))
+	router.POST(
+		"/rest/v1/pipeline/:pipelineId/resetOffset",
+		auth.secure(webServerTask.resetOffsetHandler, MANAGER_ROLES),
+	)
+	router.POST(
+		"/rest/v1/pipeline/:pipelineId/committedOffsets",
+		auth.secure(webServerTask.updateOffsetHandler, MANAGER_ROLES),
+	)
+	router.POST("/rest/v1/pipeline/:pipelineId/preview", auth.secure(webServerTask.previewHandler, PREVIEW_ROLES))
+	router.POST(
+		"/rest/v1/pipeline/:pipelineId/snapshot/:snapshotName",
+		auth.secure(webServerTask.captureSnapshotHandler, MANAGER_ROLES),
+	)
 
-	router.GET("/rest/v1/pipeline/:pipelineId/status", webServerTask.statusHandler)
-	router.GET("/rest/v1/pipeline/:pipelineId/history", webServerTask.historyHandler)
-	router.GET("/rest/v1/pipeline/:pipelineId/metrics", webServerTask.metricsHandler)
-	router.GET("/rest/v1/pipeline/:pipelineId/committedOffsets", webServerTask.getOffsetHandler)
+	router.GET("/rest/v1/pipeline/:pipelineId/status", auth.secure(webServerTask.statusHandler, READ_ROLES))
+	router.GET("/rest/v1/pipeline/:pipelineId/history", auth.secure(webServerTask.historyHandler, READ_ROLES))
+	router.GET("/rest/v1/pipeline/:pipelineId/metrics", auth.secure(webServerTask.metricsHandler, READ_ROLES))
+	router.GET(
+		"/rest/v1/pipeline/:pipelineId/committedOffsets",
+		auth.secure(webServerTask.getOffsetHandler, READ_ROLES),
+	)
+	router.GET(
+		"/rest/v1/pipeline/:pipelineId/snapshots",
+		auth.secure(webServerTask.getSnapshotsInfoHandler, READ_ROLES),
+	)
+	router.GET(
+		"/rest/v1/pipeline/:pipelineId/snapshot/:snapshotName",
+		auth.secure(webServerTask.getSnapshotHandler, READ_ROLES),
+	)
+	router.GET(
+		"/rest/v1/pipeline/:pipelineId/snapshot/:snapshotName/status",
+		auth.secure(webServerTask.getSnapshotStatusHandler, READ_ROLES),
+	)
+	router.GET("/rest/v1/pipeline/:pipelineId/alerts", auth.secure(webServerTask.getAlertsHandler, READ_ROLES))
+
+	router.DELETE(
+		"/rest/v1/pipeline/:pipelineId/snapshot/:snapshotName",
+		auth.secure(webServerTask.deleteSnapshotHandler, MANAGER_ROLES),
+	)
+	router.DELETE(
+		"/rest/v1/pipeline/:pipelineId/alerts",
+		auth.secure(webServerTask.deleteAlertHandler, MANAGER_ROLES),
+	)
 
 	// Pipeline Store APIs
-	router.GET("/rest/v1/pipelines", webServerTask.getPipelines)
-	router.GET("/rest/v1/pipeline/:pipelineId", webServerTask.getPipeline)
-	router.PUT("/rest/v1/pipeline/:pipelineTitle", webServerTask.createPipeline)
-	router.POST("/rest/v1/pipeline/:pipelineId", webServerTask.savePipeline)
+	router.GET("/rest/v1/pipelines", auth.secure(webServerTask.getPipelines, READ_ROLES))
+	router.GET("/rest/v1/pipeline/:pipelineId", auth.secure(webServerTask.getPipeline, READ_ROLES))
+	router.PUT("/rest/v1/pipeline/:pipelineTitle", auth.secure(webServerTask.createPipeline, CREATOR_ROLES))
+	router.POST("/rest/v1/pipeline/:pipelineId", auth.secure(webServerTask.savePipeline, CREATOR_ROLES))
+	router.GET("/rest/v1/pipeline/:pipelineId/rules", auth.secure(webServerTask.getPipelineRules, READ_ROLES))
+	router.POST(
+		"/rest/v1/pipeline/:pipelineId/rules",
+		auth.secure(webServerTask.savePipelineRules, CREATOR_ROLES),
+	)
 
 	// Register pprof handlers
-	router.HandlerFunc("GET", "/debug/pprof/", pprof.Index)
-	router.Handler("GET", "/debug/pprof/heap", pprof.Handler("heap"))
-	router.Handler("GET", "/debug/pprof/goroutine", pprof.Handler("goroutine"))
-	router.Handler("GET", "/debug/pprof/block", pprof.Handler("block"))
-	router.HandlerFunc("GET", "/debug/pprof/cmdline", pprof.Cmdline)
-	router.HandlerFunc("GET", "/debug/pprof/profile", pprof.Profile)
-	router.HandlerFunc("GET", "/debug/pprof/symbol", pprof.Symbol)
-	router.HandlerFunc("GET", "/debug/pprof/trace", pprof.Trace)
+	if webServerTask.config.EnablePprof {
+		router.Handler("GET", "/debug/pprof/", auth.secureHandler(http.HandlerFunc(pprof.Index), ADMIN_ROLES))
+		router.Handler("GET", "/debug/pprof/heap", auth.secureHandler(pprof.Handler("heap"), ADMIN_ROLES))
+		router.Handler("GET", "/debug/pprof/goroutine", auth.secureHandler(pprof.Handler("goroutine"), ADMIN_ROLES))
+		router.Handler("GET", "/debug/pprof/block", auth.secureHandler(pprof.Handler("block"), ADMIN_ROLES))
+		router.Handler("GET", "/debug/pprof/cmdline", auth.secureHandler(http.HandlerFunc(pprof.Cmdline), ADMIN_ROLES))
+		router.Handler("GET", "/debug/pprof/profile", auth.secureHandler(http.HandlerFunc(pprof.Profile), ADMIN_ROLES))
+		router.Handler("GET", "/debug/pprof/symbol", auth.secureHandler(http.HandlerFunc(pprof.Symbol), ADMIN_ROLES))
+		router.Handler("GET", "/debug/pprof/trace", auth.secureHandler(http.HandlerFunc(pprof.Trace), ADMIN_ROLES))
+	}
 
-	router.GET("/rest/v1/processMetrics", webServerTask.processMetricsHandler)
+	router.GET("/rest/v1/processMetrics", auth.secure(webServerTask.processMetricsHandler, READ_ROLES))
+	router.GET("/metrics", auth.secure(webServerTask.prometheusMetricsHandler, READ_ROLES))
 
 	webServerTask.httpServer = &http.Server{Addr: webServerTask.config.BindAddress, Handler: router}
+	if webServerTask.config.TLS.Enabled {
+		webServerTask.httpServer.TLSConfig, err = newTLSConfig(webServerTask.config.TLS)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -86,7 +145,14 @@ func (webServerTask *WebServerTask) homeHandler(w http.ResponseWriter, r *http.R
 }
 
 func (webServerTask *WebServerTask) Run() {
-	fmt.Println(webServerTask.httpServer.ListenAndServe())
+	if webServerTask.config.TLS.Enabled {
+		fmt.Println(webServerTask.httpServer.ListenAndServeTLS(
+			webServerTask.config.TLS.CertFile,
+			webServerTask.config.TLS.KeyFile,
+		))
+	} else {
+		fmt.Println(webServerTask.httpServer.ListenAndServe())
+	}
 }
 
 func (webServerTask *WebServerTask) Shutdown() {
@@ -122,3 +188,27 @@ func NewWebServerTask(
 	}
 	return &webServerTask, nil
 }
+
+// prometheusMetricsHandler exposes the metrics of the running pipelines and the process in the Prometheus
+// text format
+func (webServerTask *WebServerTask) prometheusMetricsHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+	_ httprouter.Params,
+) {
+	prometheusMetrics := util.NewPrometheusMetrics()
+	for _, pipelineRunner := range webServerTask.manager.GetRunners() {
+		if metricRegistry, err := pipelineRunner.GetMetrics(); err == nil {
+			prometheusMetrics.AddRegistry(
+				metricRegistry,
+				map[string]string{util.PIPELINE_LABEL: pipelineRunner.GetPipelineId()},
+			)
+		}
+	}
+	prometheusMetrics.AddRegistry(webServerTask.processManager.GetProcessMetrics(), nil)
+
+	w.Header().Set(common.HEADER_CONTENT_TYPE, util.PROMETHEUS_CONTENT_TYPE)
+	if err := prometheusMetrics.Write(w); err != nil {
+		log.Printf("[ERROR] Failed to write metrics: %s", err.Error())
+	}
+}
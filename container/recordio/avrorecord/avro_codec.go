@@ -0,0 +1,120 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package avrorecord
+
+import (
+	"errors"
+	"fmt"
+	"github.com/linkedin/goavro"
+	"sync"
+)
+
+const (
+	SCHEMA_SOURCE_SOURCE   = "SOURCE"
+	SCHEMA_SOURCE_INLINE   = "INLINE"
+	SCHEMA_SOURCE_HEADER   = "HEADER"
+	SCHEMA_SOURCE_REGISTRY = "REGISTRY"
+
+	LOOKUP_MODE_SUBJECT = "SUBJECT"
+	LOOKUP_MODE_ID      = "ID"
+	LOOKUP_MODE_AUTO    = "AUTO"
+
+	COMPRESSION_NULL    = "NULL"
+	COMPRESSION_DEFLATE = "DEFLATE"
+	COMPRESSION_SNAPPY  = "SNAPPY"
+
+	AVRO_SCHEMA_HEADER = "avroSchema"
+)
+
+type avroCodec struct {
+	codec       *goavro.Codec
+	schema      *avroSchema
+	schemaJson  string
+	schemaId    int
+	hasSchemaId bool
+}
+
+func newAvroCodec(schemaJson string) (*avroCodec, error) {
+	if schemaJson == "" {
+		return nil, errors.New("Avro schema is required")
+	}
+	codec, err := goavro.NewCodec(schemaJson)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid Avro schema: %s", err.Error()))
+	}
+	schema, err := parseAvroSchema(schemaJson)
+	if err != nil {
+		return nil, err
+	}
+	return &avroCodec{codec: codec, schema: schema, schemaJson: schemaJson}, nil
+}
+
+func newAvroCodecWithSchemaId(schemaJson string, schemaId int) (*avroCodec, error) {
+	codec, err := newAvroCodec(schemaJson)
+	if err != nil {
+		return nil, err
+	}
+	codec.schemaId = schemaId
+	codec.hasSchemaId = true
+	return codec, nil
+}
+
+// lookupCodec returns the codec for the schema from the registry configured by the lookup mode
+func lookupCodec(registry SchemaRegistry, lookupMode string, subject string, schemaId int) (*avroCodec, error) {
+	if registry == nil {
+		return nil, errors.New("Schema registry URLs are required to look up the Avro schema")
+	}
+	switch lookupMode {
+	case LOOKUP_MODE_SUBJECT:
+		latestSchemaId, schemaJson, err := registry.GetLatestSchema(subject)
+		if err != nil {
+			return nil, err
+		}
+		return newAvroCodecWithSchemaId(schemaJson, latestSchemaId)
+	case LOOKUP_MODE_ID:
+		schemaJson, err := registry.GetSchemaById(schemaId)
+		if err != nil {
+			return nil, err
+		}
+		return newAvroCodecWithSchemaId(schemaJson, schemaId)
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported schema lookup mode - %s", lookupMode))
+}
+
+// codecCache caches the codecs of schemas looked up by the id the messages are prefixed with
+type codecCache struct {
+	mutex      sync.Mutex
+	registry   SchemaRegistry
+	codecsById map[int]*avroCodec
+}
+
+func newCodecCache(registry SchemaRegistry) *codecCache {
+	return &codecCache{registry: registry, codecsById: make(map[int]*avroCodec)}
+}
+
+func (c *codecCache) get(schemaId int) (*avroCodec, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if codec, ok := c.codecsById[schemaId]; ok {
+		return codec, nil
+	}
+	codec, err := lookupCodec(c.registry, LOOKUP_MODE_ID, "", schemaId)
+	if err != nil {
+		return nil, err
+	}
+	c.codecsById[schemaId] = codec
+	return codec, nil
+}
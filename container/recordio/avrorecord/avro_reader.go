@@ -0,0 +1,165 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package avrorecord
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/linkedin/goavro"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/recordio"
+	"io"
+	"io/ioutil"
+)
+
+var ocfMagic = []byte("Obj\x01")
+
+type AvroReaderConfig struct {
+	SchemaSource   string
+	Schema         string
+	LookupMode     string
+	Subject        string
+	SchemaId       int
+	SchemaRegistry SchemaRegistry
+}
+
+type AvroReaderFactoryImpl struct {
+	config     AvroReaderConfig
+	codec      *avroCodec
+	codecCache *codecCache
+}
+
+// NewAvroReaderFactory returns a factory for readers of Avro container files, which embed their
+// schema, and of binary messages, which are read with the inline schema or the schema from the registry.
+func NewAvroReaderFactory(config AvroReaderConfig) (*AvroReaderFactoryImpl, error) {
+	factory := &AvroReaderFactoryImpl{config: config}
+	var err error
+	switch config.SchemaSource {
+	case "", SCHEMA_SOURCE_SOURCE:
+	case SCHEMA_SOURCE_INLINE:
+		factory.codec, err = newAvroCodec(config.Schema)
+	case SCHEMA_SOURCE_REGISTRY:
+		if config.LookupMode == LOOKUP_MODE_AUTO {
+			if config.SchemaRegistry == nil {
+				err = errors.New("Schema registry URLs are required to look up the Avro schema")
+			}
+			factory.codecCache = newCodecCache(config.SchemaRegistry)
+		} else {
+			factory.codec, err = lookupCodec(config.SchemaRegistry, config.LookupMode, config.Subject, config.SchemaId)
+		}
+	default:
+		err = errors.New(fmt.Sprintf("Unsupported Avro schema source - %s", config.SchemaSource))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return factory, nil
+}
+
+func (a *AvroReaderFactoryImpl) CreateReader(
+	context api.StageContext,
+	reader io.Reader,
+) (recordio.RecordReader, error) {
+	bufReader := bufio.NewReader(reader)
+	avroReader := &AvroReaderImpl{context: context, reader: reader}
+
+	if magic, _ := bufReader.Peek(len(ocfMagic)); bytes.Equal(magic, ocfMagic) {
+		ocfReader, err := goavro.NewOCFReader(bufReader)
+		if err != nil {
+			return nil, err
+		}
+		codec := ocfReader.Codec()
+		schema, err := parseAvroSchema(codec.Schema())
+		if err != nil {
+			return nil, err
+		}
+		avroReader.ocfReader = ocfReader
+		avroReader.codec = &avroCodec{codec: codec, schema: schema, schemaJson: codec.Schema()}
+		return avroReader, nil
+	}
+
+	data, err := ioutil.ReadAll(bufReader)
+	if err != nil {
+		return nil, err
+	}
+	if a.config.SchemaSource == SCHEMA_SOURCE_REGISTRY {
+		schemaId, rest, ok := readSchemaId(data)
+		if !ok && len(data) > 0 {
+			return nil, errors.New("Avro message is not prefixed with the schema id")
+		}
+		data = rest
+		if a.codecCache != nil && len(data) > 0 {
+			if avroReader.codec, err = a.codecCache.get(schemaId); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if avroReader.codec == nil {
+		avroReader.codec = a.codec
+	}
+	if avroReader.codec == nil && len(data) > 0 {
+		return nil, errors.New("Avro schema is required to read binary Avro messages without container")
+	}
+	avroReader.data = data
+	return avroReader, nil
+}
+
+type AvroReaderImpl struct {
+	context   api.StageContext
+	reader    io.Reader
+	codec     *avroCodec
+	ocfReader *goavro.OCFReader
+	data      []byte
+}
+
+func (avroReader *AvroReaderImpl) ReadRecord() (api.Record, error) {
+	var native interface{}
+	if avroReader.ocfReader != nil {
+		if !avroReader.ocfReader.Scan() {
+			return nil, avroReader.ocfReader.Err()
+		}
+		var err error
+		if native, err = avroReader.ocfReader.Read(); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(avroReader.data) == 0 {
+			return nil, nil
+		}
+		var err error
+		if native, avroReader.data, err = avroReader.codec.codec.NativeFromBinary(avroReader.data); err != nil {
+			return nil, err
+		}
+	}
+
+	field, err := avroReader.codec.schema.fieldFromNative(native, avroReader.codec.schema.root)
+	if err != nil {
+		return nil, err
+	}
+	record, err := avroReader.context.CreateRecord("sourceId", nil)
+	if err != nil {
+		return nil, err
+	}
+	record.Set(field)
+	record.GetHeader().SetAttribute(AVRO_SCHEMA_HEADER, avroReader.codec.schemaJson)
+	return record, nil
+}
+
+func (avroReader *AvroReaderImpl) Close() error {
+	return recordio.Close(avroReader.reader)
+}
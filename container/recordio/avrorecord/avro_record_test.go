@@ -0,0 +1,360 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package avrorecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "record",
+	"name": "Reading",
+	"namespace": "com.streamsets.edge",
+	"fields": [
+		{"name": "sensorId", "type": "string"},
+		{"name": "count", "type": "int"},
+		{"name": "total", "type": "long"},
+		{"name": "temperature", "type": "double"},
+		{"name": "active", "type": "boolean"},
+		{"name": "payload", "type": "bytes"},
+		{"name": "comment", "type": ["null", "string"], "default": null},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attributes", "type": {"type": "map", "values": "long"}},
+		{"name": "status", "type": {"type": "enum", "name": "Status", "symbols": ["OK", "FAILED"]}},
+		{"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+		{"name": "timestamp", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "location", "type": ["null", {
+			"type": "record",
+			"name": "Location",
+			"fields": [{"name": "lat", "type": "double"}, {"name": "lon", "type": "double"}]
+		}]}
+	]
+}`
+
+type stubSchemaRegistry struct {
+	schemas map[int]string
+}
+
+func (s *stubSchemaRegistry) GetSchemaById(schemaId int) (string, error) {
+	if schema, ok := s.schemas[schemaId]; ok {
+		return schema, nil
+	}
+	return "", errors.New("Schema not found")
+}
+
+func (s *stubSchemaRegistry) GetLatestSchema(subject string) (int, string, error) {
+	return 1, s.schemas[1], nil
+}
+
+func (s *stubSchemaRegistry) RegisterSchema(subject string, schema string) (int, error) {
+	schemaId := len(s.schemas) + 1
+	s.schemas[schemaId] = schema
+	return schemaId, nil
+}
+
+func CreateStageContext() api.StageContext {
+	return &common.StageContextImpl{
+		StageConfig: common.StageConfiguration{InstanceName: "Dummy Stage"},
+		Parameters:  nil,
+	}
+}
+
+func createTestRecord(t *testing.T, sensorId string, withLocation bool) api.Record {
+	price, _, _ := big.ParseFloat("12.34", 10, 64, big.ToNearestEven)
+	value := map[string]interface{}{
+		"sensorId":    sensorId,
+		"count":       5,
+		"total":       int64(1234567890123),
+		"temperature": 21.5,
+		"active":      true,
+		"payload":     []byte{0x01, 0x02},
+		"tags":        []interface{}{"a", "b"},
+		"attributes":  map[string]interface{}{"x": int64(1)},
+		"status":      "OK",
+		"timestamp":   int64(1510000000123),
+	}
+	if withLocation {
+		value["location"] = map[string]interface{}{"lat": 37.7, "lon": -122.4}
+		value["comment"] = "near the bay"
+	}
+	record, err := CreateStageContext().CreateRecord(sensorId, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.SetField("/price", &api.Field{Type: fieldtype.DECIMAL, Value: *price})
+	return record
+}
+
+func writeRecords(t *testing.T, config AvroWriterConfig, records ...api.Record) []byte {
+	writerFactory, err := NewAvroWriterFactory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := bytes.NewBuffer([]byte{})
+	recordWriter, err := writerFactory.CreateWriter(CreateStageContext(), buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := recordWriter.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recordWriter.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func readRecords(t *testing.T, config AvroReaderConfig, data []byte) []api.Record {
+	readerFactory, err := NewAvroReaderFactory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordReader, err := readerFactory.CreateReader(CreateStageContext(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]api.Record, 0)
+	for {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record == nil {
+			break
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func assertField(t *testing.T, record api.Record, fieldPath string, fieldType string, value interface{}) {
+	field, err := record.Get(fieldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field == nil {
+		t.Errorf("Field '%s' is missing", fieldPath)
+		return
+	}
+	if field.Type != fieldType {
+		t.Errorf("Expected type %s for field '%s', but received: %s", fieldType, fieldPath, field.Type)
+	}
+	if value != nil && field.Value != value {
+		t.Errorf("Expected value '%v' for field '%s', but received: '%v'", value, fieldPath, field.Value)
+	}
+}
+
+func assertTestRecord(t *testing.T, record api.Record, sensorId string, withLocation bool) {
+	assertField(t, record, "/sensorId", fieldtype.STRING, sensorId)
+	assertField(t, record, "/count", fieldtype.INTEGER, int32(5))
+	assertField(t, record, "/total", fieldtype.LONG, int64(1234567890123))
+	assertField(t, record, "/temperature", fieldtype.DOUBLE, 21.5)
+	assertField(t, record, "/active", fieldtype.BOOLEAN, true)
+	assertField(t, record, "/payload", fieldtype.BYTE_ARRAY, nil)
+	assertField(t, record, "/tags[1]", fieldtype.STRING, "b")
+	assertField(t, record, "/attributes/x", fieldtype.LONG, int64(1))
+	assertField(t, record, "/status", fieldtype.STRING, "OK")
+	assertField(t, record, "/timestamp", fieldtype.LONG, int64(1510000000123))
+	assertField(t, record, "/price", fieldtype.DECIMAL, nil)
+
+	priceField, _ := record.Get("/price")
+	price := priceField.Value.(big.Float)
+	if price.Text('f', 2) != "12.34" {
+		t.Errorf("Expected decimal value 12.34, but received: %s", price.Text('f', -1))
+	}
+
+	if withLocation {
+		assertField(t, record, "/comment", fieldtype.STRING, "near the bay")
+		assertField(t, record, "/location/lat", fieldtype.DOUBLE, 37.7)
+	} else {
+		assertField(t, record, "/comment", fieldtype.STRING, nil)
+		assertField(t, record, "/location", fieldtype.MAP, nil)
+	}
+
+	if record.GetHeader().GetAttributes()[AVRO_SCHEMA_HEADER] == "" {
+		t.Errorf("Expected '%s' header attribute", AVRO_SCHEMA_HEADER)
+	}
+}
+
+func TestAvroContainerFile(t *testing.T) {
+	for _, compression := range []string{COMPRESSION_NULL, COMPRESSION_DEFLATE, COMPRESSION_SNAPPY} {
+		data := writeRecords(
+			t,
+			AvroWriterConfig{
+				SchemaSource:  SCHEMA_SOURCE_INLINE,
+				Schema:        testSchema,
+				IncludeSchema: true,
+				Compression:   compression,
+			},
+			createTestRecord(t, "s1", true),
+			createTestRecord(t, "s2", false),
+		)
+
+		if !bytes.HasPrefix(data, ocfMagic) {
+			t.Fatalf("Expected Avro container file for compression %s", compression)
+		}
+
+		records := readRecords(t, AvroReaderConfig{SchemaSource: SCHEMA_SOURCE_SOURCE}, data)
+		if len(records) != 2 {
+			t.Fatalf("Expected 2 records for compression %s, but received: %d", compression, len(records))
+		}
+		assertTestRecord(t, records[0], "s1", true)
+		assertTestRecord(t, records[1], "s2", false)
+	}
+}
+
+func TestAvroBinaryMessages(t *testing.T) {
+	data := writeRecords(
+		t,
+		AvroWriterConfig{SchemaSource: SCHEMA_SOURCE_INLINE, Schema: testSchema},
+		createTestRecord(t, "s1", true),
+		createTestRecord(t, "s2", true),
+	)
+
+	records := readRecords(t, AvroReaderConfig{SchemaSource: SCHEMA_SOURCE_INLINE, Schema: testSchema}, data)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but received: %d", len(records))
+	}
+	assertTestRecord(t, records[0], "s1", true)
+	assertTestRecord(t, records[1], "s2", true)
+
+	readerFactory, _ := NewAvroReaderFactory(AvroReaderConfig{})
+	if _, err := readerFactory.CreateReader(CreateStageContext(), bytes.NewReader(data)); err == nil {
+		t.Error("Expected error when reading binary messages without schema")
+	}
+}
+
+func TestAvroSchemaFromHeader(t *testing.T) {
+	record := createTestRecord(t, "s1", true)
+	writerFactory, _ := NewAvroWriterFactory(AvroWriterConfig{SchemaSource: SCHEMA_SOURCE_HEADER})
+	recordWriter, _ := writerFactory.CreateWriter(CreateStageContext(), bytes.NewBuffer([]byte{}))
+	if err := recordWriter.WriteRecord(record); err == nil {
+		t.Error("Expected error for record without schema header attribute")
+	}
+
+	record.GetHeader().SetAttribute(AVRO_SCHEMA_HEADER, testSchema)
+	data := writeRecords(t, AvroWriterConfig{SchemaSource: SCHEMA_SOURCE_HEADER, IncludeSchema: true}, record)
+	records := readRecords(t, AvroReaderConfig{}, data)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, but received: %d", len(records))
+	}
+	assertTestRecord(t, records[0], "s1", true)
+}
+
+func TestAvroSchemaRegistry(t *testing.T) {
+	registry := &stubSchemaRegistry{schemas: map[int]string{}}
+	data := writeRecords(
+		t,
+		AvroWriterConfig{
+			SchemaSource:               SCHEMA_SOURCE_INLINE,
+			Schema:                     testSchema,
+			RegisterSchema:             true,
+			SubjectToRegister:          "readings",
+			RegistrationSchemaRegistry: registry,
+		},
+		createTestRecord(t, "s1", false),
+	)
+
+	if schemaId, _, ok := readSchemaId(data); !ok || schemaId != 1 {
+		t.Fatalf("Expected message prefixed with schema id 1, but received: %v", data[:5])
+	}
+
+	records := readRecords(
+		t,
+		AvroReaderConfig{SchemaSource: SCHEMA_SOURCE_REGISTRY, LookupMode: LOOKUP_MODE_AUTO, SchemaRegistry: registry},
+		data,
+	)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, but received: %d", len(records))
+	}
+	assertTestRecord(t, records[0], "s1", false)
+
+	_, err := NewAvroReaderFactory(AvroReaderConfig{
+		SchemaSource:   SCHEMA_SOURCE_REGISTRY,
+		LookupMode:     LOOKUP_MODE_ID,
+		SchemaId:       5,
+		SchemaRegistry: registry,
+	})
+	if err == nil {
+		t.Error("Expected error for unknown schema id")
+	}
+}
+
+func TestSchemaRegistryClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/7":
+			json.NewEncoder(w).Encode(map[string]interface{}{"schema": `"string"`})
+		case "/subjects/readings/versions/latest":
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 7, "schema": `"string"`})
+		case "/subjects/readings/versions":
+			var request map[string]string
+			json.NewDecoder(r.Body).Decode(&request)
+			if r.Method != "POST" || request["schema"] != `"string"` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 7})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	registry := NewSchemaRegistryClient([]string{"http://localhost:1", server.URL})
+
+	if schema, err := registry.GetSchemaById(7); err != nil || schema != `"string"` {
+		t.Errorf("Unexpected schema '%s' for id 7: %v", schema, err)
+	}
+	if schemaId, schema, err := registry.GetLatestSchema("readings"); err != nil || schemaId != 7 || schema != `"string"` {
+		t.Errorf("Unexpected latest schema %d '%s': %v", schemaId, schema, err)
+	}
+	if schemaId, err := registry.RegisterSchema("readings", `"string"`); err != nil || schemaId != 7 {
+		t.Errorf("Unexpected registered schema id %d: %v", schemaId, err)
+	}
+	if _, err := registry.GetSchemaById(8); err == nil {
+		t.Error("Expected error for unknown schema id")
+	}
+}
+
+func TestAvroWriterFactory_InvalidConfig(t *testing.T) {
+	invalidConfigs := []AvroWriterConfig{
+		{SchemaSource: SCHEMA_SOURCE_INLINE, Schema: testSchema, Compression: "BZIP2"},
+		{SchemaSource: SCHEMA_SOURCE_INLINE, Schema: `{"type": "unknown"}`},
+		{SchemaSource: SCHEMA_SOURCE_INLINE},
+		{SchemaSource: SCHEMA_SOURCE_REGISTRY, LookupMode: LOOKUP_MODE_SUBJECT},
+		{SchemaSource: "FILE"},
+	}
+	for _, config := range invalidConfigs {
+		if _, err := NewAvroWriterFactory(config); err == nil {
+			t.Errorf("Expected error for writer config %+v", config)
+		}
+	}
+}
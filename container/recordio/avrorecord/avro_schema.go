@@ -0,0 +1,525 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package avrorecord
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NULL    = "null"
+	BOOLEAN = "boolean"
+	INT     = "int"
+	LONG    = "long"
+	FLOAT   = "float"
+	DOUBLE  = "double"
+	BYTES   = "bytes"
+	STRING  = "string"
+	RECORD  = "record"
+	ENUM    = "enum"
+	ARRAY   = "array"
+	MAP     = "map"
+	FIXED   = "fixed"
+
+	DECIMAL          = "decimal"
+	DATE             = "date"
+	TIME_MILLIS      = "time-millis"
+	TIME_MICROS      = "time-micros"
+	TIMESTAMP_MILLIS = "timestamp-millis"
+	TIMESTAMP_MICROS = "timestamp-micros"
+
+	typeKey        = "type"
+	nameKey        = "name"
+	namespaceKey   = "namespace"
+	fieldsKey      = "fields"
+	itemsKey       = "items"
+	valuesKey      = "values"
+	logicalTypeKey = "logicalType"
+	defaultKey     = "default"
+	fullNameKey    = "_fullName"
+)
+
+var knownLogicalTypes = map[string]bool{
+	DECIMAL:          true,
+	DATE:             true,
+	TIME_MILLIS:      true,
+	TIME_MICROS:      true,
+	TIMESTAMP_MILLIS: true,
+	TIMESTAMP_MICROS: true,
+}
+
+// avroSchema is a parsed Avro schema used to convert between api.Field values and the
+// native values understood by goavro, which needs the schema to resolve unions and logical types.
+type avroSchema struct {
+	root       interface{}
+	namedTypes map[string]map[string]interface{}
+}
+
+func parseAvroSchema(schema string) (*avroSchema, error) {
+	var root interface{}
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		// primitive schemas may be given without quotes
+		root = strings.TrimSpace(schema)
+	}
+	s := &avroSchema{root: root, namedTypes: make(map[string]map[string]interface{})}
+	s.registerNamedTypes(root, "")
+	return s, nil
+}
+
+func (s *avroSchema) registerNamedTypes(schema interface{}, namespace string) {
+	switch schemaValue := schema.(type) {
+	case []interface{}:
+		for _, member := range schemaValue {
+			s.registerNamedTypes(member, namespace)
+		}
+	case map[string]interface{}:
+		switch schemaType := schemaValue[typeKey].(type) {
+		case string:
+			switch schemaType {
+			case RECORD, ENUM, FIXED:
+				name, _ := schemaValue[nameKey].(string)
+				if ns, ok := schemaValue[namespaceKey].(string); ok {
+					namespace = ns
+				}
+				if strings.Contains(name, ".") {
+					namespace = name[:strings.LastIndex(name, ".")]
+					name = name[strings.LastIndex(name, ".")+1:]
+				}
+				fullName := name
+				if namespace != "" {
+					fullName = namespace + "." + name
+				}
+				schemaValue[fullNameKey] = fullName
+				s.namedTypes[name] = schemaValue
+				s.namedTypes[fullName] = schemaValue
+				if schemaType == RECORD {
+					fields, _ := schemaValue[fieldsKey].([]interface{})
+					for _, field := range fields {
+						if fieldMap, ok := field.(map[string]interface{}); ok {
+							s.registerNamedTypes(fieldMap[typeKey], namespace)
+						}
+					}
+				}
+			case ARRAY:
+				s.registerNamedTypes(schemaValue[itemsKey], namespace)
+			case MAP:
+				s.registerNamedTypes(schemaValue[valuesKey], namespace)
+			}
+		default:
+			s.registerNamedTypes(schemaType, namespace)
+		}
+	}
+}
+
+// resolve returns the schema type name, the schema map if any and the logical type of the given schema
+func (s *avroSchema) resolve(schema interface{}) (string, map[string]interface{}, string) {
+	switch schemaValue := schema.(type) {
+	case string:
+		if named, ok := s.namedTypes[schemaValue]; ok {
+			return s.resolve(named)
+		}
+		return schemaValue, nil, ""
+	case []interface{}:
+		return "union", nil, ""
+	case map[string]interface{}:
+		schemaType, ok := schemaValue[typeKey].(string)
+		if !ok {
+			return s.resolve(schemaValue[typeKey])
+		}
+		if named, ok := s.namedTypes[schemaType]; ok && schemaType != RECORD && schemaType != ENUM && schemaType != FIXED {
+			return s.resolve(named)
+		}
+		logicalType, _ := schemaValue[logicalTypeKey].(string)
+		if !knownLogicalTypes[logicalType] {
+			logicalType = ""
+		}
+		return schemaType, schemaValue, logicalType
+	}
+	return "", nil, ""
+}
+
+// unionBranchName returns the name goavro uses for the given union member
+func (s *avroSchema) unionBranchName(schema interface{}) string {
+	schemaType, schemaMap, logicalType := s.resolve(schema)
+	switch schemaType {
+	case RECORD, ENUM, FIXED:
+		fullName, _ := schemaMap[fullNameKey].(string)
+		return fullName
+	default:
+		if logicalType != "" {
+			return schemaType + "." + logicalType
+		}
+		return schemaType
+	}
+}
+
+// fieldFromNative converts a value decoded by goavro into an api.Field
+func (s *avroSchema) fieldFromNative(native interface{}, schema interface{}) (*api.Field, error) {
+	schemaType, schemaMap, logicalType := s.resolve(schema)
+
+	if schemaType == "union" {
+		if native == nil {
+			return &api.Field{Type: s.fieldTypeForUnion(schema.([]interface{})), Value: nil}, nil
+		}
+		if unionValue, ok := native.(map[string]interface{}); ok && len(unionValue) == 1 {
+			for branchName, branchValue := range unionValue {
+				for _, member := range schema.([]interface{}) {
+					if s.unionBranchName(member) == branchName {
+						return s.fieldFromNative(branchValue, member)
+					}
+				}
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("Unable to resolve union value '%v'", native))
+	}
+
+	switch value := native.(type) {
+	case nil:
+		return &api.Field{Type: fieldtype.STRING, Value: nil}, nil
+	case map[string]interface{}:
+		mapFields := make(map[string]*api.Field, len(value))
+		for key, childNative := range value {
+			var childSchema interface{}
+			if schemaType == RECORD {
+				childSchema = getRecordFieldSchema(schemaMap, key)
+			} else if schemaMap != nil {
+				childSchema = schemaMap[valuesKey]
+			}
+			childField, err := s.fieldFromNative(childNative, childSchema)
+			if err != nil {
+				return nil, err
+			}
+			mapFields[key] = childField
+		}
+		return api.CreateMapFieldWithMapOfFields(mapFields), nil
+	case []interface{}:
+		listFields := make([]*api.Field, len(value))
+		for i, childNative := range value {
+			var childSchema interface{}
+			if schemaMap != nil {
+				childSchema = schemaMap[itemsKey]
+			}
+			childField, err := s.fieldFromNative(childNative, childSchema)
+			if err != nil {
+				return nil, err
+			}
+			listFields[i] = childField
+		}
+		return api.CreateListFieldWithListOfFields(listFields), nil
+	case *big.Rat:
+		return api.CreateBigFloatField(*new(big.Float).SetRat(value))
+	case time.Time:
+		if logicalType == TIMESTAMP_MICROS {
+			return api.CreateLongField(value.UnixNano() / int64(time.Microsecond))
+		}
+		return api.CreateLongField(value.UnixNano() / int64(time.Millisecond))
+	case time.Duration:
+		if logicalType == TIME_MICROS {
+			return api.CreateLongField(int64(value / time.Microsecond))
+		}
+		return api.CreateInteger32Field(int32(value / time.Millisecond))
+	default:
+		return api.CreateField(value)
+	}
+}
+
+func (s *avroSchema) fieldTypeForUnion(members []interface{}) string {
+	for _, member := range members {
+		schemaType, _, logicalType := s.resolve(member)
+		switch {
+		case logicalType == DECIMAL:
+			return fieldtype.DECIMAL
+		case logicalType == DATE || logicalType == TIMESTAMP_MILLIS || logicalType == TIMESTAMP_MICROS:
+			return fieldtype.LONG
+		}
+		switch schemaType {
+		case NULL:
+			continue
+		case BOOLEAN:
+			return fieldtype.BOOLEAN
+		case INT:
+			return fieldtype.INTEGER
+		case LONG:
+			return fieldtype.LONG
+		case FLOAT:
+			return fieldtype.FLOAT
+		case DOUBLE:
+			return fieldtype.DOUBLE
+		case BYTES, FIXED:
+			return fieldtype.BYTE_ARRAY
+		case RECORD, MAP:
+			return fieldtype.MAP
+		case ARRAY:
+			return fieldtype.LIST
+		default:
+			return fieldtype.STRING
+		}
+	}
+	return fieldtype.STRING
+}
+
+// nativeFromField converts an api.Field into the native value goavro expects for the given schema
+func (s *avroSchema) nativeFromField(field *api.Field, schema interface{}) (interface{}, error) {
+	schemaType, schemaMap, logicalType := s.resolve(schema)
+
+	if schemaType == "union" {
+		members := schema.([]interface{})
+		if field == nil || field.Value == nil {
+			for _, member := range members {
+				if memberType, _, _ := s.resolve(member); memberType == NULL {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("Null value is not allowed by the union schema")
+		}
+		for _, member := range members {
+			if s.isCompatible(field, member) {
+				native, err := s.nativeFromField(field, member)
+				if err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{s.unionBranchName(member): native}, nil
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("No union branch matches field type %s", field.Type))
+	}
+
+	if field == nil || field.Value == nil {
+		if schemaType == NULL {
+			return nil, nil
+		}
+		return nil, errors.New(fmt.Sprintf("Null value is not allowed for schema type %s", schemaType))
+	}
+
+	switch logicalType {
+	case DECIMAL:
+		return toBigRat(field.Value)
+	case DATE, TIMESTAMP_MILLIS:
+		millis, err := toInt64(field.Value)
+		return time.Unix(0, millis*int64(time.Millisecond)).UTC(), err
+	case TIMESTAMP_MICROS:
+		micros, err := toInt64(field.Value)
+		return time.Unix(0, micros*int64(time.Microsecond)).UTC(), err
+	case TIME_MILLIS:
+		millis, err := toInt64(field.Value)
+		return time.Duration(millis) * time.Millisecond, err
+	case TIME_MICROS:
+		micros, err := toInt64(field.Value)
+		return time.Duration(micros) * time.Microsecond, err
+	}
+
+	switch schemaType {
+	case NULL:
+		return nil, errors.New("Only null values are allowed for schema type null")
+	case BOOLEAN:
+		if value, ok := field.Value.(bool); ok {
+			return value, nil
+		}
+		return strconv.ParseBool(fmt.Sprintf("%v", field.Value))
+	case INT:
+		value, err := toInt64(field.Value)
+		return int32(value), err
+	case LONG:
+		return toInt64(field.Value)
+	case FLOAT:
+		value, err := toFloat64(field.Value)
+		return float32(value), err
+	case DOUBLE:
+		return toFloat64(field.Value)
+	case BYTES, FIXED:
+		switch value := field.Value.(type) {
+		case []byte:
+			return value, nil
+		case string:
+			return []byte(value), nil
+		}
+		return nil, errors.New(fmt.Sprintf("Unable to convert field type %s to Avro %s", field.Type, schemaType))
+	case STRING, ENUM:
+		if value, ok := field.Value.(string); ok {
+			return value, nil
+		}
+		return fmt.Sprintf("%v", field.Value), nil
+	case RECORD:
+		mapValue, ok := field.Value.(map[string]*api.Field)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unable to convert field type %s to Avro record", field.Type))
+		}
+		recordNative := make(map[string]interface{})
+		fields, _ := schemaMap[fieldsKey].([]interface{})
+		for _, recordField := range fields {
+			recordFieldMap := recordField.(map[string]interface{})
+			name := recordFieldMap[nameKey].(string)
+			childField, ok := mapValue[name]
+			if _, hasDefault := recordFieldMap[defaultKey]; !ok && hasDefault {
+				// goavro fills missing fields with the default value of the schema
+				continue
+			}
+			childNative, err := s.nativeFromField(childField, recordFieldMap[typeKey])
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("Field '%s': %s", name, err.Error()))
+			}
+			recordNative[name] = childNative
+		}
+		return recordNative, nil
+	case MAP:
+		mapValue, ok := field.Value.(map[string]*api.Field)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unable to convert field type %s to Avro map", field.Type))
+		}
+		mapNative := make(map[string]interface{}, len(mapValue))
+		for key, childField := range mapValue {
+			childNative, err := s.nativeFromField(childField, schemaMap[valuesKey])
+			if err != nil {
+				return nil, err
+			}
+			mapNative[key] = childNative
+		}
+		return mapNative, nil
+	case ARRAY:
+		listValue, ok := field.Value.([]*api.Field)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unable to convert field type %s to Avro array", field.Type))
+		}
+		listNative := make([]interface{}, len(listValue))
+		for i, childField := range listValue {
+			childNative, err := s.nativeFromField(childField, schemaMap[itemsKey])
+			if err != nil {
+				return nil, err
+			}
+			listNative[i] = childNative
+		}
+		return listNative, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported Avro schema type '%s'", schemaType))
+}
+
+// isCompatible returns true if the field can be written using the given union member schema
+func (s *avroSchema) isCompatible(field *api.Field, schema interface{}) bool {
+	schemaType, _, logicalType := s.resolve(schema)
+	if logicalType == DECIMAL {
+		return field.Type == fieldtype.DECIMAL
+	}
+	switch field.Type {
+	case fieldtype.BOOLEAN:
+		return schemaType == BOOLEAN
+	case fieldtype.BYTE, fieldtype.SHORT, fieldtype.INTEGER:
+		return schemaType == INT || schemaType == LONG
+	case fieldtype.LONG:
+		return schemaType == LONG
+	case fieldtype.FLOAT, fieldtype.DOUBLE:
+		return schemaType == FLOAT || schemaType == DOUBLE
+	case fieldtype.DECIMAL:
+		return schemaType == DOUBLE || schemaType == STRING
+	case fieldtype.STRING:
+		return schemaType == STRING || schemaType == ENUM
+	case fieldtype.BYTE_ARRAY:
+		return schemaType == BYTES || schemaType == FIXED
+	case fieldtype.MAP, fieldtype.LIST_MAP:
+		return schemaType == RECORD || schemaType == MAP
+	case fieldtype.LIST:
+		return schemaType == ARRAY
+	}
+	return false
+}
+
+func getRecordFieldSchema(schemaMap map[string]interface{}, name string) interface{} {
+	fields, _ := schemaMap[fieldsKey].([]interface{})
+	for _, field := range fields {
+		if fieldMap, ok := field.(map[string]interface{}); ok && fieldMap[nameKey] == name {
+			return fieldMap[typeKey]
+		}
+	}
+	return nil
+}
+
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case byte:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case float32:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, errors.New(fmt.Sprintf("Unable to convert value '%v' to a number", value))
+}
+
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case big.Float:
+		f, _ := v.Float64()
+		return f, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	i, err := toInt64(value)
+	return float64(i), err
+}
+
+func toBigRat(value interface{}) (*big.Rat, error) {
+	switch v := value.(type) {
+	case big.Float:
+		r, _ := v.Rat(nil)
+		if r == nil {
+			return nil, errors.New("Unable to convert infinite decimal value")
+		}
+		return r, nil
+	case big.Int:
+		return new(big.Rat).SetInt(&v), nil
+	case float32:
+		return new(big.Rat).SetFloat64(float64(v)), nil
+	case float64:
+		return new(big.Rat).SetFloat64(v), nil
+	case string:
+		if r, ok := new(big.Rat).SetString(v); ok {
+			return r, nil
+		}
+		return nil, errors.New(fmt.Sprintf("Unable to convert value '%s' to a decimal", v))
+	}
+	i, err := toInt64(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).SetInt64(i), nil
+}
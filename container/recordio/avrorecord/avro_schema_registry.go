@@ -0,0 +1,162 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package avrorecord
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SCHEMA_REGISTRY_CONTENT_TYPE = "application/vnd.schemaregistry.v1+json"
+	MAGIC_BYTE                   = byte(0)
+	SCHEMA_ID_SIZE               = 4
+)
+
+// SchemaRegistry looks up and registers Avro schemas, messages written with a schema from
+// the registry are prefixed with a magic byte and the 4 byte id of the schema.
+type SchemaRegistry interface {
+	GetSchemaById(schemaId int) (string, error)
+	GetLatestSchema(subject string) (int, string, error)
+	RegisterSchema(subject string, schema string) (int, error)
+}
+
+type schemaResponse struct {
+	Id     int    `json:"id"`
+	Schema string `json:"schema"`
+}
+
+type schemaRegistryClient struct {
+	urls       []string
+	httpClient *http.Client
+}
+
+// NewSchemaRegistryClient returns a client for the Confluent schema registry REST API,
+// the given urls are tried in order until one of them responds.
+func NewSchemaRegistryClient(urls []string) SchemaRegistry {
+	return &schemaRegistryClient{
+		urls:       urls,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *schemaRegistryClient) GetSchemaById(schemaId int) (string, error) {
+	response, err := s.send("GET", fmt.Sprintf("/schemas/ids/%d", schemaId), nil)
+	if err != nil {
+		return "", err
+	}
+	return response.Schema, nil
+}
+
+func (s *schemaRegistryClient) GetLatestSchema(subject string) (int, string, error) {
+	response, err := s.send("GET", "/subjects/"+url.PathEscape(subject)+"/versions/latest", nil)
+	if err != nil {
+		return 0, "", err
+	}
+	return response.Id, response.Schema, nil
+}
+
+func (s *schemaRegistryClient) RegisterSchema(subject string, schema string) (int, error) {
+	response, err := s.send(
+		"POST",
+		"/subjects/"+url.PathEscape(subject)+"/versions",
+		&schemaResponse{Schema: schema},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return response.Id, nil
+}
+
+func (s *schemaRegistryClient) send(method string, path string, body *schemaResponse) (*schemaResponse, error) {
+	if len(s.urls) == 0 {
+		return nil, errors.New("No schema registry URLs configured")
+	}
+	var err error
+	for _, registryUrl := range s.urls {
+		var response *schemaResponse
+		if response, err = s.sendToUrl(method, strings.TrimRight(registryUrl, "/")+path, body); err == nil {
+			return response, nil
+		}
+		log.Printf("[WARN] Schema registry request to '%s' failed: %s", registryUrl, err.Error())
+	}
+	return nil, err
+}
+
+func (s *schemaRegistryClient) sendToUrl(method string, requestUrl string, body *schemaResponse) (*schemaResponse, error) {
+	var requestBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&requestBody).Encode(map[string]string{"schema": body.Schema}); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, requestUrl, &requestBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", SCHEMA_REGISTRY_CONTENT_TYPE)
+	if body != nil {
+		req.Header.Set("Content-Type", SCHEMA_REGISTRY_CONTENT_TYPE)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf(
+			"Schema registry returned status %d: %s",
+			resp.StatusCode,
+			string(responseBody),
+		))
+	}
+
+	response := &schemaResponse{}
+	err = json.Unmarshal(responseBody, response)
+	return response, err
+}
+
+// appendSchemaId appends the magic byte and the schema id which prefix every message written
+// with a schema from the registry
+func appendSchemaId(buf []byte, schemaId int) []byte {
+	schemaIdBytes := make([]byte, SCHEMA_ID_SIZE)
+	binary.BigEndian.PutUint32(schemaIdBytes, uint32(schemaId))
+	return append(append(buf, MAGIC_BYTE), schemaIdBytes...)
+}
+
+// readSchemaId returns the schema id of a message prefixed with the magic byte and the rest of the message
+func readSchemaId(data []byte) (int, []byte, bool) {
+	if len(data) < SCHEMA_ID_SIZE+1 || data[0] != MAGIC_BYTE {
+		return 0, data, false
+	}
+	return int(binary.BigEndian.Uint32(data[1 : SCHEMA_ID_SIZE+1])), data[SCHEMA_ID_SIZE+1:], true
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package avrorecord
+
+import (
+	"errors"
+	"fmt"
+	"github.com/linkedin/goavro"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/recordio"
+	"io"
+	"sync"
+)
+
+var compressionNames = map[string]string{
+	"":                  goavro.CompressionNullLabel,
+	COMPRESSION_NULL:    goavro.CompressionNullLabel,
+	COMPRESSION_DEFLATE: goavro.CompressionDeflateLabel,
+	COMPRESSION_SNAPPY:  goavro.CompressionSnappyLabel,
+}
+
+type AvroWriterConfig struct {
+	SchemaSource               string
+	Schema                     string
+	IncludeSchema              bool
+	Compression                string
+	LookupMode                 string
+	Subject                    string
+	SchemaId                   int
+	SchemaRegistry             SchemaRegistry
+	RegisterSchema             bool
+	SubjectToRegister          string
+	RegistrationSchemaRegistry SchemaRegistry
+}
+
+type AvroWriterFactoryImpl struct {
+	config          AvroWriterConfig
+	compressionName string
+	codec           *avroCodec
+	mutex           sync.Mutex
+	headerCodecs    map[string]*avroCodec
+}
+
+// NewAvroWriterFactory returns a factory for writers of Avro container files when the schema
+// is included, or of binary messages, prefixed with the schema id if the schema comes from the registry.
+func NewAvroWriterFactory(config AvroWriterConfig) (*AvroWriterFactoryImpl, error) {
+	compressionName, ok := compressionNames[config.Compression]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Unsupported Avro compression codec - %s", config.Compression))
+	}
+	factory := &AvroWriterFactoryImpl{
+		config:          config,
+		compressionName: compressionName,
+		headerCodecs:    make(map[string]*avroCodec),
+	}
+
+	var err error
+	switch config.SchemaSource {
+	case SCHEMA_SOURCE_INLINE:
+		factory.codec, err = factory.newCodec(config.Schema)
+	case SCHEMA_SOURCE_REGISTRY:
+		factory.codec, err = lookupCodec(config.SchemaRegistry, config.LookupMode, config.Subject, config.SchemaId)
+	case SCHEMA_SOURCE_HEADER:
+	default:
+		err = errors.New(fmt.Sprintf("Unsupported Avro schema source - %s", config.SchemaSource))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return factory, nil
+}
+
+func (a *AvroWriterFactoryImpl) newCodec(schemaJson string) (*avroCodec, error) {
+	if !a.config.RegisterSchema {
+		return newAvroCodec(schemaJson)
+	}
+	if a.config.RegistrationSchemaRegistry == nil {
+		return nil, errors.New("Schema registry URLs are required to register the Avro schema")
+	}
+	if _, err := newAvroCodec(schemaJson); err != nil {
+		return nil, err
+	}
+	schemaId, err := a.config.RegistrationSchemaRegistry.RegisterSchema(a.config.SubjectToRegister, schemaJson)
+	if err != nil {
+		return nil, err
+	}
+	return newAvroCodecWithSchemaId(schemaJson, schemaId)
+}
+
+// getHeaderCodec returns the codec for the schema in the record header, codecs are cached
+// so schemas are registered only once.
+func (a *AvroWriterFactoryImpl) getHeaderCodec(record api.Record) (*avroCodec, error) {
+	schemaJson := record.GetHeader().GetAttributes()[AVRO_SCHEMA_HEADER]
+	if schemaJson == "" {
+		return nil, errors.New(fmt.Sprintf("Record is missing the '%s' header attribute", AVRO_SCHEMA_HEADER))
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if codec, ok := a.headerCodecs[schemaJson]; ok {
+		return codec, nil
+	}
+	codec, err := a.newCodec(schemaJson)
+	if err != nil {
+		return nil, err
+	}
+	a.headerCodecs[schemaJson] = codec
+	return codec, nil
+}
+
+func (a *AvroWriterFactoryImpl) CreateWriter(
+	context api.StageContext,
+	writer io.Writer,
+) (recordio.RecordWriter, error) {
+	var recordWriter recordio.RecordWriter
+	recordWriter = &AvroWriterImpl{
+		context: context,
+		writer:  writer,
+		factory: a,
+		codec:   a.codec,
+	}
+	return recordWriter, nil
+}
+
+type AvroWriterImpl struct {
+	context   api.StageContext
+	writer    io.Writer
+	factory   *AvroWriterFactoryImpl
+	codec     *avroCodec
+	ocfWriter *goavro.OCFWriter
+	pending   []interface{}
+}
+
+func (avroWriter *AvroWriterImpl) WriteRecord(r api.Record) error {
+	if avroWriter.codec == nil {
+		codec, err := avroWriter.factory.getHeaderCodec(r)
+		if err != nil {
+			return err
+		}
+		avroWriter.codec = codec
+	}
+
+	recordValue, _ := r.Get()
+	native, err := avroWriter.codec.schema.nativeFromField(recordValue, avroWriter.codec.schema.root)
+	if err != nil {
+		return err
+	}
+
+	if avroWriter.factory.config.IncludeSchema {
+		avroWriter.pending = append(avroWriter.pending, native)
+		return nil
+	}
+
+	var buf []byte
+	if avroWriter.codec.hasSchemaId {
+		buf = appendSchemaId(buf, avroWriter.codec.schemaId)
+	}
+	if buf, err = avroWriter.codec.codec.BinaryFromNative(buf, native); err != nil {
+		return err
+	}
+	_, err = avroWriter.writer.Write(buf)
+	return err
+}
+
+func (avroWriter *AvroWriterImpl) Flush() error {
+	if len(avroWriter.pending) > 0 {
+		if avroWriter.ocfWriter == nil {
+			ocfWriter, err := goavro.NewOCFWriter(goavro.OCFConfig{
+				W:               avroWriter.writer,
+				Codec:           avroWriter.codec.codec,
+				CompressionName: avroWriter.factory.compressionName,
+			})
+			if err != nil {
+				return err
+			}
+			avroWriter.ocfWriter = ocfWriter
+		}
+		if err := avroWriter.ocfWriter.Append(avroWriter.pending); err != nil {
+			return err
+		}
+		avroWriter.pending = nil
+	}
+	return recordio.Flush(avroWriter.writer)
+}
+
+func (avroWriter *AvroWriterImpl) Close() error {
+	if err := avroWriter.Flush(); err != nil {
+		return err
+	}
+	return recordio.Close(avroWriter.writer)
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package csvrecord
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	CSV           = "CSV"
+	EXCEL         = "EXCEL"
+	MYSQL         = "MYSQL"
+	TDF           = "TDF"
+	RFC4180       = "RFC4180"
+	POSTGRES_CSV  = "POSTGRES_CSV"
+	POSTGRES_TEXT = "POSTGRES_TEXT"
+	CUSTOM        = "CUSTOM"
+
+	WITH_HEADER   = "WITH_HEADER"
+	IGNORE_HEADER = "IGNORE_HEADER"
+	NO_HEADER     = "NO_HEADER"
+
+	LIST_MAP = "LIST_MAP"
+	LIST     = "LIST"
+
+	HEADER_KEY = "header"
+	VALUE_KEY  = "value"
+
+	NO_CHAR = rune(0)
+)
+
+// CsvFormat describes how the columns of a delimited row are separated, quoted and escaped.
+// NO_CHAR as Quote or Escape means the format does not support quoting or escaping.
+type CsvFormat struct {
+	Delimiter       rune
+	Quote           rune
+	Escape          rune
+	RecordSeparator string
+}
+
+var predefinedFormats = map[string]CsvFormat{
+	CSV:           {Delimiter: ',', Quote: '"', Escape: NO_CHAR, RecordSeparator: "\r\n"},
+	EXCEL:         {Delimiter: ',', Quote: '"', Escape: NO_CHAR, RecordSeparator: "\r\n"},
+	RFC4180:       {Delimiter: ',', Quote: '"', Escape: NO_CHAR, RecordSeparator: "\r\n"},
+	TDF:           {Delimiter: '\t', Quote: '"', Escape: NO_CHAR, RecordSeparator: "\r\n"},
+	MYSQL:         {Delimiter: '\t', Quote: NO_CHAR, Escape: '\\', RecordSeparator: "\n"},
+	POSTGRES_CSV:  {Delimiter: ',', Quote: '"', Escape: NO_CHAR, RecordSeparator: "\n"},
+	POSTGRES_TEXT: {Delimiter: '\t', Quote: NO_CHAR, Escape: '\\', RecordSeparator: "\n"},
+}
+
+// NewCsvFormat returns the format for the given file format name, custom characters are
+// only used for the CUSTOM file format. An empty file format defaults to CSV.
+func NewCsvFormat(fileFormat string, customDelimiter string, customEscape string, customQuote string) (*CsvFormat, error) {
+	if fileFormat == "" {
+		fileFormat = CSV
+	}
+	if fileFormat != CUSTOM {
+		format, ok := predefinedFormats[fileFormat]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unsupported Delimited File Format - %s", fileFormat))
+		}
+		return &format, nil
+	}
+
+	delimiter, err := getChar("Delimiter", customDelimiter)
+	if err != nil {
+		return nil, err
+	}
+	if delimiter == NO_CHAR {
+		return nil, errors.New("Custom Delimiter character is required")
+	}
+	escape, err := getChar("Escape", customEscape)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := getChar("Quote", customQuote)
+	if err != nil {
+		return nil, err
+	}
+	if delimiter == quote || delimiter == escape || delimiter == '\n' || delimiter == '\r' {
+		return nil, errors.New(fmt.Sprintf("Invalid Custom Delimiter character '%c'", delimiter))
+	}
+	return &CsvFormat{Delimiter: delimiter, Quote: quote, Escape: escape, RecordSeparator: "\n"}, nil
+}
+
+func getChar(name string, value string) (rune, error) {
+	switch utf8.RuneCountInString(value) {
+	case 0:
+		return NO_CHAR, nil
+	case 1:
+		r, _ := utf8.DecodeRuneInString(value)
+		return r, nil
+	default:
+		return NO_CHAR, errors.New(fmt.Sprintf("Custom %s should be a single character, but got '%s'", name, value))
+	}
+}
+
+func (f *CsvFormat) hasQuote() bool {
+	return f.Quote != NO_CHAR
+}
+
+func (f *CsvFormat) hasEscape() bool {
+	return f.Escape != NO_CHAR && f.Escape != f.Quote
+}
+
+func validateHeaderLine(headerLine string) error {
+	switch headerLine {
+	case "", WITH_HEADER, IGNORE_HEADER, NO_HEADER:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unsupported Header Line option - %s", headerLine))
+}
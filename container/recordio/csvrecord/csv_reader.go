@@ -0,0 +1,296 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package csvrecord
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/recordio"
+	"io"
+	"strconv"
+)
+
+type CsvReaderFactoryImpl struct {
+	Format     *CsvFormat
+	HeaderLine string
+	RecordType string
+}
+
+func NewCsvReaderFactory(format *CsvFormat, headerLine string, recordType string) (*CsvReaderFactoryImpl, error) {
+	if err := validateHeaderLine(headerLine); err != nil {
+		return nil, err
+	}
+	switch recordType {
+	case "":
+		recordType = LIST_MAP
+	case LIST_MAP, LIST:
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported Delimited Record Type - %s", recordType))
+	}
+	return &CsvReaderFactoryImpl{Format: format, HeaderLine: headerLine, RecordType: recordType}, nil
+}
+
+func (c *CsvReaderFactoryImpl) CreateReader(
+	context api.StageContext,
+	reader io.Reader,
+) (recordio.RecordReader, error) {
+	var recordReader recordio.RecordReader
+	recordReader = newRecordReader(context, reader, c)
+	return recordReader, nil
+}
+
+type CsvReaderImpl struct {
+	context    api.StageContext
+	reader     io.Reader
+	bufReader  *bufio.Reader
+	format     *CsvFormat
+	headerLine string
+	recordType string
+	headers    []string
+	headerRead bool
+	offset     int64
+}
+
+func (csvReader *CsvReaderImpl) ReadRecord() (api.Record, error) {
+	if err := csvReader.readHeaderIfNeeded(); err != nil {
+		return nil, err
+	}
+	columns, err := csvReader.readRow()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	record, err := csvReader.context.CreateRecord("sourceId", nil)
+	if err != nil {
+		return nil, err
+	}
+	record.Set(csvReader.createRootField(columns))
+	return record, nil
+}
+
+// GetOffset returns the number of bytes consumed from the underlying reader,
+// which is the position of the next record in the stream.
+func (csvReader *CsvReaderImpl) GetOffset() int64 {
+	return csvReader.offset
+}
+
+// SkipToOffset reads the header line if one is expected and then discards
+// the bytes up to the given offset, so reading resumes from a previously returned offset.
+func (csvReader *CsvReaderImpl) SkipToOffset(offset int64) error {
+	if err := csvReader.readHeaderIfNeeded(); err != nil {
+		return err
+	}
+	if offset > csvReader.offset {
+		discarded, err := csvReader.bufReader.Discard(int(offset - csvReader.offset))
+		csvReader.offset += int64(discarded)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+func (csvReader *CsvReaderImpl) Close() error {
+	return recordio.Close(csvReader.reader)
+}
+
+func (csvReader *CsvReaderImpl) readHeaderIfNeeded() error {
+	if csvReader.headerRead {
+		return nil
+	}
+	csvReader.headerRead = true
+	if csvReader.headerLine == WITH_HEADER || csvReader.headerLine == IGNORE_HEADER {
+		columns, err := csvReader.readRow()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if csvReader.headerLine == WITH_HEADER {
+			csvReader.headers = columns
+		}
+	}
+	return nil
+}
+
+func (csvReader *CsvReaderImpl) createRootField(columns []string) *api.Field {
+	switch csvReader.recordType {
+	case LIST:
+		listFields := make([]*api.Field, len(columns))
+		for i, column := range columns {
+			columnFields := map[string]*api.Field{}
+			if i < len(csvReader.headers) {
+				columnFields[HEADER_KEY], _ = api.CreateStringField(csvReader.headers[i])
+			}
+			columnFields[VALUE_KEY], _ = api.CreateStringField(column)
+			listFields[i] = api.CreateMapFieldWithMapOfFields(columnFields)
+		}
+		return api.CreateListFieldWithListOfFields(listFields)
+	default:
+		keys := make([]string, len(columns))
+		mapFields := make(map[string]*api.Field)
+		for i, column := range columns {
+			keys[i] = csvReader.getColumnName(i)
+			mapFields[keys[i]], _ = api.CreateStringField(column)
+		}
+		return api.CreateListMapFieldWithMapOfFields(keys, mapFields)
+	}
+}
+
+func (csvReader *CsvReaderImpl) getColumnName(index int) string {
+	if index < len(csvReader.headers) {
+		return csvReader.headers[index]
+	}
+	return strconv.Itoa(index)
+}
+
+// readRow reads the columns of the next non empty row, returns io.EOF when there are no more rows.
+func (csvReader *CsvReaderImpl) readRow() ([]string, error) {
+	for {
+		columns, isEmpty, err := csvReader.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !isEmpty {
+			return columns, nil
+		}
+	}
+}
+
+func (csvReader *CsvReaderImpl) readLine() ([]string, bool, error) {
+	columns := make([]string, 0)
+	var column bytes.Buffer
+	readAny := false
+	quoted := false
+	inQuotes := false
+
+	for {
+		r, err := csvReader.readRune()
+		if err != nil {
+			if err != io.EOF {
+				return nil, false, err
+			}
+			if inQuotes {
+				return nil, false, errors.New(
+					fmt.Sprintf("Unterminated quoted column at offset %d", csvReader.offset),
+				)
+			}
+			if !readAny {
+				return nil, false, io.EOF
+			}
+			break
+		}
+		readAny = true
+
+		if csvReader.format.hasEscape() && r == csvReader.format.Escape {
+			escaped, err := csvReader.readRune()
+			if err != nil {
+				if err == io.EOF {
+					column.WriteRune(r)
+					continue
+				}
+				return nil, false, err
+			}
+			column.WriteRune(escaped)
+			continue
+		}
+
+		if csvReader.format.hasQuote() && r == csvReader.format.Quote {
+			if inQuotes {
+				next, err := csvReader.readRune()
+				if err == nil && next == csvReader.format.Quote {
+					column.WriteRune(next)
+					continue
+				}
+				if err == nil {
+					csvReader.unreadRune(next)
+				} else if err != io.EOF {
+					return nil, false, err
+				}
+				inQuotes = false
+				continue
+			}
+			if column.Len() == 0 && !quoted {
+				inQuotes = true
+				quoted = true
+				continue
+			}
+		}
+
+		if inQuotes {
+			column.WriteRune(r)
+			continue
+		}
+
+		if r == csvReader.format.Delimiter {
+			columns = append(columns, column.String())
+			column.Reset()
+			quoted = false
+			continue
+		}
+
+		if r == '\n' {
+			break
+		}
+
+		if r == '\r' {
+			next, err := csvReader.readRune()
+			if err == nil && next == '\n' {
+				break
+			}
+			if err == nil {
+				csvReader.unreadRune(next)
+			} else if err != io.EOF {
+				return nil, false, err
+			}
+		}
+
+		column.WriteRune(r)
+	}
+
+	isEmpty := len(columns) == 0 && column.Len() == 0 && !quoted
+	columns = append(columns, column.String())
+	return columns, isEmpty, nil
+}
+
+func (csvReader *CsvReaderImpl) readRune() (rune, error) {
+	r, size, err := csvReader.bufReader.ReadRune()
+	if err != nil {
+		return r, err
+	}
+	csvReader.offset += int64(size)
+	return r, nil
+}
+
+func (csvReader *CsvReaderImpl) unreadRune(r rune) {
+	if err := csvReader.bufReader.UnreadRune(); err == nil {
+		csvReader.offset -= int64(len(string(r)))
+	}
+}
+
+func newRecordReader(context api.StageContext, reader io.Reader, factory *CsvReaderFactoryImpl) *CsvReaderImpl {
+	return &CsvReaderImpl{
+		context:    context,
+		reader:     reader,
+		bufReader:  bufio.NewReader(reader),
+		format:     factory.Format,
+		headerLine: factory.HeaderLine,
+		recordType: factory.RecordType,
+	}
+}
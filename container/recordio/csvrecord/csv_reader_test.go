@@ -0,0 +1,174 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package csvrecord
+
+import (
+	"bytes"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"testing"
+)
+
+func CreateStageContext() api.StageContext {
+	return &common.StageContextImpl{
+		StageConfig: common.StageConfiguration{InstanceName: "Dummy Stage"},
+		Parameters:  nil,
+	}
+}
+
+func createReader(t *testing.T, data string, format *CsvFormat, headerLine string, recordType string) *CsvReaderImpl {
+	readerFactory, err := NewCsvReaderFactory(format, headerLine, recordType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordReader, err := readerFactory.CreateReader(CreateStageContext(), bytes.NewBufferString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recordReader.(*CsvReaderImpl)
+}
+
+func readAllRecords(t *testing.T, recordReader *CsvReaderImpl) []api.Record {
+	records := make([]api.Record, 0)
+	for {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record == nil {
+			break
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func assertColumn(t *testing.T, record api.Record, fieldPath string, expected string) {
+	field, err := record.Get(fieldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field == nil {
+		t.Errorf("Expected field '%s' with value '%s', but field is missing", fieldPath, expected)
+		return
+	}
+	if field.Value.(string) != expected {
+		t.Errorf("Expected field '%s' with value '%s', but received: '%s'", fieldPath, expected, field.Value)
+	}
+}
+
+func TestReadCsvRecord_WithHeader(t *testing.T) {
+	format, _ := NewCsvFormat(CSV, "", "", "")
+	data := "name,city,comment\r\n" +
+		"alice,\"San Francisco, CA\",\"says \"\"hi\"\"\"\r\n" +
+		"\r\n" +
+		"bob,Austin,\"multi\nline\"\r\n"
+	records := readAllRecords(t, createReader(t, data, format, WITH_HEADER, LIST_MAP))
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but received: %d", len(records))
+	}
+	rootField, _ := records[0].Get()
+	if rootField.Type != fieldtype.LIST_MAP {
+		t.Errorf("Expected record type : LIST_MAP, but received: %s", rootField.Type)
+	}
+	assertColumn(t, records[0], "/name", "alice")
+	assertColumn(t, records[0], "/city", "San Francisco, CA")
+	assertColumn(t, records[0], "/comment", "says \"hi\"")
+	assertColumn(t, records[1], "/name", "bob")
+	assertColumn(t, records[1], "/comment", "multi\nline")
+}
+
+func TestReadCsvRecord_IgnoreAndNoHeader(t *testing.T) {
+	format, _ := NewCsvFormat(CSV, "", "", "")
+
+	records := readAllRecords(t, createReader(t, "a,b\n1,2\n", format, IGNORE_HEADER, LIST_MAP))
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, but received: %d", len(records))
+	}
+	assertColumn(t, records[0], "/0", "1")
+	assertColumn(t, records[0], "/1", "2")
+
+	records = readAllRecords(t, createReader(t, "a,b\n1,2", format, NO_HEADER, LIST))
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but received: %d", len(records))
+	}
+	rootField, _ := records[1].Get()
+	if rootField.Type != fieldtype.LIST {
+		t.Errorf("Expected record type : LIST, but received: %s", rootField.Type)
+	}
+	assertColumn(t, records[0], "[0]/value", "a")
+	assertColumn(t, records[1], "[1]/value", "2")
+}
+
+func TestReadCsvRecord_CustomFormat(t *testing.T) {
+	format, err := NewCsvFormat(CUSTOM, "|", "\\", "'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "id|value\n1|'quoted | value'\n2|escaped \\| value\n"
+	records := readAllRecords(t, createReader(t, data, format, WITH_HEADER, LIST))
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but received: %d", len(records))
+	}
+	assertColumn(t, records[0], "[0]/header", "id")
+	assertColumn(t, records[0], "[1]/value", "quoted | value")
+	assertColumn(t, records[1], "[1]/value", "escaped | value")
+}
+
+func TestReadCsvRecord_Offset(t *testing.T) {
+	format, _ := NewCsvFormat(CSV, "", "", "")
+	data := "name,age\nalice,30\nbob,40\n"
+
+	recordReader := createReader(t, data, format, WITH_HEADER, LIST_MAP)
+	if _, err := recordReader.ReadRecord(); err != nil {
+		t.Fatal(err)
+	}
+	offset := recordReader.GetOffset()
+	if offset != int64(len("name,age\nalice,30\n")) {
+		t.Errorf("Unexpected offset after first record: %d", offset)
+	}
+
+	recordReader = createReader(t, data, format, WITH_HEADER, LIST_MAP)
+	if err := recordReader.SkipToOffset(offset); err != nil {
+		t.Fatal(err)
+	}
+	records := readAllRecords(t, recordReader)
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 record, but received: %d", len(records))
+	}
+	assertColumn(t, records[0], "/name", "bob")
+	assertColumn(t, records[0], "/age", "40")
+}
+
+func TestReadCsvRecord_Errors(t *testing.T) {
+	format, _ := NewCsvFormat(CSV, "", "", "")
+	recordReader := createReader(t, "a,\"unterminated\n", format, NO_HEADER, LIST_MAP)
+	if _, err := recordReader.ReadRecord(); err == nil {
+		t.Error("Expected error for unterminated quoted column")
+	}
+
+	if _, err := NewCsvFormat("UNKNOWN", "", "", ""); err == nil {
+		t.Error("Expected error for unsupported file format")
+	}
+	if _, err := NewCsvFormat(CUSTOM, "||", "", ""); err == nil {
+		t.Error("Expected error for multi character delimiter")
+	}
+	if _, err := NewCsvReaderFactory(format, "HEADER", LIST_MAP); err == nil {
+		t.Error("Expected error for unsupported header line option")
+	}
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package csvrecord
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"github.com/streamsets/datacollector-edge/container/recordio"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type CsvWriterFactoryImpl struct {
+	Format                *CsvFormat
+	HeaderLine            string
+	ReplaceNewLines       bool
+	ReplaceNewLinesString string
+}
+
+func NewCsvWriterFactory(
+	format *CsvFormat,
+	headerLine string,
+	replaceNewLines bool,
+	replaceNewLinesString string,
+) (*CsvWriterFactoryImpl, error) {
+	if err := validateHeaderLine(headerLine); err != nil {
+		return nil, err
+	}
+	return &CsvWriterFactoryImpl{
+		Format:                format,
+		HeaderLine:            headerLine,
+		ReplaceNewLines:       replaceNewLines,
+		ReplaceNewLinesString: replaceNewLinesString,
+	}, nil
+}
+
+func (c *CsvWriterFactoryImpl) CreateWriter(
+	context api.StageContext,
+	writer io.Writer,
+) (recordio.RecordWriter, error) {
+	var recordWriter recordio.RecordWriter
+	recordWriter = newRecordWriter(context, writer, c)
+	return recordWriter, nil
+}
+
+type CsvWriterImpl struct {
+	context               api.StageContext
+	writer                *bufio.Writer
+	format                *CsvFormat
+	headerLine            string
+	replaceNewLines       bool
+	replaceNewLinesString string
+	headers               []string
+	rowsWritten           int
+}
+
+func (csvWriter *CsvWriterImpl) WriteRecord(r api.Record) error {
+	recordValue, _ := r.Get()
+	if recordValue == nil || recordValue.Value == nil {
+		return errors.New("Record has no value to write as delimited")
+	}
+
+	var headers, columns []string
+	var err error
+	switch recordValue.Type {
+	case fieldtype.MAP, fieldtype.LIST_MAP:
+		headers, columns, err = csvWriter.getMapColumns(recordValue)
+	case fieldtype.LIST:
+		headers, columns, err = csvWriter.getListColumns(recordValue.Value.([]*api.Field))
+	default:
+		err = errors.New("Unsupported Field Type for delimited record - " + recordValue.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	if csvWriter.rowsWritten == 0 && csvWriter.headerLine == WITH_HEADER {
+		if err := csvWriter.writeRow(headers); err != nil {
+			return err
+		}
+	}
+	if err := csvWriter.writeRow(columns); err != nil {
+		return err
+	}
+	csvWriter.rowsWritten++
+	return nil
+}
+
+// getMapColumns returns the columns of a map record, the columns of the first record decide
+// the header and the column order for all the following records of the writer. The columns of a
+// LIST_MAP record keep the order of its entries, the ones of a MAP record are sorted.
+func (csvWriter *CsvWriterImpl) getMapColumns(recordValue *api.Field) ([]string, []string, error) {
+	mapValue := recordValue.Value.(map[string]*api.Field)
+	if csvWriter.headers == nil {
+		if recordValue.Type == fieldtype.LIST_MAP {
+			csvWriter.headers = recordValue.GetListMapKeys()
+		} else {
+			headers := make([]string, 0, len(mapValue))
+			for key := range mapValue {
+				headers = append(headers, key)
+			}
+			sort.Slice(headers, func(i, j int) bool {
+				return lessColumnName(headers[i], headers[j])
+			})
+			csvWriter.headers = headers
+		}
+	}
+
+	columns := make([]string, len(csvWriter.headers))
+	for i, header := range csvWriter.headers {
+		if field, ok := mapValue[header]; ok {
+			column, err := getColumnValue(field)
+			if err != nil {
+				return nil, nil, err
+			}
+			columns[i] = column
+		}
+	}
+	return csvWriter.headers, columns, nil
+}
+
+// getListColumns returns the columns of a list record, list elements can either be values or
+// maps with header and value keys as generated by the delimited reader.
+func (csvWriter *CsvWriterImpl) getListColumns(listValue []*api.Field) ([]string, []string, error) {
+	headers := make([]string, len(listValue))
+	columns := make([]string, len(listValue))
+	for i, field := range listValue {
+		headers[i] = strconv.Itoa(i)
+		if field.Type == fieldtype.MAP || field.Type == fieldtype.LIST_MAP {
+			columnFields := field.Value.(map[string]*api.Field)
+			if headerField, ok := columnFields[HEADER_KEY]; ok && headerField.Value != nil {
+				headers[i] = fmt.Sprintf("%v", headerField.Value)
+			}
+			field = columnFields[VALUE_KEY]
+		}
+		column, err := getColumnValue(field)
+		if err != nil {
+			return nil, nil, err
+		}
+		columns[i] = column
+	}
+	return headers, columns, nil
+}
+
+func (csvWriter *CsvWriterImpl) writeRow(columns []string) error {
+	for i, column := range columns {
+		if i > 0 {
+			if _, err := csvWriter.writer.WriteRune(csvWriter.format.Delimiter); err != nil {
+				return err
+			}
+		}
+		if csvWriter.replaceNewLines {
+			column = replaceNewLines(column, csvWriter.replaceNewLinesString)
+		}
+		if _, err := csvWriter.writer.WriteString(csvWriter.formatColumn(column)); err != nil {
+			return err
+		}
+	}
+	_, err := csvWriter.writer.WriteString(csvWriter.format.RecordSeparator)
+	return err
+}
+
+func (csvWriter *CsvWriterImpl) formatColumn(column string) string {
+	format := csvWriter.format
+	if format.hasQuote() && strings.IndexFunc(column, csvWriter.requiresQuotes) >= 0 {
+		var escapedQuote string
+		if format.hasEscape() {
+			column = strings.Replace(column, string(format.Escape), string(format.Escape)+string(format.Escape), -1)
+			escapedQuote = string(format.Escape) + string(format.Quote)
+		} else {
+			escapedQuote = string(format.Quote) + string(format.Quote)
+		}
+		column = strings.Replace(column, string(format.Quote), escapedQuote, -1)
+		return string(format.Quote) + column + string(format.Quote)
+	}
+
+	if format.hasEscape() {
+		var escaped bytes.Buffer
+		for _, r := range column {
+			if r == format.Escape || r == format.Delimiter || r == '\n' || r == '\r' {
+				escaped.WriteRune(format.Escape)
+			}
+			escaped.WriteRune(r)
+		}
+		return escaped.String()
+	}
+	return column
+}
+
+func (csvWriter *CsvWriterImpl) requiresQuotes(r rune) bool {
+	return r == csvWriter.format.Delimiter || r == csvWriter.format.Quote || r == '\n' || r == '\r' ||
+		(csvWriter.format.hasEscape() && r == csvWriter.format.Escape)
+}
+
+func (csvWriter *CsvWriterImpl) Flush() error {
+	return recordio.Flush(csvWriter.writer)
+}
+
+func (csvWriter *CsvWriterImpl) Close() error {
+	return recordio.Close(csvWriter.writer)
+}
+
+func getColumnValue(field *api.Field) (string, error) {
+	if field == nil || field.Value == nil {
+		return "", nil
+	}
+	switch field.Type {
+	case fieldtype.MAP, fieldtype.LIST_MAP, fieldtype.LIST:
+		return "", errors.New("Unsupported nested Field Type for delimited column - " + field.Type)
+	case fieldtype.BYTE_ARRAY:
+		return base64.StdEncoding.EncodeToString(field.Value.([]byte)), nil
+	}
+	switch value := field.Value.(type) {
+	case big.Float:
+		return value.Text('f', -1), nil
+	case big.Int:
+		return value.String(), nil
+	case string:
+		return value, nil
+	default:
+		return fmt.Sprintf("%v", value), nil
+	}
+}
+
+func replaceNewLines(column string, replacement string) string {
+	column = strings.Replace(column, "\r\n", replacement, -1)
+	column = strings.Replace(column, "\n", replacement, -1)
+	return strings.Replace(column, "\r", replacement, -1)
+}
+
+// lessColumnName orders numeric column names, as generated by the delimited reader
+// for files without header, numerically and all other names lexicographically.
+func lessColumnName(a string, b string) bool {
+	aIndex, aErr := strconv.Atoi(a)
+	bIndex, bErr := strconv.Atoi(b)
+	if aErr == nil && bErr == nil {
+		return aIndex < bIndex
+	}
+	if aErr == nil || bErr == nil {
+		return aErr == nil
+	}
+	return a < b
+}
+
+func newRecordWriter(context api.StageContext, writer io.Writer, factory *CsvWriterFactoryImpl) *CsvWriterImpl {
+	return &CsvWriterImpl{
+		context:               context,
+		writer:                bufio.NewWriter(writer),
+		format:                factory.Format,
+		headerLine:            factory.HeaderLine,
+		replaceNewLines:       factory.ReplaceNewLines,
+		replaceNewLinesString: factory.ReplaceNewLinesString,
+	}
+}
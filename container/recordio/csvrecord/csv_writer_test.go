@@ -0,0 +1,105 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package csvrecord
+
+import (
+	"bytes"
+	"github.com/streamsets/datacollector-edge/api"
+	"testing"
+)
+
+func writeRecords(t *testing.T, writerFactory *CsvWriterFactoryImpl, records ...api.Record) string {
+	bufferWriter := bytes.NewBuffer([]byte{})
+	recordWriter, err := writerFactory.CreateWriter(CreateStageContext(), bufferWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := recordWriter.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordWriter.Flush()
+	recordWriter.Close()
+	return bufferWriter.String()
+}
+
+func TestWriteCsvRecord_WithHeader(t *testing.T) {
+	stageContext := CreateStageContext()
+	record1, _ := stageContext.CreateRecord("Id1", map[string]interface{}{
+		"name":    "alice",
+		"city":    "San Francisco, CA",
+		"comment": "says \"hi\"",
+	})
+	record2, _ := stageContext.CreateRecord("Id2", map[string]interface{}{
+		"name":  "bob",
+		"count": 5,
+	})
+
+	format, _ := NewCsvFormat(CSV, "", "", "")
+	writerFactory, err := NewCsvWriterFactory(format, WITH_HEADER, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "city,comment,name\r\n" +
+		"\"San Francisco, CA\",\"says \"\"hi\"\"\",alice\r\n" +
+		",,bob\r\n"
+	if output := writeRecords(t, writerFactory, record1, record2); output != expected {
+		t.Errorf("Expected output:\n%s\nbut received:\n%s", expected, output)
+	}
+}
+
+func TestWriteCsvRecord_ListAndCustomFormat(t *testing.T) {
+	stageContext := CreateStageContext()
+	record, _ := stageContext.CreateRecord("Id1", []interface{}{"1", "a|b", "multi\nline", 2.5})
+
+	format, _ := NewCsvFormat(CUSTOM, "|", "\\", "")
+	writerFactory, _ := NewCsvWriterFactory(format, NO_HEADER, false, "")
+	expected := "1|a\\|b|multi\\\nline|2.5\n"
+	if output := writeRecords(t, writerFactory, record); output != expected {
+		t.Errorf("Expected output:\n%s\nbut received:\n%s", expected, output)
+	}
+
+	format, _ = NewCsvFormat(TDF, "", "", "")
+	writerFactory, _ = NewCsvWriterFactory(format, IGNORE_HEADER, true, " ")
+	expected = "1\ta|b\tmulti line\t2.5\r\n"
+	if output := writeRecords(t, writerFactory, record); output != expected {
+		t.Errorf("Expected output:\n%s\nbut received:\n%s", expected, output)
+	}
+}
+
+func TestCsvRecord_RoundTrip(t *testing.T) {
+	data := "id,value\n1,\"a,b\"\n2,\"multi\nline\"\n"
+	format, _ := NewCsvFormat(POSTGRES_CSV, "", "", "")
+	records := readAllRecords(t, createReader(t, data, format, WITH_HEADER, LIST))
+
+	writerFactory, _ := NewCsvWriterFactory(format, WITH_HEADER, false, "")
+	if output := writeRecords(t, writerFactory, records...); output != data {
+		t.Errorf("Expected output:\n%s\nbut received:\n%s", data, output)
+	}
+}
+
+func TestCsvRecord_RoundTripListMap(t *testing.T) {
+	data := "name,city,age\nalice,Paris,30\nbob,Rome,25\n"
+	format, _ := NewCsvFormat(POSTGRES_CSV, "", "", "")
+	records := readAllRecords(t, createReader(t, data, format, WITH_HEADER, LIST_MAP))
+
+	writerFactory, _ := NewCsvWriterFactory(format, WITH_HEADER, false, "")
+	if output := writeRecords(t, writerFactory, records...); output != data {
+		t.Errorf("Expected output in the column order of the records:\n%s\nbut received:\n%s", data, output)
+	}
+}
@@ -85,7 +85,7 @@ func writeFieldToJsonObject(field *api.Field) (interface{}, error) {
 			jsonObject = append(jsonObject, fieldJsonObject)
 		}
 		return jsonObject, err
-	case fieldtype.MAP:
+	case fieldtype.MAP, fieldtype.LIST_MAP:
 		jsonObject := make(map[string]interface{})
 		fieldValue := field.Value.(map[string]*api.Field)
 		for k, v := range fieldValue {
@@ -56,6 +56,15 @@ func marshalField(prefix string, f *api.Field) map[string]interface{} {
 			sdcRecordMapValue[key] = marshalField(fmt.Sprintf(childPrefix+"/%s", key), childField)
 		}
 		sdcFieldJsonValue = sdcRecordMapValue
+	case fieldtype.LIST_MAP:
+		mapValue := f.Value.(map[string]*api.Field)
+		keys := f.GetListMapKeys()
+		childPrefix := strings.TrimRight(prefix, "/")
+		sdcRecordListMapValue := make([]interface{}, len(keys))
+		for i, key := range keys {
+			sdcRecordListMapValue[i] = marshalField(fmt.Sprintf(childPrefix+"/%s", key), mapValue[key])
+		}
+		sdcFieldJsonValue = sdcRecordListMapValue
 	case fieldtype.BYTE_ARRAY:
 		fallthrough //Will be encoded in base64 during json serialize
 	case fieldtype.BYTE:
@@ -105,6 +114,20 @@ func unmarshalField(sdcRecordFieldJson map[string]interface{}) (*api.Field, erro
 		if err == nil {
 			f = api.CreateMapFieldWithMapOfFields(mapField)
 		}
+	case fieldtype.LIST_MAP:
+		listMapValue := value.([]interface{})
+		keys := make([]string, len(listMapValue))
+		mapField := make(map[string]*api.Field, len(listMapValue))
+		for i, elem := range listMapValue {
+			elemJson := elem.(map[string]interface{})
+			if keys[i], err = getListMapKey(elemJson); err != nil {
+				return nil, err
+			}
+			if mapField[keys[i]], err = unmarshalField(elemJson); err != nil {
+				return nil, err
+			}
+		}
+		f = api.CreateListMapFieldWithMapOfFields(keys, mapField)
 	case fieldtype.BYTE_ARRAY:
 		if stringBytes, ok := value.(string); ok {
 			var buf []byte
@@ -155,6 +178,19 @@ func unmarshalField(sdcRecordFieldJson map[string]interface{}) (*api.Field, erro
 	return f, err
 }
 
+// getListMapKey returns the key of a LIST_MAP entry, which is the last element of its field path
+func getListMapKey(sdcRecordFieldJson map[string]interface{}) (string, error) {
+	fieldPath, _ := sdcRecordFieldJson[SqPath].(string)
+	pathElements, err := common.ParseFieldPath(fieldPath, true)
+	if err != nil {
+		return "", err
+	}
+	if len(pathElements) == 0 || pathElements[len(pathElements)-1].Type != common.MAP {
+		return "", errors.New(fmt.Sprintf("Invalid field path '%s' for LIST_MAP entry", fieldPath))
+	}
+	return pathElements[len(pathElements)-1].Name, nil
+}
+
 type SDCRecord struct {
 	Header *common.HeaderImpl     `json:"header"`
 	Value  map[string]interface{} `json:"value"`
@@ -114,8 +114,11 @@ func checkField(t *testing.T, actual *api.Field, expected *api.Field) {
 	if reflect.TypeOf(actual) != reflect.TypeOf(expected) {
 		t.Fatalf("Type %s does not match %s", reflect.TypeOf(actual), reflect.TypeOf(expected))
 	} else {
+		if actual.Type != expected.Type {
+			t.Fatalf("Field type %s does not match %s", actual.Type, expected.Type)
+		}
 		switch actual.Type {
-		case fieldtype.MAP:
+		case fieldtype.MAP, fieldtype.LIST_MAP:
 			mapField1 := actual.Value.(map[string]*api.Field)
 			mapField2 := expected.Value.(map[string]*api.Field)
 			if len(mapField1) != len(mapField2) {
@@ -128,6 +131,10 @@ func checkField(t *testing.T, actual *api.Field, expected *api.Field) {
 				}
 				checkField(t, v1, v2)
 			}
+			if actual.Type == fieldtype.LIST_MAP &&
+				!reflect.DeepEqual(actual.GetListMapKeys(), expected.GetListMapKeys()) {
+				t.Fatalf("LIST_MAP keys %v do not match %v", actual.GetListMapKeys(), expected.GetListMapKeys())
+			}
 		case fieldtype.LIST:
 			listField1 := actual.Value.([]*api.Field)
 			listField2 := expected.Value.([]*api.Field)
@@ -279,6 +286,14 @@ func TestReadAndWriteRecord(t *testing.T) {
 	record3.GetHeader().SetAttribute("Sample Attribute", "Sample Value3")
 	expectedRecords = append(expectedRecords, record3)
 
+	record4, err := st.CreateRecord("Sample Record Id4", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapField, _ := api.CreateMapField(map[string]interface{}{"b": "2", "a": "1", "c d": "3"})
+	record4.Set(api.CreateListMapFieldWithMapOfFields([]string{"b", "a", "c d"}, mapField.Value.(map[string]*api.Field)))
+	expectedRecords = append(expectedRecords, record4)
+
 	bufferWriter := bytes.NewBuffer([]byte{})
 
 	recordWriterFactory := &SDCRecordWriterFactoryImpl{}
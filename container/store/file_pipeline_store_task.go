@@ -30,6 +30,7 @@ import (
 const (
 	PIPELINE_FILE             = "pipeline.json"
 	PIPELINE_INFO_FILE        = "info.json"
+	PIPELINE_RULES_FILE       = "rules.json"
 	PIPELINES_FOLDER          = "/data/pipelines/"
 	PIPELINES_RUN_INFO_FOLDER = "/data/runInfo/"
 )
@@ -239,6 +240,57 @@ func (store *FilePipelineStoreTask) Delete(pipelineId string) error {
 	return err
 }
 
+func (store *FilePipelineStoreTask) RetrieveRules(pipelineId string) (common.RuleDefinitions, error) {
+	ruleDefinitions := common.RuleDefinitions{
+		SchemaVersion:          common.RULE_DEFINITIONS_SCHEMA_VERSION,
+		Version:                common.RULE_DEFINITIONS_VERSION,
+		MetricsRuleDefinitions: []common.MetricsRuleDefinition{},
+		DataRuleDefinitions:    []common.DataRuleDefinition{},
+		DriftRuleDefinitions:   []interface{}{},
+		EmailIds:               []string{},
+		Configuration:          []common.Config{},
+	}
+	if !store.hasPipeline(pipelineId) {
+		return ruleDefinitions, errors.New("Pipeline '" + pipelineId + " does not exist")
+	}
+
+	file, err := os.Open(store.getPipelineRulesFile(pipelineId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// no rules were saved for the pipeline yet
+			return ruleDefinitions, nil
+		}
+		return ruleDefinitions, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	err = decoder.Decode(&ruleDefinitions)
+	return ruleDefinitions, err
+}
+
+func (store *FilePipelineStoreTask) StoreRules(
+	pipelineId string,
+	ruleDefinitions common.RuleDefinitions,
+) (common.RuleDefinitions, error) {
+	savedRuleDefinitions, err := store.RetrieveRules(pipelineId)
+	if err != nil {
+		return ruleDefinitions, err
+	}
+
+	if savedRuleDefinitions.UUID != "" && savedRuleDefinitions.UUID != ruleDefinitions.UUID {
+		return ruleDefinitions, errors.New("The rules of pipeline '" + pipelineId + "' have been changed.")
+	}
+
+	ruleDefinitions.UUID = uuid.NewV4().String()
+	ruleDefinitionsJson, err := json.MarshalIndent(ruleDefinitions, "", "  ")
+	if err != nil {
+		return ruleDefinitions, err
+	}
+	err = ioutil.WriteFile(store.getPipelineRulesFile(pipelineId), ruleDefinitionsJson, 0644)
+	return ruleDefinitions, err
+}
+
 func (store *FilePipelineStoreTask) hasPipeline(pipelineId string) bool {
 	_, err := os.Stat(store.getPipelineDir(pipelineId))
 	if err == nil {
@@ -258,6 +310,10 @@ func (store *FilePipelineStoreTask) getPipelineInfoFile(pipelineId string) strin
 	return store.getPipelineDir(pipelineId) + PIPELINE_INFO_FILE
 }
 
+func (store *FilePipelineStoreTask) getPipelineRulesFile(pipelineId string) string {
+	return store.getPipelineDir(pipelineId) + PIPELINE_RULES_FILE
+}
+
 func (store *FilePipelineStoreTask) getPipelineDir(pipelineId string) string {
 	return store.runtimeInfo.BaseDir + PIPELINES_FOLDER + pipelineId + "/"
 }
@@ -194,3 +194,64 @@ func TestFilePipelineStoreTask_Delete(t *testing.T) {
 		t.Error("Excepted error from delete API")
 	}
 }
+
+func TestFilePipelineStoreTask_StoreRules(t *testing.T) {
+	pipelineStoreTask := getPipelineStoreTask(t, "TestFilePipelineStoreTask_StoreRules")
+
+	_, err := pipelineStoreTask.Create("testRulesPipeline", "testPipeline", "Sample desc", false)
+	if err != nil {
+		t.Error("Error from Create: ", err)
+		return
+	}
+
+	ruleDefinitions, err := pipelineStoreTask.RetrieveRules("testRulesPipeline")
+	if err != nil {
+		t.Error("Error from RetrieveRules: ", err)
+		return
+	}
+	if len(ruleDefinitions.MetricsRuleDefinitions) != 0 || len(ruleDefinitions.DataRuleDefinitions) != 0 {
+		t.Error("Excepted empty rule definitions for a new pipeline")
+	}
+
+	ruleDefinitions.MetricsRuleDefinitions = append(
+		ruleDefinitions.MetricsRuleDefinitions,
+		common.MetricsRuleDefinition{
+			Id:            "errorRecordsRule",
+			MetricId:      "pipeline.batchErrorRecords.meter",
+			MetricType:    "METER",
+			MetricElement: "METER_COUNT",
+			Condition:     "${value() > 100}",
+			Enabled:       true,
+		},
+	)
+	savedRuleDefinitions, err := pipelineStoreTask.StoreRules("testRulesPipeline", ruleDefinitions)
+	if err != nil {
+		t.Error("Error from StoreRules: ", err)
+		return
+	}
+
+	ruleDefinitions, err = pipelineStoreTask.RetrieveRules("testRulesPipeline")
+	if err != nil {
+		t.Error("Error from RetrieveRules: ", err)
+		return
+	}
+	if len(ruleDefinitions.MetricsRuleDefinitions) != 1 ||
+		ruleDefinitions.MetricsRuleDefinitions[0].Id != "errorRecordsRule" {
+		t.Error("Excepted the stored metric rule to be retrieved")
+	}
+	if ruleDefinitions.UUID != savedRuleDefinitions.UUID {
+		t.Error("Excepted retrieved rules UUID to match the stored one")
+	}
+
+	// storing rules with an outdated UUID should fail
+	ruleDefinitions.UUID = "outdatedUUID"
+	_, err = pipelineStoreTask.StoreRules("testRulesPipeline", ruleDefinitions)
+	if err == nil {
+		t.Error("Excepted error when storing rules with an outdated UUID")
+	}
+
+	_, err = pipelineStoreTask.RetrieveRules("notAValidPipelineId")
+	if err == nil {
+		t.Error("Excepted error for invalid pipelineId")
+	}
+}
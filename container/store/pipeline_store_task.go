@@ -29,4 +29,6 @@ type PipelineStoreTask interface {
 	Save(pipelineId string, pipelineConfiguration common.PipelineConfiguration) (common.PipelineConfiguration, error)
 	LoadPipelineConfig(pipelineId string) (common.PipelineConfiguration, error)
 	Delete(pipelineId string) error
+	RetrieveRules(pipelineId string) (common.RuleDefinitions, error)
+	StoreRules(pipelineId string, ruleDefinitions common.RuleDefinitions) (common.RuleDefinitions, error)
 }
@@ -0,0 +1,259 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	PROMETHEUS_CONTENT_TYPE  = "text/plain; version=0.0.4; charset=utf-8"
+	PROMETHEUS_METRIC_PREFIX = "sdc_edge_"
+	PIPELINE_LABEL           = "pipeline"
+	STAGE_LABEL              = "stage"
+	LANE_LABEL               = "lane"
+	RUNNER_LABEL             = "runner"
+	QUANTILE_LABEL           = "quantile"
+	WINDOW_LABEL             = "window"
+
+	// RUNNER_SEPARATOR separates the stage instance name from the id of the pipe runner in the names of the
+	// metrics kept for every runner, like stage.<instanceName>@<runnerId>.bufferQueueDepth
+	RUNNER_SEPARATOR = "@"
+
+	stageMetricPrefix = "stage."
+	laneSeparator     = ":"
+)
+
+var prometheusQuantiles = []float64{0.5, 0.75, 0.95, 0.98, 0.99, 0.999}
+
+type prometheusSample struct {
+	suffix string
+	labels map[string]string
+	value  float64
+}
+
+type prometheusFamily struct {
+	metricType string
+	samples    []prometheusSample
+}
+
+// PrometheusMetrics translates the metrics of one or more registries to the Prometheus text exposition
+// format. Stage metrics are labeled with the stage instance name and the lane, if any.
+type PrometheusMetrics struct {
+	families map[string]*prometheusFamily
+}
+
+func (p *PrometheusMetrics) AddRegistry(registry metrics.Registry, labels map[string]string) {
+	registry.Each(func(name string, i interface{}) {
+		familyName, metricLabels := toPrometheusName(name, labels)
+		switch metric := i.(type) {
+		case metrics.Counter:
+			p.add(familyName+"_total", "counter", "", metricLabels, float64(metric.Count()))
+		case metrics.Gauge:
+			p.add(familyName, "gauge", "", metricLabels, float64(metric.Value()))
+		case metrics.GaugeFloat64:
+			p.add(familyName, "gauge", "", metricLabels, metric.Value())
+		case metrics.Meter:
+			m := metric.Snapshot()
+			// counters and meters usually share the same name, keep their families apart
+			p.add(familyName+"_meter_total", "counter", "", metricLabels, float64(m.Count()))
+			p.addRates(familyName+"_rate", metricLabels, m.Rate1(), m.Rate5(), m.Rate15(), m.RateMean())
+		case metrics.Histogram:
+			h := metric.Snapshot()
+			p.addSummary(familyName, metricLabels, h.Percentiles(prometheusQuantiles), float64(h.Sum()), h.Count(), 1)
+		case metrics.Timer:
+			t := metric.Snapshot()
+			// timers are reported in seconds as recommended by Prometheus
+			secondsName := familyName + "_seconds"
+			scale := float64(time.Second)
+			p.addSummary(secondsName, metricLabels, t.Percentiles(prometheusQuantiles), float64(t.Sum()), t.Count(), scale)
+		}
+	})
+}
+
+func (p *PrometheusMetrics) Write(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	familyNames := make([]string, 0, len(p.families))
+	for familyName := range p.families {
+		familyNames = append(familyNames, familyName)
+	}
+	sort.Strings(familyNames)
+
+	for _, familyName := range familyNames {
+		family := p.families[familyName]
+		fmt.Fprintf(writer, "# TYPE %s %s\n", familyName, family.metricType)
+		for _, sample := range family.samples {
+			fmt.Fprintf(
+				writer,
+				"%s%s%s %s\n",
+				familyName,
+				sample.suffix,
+				formatPrometheusLabels(sample.labels),
+				formatPrometheusValue(sample.value),
+			)
+		}
+	}
+	return writer.Flush()
+}
+
+func (p *PrometheusMetrics) add(
+	familyName string,
+	metricType string,
+	suffix string,
+	labels map[string]string,
+	value float64,
+) {
+	family, ok := p.families[familyName]
+	if !ok {
+		family = &prometheusFamily{metricType: metricType}
+		p.families[familyName] = family
+	}
+	family.samples = append(family.samples, prometheusSample{suffix: suffix, labels: labels, value: value})
+}
+
+func (p *PrometheusMetrics) addRates(familyName string, labels map[string]string, m1, m5, m15, mean float64) {
+	windows := []string{"1m", "5m", "15m", "mean"}
+	for i, rate := range []float64{m1, m5, m15, mean} {
+		p.add(familyName, "gauge", "", withLabel(labels, WINDOW_LABEL, windows[i]), rate)
+	}
+}
+
+func (p *PrometheusMetrics) addSummary(
+	familyName string,
+	labels map[string]string,
+	percentiles []float64,
+	sum float64,
+	count int64,
+	scale float64,
+) {
+	for i, quantile := range prometheusQuantiles {
+		quantileLabels := withLabel(labels, QUANTILE_LABEL, strconv.FormatFloat(quantile, 'g', -1, 64))
+		p.add(familyName, "summary", "", quantileLabels, percentiles[i]/scale)
+	}
+	p.add(familyName, "summary", "_sum", labels, sum/scale)
+	p.add(familyName, "summary", "_count", labels, float64(count))
+}
+
+// toPrometheusName converts metric names like stage.<instanceName>:<lane>.outputRecords.counter to
+// sdc_edge_stage_output_records along with the stage and lane labels, and names of runner metrics like
+// stage.<instanceName>@<runnerId>.bufferQueueDepth.gauge along with the stage and runner labels.
+func toPrometheusName(name string, labels map[string]string) (string, map[string]string) {
+	metricLabels := make(map[string]string, len(labels)+2)
+	for k, v := range labels {
+		metricLabels[k] = v
+	}
+
+	for _, suffix := range []string{COUNTER_SUFFIX, METER_SUFFIX, HISTOGRAM_M5_SUFFIX, TIMER_SUFFIX, GAUGE_SUFFIX} {
+		if strings.HasSuffix(name, suffix) {
+			name = strings.TrimSuffix(name, suffix)
+			break
+		}
+	}
+
+	if strings.HasPrefix(name, stageMetricPrefix) {
+		stageName := strings.TrimPrefix(name, stageMetricPrefix)
+		if index := strings.Index(stageName, "."); index > 0 {
+			name = "stage" + stageName[index:]
+			stageName = stageName[:index]
+			if runnerIndex := strings.Index(stageName, RUNNER_SEPARATOR); runnerIndex > 0 {
+				metricLabels[RUNNER_LABEL] = stageName[runnerIndex+1:]
+				stageName = stageName[:runnerIndex]
+			}
+			if laneIndex := strings.Index(stageName, laneSeparator); laneIndex > 0 {
+				metricLabels[LANE_LABEL] = stageName[laneIndex+1:]
+				stageName = stageName[:laneIndex]
+			}
+			metricLabels[STAGE_LABEL] = stageName
+		}
+	}
+
+	return PROMETHEUS_METRIC_PREFIX + sanitizePrometheusName(name), metricLabels
+}
+
+// sanitizePrometheusName converts the camel case metric names to snake case and replaces the characters not
+// allowed by Prometheus
+func sanitizePrometheusName(name string) string {
+	runes := []rune(name)
+	sanitized := make([]rune, 0, len(runes)+8)
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+				sanitized = append(sanitized, '_')
+			}
+			sanitized = append(sanitized, unicode.ToLower(r))
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			sanitized = append(sanitized, r)
+		default:
+			sanitized = append(sanitized, '_')
+		}
+	}
+	return string(sanitized)
+}
+
+func withLabel(labels map[string]string, name string, value string) map[string]string {
+	newLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		newLabels[k] = v
+	}
+	newLabels[name] = value
+	return newLabels
+}
+
+func formatPrometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(labels[name])
+		pairs = append(pairs, name+`="`+value+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatPrometheusValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		families: make(map[string]*prometheusFamily),
+	}
+}
@@ -15,7 +15,13 @@
  */
 package util
 
-import "testing"
+import (
+	"bytes"
+	"github.com/rcrowley/go-metrics"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestContains(t *testing.T) {
 	letters := []string{"a", "b", "c", "d"}
@@ -28,3 +34,42 @@ func TestContains(t *testing.T) {
 		t.Error("Expected false, got true")
 	}
 }
+
+func TestPrometheusMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	CreateCounter(registry, "pipeline.batchInputRecords").Inc(10)
+	CreateMeter(registry, "pipeline.batchInputRecords").Mark(10)
+	CreateCounter(registry, "stage.DevRandom_01:lane1.outputRecords").Inc(5)
+	CreateGauge(registry, "stage.Trash_01.bufferQueueDepth").Update(3)
+	CreateGauge(registry, "stage.Trash_01"+RUNNER_SEPARATOR+"1.bufferQueueDepth").Update(2)
+	CreateTimer(registry, "pipeline.batchProcessing").Update(2 * time.Second)
+	CreateHistogram5Min(registry, "pipeline.inputRecordsPerBatch").Update(4)
+
+	prometheusMetrics := NewPrometheusMetrics()
+	prometheusMetrics.AddRegistry(registry, map[string]string{PIPELINE_LABEL: "pipeline1"})
+	var buf bytes.Buffer
+	if err := prometheusMetrics.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+
+	expectedLines := []string{
+		"# TYPE sdc_edge_pipeline_batch_input_records_total counter",
+		`sdc_edge_pipeline_batch_input_records_total{pipeline="pipeline1"} 10`,
+		`sdc_edge_pipeline_batch_input_records_meter_total{pipeline="pipeline1"} 10`,
+		`sdc_edge_stage_output_records_total{lane="lane1",pipeline="pipeline1",stage="DevRandom_01"} 5`,
+		"# TYPE sdc_edge_stage_buffer_queue_depth gauge",
+		`sdc_edge_stage_buffer_queue_depth{pipeline="pipeline1",stage="Trash_01"} 3`,
+		`sdc_edge_stage_buffer_queue_depth{pipeline="pipeline1",runner="1",stage="Trash_01"} 2`,
+		"# TYPE sdc_edge_pipeline_batch_processing_seconds summary",
+		`sdc_edge_pipeline_batch_processing_seconds{pipeline="pipeline1",quantile="0.99"} 2`,
+		`sdc_edge_pipeline_batch_processing_seconds_sum{pipeline="pipeline1"} 2`,
+		`sdc_edge_pipeline_batch_processing_seconds_count{pipeline="pipeline1"} 1`,
+		`sdc_edge_pipeline_input_records_per_batch_count{pipeline="pipeline1"} 1`,
+	}
+	for _, expectedLine := range expectedLines {
+		if !strings.Contains(output, expectedLine+"\n") {
+			t.Errorf("Expected line '%s' in output:\n%s", expectedLine, output)
+		}
+	}
+}
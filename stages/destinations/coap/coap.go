@@ -17,14 +17,18 @@ package coap
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/dustin/go-coap"
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
-	"github.com/streamsets/datacollector-edge/container/recordio"
-	"github.com/streamsets/datacollector-edge/container/recordio/jsonrecord"
+	"github.com/streamsets/datacollector-edge/stages/lib/datagenerator"
 	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
 	"log"
+	"net"
 	"net/url"
+	"time"
 )
 
 const (
@@ -39,21 +43,55 @@ const (
 	POST               = "POST"
 	PUT                = "PUT"
 	DELETE             = "DELETE"
+
+	DEFAULT_DATA_FORMAT    = "JSON"
+	DEFAULT_BLOCK_SIZE     = 1024
+	MIN_BLOCK_SIZE         = 16
+	DEFAULT_ACK_TIMEOUT    = 2000
+	DEFAULT_MAX_RETRANSMIT = 4
+	MAX_PACKET_LENGTH      = 1500
+
+	// Block1 option of the block-wise transfer of request payloads (RFC 7959)
+	BLOCK1 coap.OptionID = 27
+	// Continue response code (2.31) acknowledging a block of the request payload
+	CONTINUE coap.COAPCode = 95
 )
 
 type CoapClientDestination struct {
 	*common.BaseStage
-	Conf                ClientTargetConfig `ConfigDefBean:"conf"`
-	recordWriterFactory recordio.RecordWriterFactory
+	Conf      ClientTargetConfig `ConfigDefBean:"conf"`
+	conn      *net.UDPConn
+	resource  *url.URL
+	messageId uint16
+	token     uint32
 }
 
+// ClientTargetConfig holds the settings of the destination. Records are sent in a message each, or in a single
+// message per batch with SingleRequestPerBatch. Payloads larger than BlockSize are sent block-wise. Confirmable
+// messages are retransmitted MaxRetransmit times, doubling AckTimeoutMillis after every attempt, before their
+// records are sent to error. The records of messages which are reset or answered with a client or server error
+// response code are sent to error as well, only transport errors fail the batch.
 type ClientTargetConfig struct {
-	ResourceUrl string `ConfigDef:"type=STRING,required=true"`
-	CoapMethod  string `ConfigDef:"type=STRING,required=true"`
-	RequestType string `ConfigDef:"type=STRING,required=true"`
+	ResourceUrl               string                                  `ConfigDef:"type=STRING,required=true"`
+	CoapMethod                string                                  `ConfigDef:"type=STRING,required=true"`
+	RequestType               string                                  `ConfigDef:"type=STRING,required=true"`
+	SingleRequestPerBatch     bool                                    `ConfigDef:"type=BOOLEAN,required=false"`
+	BlockSize                 float64                                 `ConfigDef:"type=NUMBER,required=false"`
+	AckTimeoutMillis          float64                                 `ConfigDef:"type=NUMBER,required=false"`
+	MaxRetransmit             float64                                 `ConfigDef:"type=NUMBER,required=false"`
+	DataFormat                string                                  `ConfigDef:"type=STRING,required=false"`
+	DataGeneratorFormatConfig datagenerator.DataGeneratorFormatConfig `ConfigDefBean:"dataGeneratorFormatConfig"`
+}
+
+// messageError is the error of a message which was not acknowledged after all retransmissions, was reset or
+// was answered with an error response code, the records of the message are sent to error
+type messageError struct {
+	message string
 }
 
-var mid uint16
+func (m *messageError) Error() string {
+	return m.message
+}
 
 func init() {
 	stagelibrary.SetCreator(LIBRARY, STAGE_NAME, func() api.Stage {
@@ -62,69 +100,283 @@ func init() {
 }
 
 func (c *CoapClientDestination) Init(stageContext api.StageContext) error {
-	if err := c.BaseStage.Init(stageContext); err != nil {
+	var err error
+	if err = c.BaseStage.Init(stageContext); err != nil {
 		return err
 	}
 	log.Println("[DEBUG] CoapClientDestination Init method")
-	// TODO: Create RecordWriter based on configuration
-	c.recordWriterFactory = &jsonrecord.JsonWriterFactoryImpl{}
-	mid = 0
+
+	if c.Conf.BlockSize == 0 {
+		c.Conf.BlockSize = DEFAULT_BLOCK_SIZE
+	}
+	if getBlockSizeExponent(int(c.Conf.BlockSize)) < 0 {
+		return errors.New(fmt.Sprintf(
+			"Block size must be a power of two between %d and %d: %v",
+			MIN_BLOCK_SIZE,
+			DEFAULT_BLOCK_SIZE,
+			c.Conf.BlockSize,
+		))
+	}
+	if c.Conf.AckTimeoutMillis <= 0 {
+		c.Conf.AckTimeoutMillis = DEFAULT_ACK_TIMEOUT
+	}
+	if c.Conf.MaxRetransmit < 0 {
+		return errors.New(fmt.Sprintf("Max retransmit must not be negative: %v", c.Conf.MaxRetransmit))
+	} else if c.Conf.MaxRetransmit == 0 {
+		c.Conf.MaxRetransmit = DEFAULT_MAX_RETRANSMIT
+	}
+	if c.Conf.DataFormat == "" {
+		c.Conf.DataFormat = DEFAULT_DATA_FORMAT
+	}
+	if err = c.Conf.DataGeneratorFormatConfig.Init(c.Conf.DataFormat); err != nil {
+		return err
+	}
+
+	if c.resource, err = url.Parse(c.Conf.ResourceUrl); err != nil {
+		return err
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", c.resource.Host)
+	if err != nil {
+		return err
+	}
+	if c.conn, err = net.DialUDP("udp", nil, udpAddr); err != nil {
+		log.Printf("[ERROR] Error dialing: %v", err)
+		return err
+	}
+	c.messageId = 0
+	return nil
+}
+
+func (c *CoapClientDestination) Destroy() error {
+	if c.conn != nil {
+		return c.conn.Close()
+	}
 	return nil
 }
 
 func (c *CoapClientDestination) Write(batch api.Batch) error {
 	log.Println("[DEBUG] CoapClientDestination Write method")
-	for _, record := range batch.GetRecords() {
-		err := c.sendRecordToSDC(record)
-		if err != nil {
+	records := batch.GetRecords()
+	if c.Conf.SingleRequestPerBatch {
+		if len(records) == 0 {
+			return nil
+		}
+		return c.sendRecordsToSDC(records)
+	}
+	for _, record := range records {
+		if err := c.sendRecordsToSDC([]api.Record{record}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *CoapClientDestination) sendRecordToSDC(record api.Record) error {
+// sendRecordsToSDC sends the records in a message. Records which can't be written and the records of messages
+// failing with a messageError are sent to error.
+func (c *CoapClientDestination) sendRecordsToSDC(records []api.Record) error {
 	payloadBuffer := bytes.NewBuffer([]byte{})
-	recordWriter, err := c.recordWriterFactory.CreateWriter(c.GetStageContext(), payloadBuffer)
+	recordWriter, err := c.Conf.DataGeneratorFormatConfig.RecordWriterFactory.CreateWriter(
+		c.GetStageContext(),
+		payloadBuffer,
+	)
 	if err != nil {
 		return err
 	}
-	err = recordWriter.WriteRecord(record)
+	writtenRecords := make([]api.Record, 0, len(records))
+	for _, record := range records {
+		if err = recordWriter.WriteRecord(record); err != nil {
+			log.Printf("[ERROR] Error writing record: %s", err.Error())
+			c.GetStageContext().ToError(err, record)
+			continue
+		}
+		writtenRecords = append(writtenRecords, record)
+	}
+	if err = recordWriter.Flush(); err == nil {
+		err = recordWriter.Close()
+	}
+	if err == nil && len(writtenRecords) > 0 {
+		err = c.send(payloadBuffer.Bytes())
+		if _, ok := err.(*messageError); !ok {
+			return err
+		}
+	}
 	if err != nil {
-		return err
+		log.Printf("[ERROR] %s", err.Error())
+		for _, record := range writtenRecords {
+			c.GetStageContext().ToError(err, record)
+		}
 	}
-	recordWriter.Flush()
-	recordWriter.Close()
+	return nil
+}
 
-	parsedURL, err := url.Parse(c.Conf.ResourceUrl)
-	if err != nil {
-		return err
+// send sends the payload in a message, or block-wise when it is larger than the block size
+func (c *CoapClientDestination) send(payload []byte) error {
+	c.token++
+	blockSize := int(c.Conf.BlockSize)
+	if len(payload) <= blockSize {
+		response, err := c.exchange(c.newMessage(payload))
+		if err != nil {
+			return err
+		}
+		return c.checkResponse(response)
 	}
 
-	req := coap.Message{
+	blockSizeExponent := uint32(getBlockSizeExponent(blockSize))
+	for blockNumber := 0; blockNumber*blockSize < len(payload); blockNumber++ {
+		start := blockNumber * blockSize
+		end := start + blockSize
+		more := uint32(1)
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+
+		message := c.newMessage(payload[start:end])
+		message.SetOption(BLOCK1, uint32(blockNumber)<<4|more<<3|blockSizeExponent)
+		if blockNumber == 0 {
+			message.SetOption(coap.Size1, uint32(len(payload)))
+		}
+		response, err := c.exchange(message)
+		if err != nil {
+			return err
+		}
+		if err = c.checkResponse(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CoapClientDestination) newMessage(payload []byte) coap.Message {
+	c.messageId++
+	token := make([]byte, 4)
+	binary.BigEndian.PutUint32(token, c.token)
+	message := coap.Message{
 		Type:      getCoapType(c.Conf.RequestType),
 		Code:      getCoapMethod(c.Conf.CoapMethod),
-		MessageID: mid,
-		Payload:   payloadBuffer.Bytes(),
+		MessageID: c.messageId,
+		Token:     token,
+		Payload:   payload,
+	}
+	if len(c.resource.Path) > 0 && c.resource.Path != "/" {
+		message.SetPathString(c.resource.Path)
 	}
-	req.SetPathString(parsedURL.Path)
+	return message
+}
 
-	coapClient, err := coap.Dial("udp", parsedURL.Host)
-	if err != nil {
-		log.Printf("[ERROR] Error dialing: %v", err)
-		return err
+// exchange sends the message and returns its response, confirmable messages are retransmitted with an
+// exponential backoff until they are acknowledged. Non-confirmable messages are not answered.
+func (c *CoapClientDestination) exchange(message coap.Message) (*coap.Message, error) {
+	if !message.IsConfirmable() {
+		return nil, coap.Transmit(c.conn, nil, message)
 	}
 
-	_, err = coapClient.Send(req)
-	if err != nil {
-		log.Printf("[ERROR] Error sending request: %v", err)
-		return err
+	timeout := time.Duration(c.Conf.AckTimeoutMillis) * time.Millisecond
+	for retransmissions := 0; ; retransmissions++ {
+		if err := coap.Transmit(c.conn, nil, message); err != nil {
+			return nil, err
+		}
+		response, err := c.receive(message, timeout)
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			return response, err
+		}
+		if retransmissions >= int(c.Conf.MaxRetransmit) {
+			return nil, &messageError{message: fmt.Sprintf(
+				"CoAP message %d to '%s' was not acknowledged after %d retransmissions",
+				message.MessageID,
+				c.Conf.ResourceUrl,
+				retransmissions,
+			)}
+		}
+		log.Printf("[WARN] CoAP message %d was not acknowledged in %s, retransmitting", message.MessageID, timeout)
+		timeout *= 2
 	}
+}
 
-	mid++
+// receive returns the response of the confirmable message. An empty acknowledgement is followed by a separate
+// response carrying the token of the message, which is acknowledged in turn when confirmable.
+func (c *CoapClientDestination) receive(message coap.Message, timeout time.Duration) (*coap.Message, error) {
+	buffer := make([]byte, MAX_PACKET_LENGTH)
+	acknowledged := false
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		n, err := c.conn.Read(buffer)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && acknowledged {
+				return nil, &messageError{message: fmt.Sprintf(
+					"No response received for CoAP message %d to '%s'",
+					message.MessageID,
+					c.Conf.ResourceUrl,
+				)}
+			}
+			return nil, err
+		}
+		response, err := coap.ParseMessage(buffer[:n])
+		if err != nil {
+			log.Printf("[WARN] Ignoring invalid CoAP message: %s", err.Error())
+			continue
+		}
+
+		switch {
+		case response.MessageID == message.MessageID &&
+			(response.Type == coap.Acknowledgement || response.Type == coap.Reset):
+			if response.Type == coap.Acknowledgement && response.Code == 0 {
+				// the response is sent separately, waiting for it as long as for all retransmissions
+				acknowledged = true
+				maxTransmitWait := getMaxTransmitWait(c.Conf.AckTimeoutMillis, c.Conf.MaxRetransmit)
+				c.conn.SetReadDeadline(time.Now().Add(maxTransmitWait))
+				continue
+			}
+			return &response, nil
+		case acknowledged && bytes.Equal(response.Token, message.Token):
+			if response.IsConfirmable() {
+				coap.Transmit(c.conn, nil, coap.Message{Type: coap.Acknowledgement, MessageID: response.MessageID})
+			}
+			return &response, nil
+		}
+	}
+}
+
+// checkResponse returns a messageError for reset messages and client or server error responses
+func (c *CoapClientDestination) checkResponse(response *coap.Message) error {
+	if response == nil {
+		return nil
+	}
+	if response.Type == coap.Reset {
+		return &messageError{message: fmt.Sprintf(
+			"CoAP message %d to '%s' was reset",
+			response.MessageID,
+			c.Conf.ResourceUrl,
+		)}
+	}
+	if codeClass := response.Code >> 5; codeClass == 4 || codeClass == 5 {
+		return &messageError{message: fmt.Sprintf(
+			"CoAP request to '%s' failed with response code %d.%02d %s: %s",
+			c.Conf.ResourceUrl,
+			codeClass,
+			response.Code&0x1f,
+			response.Code,
+			string(response.Payload),
+		)}
+	}
 	return nil
 }
 
+// getBlockSizeExponent returns the SZX value of the block size, or -1 when the block size is not supported
+func getBlockSizeExponent(blockSize int) int {
+	for exponent := 0; exponent <= 6; exponent++ {
+		if MIN_BLOCK_SIZE<<uint(exponent) == blockSize {
+			return exponent
+		}
+	}
+	return -1
+}
+
+// getMaxTransmitWait returns the time spent sending a confirmable message with all its retransmissions
+func getMaxTransmitWait(ackTimeoutMillis float64, maxRetransmit float64) time.Duration {
+	return time.Duration(ackTimeoutMillis) * time.Millisecond * time.Duration((1<<(uint(maxRetransmit)+1))-1)
+}
+
 func getCoapType(requestType string) coap.COAPType {
 	switch requestType {
 	case CONFIRMABLE:
@@ -16,10 +16,16 @@
 package coap
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/dustin/go-coap"
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/creation"
 	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -96,3 +102,292 @@ func TestNonConfirmableMessage(t *testing.T) {
 	}
 	stageInstance.Destroy()
 }
+
+// coapServer answers the messages it receives with the response of its handler
+type coapServer struct {
+	conn     *net.UDPConn
+	handler  func(message coap.Message, block1 uint32) *coap.Message
+	messages []coap.Message
+	blocks   []uint32
+	mutex    sync.Mutex
+}
+
+func newCoapServer(t *testing.T, handler func(message coap.Message, block1 uint32) *coap.Message) *coapServer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &coapServer{conn: conn, handler: handler}
+	go func() {
+		buffer := make([]byte, MAX_PACKET_LENGTH)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			data := append([]byte{}, buffer[:n]...)
+			message, err := coap.ParseMessage(data)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			block1 := getBlock1(data)
+			server.mutex.Lock()
+			server.messages = append(server.messages, message)
+			server.blocks = append(server.blocks, block1)
+			server.mutex.Unlock()
+			if response := handler(message, block1); response != nil {
+				coap.Transmit(conn, addr, *response)
+			}
+		}
+	}()
+	return server
+}
+
+func (s *coapServer) getMessages() ([]coap.Message, []uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.messages, s.blocks
+}
+
+func (s *coapServer) getResourceUrl() string {
+	return "coap://" + s.conn.LocalAddr().String() + "/sdc"
+}
+
+// getBlock1 returns the value of the Block1 option of the message, which go-coap does not parse
+func getBlock1(data []byte) uint32 {
+	position := 4 + int(data[0]&0x0f)
+	optionId := 0
+	for position < len(data) && data[position] != 0xff {
+		delta := int(data[position] >> 4)
+		length := int(data[position] & 0x0f)
+		position++
+		if delta == 13 {
+			delta = int(data[position]) + 13
+			position++
+		}
+		if length == 13 {
+			length = int(data[position]) + 13
+			position++
+		}
+		optionId += delta
+		if optionId == int(BLOCK1) {
+			value := uint32(0)
+			for _, b := range data[position : position+length] {
+				value = value<<8 | uint32(b)
+			}
+			return value
+		}
+		position += length
+	}
+	return 0
+}
+
+func acknowledge(message coap.Message, code coap.COAPCode) *coap.Message {
+	return &coap.Message{Type: coap.Acknowledgement, Code: code, MessageID: message.MessageID, Token: message.Token}
+}
+
+func createDestination(t *testing.T, resourceUrl string, configs ...common.Config) (*CoapClientDestination, *common.StageContextImpl) {
+	stageContext := getStageContext(resourceUrl, POST, CONFIRMABLE)
+	stageContext.StageConfig.Configuration = append(stageContext.StageConfig.Configuration, configs...)
+	stageContext.ErrorSink = common.NewErrorSink()
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stageBean.Stage.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	return stageBean.Stage.(*CoapClientDestination), stageContext
+}
+
+func createBatch(stageContext *common.StageContextImpl, values ...string) api.Batch {
+	records := make([]api.Record, len(values))
+	for i, value := range values {
+		records[i], _ = stageContext.CreateRecord("", map[string]interface{}{"value": value})
+	}
+	return runner.NewBatchImpl("random", records, "randomOffset")
+}
+
+func TestCoapClientDestination_SingleRequestPerBatch(t *testing.T) {
+	server := newCoapServer(t, func(message coap.Message, block1 uint32) *coap.Message {
+		return acknowledge(message, coap.Changed)
+	})
+	defer server.conn.Close()
+
+	destination, stageContext := createDestination(t, server.getResourceUrl(), common.Config{
+		Name:  "conf.singleRequestPerBatch",
+		Value: true,
+	})
+	defer destination.Destroy()
+	if err := destination.Write(createBatch(stageContext, "a", "b", "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, _ := server.getMessages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(messages))
+	}
+	if messages[0].PathString() != "sdc" || messages[0].Code != coap.POST {
+		t.Errorf("Unexpected message: %v", messages[0])
+	}
+	if strings.Count(string(messages[0].Payload), "value") != 3 {
+		t.Errorf("Expected the 3 records in the payload: %s", messages[0].Payload)
+	}
+}
+
+func TestCoapClientDestination_BlockWiseTransfer(t *testing.T) {
+	server := newCoapServer(t, func(message coap.Message, block1 uint32) *coap.Message {
+		if block1&0x08 != 0 {
+			return acknowledge(message, CONTINUE)
+		}
+		return acknowledge(message, coap.Changed)
+	})
+	defer server.conn.Close()
+
+	destination, stageContext := createDestination(t, server.getResourceUrl(), common.Config{
+		Name:  "conf.blockSize",
+		Value: float64(32),
+	})
+	defer destination.Destroy()
+	value := strings.Repeat("0123456789", 10)
+	if err := destination.Write(createBatch(stageContext, value)); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, blocks := server.getMessages()
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 blocks, got %d", len(messages))
+	}
+	payload := bytes.NewBuffer([]byte{})
+	for i, block := range blocks {
+		if int(block>>4) != i {
+			t.Errorf("Expected block number %d, got %d", i, block>>4)
+		}
+		if block&0x07 != 1 {
+			t.Errorf("Expected block size exponent 1, got %d", block&0x07)
+		}
+		if more := block&0x08 != 0; more != (i < 3) {
+			t.Errorf("Unexpected more flag for block %d", i)
+		}
+		if !bytes.Equal(messages[i].Token, messages[0].Token) {
+			t.Error("Expected the same token for all blocks")
+		}
+		payload.Write(messages[i].Payload)
+	}
+	if messages[0].Option(coap.Size1) != uint32(payload.Len()) {
+		t.Errorf("Expected payload size %d, got %v", payload.Len(), messages[0].Option(coap.Size1))
+	}
+	if !strings.Contains(payload.String(), value) {
+		t.Errorf("Unexpected payload: %s", payload.String())
+	}
+}
+
+func TestCoapClientDestination_Retransmission(t *testing.T) {
+	attempts := 0
+	server := newCoapServer(t, func(message coap.Message, block1 uint32) *coap.Message {
+		// only the third transmission of the first message is acknowledged
+		if attempts++; attempts != 3 {
+			return nil
+		}
+		return acknowledge(message, coap.Changed)
+	})
+	defer server.conn.Close()
+
+	destination, stageContext := createDestination(
+		t,
+		server.getResourceUrl(),
+		common.Config{Name: "conf.ackTimeoutMillis", Value: float64(20)},
+		common.Config{Name: "conf.maxRetransmit", Value: float64(2)},
+	)
+	defer destination.Destroy()
+
+	if err := destination.Write(createBatch(stageContext, "a")); err != nil {
+		t.Fatal(err)
+	}
+	messages, _ := server.getMessages()
+	if len(messages) != 3 || messages[2].MessageID != messages[0].MessageID {
+		t.Errorf("Expected the message to be sent 3 times, got %d messages", len(messages))
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 0 {
+		t.Error("Expected no error records")
+	}
+
+	// records of messages not acknowledged after all retransmissions are sent to error
+	if err := destination.Write(createBatch(stageContext, "b", "c")); err != nil {
+		t.Fatal(err)
+	}
+	messages, _ = server.getMessages()
+	if len(messages) != 3+2*3 {
+		t.Errorf("Expected 3 transmissions of each message, got %d messages", len(messages)-3)
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 2 {
+		t.Errorf("Expected 2 error records, got %d", stageContext.ErrorSink.GetTotalErrorRecords())
+	}
+}
+
+func TestCoapClientDestination_ErrorResponse(t *testing.T) {
+	for _, code := range []coap.COAPCode{coap.NotFound, coap.InternalServerError} {
+		server := newCoapServer(t, func(message coap.Message, block1 uint32) *coap.Message {
+			return acknowledge(message, code)
+		})
+		destination, stageContext := createDestination(t, server.getResourceUrl())
+		if err := destination.Write(createBatch(stageContext, "a", "b")); err != nil {
+			t.Errorf("Expected the records to be sent to error for response code %s, but got %s", code, err)
+		}
+		errorRecords := stageContext.ErrorSink.GetStageErrorRecords("")
+		if len(errorRecords) != 2 {
+			t.Errorf("Expected 2 error records for response code %s, got %d", code, len(errorRecords))
+		} else if errorMessage := errorRecords[0].GetHeader().GetErrorMessage(); !strings.Contains(
+			errorMessage,
+			fmt.Sprintf("%d.%02d", code>>5, code&0x1f),
+		) {
+			t.Errorf("Expected the response code in the error message, but got '%s'", errorMessage)
+		}
+		destination.Destroy()
+		server.conn.Close()
+	}
+
+	// transport errors fail the batch
+	destination, stageContext := createDestination(t, "coap://127.0.0.1:1/sdc")
+	defer destination.Destroy()
+	destination.conn.Close()
+	if err := destination.Write(createBatch(stageContext, "a")); err == nil {
+		t.Error("Expected an error when the message can't be sent")
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 0 {
+		t.Error("Expected no error records for a transport error")
+	}
+}
+
+func TestCoapClientDestination_WriteRecordError(t *testing.T) {
+	server := newCoapServer(t, func(message coap.Message, block1 uint32) *coap.Message {
+		return acknowledge(message, coap.Changed)
+	})
+	defer server.conn.Close()
+
+	destination, stageContext := createDestination(
+		t,
+		server.getResourceUrl(),
+		common.Config{Name: "conf.dataFormat", Value: "DELIMITED"},
+		common.Config{Name: "conf.singleRequestPerBatch", Value: true},
+	)
+	defer destination.Destroy()
+
+	batch := createBatch(stageContext, "a", "b")
+	nestedRecord, _ := stageContext.CreateRecord("", map[string]interface{}{
+		"value": map[string]interface{}{"nested": "c"},
+	})
+	records := append(batch.GetRecords(), nestedRecord)
+	if err := destination.Write(runner.NewBatchImpl("random", records, "randomOffset")); err != nil {
+		t.Fatal(err)
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 1 {
+		t.Errorf("Expected the record which can't be written to be sent to error, got %d error records",
+			stageContext.ErrorSink.GetTotalErrorRecords())
+	}
+	messages, _ := server.getMessages()
+	if len(messages) != 1 || strings.Count(string(messages[0].Payload), "\n") != 2 {
+		t.Errorf("Expected a message with the 2 other records, got %v", messages)
+	}
+}
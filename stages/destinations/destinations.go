@@ -18,6 +18,8 @@ package destinations
 import (
 	_ "github.com/streamsets/datacollector-edge/stages/destinations/coap"
 	_ "github.com/streamsets/datacollector-edge/stages/destinations/http"
+	_ "github.com/streamsets/datacollector-edge/stages/destinations/kafka"
+	_ "github.com/streamsets/datacollector-edge/stages/destinations/localfs"
 	_ "github.com/streamsets/datacollector-edge/stages/destinations/mqtt"
 	_ "github.com/streamsets/datacollector-edge/stages/destinations/trash"
 	_ "github.com/streamsets/datacollector-edge/stages/destinations/websocket"
@@ -18,45 +18,71 @@ package http
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"errors"
+	"fmt"
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/el"
 	"github.com/streamsets/datacollector-edge/stages/lib/datagenerator"
+	"github.com/streamsets/datacollector-edge/stages/lib/httpcommon"
+	"github.com/streamsets/datacollector-edge/stages/lib/wholefile"
 	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 )
 
 const (
 	LIBRARY    = "streamsets-datacollector-basic-lib"
 	STAGE_NAME = "com_streamsets_pipeline_stage_destination_http_HttpClientDTarget"
+
+	JSON_CONTENT_TYPE      = "application/json;charset=UTF-8"
+	TEXT_CONTENT_TYPE      = "text/plain;charset=UTF-8"
+	DELIMITED_CONTENT_TYPE = "text/csv;charset=UTF-8"
+	XML_CONTENT_TYPE       = "application/xml;charset=UTF-8"
+	BINARY_CONTENT_TYPE    = "application/octet-stream"
+	RESOURCE_URL_FIELD     = "resourceUrl"
+
+	EXPRESSION        = "EXPRESSION"
+	RESOURCE_URL      = "resourceUrl"
+	HEADERS           = "headers"
+	METHOD_EXPRESSION = "methodExpression"
 )
 
 type HttpClientDestination struct {
 	*common.BaseStage
-	Conf HttpClientTargetConfig `ConfigDefBean:"conf"`
+	Conf   HttpClientTargetConfig `ConfigDefBean:"conf"`
+	client *httpcommon.Client
 }
 
+// HttpClientTargetConfig holds the settings of the destination. ResourceUrl, the header values and
+// MethodExpression, used when HttpMethod is EXPRESSION, are evaluated for every record. With a single request
+// per batch, the records are grouped by the evaluated method, URL and headers.
+//
+// Failed responses are handled by the action configured for their status code in ResponseStatusActionConfigs,
+// by default 429 and 5xx responses are retried and the records of the other ones are sent to error.
 type HttpClientTargetConfig struct {
-	ResourceUrl               string                                  `ConfigDef:"type=STRING,required=true"`
-	Headers                   map[string]string                       `ConfigDef:"type=MAP,required=true"`
-	SingleRequestPerBatch     bool                                    `ConfigDef:"type=BOOLEAN,required=true"`
-	Client                    ClientConfigBean                        `ConfigDefBean:"client"`
-	DataFormat                string                                  `ConfigDef:"type=STRING,required=true"`
-	DataGeneratorFormatConfig datagenerator.DataGeneratorFormatConfig `ConfigDefBean:"dataGeneratorFormatConfig"`
-}
-
-type ClientConfigBean struct {
-	HttpCompression string        `ConfigDef:"type=STRING,required=true"`
-	TlsConfig       TlsConfigBean `ConfigDefBean:"tlsConfig"`
+	ResourceUrl                 string                                    `ConfigDef:"type=STRING,required=true,evaluation=EXPLICIT"`
+	Headers                     map[string]string                         `ConfigDef:"type=MAP,required=true,evaluation=EXPLICIT"`
+	HttpMethod                  string                                    `ConfigDef:"type=STRING,required=false"`
+	MethodExpression            string                                    `ConfigDef:"type=STRING,required=false,evaluation=EXPLICIT"`
+	SingleRequestPerBatch       bool                                      `ConfigDef:"type=BOOLEAN,required=true"`
+	Client                      httpcommon.ClientConfigBean               `ConfigDefBean:"client"`
+	ResponseStatusActionConfigs []httpcommon.HttpResponseActionConfigBean `ConfigDef:"type=MODEL" ListBeanModel:"name=responseStatusActionConfigs"`
+	DataFormat                  string                                    `ConfigDef:"type=STRING,required=true"`
+	DataGeneratorFormatConfig   datagenerator.DataGeneratorFormatConfig   `ConfigDefBean:"dataGeneratorFormatConfig"`
 }
 
-type TlsConfigBean struct {
-	TlsEnabled         bool   `ConfigDef:"type=BOOLEAN,required=true"`
-	TrustStoreFilePath string `ConfigDef:"type=STRING,required=true"`
+// request is the HTTP request sending a group of records
+type request struct {
+	method  string
+	url     string
+	headers map[string]string
+	records []api.Record
 }
 
 func init() {
@@ -71,12 +97,33 @@ func (h *HttpClientDestination) Init(stageContext api.StageContext) error {
 		return err
 	}
 	log.Println("[DEBUG] HttpClientDestination Init method")
+
+	switch h.Conf.HttpMethod {
+	case "":
+		h.Conf.HttpMethod = http.MethodPost
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodGet, http.MethodHead:
+	case EXPRESSION:
+		if len(h.Conf.MethodExpression) == 0 {
+			return errors.New("HTTP method expression must not be empty")
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unsupported HTTP method: %s", h.Conf.HttpMethod))
+	}
+
+	if err = httpcommon.ValidateResponseActions(h.Conf.ResponseStatusActionConfigs); err != nil {
+		return err
+	}
+	if h.client, err = h.Conf.Client.NewClient(); err != nil {
+		return err
+	}
 	return h.Conf.DataGeneratorFormatConfig.Init(h.Conf.DataFormat)
 }
 
 func (h *HttpClientDestination) Write(batch api.Batch) error {
 	log.Println("[DEBUG] HttpClientDestination write method")
-	if h.Conf.SingleRequestPerBatch && len(batch.GetRecords()) > 0 {
+	if h.Conf.DataFormat == wholefile.WHOLE_FILE {
+		return h.writeWholeFiles(batch)
+	} else if h.Conf.SingleRequestPerBatch && len(batch.GetRecords()) > 0 {
 		return h.writeSingleRequestPerBatch(batch)
 	} else {
 		return h.writeSingleRequestPerRecord(batch)
@@ -84,104 +131,254 @@ func (h *HttpClientDestination) Write(batch api.Batch) error {
 }
 
 func (h *HttpClientDestination) writeSingleRequestPerBatch(batch api.Batch) error {
-	var err error
-	recordWriterFactory := h.Conf.DataGeneratorFormatConfig.RecordWriterFactory
-	batchBuffer := bytes.NewBuffer([]byte{})
-	recordWriter, err := recordWriterFactory.CreateWriter(h.GetStageContext(), batchBuffer)
-	if err != nil {
-		return err
-	}
+	requests := make([]*request, 0)
+	requestsByKey := make(map[string]*request)
 	for _, record := range batch.GetRecords() {
-		err = recordWriter.WriteRecord(record)
+		req, err := h.newRequest(record)
 		if err != nil {
+			h.GetStageContext().ToError(err, record)
+			continue
+		}
+		key := req.getKey()
+		if existingRequest, ok := requestsByKey[key]; ok {
+			existingRequest.records = append(existingRequest.records, record)
+		} else {
+			requestsByKey[key] = req
+			requests = append(requests, req)
+		}
+	}
+
+	for _, req := range requests {
+		if err := h.writeRecords(req); err != nil {
 			return err
 		}
 	}
-	recordWriter.Flush()
-	recordWriter.Close()
-	return h.sendToSDC(batchBuffer.Bytes())
+	return nil
 }
 
 func (h *HttpClientDestination) writeSingleRequestPerRecord(batch api.Batch) error {
-	var err error
-	recordWriterFactory := h.Conf.DataGeneratorFormatConfig.RecordWriterFactory
 	for _, record := range batch.GetRecords() {
-		recordBuffer := bytes.NewBuffer([]byte{})
-		recordWriter, err := recordWriterFactory.CreateWriter(h.GetStageContext(), recordBuffer)
+		req, err := h.newRequest(record)
 		if err != nil {
-			return err
+			h.GetStageContext().ToError(err, record)
+			continue
 		}
-		err = recordWriter.WriteRecord(record)
-		if err != nil {
+		if err = h.writeRecords(req); err != nil {
 			return err
 		}
-		recordWriter.Flush()
-		recordWriter.Close()
-		err = h.sendToSDC(recordBuffer.Bytes())
-		if err != nil {
+	}
+	return nil
+}
+
+// writeRecords sends the records of the request in a single request body
+func (h *HttpClientDestination) writeRecords(req *request) error {
+	buffer := bytes.NewBuffer([]byte{})
+	recordWriter, err := h.Conf.DataGeneratorFormatConfig.RecordWriterFactory.CreateWriter(
+		h.GetStageContext(),
+		buffer,
+	)
+	if err != nil {
+		return err
+	}
+	for _, record := range req.records {
+		if err = recordWriter.WriteRecord(record); err != nil {
 			return err
 		}
 	}
-	return err
+	if err = recordWriter.Flush(); err != nil {
+		return err
+	}
+	if err = recordWriter.Close(); err != nil {
+		return err
+	}
+
+	return h.handleSendError(req, h.send(req, h.getContentType(), func() (io.Reader, error) {
+		return bytes.NewReader(buffer.Bytes()), nil
+	}))
 }
 
-func (h *HttpClientDestination) sendToSDC(jsonValue []byte) error {
-	var buf bytes.Buffer
+// getContentType returns the content type of the request bodies written with the data format
+func (h *HttpClientDestination) getContentType() string {
+	switch h.Conf.DataFormat {
+	case "JSON", "SDC_JSON":
+		return JSON_CONTENT_TYPE
+	case "TEXT":
+		return TEXT_CONTENT_TYPE
+	case "DELIMITED":
+		return DELIMITED_CONTENT_TYPE
+	case "XML":
+		return XML_CONTENT_TYPE
+	}
+	return BINARY_CONTENT_TYPE
+}
 
-	if h.Conf.Client.HttpCompression == "GZIP" {
-		gz := gzip.NewWriter(&buf)
-		if _, err := gz.Write(jsonValue); err != nil {
+// writeWholeFiles sends a request per whole file record, the file is streamed as the request body
+func (h *HttpClientDestination) writeWholeFiles(batch api.Batch) error {
+	for _, record := range batch.GetRecords() {
+		if err := h.writeWholeFile(record); err != nil {
 			return err
 		}
-		gz.Close()
-	} else {
-		buf = *bytes.NewBuffer(jsonValue)
+	}
+	return nil
+}
+
+func (h *HttpClientDestination) writeWholeFile(record api.Record) error {
+	req, err := h.newRequest(record)
+	if err != nil {
+		h.GetStageContext().ToError(err, record)
+		return nil
+	}
+	fileRef, err := wholefile.GetFileRef(record)
+	if err != nil {
+		h.GetStageContext().ToError(err, record)
+		return nil
 	}
 
-	req, err := http.NewRequest("POST", h.Conf.ResourceUrl, &buf)
-	if h.Conf.Headers != nil {
-		for key, value := range h.Conf.Headers {
-			req.Header.Set(key, value)
+	var checksumReader *wholefile.ChecksumReader
+	var reader io.ReadCloser
+	var readErr error
+	defer func() {
+		if reader != nil {
+			reader.Close()
 		}
-	}
+	}()
 
-	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
-	if h.Conf.Client.HttpCompression == "GZIP" {
-		req.Header.Set("Content-Encoding", "gzip")
+	// every attempt streams the file again
+	err = h.send(req, BINARY_CONTENT_TYPE, func() (io.Reader, error) {
+		if reader != nil {
+			reader.Close()
+		}
+		if reader, readErr = fileRef.GetReader(); readErr != nil {
+			return nil, readErr
+		}
+		if !h.Conf.DataGeneratorFormatConfig.IncludeChecksumInTheEvents {
+			return reader, nil
+		}
+		var err error
+		checksumReader, err = wholefile.NewChecksumReader(reader, h.Conf.DataGeneratorFormatConfig.ChecksumAlgorithm)
+		return checksumReader, err
+	})
+	if readErr != nil {
+		h.GetStageContext().ToError(readErr, record)
+		return nil
+	} else if err != nil {
+		return h.handleSendError(req, err)
 	}
 
-	var client *http.Client
+	err = wholefile.ToWholeFileProcessedEvent(
+		h.GetStageContext(),
+		record,
+		map[string]interface{}{RESOURCE_URL_FIELD: req.url},
+		checksumReader,
+	)
+	if err != nil {
+		log.Printf("[ERROR] Error creating whole file processed event: %s", err)
+	}
+	return nil
+}
 
-	if h.Conf.Client.TlsConfig.TlsEnabled {
-		caCert, err := ioutil.ReadFile(h.Conf.Client.TlsConfig.TrustStoreFilePath)
-		if err != nil {
-			return err
+// handleSendError sends the records of the request to error when the request failed because of the response,
+// unless the action of the response status code is to fail the stage. Other errors fail the batch.
+func (h *HttpClientDestination) handleSendError(req *request, err error) error {
+	if respErr, ok := err.(*httpcommon.ResponseError); ok && respErr.Action != httpcommon.STAGE_ERROR {
+		log.Printf("[ERROR] %s", respErr.Error())
+		for _, record := range req.records {
+			h.GetStageContext().ToError(respErr, record)
 		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
+		return nil
+	}
+	return err
+}
+
+// newRequest returns the request of the record, with its method, URL and headers evaluated for the record
+func (h *HttpClientDestination) newRequest(record api.Record) (*request, error) {
+	recordContext := context.WithValue(context.Background(), el.RECORD_CONTEXT_VAR, record)
+	evaluate := func(template string, configName string) (string, error) {
+		return el.EvaluateTemplate(template, func(expression string) (interface{}, error) {
+			return h.GetStageContext().Evaluate(expression, configName, recordContext)
+		})
+	}
 
-		client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					RootCAs:            caCertPool,
-					InsecureSkipVerify: true,
-				},
-			},
+	var err error
+	req := &request{
+		method:  h.Conf.HttpMethod,
+		headers: make(map[string]string, len(h.Conf.Headers)),
+		records: []api.Record{record},
+	}
+	if req.method == EXPRESSION {
+		if req.method, err = evaluate(h.Conf.MethodExpression, METHOD_EXPRESSION); err != nil {
+			return nil, err
 		}
-	} else {
-		client = &http.Client{}
+		req.method = strings.ToUpper(strings.TrimSpace(req.method))
+	}
+	if req.url, err = evaluate(h.Conf.ResourceUrl, RESOURCE_URL); err != nil {
+		return nil, err
+	}
+	for key, value := range h.Conf.Headers {
+		if req.headers[key], err = evaluate(value, HEADERS); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// getKey returns the key grouping the records sent with the same method, URL and headers
+func (r *request) getKey() string {
+	keys := make([]string, 0, len(r.headers))
+	for key := range r.headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{r.method, r.url}
+	for _, key := range keys {
+		parts = append(parts, key+":"+r.headers[key])
 	}
+	return strings.Join(parts, "\n")
+}
 
-	resp, err := client.Do(req)
+// send sends the request, the body is created again for every attempt
+func (h *HttpClientDestination) send(req *request, contentType string, newBody func() (io.Reader, error)) error {
+	resp, err := h.client.DoWithRetries(func() (*http.Request, error) {
+		content, err := newBody()
+		if err != nil {
+			return nil, err
+		}
+		return h.newHttpRequest(req, contentType, content)
+	}, h.Conf.ResponseStatusActionConfigs, h.GetStageContext().GetStopChannel())
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return resp.Body.Close()
+}
 
-	log.Println("[DEBUG] response Status:", resp.Status)
-	if resp.StatusCode != 200 {
-		return errors.New(resp.Status)
+// newHttpRequest returns the HTTP request sending the content, compressed content is streamed through a pipe
+// closed by the client once the request is sent
+func (h *HttpClientDestination) newHttpRequest(req *request, contentType string, content io.Reader) (*http.Request, error) {
+	body := content
+	if h.Conf.Client.HttpCompression == "GZIP" {
+		pipeReader, pipeWriter := io.Pipe()
+		go func() {
+			gz := gzip.NewWriter(pipeWriter)
+			_, err := io.Copy(gz, content)
+			if err == nil {
+				err = gz.Close()
+			}
+			pipeWriter.CloseWithError(err)
+		}()
+		body = pipeReader
 	}
 
-	return nil
+	httpReq, err := http.NewRequest(req.method, req.url, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	for key, value := range req.headers {
+		httpReq.Header.Set(key, value)
+	}
+	if h.Conf.Client.HttpCompression == "GZIP" {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+	return httpReq, nil
 }
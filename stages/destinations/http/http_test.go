@@ -16,8 +16,20 @@
 package http
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"github.com/streamsets/datacollector-edge/stages/lib/wholefile"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -86,3 +98,301 @@ func TestHttpClientDestination_Init(t *testing.T) {
 		t.Error("Failed to inject config value for Headers")
 	}
 }
+
+// eventContext keeps the events produced by the stage
+type eventContext struct {
+	*common.StageContextImpl
+	events []api.Record
+}
+
+func (e *eventContext) ToEvent(record api.Record) {
+	e.events = append(e.events, record)
+}
+
+func TestHttpClientDestination_WholeFile(t *testing.T) {
+	var receivedContentType string
+	var receivedContent []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedContentType = r.Header.Get("Content-Type")
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		receivedContent, _ = ioutil.ReadAll(reader)
+	}))
+	defer server.Close()
+
+	sourceFile, err := ioutil.TempFile("", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(sourceFile.Name())
+	sourceFile.WriteString("The quick brown fox jumps over the lazy dog")
+	sourceFile.Close()
+
+	stageContext := &eventContext{StageContextImpl: getStageContext(server.URL, nil, nil)}
+	stageContext.ErrorSink = common.NewErrorSink()
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: "conf.dataFormat", Value: wholefile.WHOLE_FILE},
+		common.Config{Name: "conf.client.httpCompression", Value: "GZIP"},
+		common.Config{Name: "conf.dataGeneratorFormatConfig.includeChecksumInTheEvents", Value: true},
+		common.Config{Name: "conf.dataGeneratorFormatConfig.checksumAlgorithm", Value: wholefile.SHA256},
+	)
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage
+	if err = stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := wholefile.CreateRecord(stageContext, sourceFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	textRecord, _ := stageContext.CreateRecord("text", "not a file")
+	batch := runner.NewBatchImpl("http", []api.Record{record, textRecord}, "offset")
+	if err = stageInstance.(api.Destination).Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(receivedContent) != "The quick brown fox jumps over the lazy dog" {
+		t.Errorf("Unexpected content received: %s", string(receivedContent))
+	}
+	if receivedContentType != BINARY_CONTENT_TYPE {
+		t.Errorf("Unexpected content type received: %s", receivedContentType)
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 1 {
+		t.Errorf("Expected the record without file reference to be sent to error")
+	}
+
+	if len(stageContext.events) != 1 {
+		t.Fatalf("Expected 1 event, but got %d", len(stageContext.events))
+	}
+	checksum, _ := stageContext.events[0].Get("/" + wholefile.CHECKSUM)
+	if checksum == nil || checksum.Value != "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592" {
+		t.Errorf("Unexpected checksum in event: %v", checksum)
+	}
+}
+
+func createDestination(
+	t *testing.T,
+	resourceUrl string,
+	headers []interface{},
+	parameters map[string]interface{},
+	configs ...common.Config,
+) (*HttpClientDestination, *common.StageContextImpl) {
+	stageContext := getStageContext(resourceUrl, headers, parameters)
+	stageContext.ErrorSink = common.NewErrorSink()
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: "conf.dataFormat", Value: "JSON"},
+	)
+	stageContext.StageConfig.Configuration = append(stageContext.StageConfig.Configuration, configs...)
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stageBean.Stage.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	return stageBean.Stage.(*HttpClientDestination), stageContext
+}
+
+func createRecord(t *testing.T, stageContext *common.StageContextImpl, attributes map[string]string) api.Record {
+	record, err := stageContext.CreateRecord("http", map[string]interface{}{"value": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range attributes {
+		record.GetHeader().SetAttribute(name, value)
+	}
+	return record
+}
+
+func TestHttpClientDestination_RequestPerRecordGroup(t *testing.T) {
+	var mutex sync.Mutex
+	requests := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, fmt.Sprintf(
+			"%s %s %s %d",
+			r.Method,
+			r.URL.Path,
+			r.Header.Get("X-Sensor"),
+			bytes.Count(body, []byte("\n")),
+		))
+	}))
+	defer server.Close()
+
+	headers := []interface{}{
+		map[string]interface{}{"key": "X-Sensor", "value": "${record:attribute('sensor')}"},
+	}
+	stageInstance, stageContext := createDestination(
+		t,
+		"${baseUrl}/sensors/${record:attribute('sensor')}",
+		headers,
+		map[string]interface{}{"baseUrl": server.URL},
+		common.Config{Name: "conf.httpMethod", Value: EXPRESSION},
+		common.Config{Name: "conf.methodExpression", Value: "${record:attribute('method')}"},
+		common.Config{Name: "conf.singleRequestPerBatch", Value: true},
+	)
+
+	records := []api.Record{
+		createRecord(t, stageContext, map[string]string{"sensor": "s1", "method": "put"}),
+		createRecord(t, stageContext, map[string]string{"sensor": "s2", "method": "POST"}),
+		createRecord(t, stageContext, map[string]string{"sensor": "s1", "method": "PUT"}),
+	}
+	if err := stageInstance.Write(runner.NewBatchImpl("http", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRequests := []string{"PUT /sensors/s1 s1 2", "POST /sensors/s2 s2 1"}
+	if len(requests) != len(expectedRequests) {
+		t.Fatalf("Expected requests %v, but got %v", expectedRequests, requests)
+	}
+	for i, expectedRequest := range expectedRequests {
+		if requests[i] != expectedRequest {
+			t.Errorf("Expected request '%s', but got '%s'", expectedRequest, requests[i])
+		}
+	}
+}
+
+func TestHttpClientDestination_ResponseActions(t *testing.T) {
+	var mutex sync.Mutex
+	requestCounts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requestCounts[r.URL.Path]++
+		requestCount := requestCounts[r.URL.Path]
+		mutex.Unlock()
+		switch r.URL.Path {
+		case "/unavailable":
+			if requestCount == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/throttled":
+			if requestCount < 3 {
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		case "/invalid":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "invalid record")
+		case "/down":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	stageInstance, stageContext := createDestination(
+		t,
+		server.URL+"/${record:attribute('path')}",
+		nil,
+		nil,
+		common.Config{Name: "conf.responseStatusActionConfigs", Value: []interface{}{
+			map[string]interface{}{
+				"statusCode":      float64(429),
+				"action":          "RETRY_LINEAR_BACKOFF",
+				"maxNumRetries":   float64(2),
+				"backoffInterval": float64(10),
+			},
+			map[string]interface{}{
+				"statusCode":      float64(500),
+				"action":          "RETRY_IMMEDIATELY",
+				"maxNumRetries":   float64(1),
+				"backoffInterval": float64(0),
+			},
+			map[string]interface{}{
+				"statusCode": float64(404),
+				"action":     "STAGE_ERROR",
+			},
+		}},
+	)
+
+	records := []api.Record{
+		createRecord(t, stageContext, map[string]string{"path": "unavailable"}),
+		createRecord(t, stageContext, map[string]string{"path": "throttled"}),
+		createRecord(t, stageContext, map[string]string{"path": "invalid"}),
+		createRecord(t, stageContext, map[string]string{"path": "down"}),
+	}
+	if err := stageInstance.Write(runner.NewBatchImpl("http", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRequestCounts := map[string]int{"/unavailable": 2, "/throttled": 3, "/invalid": 1, "/down": 2}
+	for path, expectedRequestCount := range expectedRequestCounts {
+		if requestCounts[path] != expectedRequestCount {
+			t.Errorf("Expected %d requests to %s, but got %d", expectedRequestCount, path, requestCounts[path])
+		}
+	}
+	errorRecords := stageContext.ErrorSink.GetStageErrorRecords("")
+	if len(errorRecords) != 2 {
+		t.Fatalf("Expected the invalid and down records to be sent to error, but got %d", len(errorRecords))
+	}
+	if errorMessage := errorRecords[0].GetHeader().GetErrorMessage(); !strings.Contains(errorMessage, "invalid record") {
+		t.Errorf("Expected the response body in the error message, but got '%s'", errorMessage)
+	}
+
+	records = []api.Record{createRecord(t, stageContext, map[string]string{"path": "missing"})}
+	if err := stageInstance.Write(runner.NewBatchImpl("http", records, "offset")); err == nil {
+		t.Error("Expected the stage to fail for the missing resource")
+	}
+}
+
+func TestHttpClientDestination_ContentType(t *testing.T) {
+	var contentType, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	stageInstance, stageContext := createDestination(
+		t,
+		server.URL,
+		nil,
+		nil,
+		common.Config{Name: "conf.dataFormat", Value: "DELIMITED"},
+	)
+	records := []api.Record{createRecord(t, stageContext, nil)}
+	if err := stageInstance.Write(runner.NewBatchImpl("http", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != DELIMITED_CONTENT_TYPE {
+		t.Errorf("Expected content type '%s', but got '%s'", DELIMITED_CONTENT_TYPE, contentType)
+	}
+	if strings.TrimSpace(body) != "1" {
+		t.Errorf("Expected the record as delimited row, but got '%s'", body)
+	}
+}
+
+func TestHttpClientDestination_InvalidConfig(t *testing.T) {
+	invalidConfigs := []common.Config{
+		{Name: "conf.httpMethod", Value: "CONNECT"},
+		{Name: "conf.httpMethod", Value: EXPRESSION},
+		{Name: "conf.client.authType", Value: "KERBEROS"},
+		{Name: "conf.responseStatusActionConfigs", Value: []interface{}{
+			map[string]interface{}{"statusCode": float64(500), "action": "IGNORE"},
+		}},
+	}
+	for _, invalidConfig := range invalidConfigs {
+		stageContext := getStageContext("http://localhost", nil, nil)
+		stageContext.StageConfig.Configuration = append(stageContext.StageConfig.Configuration, invalidConfig)
+		stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stageBean.Stage.Init(stageContext); err == nil {
+			t.Errorf("Expected an error for invalid configuration %v", invalidConfig)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/el"
+	"github.com/streamsets/datacollector-edge/stages/lib/datagenerator"
+	kafkalib "github.com/streamsets/datacollector-edge/stages/lib/kafka"
+	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"log"
+	"strconv"
+	"strings"
+)
+
+const (
+	LIBRARY          = "streamsets-datacollector-apache-kafka_1_0-lib"
+	STAGE_NAME       = "com_streamsets_pipeline_stage_destination_kafka_KafkaDTarget"
+	ERROR_STAGE_NAME = "com_streamsets_pipeline_stage_destination_kafka_ToErrorKafkaDTarget"
+
+	ROUND_ROBIN = "ROUND_ROBIN"
+	RANDOM      = "RANDOM"
+	EXPRESSION  = "EXPRESSION"
+	DEFAULT     = "DEFAULT"
+
+	ACKS             = "acks"
+	COMPRESSION_TYPE = "compression.type"
+	LINGER_MS        = "linger.ms"
+	BATCH_SIZE       = "batch.size"
+	RETRIES          = "retries"
+	RETRY_BACKOFF_MS = "retry.backoff.ms"
+	MAX_REQUEST_SIZE = "max.request.size"
+
+	TOPIC_EXPRESSION = "topicExpression"
+	PARTITION        = "partition"
+	MESSAGE_KEY      = "messageKey"
+	WHITE_LIST_ALL   = "*"
+)
+
+type KafkaDestination struct {
+	*common.BaseStage
+	Conf           KafkaTargetConfig `ConfigDefBean:"conf"`
+	producer       sarama.SyncProducer
+	topicWhiteList map[string]bool
+}
+
+// KafkaTargetConfig holds the settings of the destination. With runtime topic resolution the topic of every
+// record is evaluated from TopicExpression and must be part of TopicWhiteList unless the list is "*".
+// Partition and MessageKey can be expressions evaluated for every record, like ${record:value('/deviceId')}.
+//
+// KafkaProducerConfigs takes the Kafka producer properties like acks, compression.type, linger.ms,
+// batch.size, retries, security.protocol or sasl.username.
+type KafkaTargetConfig struct {
+	MetadataBrokerList        string                                  `ConfigDef:"type=STRING,required=true"`
+	RuntimeTopicResolution    bool                                    `ConfigDef:"type=BOOLEAN,required=false"`
+	TopicExpression           string                                  `ConfigDef:"type=STRING,required=false,evaluation=EXPLICIT"`
+	TopicWhiteList            string                                  `ConfigDef:"type=STRING,required=false"`
+	Topic                     string                                  `ConfigDef:"type=STRING,required=false"`
+	PartitionStrategy         string                                  `ConfigDef:"type=STRING,required=false"`
+	Partition                 string                                  `ConfigDef:"type=STRING,required=false,evaluation=EXPLICIT"`
+	MessageKey                string                                  `ConfigDef:"type=STRING,required=false,evaluation=EXPLICIT"`
+	KafkaProducerConfigs      map[string]string                       `ConfigDef:"type=MAP,required=false"`
+	DataFormat                string                                  `ConfigDef:"type=STRING,required=true"`
+	DataGeneratorFormatConfig datagenerator.DataGeneratorFormatConfig `ConfigDefBean:"dataGeneratorFormatConfig"`
+}
+
+func init() {
+	stagelibrary.SetCreator(LIBRARY, STAGE_NAME, func() api.Stage {
+		return &KafkaDestination{BaseStage: &common.BaseStage{}}
+	})
+
+	stagelibrary.SetCreator(LIBRARY, ERROR_STAGE_NAME, func() api.Stage {
+		return &KafkaDestination{BaseStage: &common.BaseStage{}}
+	})
+}
+
+func (k *KafkaDestination) Init(stageContext api.StageContext) error {
+	log.Println("[DEBUG] KafkaDestination Init method")
+	if err := k.BaseStage.Init(stageContext); err != nil {
+		return err
+	}
+
+	if k.GetStageContext().IsErrorStage() {
+		k.Conf.DataFormat = "SDC_JSON"
+	}
+	if err := k.Conf.DataGeneratorFormatConfig.Init(k.Conf.DataFormat); err != nil {
+		return err
+	}
+
+	if err := k.initTopic(); err != nil {
+		return err
+	}
+
+	config, err := k.getProducerConfig()
+	if err != nil {
+		return err
+	}
+
+	brokerList := kafkalib.GetBrokerList(k.Conf.MetadataBrokerList)
+	if len(brokerList) == 0 {
+		return errors.New("Metadata broker list must not be empty")
+	}
+	k.producer, err = sarama.NewSyncProducer(brokerList, config)
+	return err
+}
+
+func (k *KafkaDestination) initTopic() error {
+	if !k.Conf.RuntimeTopicResolution {
+		if len(k.Conf.Topic) == 0 {
+			return errors.New("Topic must not be empty")
+		}
+		return nil
+	}
+
+	if len(k.Conf.TopicExpression) == 0 {
+		return errors.New("Topic expression must not be empty")
+	}
+	if strings.TrimSpace(k.Conf.TopicWhiteList) != WHITE_LIST_ALL {
+		k.topicWhiteList = make(map[string]bool)
+		for _, topic := range strings.Split(k.Conf.TopicWhiteList, ",") {
+			if topic = strings.TrimSpace(topic); len(topic) > 0 {
+				k.topicWhiteList[topic] = true
+			}
+		}
+		if len(k.topicWhiteList) == 0 {
+			return errors.New("Topic white list must not be empty, use '*' to allow all topics")
+		}
+	}
+	return nil
+}
+
+func (k *KafkaDestination) getProducerConfig() (*sarama.Config, error) {
+	config, err := kafkalib.NewClientConfig(
+		k.Conf.KafkaProducerConfigs,
+		ACKS,
+		COMPRESSION_TYPE,
+		LINGER_MS,
+		BATCH_SIZE,
+		RETRIES,
+		RETRY_BACKOFF_MS,
+		MAX_REQUEST_SIZE,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// required by the sync producer to report the messages sent
+	config.Producer.Return.Successes = true
+
+	for key, value := range k.Conf.KafkaProducerConfigs {
+		switch key {
+		case ACKS:
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "0":
+				config.Producer.RequiredAcks = sarama.NoResponse
+			case "1":
+				config.Producer.RequiredAcks = sarama.WaitForLocal
+			case "all", "-1":
+				config.Producer.RequiredAcks = sarama.WaitForAll
+			default:
+				err = errors.New(fmt.Sprintf("Unsupported value '%s' for Kafka configuration '%s'", value, key))
+			}
+		case COMPRESSION_TYPE:
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "none":
+				config.Producer.Compression = sarama.CompressionNone
+			case "gzip":
+				config.Producer.Compression = sarama.CompressionGZIP
+			case "snappy":
+				config.Producer.Compression = sarama.CompressionSnappy
+			case "lz4":
+				config.Producer.Compression = sarama.CompressionLZ4
+			default:
+				err = errors.New(fmt.Sprintf("Unsupported value '%s' for Kafka configuration '%s'", value, key))
+			}
+		case LINGER_MS:
+			config.Producer.Flush.Frequency, err = kafkalib.GetDurationMillis(key, value)
+		case BATCH_SIZE:
+			config.Producer.Flush.Bytes, err = kafkalib.GetInt(key, value)
+		case RETRIES:
+			config.Producer.Retry.Max, err = kafkalib.GetInt(key, value)
+		case RETRY_BACKOFF_MS:
+			config.Producer.Retry.Backoff, err = kafkalib.GetDurationMillis(key, value)
+		case MAX_REQUEST_SIZE:
+			config.Producer.MaxMessageBytes, err = kafkalib.GetInt(key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch k.Conf.PartitionStrategy {
+	case ROUND_ROBIN:
+		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	case RANDOM:
+		config.Producer.Partitioner = sarama.NewRandomPartitioner
+	case EXPRESSION:
+		if len(k.Conf.Partition) == 0 {
+			return nil, errors.New("Partition expression must not be empty")
+		}
+		config.Producer.Partitioner = sarama.NewManualPartitioner
+	case DEFAULT, "":
+		// messages without key are sent to random partitions
+		config.Producer.Partitioner = sarama.NewHashPartitioner
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported partition strategy: %s", k.Conf.PartitionStrategy))
+	}
+
+	return config, config.Validate()
+}
+
+// Write sends a message for every record of the batch, the records that could not be sent are sent to error
+func (k *KafkaDestination) Write(batch api.Batch) error {
+	log.Println("[DEBUG] KafkaDestination write method")
+	messages := make([]*sarama.ProducerMessage, 0, len(batch.GetRecords()))
+	for _, record := range batch.GetRecords() {
+		message, err := k.createMessage(record)
+		if err != nil {
+			log.Println("[ERROR] Error creating Kafka message", err)
+			k.GetStageContext().ToError(err, record)
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	if err := k.producer.SendMessages(messages); err != nil {
+		producerErrors, ok := err.(sarama.ProducerErrors)
+		if !ok {
+			return err
+		}
+		for _, producerError := range producerErrors {
+			log.Println("[ERROR] Error sending Kafka message", producerError.Err)
+			k.GetStageContext().ToError(producerError.Err, producerError.Msg.Metadata.(api.Record))
+		}
+	}
+	return nil
+}
+
+func (k *KafkaDestination) createMessage(record api.Record) (*sarama.ProducerMessage, error) {
+	topic, err := k.getTopic(record)
+	if err != nil {
+		return nil, err
+	}
+
+	recordBuffer := bytes.NewBuffer([]byte{})
+	recordWriter, err := k.Conf.DataGeneratorFormatConfig.RecordWriterFactory.CreateWriter(
+		k.GetStageContext(),
+		recordBuffer,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = recordWriter.WriteRecord(record); err != nil {
+		return nil, err
+	}
+	recordWriter.Flush()
+	recordWriter.Close()
+
+	message := &sarama.ProducerMessage{
+		Topic:    topic,
+		Value:    sarama.ByteEncoder(recordBuffer.Bytes()),
+		Metadata: record,
+	}
+
+	if len(k.Conf.MessageKey) > 0 {
+		key, err := k.evaluate(k.Conf.MessageKey, MESSAGE_KEY, record)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) > 0 {
+			message.Key = sarama.StringEncoder(key)
+		}
+	}
+
+	if k.Conf.PartitionStrategy == EXPRESSION {
+		if message.Partition, err = k.getPartition(record); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+func (k *KafkaDestination) getTopic(record api.Record) (string, error) {
+	if !k.Conf.RuntimeTopicResolution {
+		return k.Conf.Topic, nil
+	}
+	topic, err := k.evaluate(k.Conf.TopicExpression, TOPIC_EXPRESSION, record)
+	if err != nil {
+		return "", err
+	}
+	if len(topic) == 0 {
+		return "", errors.New(fmt.Sprintf("Topic expression '%s' evaluated to an empty topic", k.Conf.TopicExpression))
+	}
+	if k.topicWhiteList != nil && !k.topicWhiteList[topic] {
+		return "", errors.New(fmt.Sprintf("Topic '%s' is not in the topic white list", topic))
+	}
+	return topic, nil
+}
+
+func (k *KafkaDestination) getPartition(record api.Record) (int32, error) {
+	value, err := k.evaluate(k.Conf.Partition, PARTITION, record)
+	if err != nil {
+		return 0, err
+	}
+	partition, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || partition < 0 {
+		return 0, errors.New(fmt.Sprintf("Partition expression '%s' evaluated to an invalid partition '%s'",
+			k.Conf.Partition, value))
+	}
+	return int32(partition), nil
+}
+
+func (k *KafkaDestination) evaluate(expression string, configName string, record api.Record) (string, error) {
+	if !el.IsElString(expression) {
+		return expression, nil
+	}
+	recordContext := context.WithValue(context.Background(), el.RECORD_CONTEXT_VAR, record)
+	result, err := k.GetStageContext().Evaluate(expression, configName, recordContext)
+	if err != nil || result == nil {
+		return "", err
+	}
+	return fmt.Sprint(result), nil
+}
+
+func (k *KafkaDestination) Destroy() error {
+	log.Println("[DEBUG] KafkaDestination Destroy method")
+	if k.producer != nil {
+		return k.producer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"testing"
+)
+
+func getStageContext(configuration map[string]interface{}) *common.StageContextImpl {
+	stageConfig := common.StageConfiguration{}
+	stageConfig.Library = LIBRARY
+	stageConfig.StageName = STAGE_NAME
+	stageConfig.InstanceName = "kafka"
+	stageConfig.Configuration = []common.Config{
+		{
+			Name:  "conf.dataFormat",
+			Value: "JSON",
+		},
+	}
+	for name, value := range configuration {
+		stageConfig.Configuration = append(stageConfig.Configuration, common.Config{Name: name, Value: value})
+	}
+	return &common.StageContextImpl{
+		StageConfig: stageConfig,
+		ErrorSink:   common.NewErrorSink(),
+	}
+}
+
+func createStage(t *testing.T, stageContext *common.StageContextImpl) *KafkaDestination {
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stageBean.Stage.(*KafkaDestination)
+}
+
+func newMockBroker(t *testing.T, topics ...string) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	metadataResponse := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	for _, topic := range topics {
+		metadataResponse.SetLeader(topic, 0, broker.BrokerID())
+		metadataResponse.SetLeader(topic, 1, broker.BrokerID())
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadataResponse,
+		"ProduceRequest":  sarama.NewMockProduceResponse(t),
+	})
+	return broker
+}
+
+func createRecords(t *testing.T, stageContext *common.StageContextImpl, values ...map[string]interface{}) []api.Record {
+	records := make([]api.Record, len(values))
+	for i, value := range values {
+		record, err := stageContext.CreateRecord("record", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[i] = record
+	}
+	return records
+}
+
+func TestKafkaDestination_Init(t *testing.T) {
+	stageContext := getStageContext(map[string]interface{}{
+		"conf.metadataBrokerList": "localhost:9092",
+		"conf.topic":              "sdc",
+		"conf.partitionStrategy":  ROUND_ROBIN,
+		"conf.messageKey":         "${record:value('/id')}",
+		"conf.kafkaProducerConfigs": []interface{}{
+			map[string]interface{}{"key": ACKS, "value": "all"},
+			map[string]interface{}{"key": COMPRESSION_TYPE, "value": "gzip"},
+		},
+	})
+	stageInstance := createStage(t, stageContext)
+
+	if stageInstance.Conf.MetadataBrokerList != "localhost:9092" {
+		t.Error("Failed to inject config value for metadataBrokerList")
+	}
+	if stageInstance.Conf.Topic != "sdc" {
+		t.Error("Failed to inject config value for topic")
+	}
+	if stageInstance.Conf.PartitionStrategy != ROUND_ROBIN {
+		t.Error("Failed to inject config value for partitionStrategy")
+	}
+	if stageInstance.Conf.MessageKey != "${record:value('/id')}" {
+		t.Error("Failed to inject config value for messageKey")
+	}
+	if stageInstance.Conf.KafkaProducerConfigs[ACKS] != "all" {
+		t.Error("Failed to inject config value for kafkaProducerConfigs")
+	}
+
+	config, err := stageInstance.getProducerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Producer.RequiredAcks != sarama.WaitForAll {
+		t.Errorf("Expected acks from all replicas, but got %v", config.Producer.RequiredAcks)
+	}
+	if config.Producer.Compression != sarama.CompressionGZIP {
+		t.Errorf("Expected gzip compression, but got %v", config.Producer.Compression)
+	}
+}
+
+func TestKafkaDestination_InvalidConfig(t *testing.T) {
+	invalidConfigs := []map[string]interface{}{
+		{
+			"conf.metadataBrokerList": "localhost:9092",
+		},
+		{
+			"conf.metadataBrokerList": "",
+			"conf.topic":              "sdc",
+		},
+		{
+			"conf.metadataBrokerList":     "localhost:9092",
+			"conf.runtimeTopicResolution": true,
+			"conf.topicExpression":        "${record:value('/topic')}",
+			"conf.topicWhiteList":         "",
+		},
+		{
+			"conf.metadataBrokerList": "localhost:9092",
+			"conf.topic":              "sdc",
+			"conf.partitionStrategy":  "UNKNOWN",
+		},
+		{
+			"conf.metadataBrokerList": "localhost:9092",
+			"conf.topic":              "sdc",
+			"conf.kafkaProducerConfigs": []interface{}{
+				map[string]interface{}{"key": ACKS, "value": "2"},
+			},
+		},
+		{
+			"conf.metadataBrokerList": "localhost:9092",
+			"conf.topic":              "sdc",
+			"conf.kafkaProducerConfigs": []interface{}{
+				map[string]interface{}{"key": LINGER_MS, "value": "soon"},
+			},
+		},
+	}
+
+	for _, invalidConfig := range invalidConfigs {
+		stageContext := getStageContext(invalidConfig)
+		stageInstance := createStage(t, stageContext)
+		if err := stageInstance.Init(stageContext); err == nil {
+			t.Errorf("Expected an error for invalid configuration %v", invalidConfig)
+			stageInstance.Destroy()
+		}
+	}
+}
+
+func TestKafkaDestination_Write(t *testing.T) {
+	broker := newMockBroker(t, "a", "b")
+	defer broker.Close()
+
+	stageContext := getStageContext(map[string]interface{}{
+		"conf.metadataBrokerList":     broker.Addr(),
+		"conf.runtimeTopicResolution": true,
+		"conf.topicExpression":        "${record:value('/deviceId')}",
+		"conf.topicWhiteList":         "a, b",
+		"conf.partitionStrategy":      DEFAULT,
+		"conf.messageKey":             "${record:value('/deviceId')}",
+	})
+	stageInstance := createStage(t, stageContext)
+	if err := stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	defer stageInstance.Destroy()
+
+	records := createRecords(
+		t,
+		stageContext,
+		map[string]interface{}{"deviceId": "a", "value": 1},
+		map[string]interface{}{"deviceId": "b", "value": 2},
+		map[string]interface{}{"deviceId": "c", "value": 3},
+	)
+	if err := stageInstance.Write(runner.NewBatchImpl("kafka", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+
+	errorRecords := stageContext.ErrorSink.GetStageErrorRecords("kafka")
+	if len(errorRecords) != 1 {
+		t.Fatalf("Expected 1 error record, but got %d", len(errorRecords))
+	}
+	if deviceId, _ := errorRecords[0].Get("/deviceId"); deviceId.Value != "c" {
+		t.Errorf("Expected the record of topic c to be sent to error, but got %v", deviceId.Value)
+	}
+}
+
+func TestKafkaDestination_WriteError(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("sdc", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).SetError("sdc", 0, sarama.ErrMessageSizeTooLarge),
+	})
+
+	stageContext := getStageContext(map[string]interface{}{
+		"conf.metadataBrokerList": broker.Addr(),
+		"conf.topic":              "sdc",
+	})
+	stageInstance := createStage(t, stageContext)
+	if err := stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	defer stageInstance.Destroy()
+
+	records := createRecords(t, stageContext, map[string]interface{}{"value": 1}, map[string]interface{}{"value": 2})
+	if err := stageInstance.Write(runner.NewBatchImpl("kafka", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 2 {
+		t.Errorf("Expected 2 error records, but got %d", stageContext.ErrorSink.GetTotalErrorRecords())
+	}
+}
+
+func TestKafkaDestination_CreateMessage(t *testing.T) {
+	stageContext := getStageContext(map[string]interface{}{
+		"conf.metadataBrokerList": "localhost:9092",
+		"conf.topic":              "sdc",
+		"conf.partitionStrategy":  EXPRESSION,
+		"conf.partition":          "${record:value('/partition')}",
+		"conf.messageKey":         "${record:value('/id')}",
+	})
+	stageInstance := createStage(t, stageContext)
+	if err := stageInstance.BaseStage.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	if err := stageInstance.Conf.DataGeneratorFormatConfig.Init(stageInstance.Conf.DataFormat); err != nil {
+		t.Fatal(err)
+	}
+
+	records := createRecords(
+		t,
+		stageContext,
+		map[string]interface{}{"id": "device1", "partition": 1},
+		map[string]interface{}{"id": "device2", "partition": -1},
+	)
+
+	message, err := stageInstance.createMessage(records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Topic != "sdc" || message.Partition != 1 {
+		t.Errorf("Unexpected topic and partition: %s %d", message.Topic, message.Partition)
+	}
+	if key, _ := message.Key.Encode(); string(key) != "device1" {
+		t.Errorf("Expected key device1, but got %s", string(key))
+	}
+	if value, _ := message.Value.Encode(); len(value) == 0 {
+		t.Error("Expected the record to be written in the message")
+	}
+
+	if _, err = stageInstance.createMessage(records[1]); err == nil {
+		t.Error("Expected an error for a negative partition")
+	}
+}
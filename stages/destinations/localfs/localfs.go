@@ -0,0 +1,484 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package localfs
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/satori/go.uuid"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/el"
+	"github.com/streamsets/datacollector-edge/container/recordio"
+	"github.com/streamsets/datacollector-edge/stages/lib/datagenerator"
+	"github.com/streamsets/datacollector-edge/stages/lib/wholefile"
+	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LIBRARY             = "streamsets-datacollector-basic-lib"
+	STAGE_NAME          = "com_streamsets_pipeline_stage_destination_localfilesystem_LocalFileSystemDTarget"
+	TMP_FILE_PREFIX     = "_tmp_"
+	DEFAULT_PREFIX      = "sdc"
+	DEFAULT_TIME_DRIVER = "${time:now()}"
+	GZIP_SUFFIX         = ".gz"
+	COMPRESSION_NONE    = "NONE"
+	COMPRESSION_GZIP    = "GZIP"
+	DIR_PATH_TEMPLATE   = "dirPathTemplate"
+	TIME_DRIVER         = "timeDriver"
+	FILE_NAME_EL        = "dataGeneratorFormatConfig.fileNameEL"
+	MEGABYTE            = 1024 * 1024
+
+	FILE_CLOSED_EVENT         = "file-closed"
+	FILE_CLOSED_EVENT_VERSION = 1
+	FILE_PATH_FIELD           = "filepath"
+	FILE_NAME_FIELD           = "filename"
+	LENGTH_FIELD              = "length"
+)
+
+type LocalFileSystemDestination struct {
+	*common.BaseStage
+	Configs     LocalFileSystemConfigBean `ConfigDefBean:"configs"`
+	location    *time.Location
+	activeFiles map[string]*outputFile
+	mutex       sync.Mutex
+}
+
+// LocalFileSystemConfigBean holds the settings of the destination. DirPathTemplate can hold expressions evaluated
+// for every record, like /data/${YYYY()}/${MM()}/${record:attribute('sensor')}, the time functions return the
+// time evaluated from TimeDriver in the time zone TimeZoneID, either a time or milliseconds since the epoch.
+//
+// Files roll once they hold MaxRecordsPerFile records, reach MaxFileSize MB or were not written for
+// IdleTimeout seconds, a value of 0 disables the limit.
+//
+// With the WHOLE_FILE data format every record is a file streamed to the directory under the name evaluated
+// from the FileNameEL of the data format, files are neither rolled nor compressed.
+type LocalFileSystemConfigBean struct {
+	DirPathTemplate           string                                  `ConfigDef:"type=STRING,required=true,evaluation=EXPLICIT"`
+	TimeDriver                string                                  `ConfigDef:"type=STRING,required=false,evaluation=EXPLICIT"`
+	TimeZoneID                string                                  `ConfigDef:"type=STRING,required=false"`
+	UniquePrefix              string                                  `ConfigDef:"type=STRING,required=false"`
+	FileNameSuffix            string                                  `ConfigDef:"type=STRING,required=false"`
+	MaxRecordsPerFile         float64                                 `ConfigDef:"type=NUMBER,required=false"`
+	MaxFileSize               float64                                 `ConfigDef:"type=NUMBER,required=false"`
+	IdleTimeout               float64                                 `ConfigDef:"type=NUMBER,required=false"`
+	Compression               string                                  `ConfigDef:"type=STRING,required=false"`
+	DataFormat                string                                  `ConfigDef:"type=STRING,required=true"`
+	DataGeneratorFormatConfig datagenerator.DataGeneratorFormatConfig `ConfigDefBean:"dataGeneratorFormatConfig"`
+}
+
+// outputFile is a file being written under its temporary name, it is renamed once closed
+type outputFile struct {
+	dir          string
+	tmpPath      string
+	path         string
+	file         *os.File
+	counter      *countingWriter
+	gzipWriter   *gzip.Writer
+	recordWriter recordio.RecordWriter
+	records      int64
+	idleTimer    *time.Timer
+}
+
+// countingWriter counts the bytes written to the file
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+func init() {
+	stagelibrary.SetCreator(LIBRARY, STAGE_NAME, func() api.Stage {
+		return &LocalFileSystemDestination{BaseStage: &common.BaseStage{}}
+	})
+}
+
+func (l *LocalFileSystemDestination) Init(stageContext api.StageContext) error {
+	log.Println("[DEBUG] LocalFileSystemDestination Init method")
+	if err := l.BaseStage.Init(stageContext); err != nil {
+		return err
+	}
+
+	if len(l.Configs.DirPathTemplate) == 0 {
+		return errors.New("Directory template must not be empty")
+	}
+	if len(l.Configs.TimeDriver) == 0 {
+		l.Configs.TimeDriver = DEFAULT_TIME_DRIVER
+	}
+	if len(l.Configs.UniquePrefix) == 0 {
+		l.Configs.UniquePrefix = DEFAULT_PREFIX
+	}
+	switch l.Configs.Compression {
+	case "":
+		l.Configs.Compression = COMPRESSION_NONE
+	case COMPRESSION_NONE, COMPRESSION_GZIP:
+	default:
+		return errors.New(fmt.Sprintf("Unsupported compression: %s", l.Configs.Compression))
+	}
+
+	var err error
+	if l.location, err = time.LoadLocation(l.Configs.TimeZoneID); err != nil {
+		return err
+	}
+
+	if err = l.Configs.DataGeneratorFormatConfig.Init(l.Configs.DataFormat); err != nil {
+		return err
+	}
+	if l.Configs.DataFormat != wholefile.WHOLE_FILE {
+		if err = l.recoverTmpFiles(); err != nil {
+			return err
+		}
+	}
+	l.activeFiles = make(map[string]*outputFile)
+	return nil
+}
+
+// recoverTmpFiles renames the temporary files left by a previous run under the directory template to their
+// final names, the directories evaluated from the template are below the part of it without expressions
+func (l *LocalFileSystemDestination) recoverTmpFiles() error {
+	rootDir := l.Configs.DirPathTemplate
+	if index := strings.Index(rootDir, "${"); index >= 0 {
+		rootDir = rootDir[:index]
+		if !strings.HasSuffix(rootDir, string(filepath.Separator)) {
+			rootDir = filepath.Dir(rootDir)
+		}
+	}
+	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	tmpFilePrefix := TMP_FILE_PREFIX + l.Configs.UniquePrefix + "-"
+	return filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("[WARN] Error looking for temporary files in '%s': %s", path, err)
+			return nil
+		}
+		if info.IsDir() || !strings.HasPrefix(info.Name(), tmpFilePrefix) {
+			return nil
+		}
+		recoveredPath := filepath.Join(filepath.Dir(path), strings.TrimPrefix(info.Name(), TMP_FILE_PREFIX))
+		log.Printf("[INFO] Recovering file '%s' left by a previous run as '%s'", path, recoveredPath)
+		return os.Rename(path, recoveredPath)
+	})
+}
+
+// Write appends the records to the active files of their directories, the files are flushed once the batch
+// is written
+func (l *LocalFileSystemDestination) Write(batch api.Batch) error {
+	log.Println("[DEBUG] LocalFileSystemDestination write method")
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, record := range batch.GetRecords() {
+		var err error
+		if l.Configs.DataFormat == wholefile.WHOLE_FILE {
+			err = l.writeWholeFile(record)
+		} else {
+			err = l.writeRecord(record)
+		}
+		if err != nil {
+			log.Println("[ERROR] Error writing record", err)
+			l.GetStageContext().ToError(err, record)
+		}
+	}
+
+	for dir, activeFile := range l.activeFiles {
+		err := activeFile.recordWriter.Flush()
+		if err == nil && activeFile.gzipWriter != nil {
+			err = activeFile.gzipWriter.Flush()
+		}
+		if err != nil {
+			log.Printf("[ERROR] Error flushing file '%s': %s", activeFile.tmpPath, err)
+			l.closeFile(dir, activeFile)
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LocalFileSystemDestination) writeRecord(record api.Record) error {
+	dir, err := l.getDirPath(record)
+	if err != nil {
+		return err
+	}
+
+	activeFile, ok := l.activeFiles[dir]
+	if !ok {
+		if activeFile, err = l.createFile(dir); err != nil {
+			return err
+		}
+		l.activeFiles[dir] = activeFile
+	}
+
+	if err = activeFile.recordWriter.WriteRecord(record); err != nil {
+		return err
+	}
+	activeFile.records++
+
+	if (l.Configs.MaxRecordsPerFile > 0 && activeFile.records >= int64(l.Configs.MaxRecordsPerFile)) ||
+		(l.Configs.MaxFileSize > 0 && activeFile.counter.count >= int64(l.Configs.MaxFileSize*MEGABYTE)) {
+		l.closeFile(dir, activeFile)
+	} else if activeFile.idleTimer != nil {
+		activeFile.idleTimer.Reset(l.getIdleTimeout())
+	}
+	return nil
+}
+
+// writeWholeFile streams the file of a whole file record under its temporary name and renames it once written
+func (l *LocalFileSystemDestination) writeWholeFile(record api.Record) error {
+	fileRef, err := wholefile.GetFileRef(record)
+	if err != nil {
+		return err
+	}
+
+	dir, err := l.getDirPath(record)
+	if err != nil {
+		return err
+	}
+
+	recordContext := context.WithValue(context.Background(), el.RECORD_CONTEXT_VAR, record)
+	fileName, err := el.EvaluateTemplate(
+		l.Configs.DataGeneratorFormatConfig.FileNameEL,
+		func(expression string) (interface{}, error) {
+			return l.GetStageContext().Evaluate(expression, FILE_NAME_EL, recordContext)
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if len(fileName) == 0 || filepath.Base(fileName) != fileName {
+		return errors.New(fmt.Sprintf("Invalid whole file name '%s'", fileName))
+	}
+
+	path := filepath.Join(dir, fileName)
+	if _, err := os.Stat(path); err == nil &&
+		l.Configs.DataGeneratorFormatConfig.WholeFileExistsAction != wholefile.OVERWRITE {
+		return errors.New(fmt.Sprintf("File '%s' already exists", path))
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	reader, err := fileRef.GetReader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	var content io.Reader = reader
+	var checksumReader *wholefile.ChecksumReader
+	if l.Configs.DataGeneratorFormatConfig.IncludeChecksumInTheEvents {
+		checksumReader, err = wholefile.NewChecksumReader(reader, l.Configs.DataGeneratorFormatConfig.ChecksumAlgorithm)
+		if err != nil {
+			return err
+		}
+		content = checksumReader
+	}
+
+	tmpPath := filepath.Join(dir, TMP_FILE_PREFIX+fileName)
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	length, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	log.Printf("[DEBUG] Wrote whole file '%s'", path)
+
+	return wholefile.ToWholeFileProcessedEvent(
+		l.GetStageContext(),
+		record,
+		map[string]interface{}{
+			FILE_PATH_FIELD: path,
+			FILE_NAME_FIELD: fileName,
+			LENGTH_FIELD:    length,
+		},
+		checksumReader,
+	)
+}
+
+func (l *LocalFileSystemDestination) getDirPath(record api.Record) (string, error) {
+	recordContext := context.WithValue(context.Background(), el.RECORD_CONTEXT_VAR, record)
+
+	recordTime := time.Now()
+	if el.IsElString(l.Configs.TimeDriver) {
+		result, err := l.GetStageContext().Evaluate(l.Configs.TimeDriver, TIME_DRIVER, recordContext)
+		if err != nil {
+			return "", err
+		}
+		switch value := result.(type) {
+		case time.Time:
+			recordTime = value
+		case float64:
+			recordTime = time.Unix(0, int64(value)*int64(time.Millisecond))
+		case int64:
+			recordTime = time.Unix(0, value*int64(time.Millisecond))
+		case int:
+			recordTime = time.Unix(0, int64(value)*int64(time.Millisecond))
+		default:
+			return "", errors.New(fmt.Sprintf("Time driver '%s' evaluated to '%v' which is not a time",
+				l.Configs.TimeDriver, result))
+		}
+	}
+	recordContext = context.WithValue(recordContext, el.TIME_CONTEXT_VAR, recordTime.In(l.location))
+
+	dir, err := el.EvaluateTemplate(l.Configs.DirPathTemplate, func(expression string) (interface{}, error) {
+		return l.GetStageContext().Evaluate(expression, DIR_PATH_TEMPLATE, recordContext)
+	})
+	if err != nil {
+		return "", err
+	}
+	return filepath.Clean(dir), nil
+}
+
+func (l *LocalFileSystemDestination) createFile(dir string) (*outputFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	fileName := l.Configs.UniquePrefix + "-" + uuid.NewV4().String()
+	if len(l.Configs.FileNameSuffix) > 0 {
+		fileName += "." + l.Configs.FileNameSuffix
+	}
+	if l.Configs.Compression == COMPRESSION_GZIP {
+		fileName += GZIP_SUFFIX
+	}
+
+	activeFile := &outputFile{
+		dir:     dir,
+		tmpPath: filepath.Join(dir, TMP_FILE_PREFIX+fileName),
+		path:    filepath.Join(dir, fileName),
+	}
+
+	var err error
+	if activeFile.file, err = os.OpenFile(activeFile.tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err != nil {
+		return nil, err
+	}
+	activeFile.counter = &countingWriter{writer: activeFile.file}
+
+	// the record writers may buffer the writer they get, so the gzip writer is flushed and closed by closeFile
+	var writer io.Writer = activeFile.counter
+	if l.Configs.Compression == COMPRESSION_GZIP {
+		activeFile.gzipWriter = gzip.NewWriter(activeFile.counter)
+		writer = activeFile.gzipWriter
+	}
+	activeFile.recordWriter, err = l.Configs.DataGeneratorFormatConfig.RecordWriterFactory.CreateWriter(
+		l.GetStageContext(),
+		writer,
+	)
+	if err != nil {
+		activeFile.file.Close()
+		os.Remove(activeFile.tmpPath)
+		return nil, err
+	}
+
+	if l.Configs.IdleTimeout > 0 {
+		activeFile.idleTimer = time.AfterFunc(l.getIdleTimeout(), func() {
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			if l.activeFiles[dir] == activeFile {
+				log.Printf("[DEBUG] Closing idle file '%s'", activeFile.tmpPath)
+				l.closeFile(dir, activeFile)
+			}
+		})
+	}
+	return activeFile, nil
+}
+
+func (l *LocalFileSystemDestination) getIdleTimeout() time.Duration {
+	return time.Duration(l.Configs.IdleTimeout * float64(time.Second))
+}
+
+// closeFile renames the file to its final name and produces a file closed event
+func (l *LocalFileSystemDestination) closeFile(dir string, activeFile *outputFile) {
+	delete(l.activeFiles, dir)
+	if activeFile.idleTimer != nil {
+		activeFile.idleTimer.Stop()
+	}
+
+	err := activeFile.recordWriter.Flush()
+	if closeErr := activeFile.recordWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if activeFile.gzipWriter != nil {
+		if closeErr := activeFile.gzipWriter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := activeFile.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("[ERROR] Error closing file '%s': %s", activeFile.tmpPath, err)
+		l.GetStageContext().ReportError(err)
+		return
+	}
+	if err := os.Rename(activeFile.tmpPath, activeFile.path); err != nil {
+		log.Printf("[ERROR] Error renaming file '%s': %s", activeFile.tmpPath, err)
+		l.GetStageContext().ReportError(err)
+		return
+	}
+	log.Printf("[DEBUG] Closed file '%s'", activeFile.path)
+
+	eventRecord, err := l.GetStageContext().CreateEventRecord(
+		activeFile.path,
+		map[string]interface{}{
+			FILE_PATH_FIELD: activeFile.path,
+			FILE_NAME_FIELD: filepath.Base(activeFile.path),
+			LENGTH_FIELD:    activeFile.counter.count,
+		},
+		FILE_CLOSED_EVENT,
+		FILE_CLOSED_EVENT_VERSION,
+	)
+	if err != nil {
+		log.Printf("[ERROR] Error creating file closed event: %s", err)
+		return
+	}
+	l.GetStageContext().ToEvent(eventRecord)
+}
+
+func (l *LocalFileSystemDestination) Destroy() error {
+	log.Println("[DEBUG] LocalFileSystemDestination Destroy method")
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for dir, activeFile := range l.activeFiles {
+		l.closeFile(dir, activeFile)
+	}
+	return nil
+}
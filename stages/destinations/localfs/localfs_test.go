@@ -0,0 +1,445 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package localfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"github.com/streamsets/datacollector-edge/stages/lib/wholefile"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventContext keeps the events produced by the stage
+type eventContext struct {
+	*common.StageContextImpl
+	events []api.Record
+	mutex  sync.Mutex
+}
+
+func (e *eventContext) ToEvent(record api.Record) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.events = append(e.events, record)
+}
+
+func (e *eventContext) getEvents() []api.Record {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.events
+}
+
+func getStageContext(configuration map[string]interface{}) *eventContext {
+	stageConfig := common.StageConfiguration{}
+	stageConfig.Library = LIBRARY
+	stageConfig.StageName = STAGE_NAME
+	stageConfig.InstanceName = "localfs"
+	stageConfig.Configuration = []common.Config{
+		{
+			Name:  "configs.dataFormat",
+			Value: "JSON",
+		},
+	}
+	for name, value := range configuration {
+		stageConfig.Configuration = append(stageConfig.Configuration, common.Config{Name: name, Value: value})
+	}
+	return &eventContext{
+		StageContextImpl: &common.StageContextImpl{
+			StageConfig: stageConfig,
+			ErrorSink:   common.NewErrorSink(),
+		},
+	}
+}
+
+func createStage(t *testing.T, stageContext *eventContext) *LocalFileSystemDestination {
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage.(*LocalFileSystemDestination)
+	if err = stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	return stageInstance
+}
+
+func createRecord(t *testing.T, stageContext *eventContext, sensor string, value interface{}) api.Record {
+	record, err := stageContext.CreateRecord("localfs", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.GetHeader().SetAttribute("sensor", sensor)
+	return record
+}
+
+func listFiles(t *testing.T, dir string) (closedFiles []string, tmpFiles []string) {
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), TMP_FILE_PREFIX) {
+			tmpFiles = append(tmpFiles, path)
+		} else {
+			closedFiles = append(closedFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func countLines(t *testing.T, path string, compressed bool) int {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if compressed {
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner = bufio.NewScanner(reader)
+	}
+	lines := 0
+	for scanner.Scan() {
+		lines++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestLocalFileSystemDestination_InvalidConfig(t *testing.T) {
+	invalidConfigs := []map[string]interface{}{
+		{"configs.dirPathTemplate": ""},
+		{"configs.dirPathTemplate": "/tmp", "configs.compression": "BZIP2"},
+		{"configs.dirPathTemplate": "/tmp", "configs.timeZoneID": "Nowhere/Unknown"},
+	}
+	for _, invalidConfig := range invalidConfigs {
+		stageContext := getStageContext(invalidConfig)
+		stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stageBean.Stage.Init(stageContext); err == nil {
+			t.Errorf("Expected an error for invalid configuration %v", invalidConfig)
+		}
+	}
+}
+
+func TestLocalFileSystemDestination_Write(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stageContext := getStageContext(map[string]interface{}{
+		"configs.dirPathTemplate":   dir + "/${YYYY()}/${record:attribute('sensor')}",
+		"configs.timeDriver":        "${record:value('/time')}",
+		"configs.uniquePrefix":      "edge",
+		"configs.fileNameSuffix":    "json",
+		"configs.maxRecordsPerFile": float64(2),
+	})
+	stageInstance := createStage(t, stageContext)
+
+	recordTime := time.Date(2017, time.March, 9, 14, 5, 7, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	records := []api.Record{
+		createRecord(t, stageContext, "s1", map[string]interface{}{"time": recordTime, "value": 1}),
+		createRecord(t, stageContext, "s1", map[string]interface{}{"time": recordTime, "value": 2}),
+		createRecord(t, stageContext, "s2", map[string]interface{}{"time": recordTime, "value": 3}),
+		createRecord(t, stageContext, "s1", map[string]interface{}{"time": recordTime, "value": 4}),
+		createRecord(t, stageContext, "s1", map[string]interface{}{"value": 5}),
+	}
+	if err = stageInstance.Write(runner.NewBatchImpl("localfs", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 1 {
+		t.Errorf("Expected the record without time to be sent to error")
+	}
+
+	closedFiles, tmpFiles := listFiles(t, dir)
+	if len(closedFiles) != 1 || len(tmpFiles) != 2 {
+		t.Fatalf("Expected 1 closed file and 2 open files, but got %v and %v", closedFiles, tmpFiles)
+	}
+	if filepath.Dir(closedFiles[0]) != filepath.Join(dir, "2017", "s1") {
+		t.Errorf("Unexpected directory for file %s", closedFiles[0])
+	}
+	if name := filepath.Base(closedFiles[0]); !strings.HasPrefix(name, "edge-") || !strings.HasSuffix(name, ".json") {
+		t.Errorf("Unexpected file name %s", name)
+	}
+	if lines := countLines(t, closedFiles[0], false); lines != 2 {
+		t.Errorf("Expected 2 records in the rolled file, but got %d", lines)
+	}
+	// the active files are flushed once the batch is written
+	for _, tmpFile := range tmpFiles {
+		if lines := countLines(t, tmpFile, false); lines != 1 {
+			t.Errorf("Expected 1 record in the active file, but got %d", lines)
+		}
+	}
+
+	events := stageContext.getEvents()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, but got %d", len(events))
+	}
+	if eventType := events[0].GetHeader().GetAttributes()[common.EVENT_TYPE_ATTRIBUTE]; eventType != FILE_CLOSED_EVENT {
+		t.Errorf("Unexpected event type %s", eventType)
+	}
+	if filePath, _ := events[0].Get("/" + FILE_PATH_FIELD); filePath.Value != closedFiles[0] {
+		t.Errorf("Unexpected file path in event: %v", filePath.Value)
+	}
+
+	stageInstance.Destroy()
+	closedFiles, tmpFiles = listFiles(t, dir)
+	if len(closedFiles) != 3 || len(tmpFiles) != 0 {
+		t.Errorf("Expected all files to be closed on destroy, but got %v and %v", closedFiles, tmpFiles)
+	}
+	if len(stageContext.getEvents()) != 3 {
+		t.Errorf("Expected 3 events, but got %d", len(stageContext.getEvents()))
+	}
+}
+
+func TestLocalFileSystemDestination_MaxFileSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stageContext := getStageContext(map[string]interface{}{
+		"configs.dirPathTemplate": dir,
+		"configs.maxFileSize":     float64(10) / MEGABYTE,
+	})
+	stageInstance := createStage(t, stageContext)
+	defer stageInstance.Destroy()
+
+	records := []api.Record{
+		createRecord(t, stageContext, "s1", map[string]interface{}{"value": "first record"}),
+		createRecord(t, stageContext, "s1", map[string]interface{}{"value": "second record"}),
+	}
+	if err = stageInstance.Write(runner.NewBatchImpl("localfs", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+
+	closedFiles, tmpFiles := listFiles(t, dir)
+	if len(closedFiles) != 2 || len(tmpFiles) != 0 {
+		t.Errorf("Expected every record to roll the file, but got %v and %v", closedFiles, tmpFiles)
+	}
+}
+
+func TestLocalFileSystemDestination_IdleTimeoutAndCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stageContext := getStageContext(map[string]interface{}{
+		"configs.dirPathTemplate": dir,
+		"configs.idleTimeout":     float64(0.1),
+		"configs.compression":     COMPRESSION_GZIP,
+	})
+	stageInstance := createStage(t, stageContext)
+	defer stageInstance.Destroy()
+
+	records := []api.Record{
+		createRecord(t, stageContext, "s1", map[string]interface{}{"value": 1}),
+		createRecord(t, stageContext, "s1", map[string]interface{}{"value": 2}),
+	}
+	if err = stageInstance.Write(runner.NewBatchImpl("localfs", records, "offset")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stageContext.getEvents()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closedFiles, tmpFiles := listFiles(t, dir)
+	if len(closedFiles) != 1 || len(tmpFiles) != 0 {
+		t.Fatalf("Expected the idle file to be closed, but got %v and %v", closedFiles, tmpFiles)
+	}
+	if !strings.HasSuffix(closedFiles[0], GZIP_SUFFIX) {
+		t.Errorf("Expected a compressed file, but got %s", closedFiles[0])
+	}
+	if lines := countLines(t, closedFiles[0], true); lines != 2 {
+		t.Errorf("Expected 2 records in the compressed file, but got %d", lines)
+	}
+}
+
+func TestLocalFileSystemDestination_CompressedTextAndDelimited(t *testing.T) {
+	for _, dataFormat := range []string{"TEXT", "DELIMITED"} {
+		dir, err := ioutil.TempDir("", "localfs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		stageContext := getStageContext(map[string]interface{}{
+			"configs.dirPathTemplate": dir,
+			"configs.compression":     COMPRESSION_GZIP,
+			"configs.dataFormat":      dataFormat,
+		})
+		stageInstance := createStage(t, stageContext)
+
+		records := []api.Record{
+			createRecord(t, stageContext, "s1", map[string]interface{}{"text": "first record"}),
+			createRecord(t, stageContext, "s1", map[string]interface{}{"text": "second record"}),
+			createRecord(t, stageContext, "s1", map[string]interface{}{"text": "third record"}),
+		}
+		if err = stageInstance.Write(runner.NewBatchImpl("localfs", records, "offset")); err != nil {
+			t.Fatal(err)
+		}
+		stageInstance.Destroy()
+
+		closedFiles, tmpFiles := listFiles(t, dir)
+		if len(closedFiles) != 1 || len(tmpFiles) != 0 {
+			t.Fatalf("Expected one closed %s file, but got %v and %v", dataFormat, closedFiles, tmpFiles)
+		}
+		if lines := countLines(t, closedFiles[0], true); lines != 3 {
+			t.Errorf("Expected 3 records in the compressed %s file, but got %d", dataFormat, lines)
+		}
+	}
+}
+
+func TestLocalFileSystemDestination_RecoverTmpFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sensorDir := filepath.Join(dir, "s1")
+	if err := os.MkdirAll(sensorDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	leftoverFile := filepath.Join(sensorDir, TMP_FILE_PREFIX+DEFAULT_PREFIX+"-leftover")
+	if err := ioutil.WriteFile(leftoverFile, []byte("{\"value\":1}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherFile := filepath.Join(sensorDir, TMP_FILE_PREFIX+"other-file")
+	if err := ioutil.WriteFile(otherFile, []byte("{\"value\":2}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stageContext := getStageContext(map[string]interface{}{
+		"configs.dirPathTemplate": dir + "/${record:attribute('sensor')}",
+	})
+	stageInstance := createStage(t, stageContext)
+	defer stageInstance.Destroy()
+
+	if _, err := os.Stat(filepath.Join(sensorDir, DEFAULT_PREFIX+"-leftover")); err != nil {
+		t.Errorf("Expected the temporary file of the previous run to be renamed: %s", err)
+	}
+	if _, err := os.Stat(otherFile); err != nil {
+		t.Errorf("Expected the temporary file with another prefix to be left alone: %s", err)
+	}
+}
+
+func TestLocalFileSystemDestination_WholeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sourcePath := filepath.Join(dir, "source.txt")
+	if err := ioutil.WriteFile(sourcePath, []byte("The quick brown fox jumps over the lazy dog"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stageContext := getStageContext(map[string]interface{}{
+		"configs.dirPathTemplate":                                      dir + "/${record:attribute('sensor')}",
+		"configs.dataFormat":                                           wholefile.WHOLE_FILE,
+		"configs.dataGeneratorFormatConfig.fileNameEL":                 "${record:value('/fileInfo/filename')}",
+		"configs.dataGeneratorFormatConfig.includeChecksumInTheEvents": true,
+		"configs.dataGeneratorFormatConfig.checksumAlgorithm":          wholefile.MD5,
+	})
+	stageInstance := createStage(t, stageContext)
+	defer stageInstance.Destroy()
+
+	record, err := wholefile.CreateRecord(stageContext, sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record.GetHeader().SetAttribute("sensor", "s1")
+
+	if err = stageInstance.Write(runner.NewBatchImpl("localfs", []api.Record{record}, "offset")); err != nil {
+		t.Fatal(err)
+	}
+
+	targetPath := filepath.Join(dir, "s1", "source.txt")
+	if content, err := ioutil.ReadFile(targetPath); err != nil ||
+		string(content) != "The quick brown fox jumps over the lazy dog" {
+		t.Errorf("Unexpected content of whole file '%s': %s %v", targetPath, string(content), err)
+	}
+	if _, tmpFiles := listFiles(t, filepath.Join(dir, "s1")); len(tmpFiles) != 0 {
+		t.Errorf("Expected no temporary files, but got %v", tmpFiles)
+	}
+
+	events := stageContext.getEvents()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, but got %d", len(events))
+	}
+	attributes := events[0].GetHeader().GetAttributes()
+	if eventType := attributes[common.EVENT_TYPE_ATTRIBUTE]; eventType != wholefile.WHOLE_FILE_PROCESSED_EVENT {
+		t.Errorf("Unexpected event type %s", eventType)
+	}
+	if checksum, _ := events[0].Get("/" + wholefile.CHECKSUM); checksum == nil ||
+		checksum.Value != "9e107d9d372bb6826bd81d3542a419d6" {
+		t.Errorf("Unexpected checksum in event: %v", checksum)
+	}
+	if filePath, _ := events[0].Get("/" + wholefile.TARGET_FILE_INFO + "/" + FILE_PATH_FIELD); filePath == nil ||
+		filePath.Value != targetPath {
+		t.Errorf("Unexpected target file path in event: %v", filePath)
+	}
+	if size, _ := events[0].Get("/" + wholefile.SOURCE_FILE_INFO + "/" + wholefile.SIZE); size == nil ||
+		size.Value != int64(43) {
+		t.Errorf("Unexpected source file size in event: %v", size)
+	}
+
+	// the existing file is not overwritten by default
+	if err = stageInstance.Write(runner.NewBatchImpl("localfs", []api.Record{record}, "offset")); err != nil {
+		t.Fatal(err)
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 1 {
+		t.Errorf("Expected the record of the existing file to be sent to error")
+	}
+
+	stageInstance.Configs.DataGeneratorFormatConfig.WholeFileExistsAction = wholefile.OVERWRITE
+	if err = stageInstance.Write(runner.NewBatchImpl("localfs", []api.Record{record}, "offset")); err != nil {
+		t.Fatal(err)
+	}
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 1 || len(stageContext.getEvents()) != 2 {
+		t.Errorf("Expected the existing file to be overwritten")
+	}
+}
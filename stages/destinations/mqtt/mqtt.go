@@ -17,8 +17,12 @@ package mqtt
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/el"
 	"github.com/streamsets/datacollector-edge/container/recordio"
 	"github.com/streamsets/datacollector-edge/stages/lib/datagenerator"
 	mqttlib "github.com/streamsets/datacollector-edge/stages/lib/mqtt"
@@ -30,6 +34,7 @@ const (
 	LIBRARY          = "streamsets-datacollector-basic-lib"
 	STAGE_NAME       = "com_streamsets_pipeline_stage_destination_mqtt_MqttClientDTarget"
 	ERROR_STAGE_NAME = "com_streamsets_pipeline_stage_destination_mqtt_ToErrorMqttClientDTarget"
+	TOPIC            = "topic"
 )
 
 type MqttClientDestination struct {
@@ -40,8 +45,11 @@ type MqttClientDestination struct {
 	recordWriterFactory recordio.RecordWriterFactory
 }
 
+// MqttClientTargetConfigBean holds the publishing settings of the destination, Topic can be an expression
+// evaluated for every record, like ${record:value('/deviceId')}.
 type MqttClientTargetConfigBean struct {
-	Topic                     string                                  `ConfigDef:"type=STRING,required=true"`
+	Topic                     string                                  `ConfigDef:"type=STRING,required=true,evaluation=EXPLICIT"`
+	Retained                  bool                                    `ConfigDef:"type=BOOLEAN,required=false"`
 	DataFormat                string                                  `ConfigDef:"type=STRING,required=true"`
 	DataGeneratorFormatConfig datagenerator.DataGeneratorFormatConfig `ConfigDefBean:"dataGeneratorFormatConfig"`
 }
@@ -70,14 +78,56 @@ func (md *MqttClientDestination) Init(stageContext api.StageContext) error {
 	return md.PublisherConf.DataGeneratorFormatConfig.Init(md.PublisherConf.DataFormat)
 }
 
+// Write publishes one message per topic, holding the records of the batch sent to that topic
 func (md *MqttClientDestination) Write(batch api.Batch) error {
 	log.Println("[DEBUG] MqttClientDestination write method")
+	topics := make([]string, 0)
+	recordsByTopic := make(map[string][]api.Record)
+	for _, record := range batch.GetRecords() {
+		topic, err := md.getTopic(record)
+		if err != nil {
+			log.Println("[Error] Error Evaluating Topic", err)
+			md.GetStageContext().ToError(err, record)
+			continue
+		}
+		if _, ok := recordsByTopic[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		recordsByTopic[topic] = append(recordsByTopic[topic], record)
+	}
+
+	for _, topic := range topics {
+		md.publish(topic, recordsByTopic[topic])
+	}
+	return nil
+}
+
+func (md *MqttClientDestination) getTopic(record api.Record) (string, error) {
+	if !el.IsElString(md.PublisherConf.Topic) {
+		return md.PublisherConf.Topic, nil
+	}
+	recordContext := context.WithValue(context.Background(), el.RECORD_CONTEXT_VAR, record)
+	result, err := md.GetStageContext().Evaluate(md.PublisherConf.Topic, TOPIC, recordContext)
+	if err != nil {
+		return "", err
+	}
+	if result == nil {
+		result = ""
+	}
+	topic := fmt.Sprint(result)
+	if topic == "" {
+		return "", errors.New(fmt.Sprintf("Topic expression '%s' evaluated to an empty topic", md.PublisherConf.Topic))
+	}
+	return topic, nil
+}
+
+func (md *MqttClientDestination) publish(topic string, records []api.Record) {
 	var recordWriter recordio.RecordWriter = nil
 	nonErrorRecordsForWrite := make([]api.Record, 0)
 	recordValueBuffer := bytes.NewBuffer([]byte{})
 	var err error = nil
 	if recordWriter, err = md.PublisherConf.DataGeneratorFormatConfig.RecordWriterFactory.CreateWriter(md.GetStageContext(), recordValueBuffer); err == nil {
-		for _, record := range batch.GetRecords() {
+		for _, record := range records {
 			if err = recordWriter.WriteRecord(record); err != nil {
 				log.Println("[Error] Error Writing Record", err)
 				md.GetStageContext().ToError(err, record)
@@ -87,20 +137,19 @@ func (md *MqttClientDestination) Write(batch api.Batch) error {
 		}
 		if err = recordWriter.Close(); err == nil {
 			if tkn := md.Client.Publish(
-				md.PublisherConf.Topic,
+				topic,
 				byte(md.Qos),
-				false,
+				md.PublisherConf.Retained,
 				recordValueBuffer.Bytes(),
 			); tkn.Wait() && tkn.Error() != nil {
-				err = tkn.Error()
+				md.sendRecordsToError(nonErrorRecordsForWrite, tkn.Error())
 			}
 		} else {
 			md.sendRecordsToError(nonErrorRecordsForWrite, err)
 		}
 	} else {
-		md.sendRecordsToError(batch.GetRecords(), err)
+		md.sendRecordsToError(records, err)
 	}
-	return nil
 }
 
 func (md *MqttClientDestination) sendRecordsToError(records []api.Record, err error) {
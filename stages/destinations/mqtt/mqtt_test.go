@@ -16,8 +16,12 @@
 package mqtt
 
 import (
+	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"github.com/streamsets/datacollector-edge/stages/lib/mqtt/mqtttest"
+	"strings"
 	"testing"
 )
 
@@ -57,6 +61,7 @@ func getStageContext(
 	return &common.StageContextImpl{
 		StageConfig: stageConfig,
 		Parameters:  parameters,
+		ErrorSink:   common.NewErrorSink(),
 	}
 }
 
@@ -99,3 +104,103 @@ func TestMqttClientDestination_Init(t *testing.T) {
 		t.Error("Failed to inject config value for dataFormat")
 	}
 }
+
+func TestMqttClientDestination_Write(t *testing.T) {
+	broker, err := mqtttest.NewBroker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	stageContext := getStageContext(
+		broker.Url(),
+		"clientId",
+		"AT_LEAST_ONCE",
+		"${record:value('/deviceId')}",
+		"JSON",
+		nil,
+	)
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: "publisherConf.retained", Value: true},
+	)
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage
+	if err = stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	defer stageInstance.Destroy()
+	if connectPackets := broker.GetConnectPackets(); !connectPackets[0].CleanSession {
+		t.Error("Expected the destination to connect with a clean session")
+	}
+
+	records := make([]api.Record, 3)
+	records[0], _ = stageContext.CreateRecord("1", map[string]interface{}{"deviceId": "a", "value": 1})
+	records[1], _ = stageContext.CreateRecord("2", map[string]interface{}{"deviceId": "b", "value": 2})
+	records[2], _ = stageContext.CreateRecord("3", map[string]interface{}{"deviceId": "a", "value": 3})
+	batch := runner.NewBatchImpl("mqtt", records, "offset")
+	if err = stageInstance.(api.Destination).Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	messageA := broker.GetRetainedMessage("a")
+	if messageA == nil {
+		t.Fatal("Expected a retained message for topic a")
+	}
+	payloadA := string(messageA.Payload)
+	if strings.Count(payloadA, "deviceId") != 2 || !strings.Contains(payloadA, "3") {
+		t.Errorf("Unexpected payload for topic a: %s", payloadA)
+	}
+
+	messageB := broker.GetRetainedMessage("b")
+	if messageB == nil {
+		t.Fatal("Expected a retained message for topic b")
+	}
+	if payloadB := string(messageB.Payload); strings.Count(payloadB, "deviceId") != 1 {
+		t.Errorf("Unexpected payload for topic b: %s", payloadB)
+	}
+
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 0 {
+		t.Errorf("Expected no error records, but got %d", stageContext.ErrorSink.GetTotalErrorRecords())
+	}
+}
+
+func TestMqttClientDestination_WriteInvalidTopic(t *testing.T) {
+	broker, err := mqtttest.NewBroker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	stageContext := getStageContext(
+		broker.Url(),
+		"clientId",
+		"AT_MOST_ONCE",
+		"${record:value('/deviceId')}",
+		"JSON",
+		nil,
+	)
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage
+	if err = stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	defer stageInstance.Destroy()
+
+	records := make([]api.Record, 1)
+	records[0], _ = stageContext.CreateRecord("1", map[string]interface{}{"deviceId": "", "value": 1})
+	batch := runner.NewBatchImpl("mqtt", records, "offset")
+	if err = stageInstance.(api.Destination).Write(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if stageContext.ErrorSink.GetTotalErrorRecords() != 1 {
+		t.Errorf("Expected 1 error record, but got %d", stageContext.ErrorSink.GetTotalErrorRecords())
+	}
+}
@@ -18,9 +18,12 @@ package datagenerator
 import (
 	"errors"
 	"github.com/streamsets/datacollector-edge/container/recordio"
+	"github.com/streamsets/datacollector-edge/container/recordio/avrorecord"
+	"github.com/streamsets/datacollector-edge/container/recordio/csvrecord"
 	"github.com/streamsets/datacollector-edge/container/recordio/jsonrecord"
 	"github.com/streamsets/datacollector-edge/container/recordio/sdcrecord"
 	"github.com/streamsets/datacollector-edge/container/recordio/textrecord"
+	"github.com/streamsets/datacollector-edge/stages/lib/wholefile"
 )
 
 type DataGeneratorFormatConfig struct {
@@ -52,8 +55,9 @@ type DataGeneratorFormatConfig struct {
 	SchemaRegistryUrlsForRegistration []string `ConfigDef:"type=LIST,required=true"`
 	SchemaRegistryUrls                []string `ConfigDef:"type=LIST,required=true"`
 	SchemaLookupMode                  string   `ConfigDef:"type=STRING,required=true"`
+	Subject                           string   `ConfigDef:"type=STRING,required=true"`
 	SubjectToRegister                 string   `ConfigDef:"type=STRING,required=true"`
-	SchemaId                          float64  `ConfigDef:"type=STRING,required=true"`
+	SchemaId                          float64  `ConfigDef:"type=NUMBER,required=true"`
 	IncludeSchema                     bool     `ConfigDef:"type=BOOLEAN,required=true"`
 	AvroCompression                   string   `ConfigDef:"type=STRING,required=true"`
 
@@ -65,7 +69,7 @@ type DataGeneratorFormatConfig struct {
 	MessageType         string `ConfigDef:"type=STRING,required=true"`
 
 	/** For Whole File Content **/
-	FileNameEL                 string `ConfigDef:"type=STRING,required=true"`
+	FileNameEL                 string `ConfigDef:"type=STRING,required=true,evaluation=EXPLICIT"`
 	WholeFileExistsAction      string `ConfigDef:"type=STRING,required=true"`
 	IncludeChecksumInTheEvents bool   `ConfigDef:"type=BOOLEAN,required=true"`
 	ChecksumAlgorithm          string `ConfigDef:"type=STRING,required=true"`
@@ -87,6 +91,64 @@ func (d *DataGeneratorFormatConfig) Init(dataFormat string) error {
 		d.RecordWriterFactory = &jsonrecord.JsonWriterFactoryImpl{}
 	case "SDC_JSON":
 		d.RecordWriterFactory = &sdcrecord.SDCRecordWriterFactoryImpl{}
+	case "DELIMITED":
+		csvFormat, err := csvrecord.NewCsvFormat(
+			d.CsvFileFormat,
+			d.CsvCustomDelimiter,
+			d.CsvCustomEscape,
+			d.CsvCustomQuote,
+		)
+		if err != nil {
+			return err
+		}
+		d.RecordWriterFactory, err = csvrecord.NewCsvWriterFactory(
+			csvFormat,
+			d.CsvHeader,
+			d.CsvReplaceNewLines,
+			d.CsvReplaceNewLinesString,
+		)
+		if err != nil {
+			return err
+		}
+	case "AVRO":
+		avroConfig := avrorecord.AvroWriterConfig{
+			SchemaSource:      d.AvroSchemaSource,
+			Schema:            d.AvroSchema,
+			IncludeSchema:     d.IncludeSchema,
+			Compression:       d.AvroCompression,
+			LookupMode:        d.SchemaLookupMode,
+			Subject:           d.Subject,
+			SchemaId:          int(d.SchemaId),
+			RegisterSchema:    d.RegisterSchema,
+			SubjectToRegister: d.SubjectToRegister,
+		}
+		if len(d.SchemaRegistryUrls) > 0 {
+			avroConfig.SchemaRegistry = avrorecord.NewSchemaRegistryClient(d.SchemaRegistryUrls)
+		}
+		if len(d.SchemaRegistryUrlsForRegistration) > 0 {
+			avroConfig.RegistrationSchemaRegistry = avrorecord.NewSchemaRegistryClient(
+				d.SchemaRegistryUrlsForRegistration,
+			)
+		}
+		var err error
+		if d.RecordWriterFactory, err = avrorecord.NewAvroWriterFactory(avroConfig); err != nil {
+			return err
+		}
+	case wholefile.WHOLE_FILE:
+		// whole files are streamed by the destinations, there is no record writer
+		switch d.WholeFileExistsAction {
+		case "":
+			d.WholeFileExistsAction = wholefile.TO_ERROR
+		case wholefile.TO_ERROR, wholefile.OVERWRITE:
+		default:
+			return errors.New("Unsupported Whole File Exists Action - " + d.WholeFileExistsAction)
+		}
+		if d.IncludeChecksumInTheEvents {
+			if d.ChecksumAlgorithm == "" {
+				d.ChecksumAlgorithm = wholefile.MD5
+			}
+			return wholefile.ValidateChecksumAlgorithm(d.ChecksumAlgorithm)
+		}
 	default:
 		return errors.New("Unsupported Data Format - " + dataFormat)
 	}
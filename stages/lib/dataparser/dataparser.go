@@ -0,0 +1,89 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package dataparser
+
+import (
+	"errors"
+	"github.com/streamsets/datacollector-edge/container/recordio"
+	"github.com/streamsets/datacollector-edge/container/recordio/avrorecord"
+	"github.com/streamsets/datacollector-edge/container/recordio/csvrecord"
+	"github.com/streamsets/datacollector-edge/container/recordio/jsonrecord"
+	"github.com/streamsets/datacollector-edge/container/recordio/sdcrecord"
+	"github.com/streamsets/datacollector-edge/container/recordio/textrecord"
+)
+
+type DataParserFormatConfig struct {
+	/** For DELIMITED Content **/
+	CsvFileFormat      string `ConfigDef:"type=STRING,required=true"`
+	CsvHeader          string `ConfigDef:"type=STRING,required=true"`
+	CsvCustomDelimiter string `ConfigDef:"type=STRING,required=true"`
+	CsvCustomEscape    string `ConfigDef:"type=STRING,required=true"`
+	CsvCustomQuote     string `ConfigDef:"type=STRING,required=true"`
+	CsvRecordType      string `ConfigDef:"type=STRING,required=true"`
+
+	/** For AVRO Content **/
+	AvroSchemaSource   string   `ConfigDef:"type=STRING,required=true"`
+	AvroSchema         string   `ConfigDef:"type=STRING,required=true"`
+	SchemaRegistryUrls []string `ConfigDef:"type=LIST,required=true"`
+	SchemaLookupMode   string   `ConfigDef:"type=STRING,required=true"`
+	Subject            string   `ConfigDef:"type=STRING,required=true"`
+	SchemaId           float64  `ConfigDef:"type=NUMBER,required=true"`
+
+	RecordReaderFactory recordio.RecordReaderFactory
+}
+
+func (d *DataParserFormatConfig) Init(dataFormat string) error {
+	switch dataFormat {
+	case "TEXT":
+		d.RecordReaderFactory = &textrecord.TextReaderFactoryImpl{}
+	case "JSON":
+		d.RecordReaderFactory = &jsonrecord.JsonReaderFactoryImpl{}
+	case "SDC_JSON":
+		d.RecordReaderFactory = &sdcrecord.SDCRecordReaderFactoryImpl{}
+	case "DELIMITED":
+		csvFormat, err := csvrecord.NewCsvFormat(
+			d.CsvFileFormat,
+			d.CsvCustomDelimiter,
+			d.CsvCustomEscape,
+			d.CsvCustomQuote,
+		)
+		if err != nil {
+			return err
+		}
+		d.RecordReaderFactory, err = csvrecord.NewCsvReaderFactory(csvFormat, d.CsvHeader, d.CsvRecordType)
+		if err != nil {
+			return err
+		}
+	case "AVRO":
+		avroConfig := avrorecord.AvroReaderConfig{
+			SchemaSource: d.AvroSchemaSource,
+			Schema:       d.AvroSchema,
+			LookupMode:   d.SchemaLookupMode,
+			Subject:      d.Subject,
+			SchemaId:     int(d.SchemaId),
+		}
+		if len(d.SchemaRegistryUrls) > 0 {
+			avroConfig.SchemaRegistry = avrorecord.NewSchemaRegistryClient(d.SchemaRegistryUrls)
+		}
+		var err error
+		if d.RecordReaderFactory, err = avrorecord.NewAvroReaderFactory(avroConfig); err != nil {
+			return err
+		}
+	default:
+		return errors.New("Unsupported Data Format - " + dataFormat)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpcommon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/stages/lib/tlsconfig"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AUTH_NONE   = "NONE"
+	AUTH_BASIC  = "BASIC"
+	AUTH_BEARER = "BEARER"
+
+	CLIENT_CREDENTIALS = "CLIENT_CREDENTIALS"
+
+	HEADER_AUTHORIZATION = "Authorization"
+	BEARER_PREFIX        = "Bearer "
+
+	// tokens are refreshed a bit before they expire
+	TOKEN_EXPIRY_MARGIN = 10 * time.Second
+	MAX_ERROR_BODY_SIZE = 1024
+)
+
+// ClientConfigBean holds the settings of the HTTP client shared by the HTTP stages. Timeouts are in
+// milliseconds, 0 means no timeout. The read timeout bounds the wait for the response headers, so that
+// large request bodies can still be streamed.
+type ClientConfigBean struct {
+	HttpCompression      string                  `ConfigDef:"type=STRING,required=false"`
+	ConnectTimeoutMillis float64                 `ConfigDef:"type=NUMBER,required=false"`
+	ReadTimeoutMillis    float64                 `ConfigDef:"type=NUMBER,required=false"`
+	AuthType             string                  `ConfigDef:"type=STRING,required=false"`
+	BasicAuth            BasicAuthConfigBean     `ConfigDefBean:"basicAuth"`
+	BearerAuth           BearerAuthConfigBean    `ConfigDefBean:"bearerAuth"`
+	UseOAuth2            bool                    `ConfigDef:"type=BOOLEAN,required=false"`
+	OAuth2               OAuth2ConfigBean        `ConfigDefBean:"oauth2"`
+	UseProxy             bool                    `ConfigDef:"type=BOOLEAN,required=false"`
+	Proxy                ProxyConfigBean         `ConfigDefBean:"proxy"`
+	TlsConfig            tlsconfig.TlsConfigBean `ConfigDefBean:"tlsConfig"`
+}
+
+type BasicAuthConfigBean struct {
+	Username string `ConfigDef:"type=STRING,required=false"`
+	Password string `ConfigDef:"type=STRING,required=false"`
+}
+
+type BearerAuthConfigBean struct {
+	Token string `ConfigDef:"type=STRING,required=false"`
+}
+
+// OAuth2ConfigBean holds the settings of the OAuth2 client credentials grant, the access token is requested
+// from TokenUrl and sent as bearer token until it expires
+type OAuth2ConfigBean struct {
+	CredentialsGrantType string            `ConfigDef:"type=STRING,required=false"`
+	TokenUrl             string            `ConfigDef:"type=STRING,required=false"`
+	ClientId             string            `ConfigDef:"type=STRING,required=false"`
+	ClientSecret         string            `ConfigDef:"type=STRING,required=false"`
+	AdditionalValues     map[string]string `ConfigDef:"type=MAP,required=false"`
+}
+
+type ProxyConfigBean struct {
+	Uri      string `ConfigDef:"type=STRING,required=false"`
+	Username string `ConfigDef:"type=STRING,required=false"`
+	Password string `ConfigDef:"type=STRING,required=false"`
+}
+
+// Client sends the requests of the HTTP stages with the configured authentication
+type Client struct {
+	config     *ClientConfigBean
+	httpClient *http.Client
+	token      *oauth2Token
+	tokenMutex sync.Mutex
+}
+
+type oauth2Token struct {
+	AccessToken string  `json:"access_token"`
+	TokenType   string  `json:"token_type"`
+	ExpiresIn   float64 `json:"expires_in"`
+	expiry      time.Time
+}
+
+// NewClient validates the client settings and returns the client using them
+func (c *ClientConfigBean) NewClient() (*Client, error) {
+	switch c.AuthType {
+	case "":
+		c.AuthType = AUTH_NONE
+	case AUTH_NONE, AUTH_BASIC, AUTH_BEARER:
+	default:
+		return nil, errors.New(fmt.Sprintf("Unsupported authentication type: %s", c.AuthType))
+	}
+	if c.UseOAuth2 {
+		if c.OAuth2.CredentialsGrantType != "" && c.OAuth2.CredentialsGrantType != CLIENT_CREDENTIALS {
+			return nil, errors.New(fmt.Sprintf("Unsupported OAuth2 grant type: %s", c.OAuth2.CredentialsGrantType))
+		}
+		if len(c.OAuth2.TokenUrl) == 0 {
+			return nil, errors.New("OAuth2 requires a token URL")
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   time.Duration(c.ConnectTimeoutMillis) * time.Millisecond,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: time.Duration(c.ReadTimeoutMillis) * time.Millisecond,
+	}
+
+	if c.UseProxy {
+		proxyUrl, err := url.Parse(c.Proxy.Uri)
+		if err != nil || len(proxyUrl.Host) == 0 {
+			return nil, errors.New(fmt.Sprintf("Invalid proxy URI: %s", c.Proxy.Uri))
+		}
+		if len(c.Proxy.Username) > 0 {
+			proxyUrl.User = url.UserPassword(c.Proxy.Username, c.Proxy.Password)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	if c.TlsConfig.TlsEnabled {
+		tlsConfig, err := c.TlsConfig.NewClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &Client{config: c, httpClient: &http.Client{Transport: transport}}, nil
+}
+
+// Do sends the request with the authentication header, an OAuth2 access token is requested when there is
+// none or it expired, and dropped when the server rejects it
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	switch c.config.AuthType {
+	case AUTH_BASIC:
+		req.SetBasicAuth(c.config.BasicAuth.Username, c.config.BasicAuth.Password)
+	case AUTH_BEARER:
+		req.Header.Set(HEADER_AUTHORIZATION, BEARER_PREFIX+c.config.BearerAuth.Token)
+	}
+
+	if c.config.UseOAuth2 {
+		accessToken, err := c.getAccessToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(HEADER_AUTHORIZATION, BEARER_PREFIX+accessToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized && c.config.UseOAuth2 {
+		c.tokenMutex.Lock()
+		c.token = nil
+		c.tokenMutex.Unlock()
+	}
+	return resp, err
+}
+
+func (c *Client) getAccessToken() (string, error) {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	if c.token != nil && (c.token.expiry.IsZero() || time.Now().Before(c.token.expiry)) {
+		return c.token.AccessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	for key, value := range c.config.OAuth2.AdditionalValues {
+		form.Set(key, value)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.config.OAuth2.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.config.OAuth2.ClientId), url.QueryEscape(c.config.OAuth2.ClientSecret))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf(
+			"Failed to get OAuth2 access token from '%s', status '%s': %s",
+			c.config.OAuth2.TokenUrl,
+			resp.Status,
+			ReadErrorBody(resp.Body),
+		))
+	}
+
+	token := &oauth2Token{}
+	if err = json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return "", err
+	}
+	if len(token.AccessToken) == 0 {
+		return "", errors.New(fmt.Sprintf("No access token returned by '%s'", c.config.OAuth2.TokenUrl))
+	}
+	if token.ExpiresIn > 0 {
+		token.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - TOKEN_EXPIRY_MARGIN)
+	}
+	log.Printf("[DEBUG] Got OAuth2 access token from '%s'", c.config.OAuth2.TokenUrl)
+	c.token = token
+	return token.AccessToken, nil
+}
+
+// ResponseError is the error of a request which failed because of its response, Action is the action of the
+// response status code which stopped the retries
+type ResponseError struct {
+	StatusCode int
+	Action     string
+	message    string
+}
+
+func (r *ResponseError) Error() string {
+	return r.message
+}
+
+// DoWithRetries sends the request until it succeeds or the action of the response status code stops retrying,
+// newRequest is called for every attempt so that the request body is sent again. The body of the successful
+// response is closed by the caller, failed responses return a ResponseError. Closing stop interrupts the wait
+// before the next retry with an error which is not a ResponseError, so that the request is not handled as failed
+// by its response.
+func (c *Client) DoWithRetries(
+	newRequest func() (*http.Request, error),
+	responseActions []HttpResponseActionConfigBean,
+	stop <-chan struct{},
+) (*http.Response, error) {
+	for retries := 0; ; retries++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("[DEBUG] response Status:", resp.Status)
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		errorBody := ReadErrorBody(resp.Body)
+		resp.Body.Close()
+		responseAction := GetResponseAction(resp.StatusCode, responseActions)
+		responseError := &ResponseError{
+			StatusCode: resp.StatusCode,
+			Action:     responseAction.Action,
+			message: fmt.Sprintf(
+				"%s request to '%s' failed with status '%s': %s",
+				req.Method,
+				req.URL,
+				resp.Status,
+				errorBody,
+			),
+		}
+		if !responseAction.IsRetry(retries) {
+			return nil, responseError
+		}
+		delay := responseAction.GetRetryDelay(retries, resp)
+		log.Printf("[WARN] %s, retrying in %s", responseError.Error(), delay)
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return nil, errors.New(fmt.Sprintf("Stopped retrying, %s", responseError.Error()))
+		}
+	}
+}
+
+// ReadErrorBody returns the beginning of a response body, to be included in error messages
+func ReadErrorBody(body io.Reader) string {
+	data, _ := ioutil.ReadAll(io.LimitReader(body, MAX_ERROR_BODY_SIZE))
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpcommon
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/stages/lib/tlsconfig"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetResponseAction(t *testing.T) {
+	configuredActions := []HttpResponseActionConfigBean{
+		{StatusCode: 503, Action: RETRY_LINEAR_BACKOFF, MaxNumRetries: 2, BackoffInterval: 100},
+		{StatusCode: 404, Action: STAGE_ERROR},
+	}
+	if err := ValidateResponseActions(configuredActions); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateResponseActions([]HttpResponseActionConfigBean{{StatusCode: 500, Action: "IGNORE"}}); err == nil {
+		t.Error("Expected an error for an unsupported action")
+	}
+
+	defaultRetries := []HttpResponseActionConfigBean{{StatusCode: 503, Action: RETRY_IMMEDIATELY}}
+	if err := ValidateResponseActions(defaultRetries); err != nil {
+		t.Fatal(err)
+	}
+	if !defaultRetries[0].IsRetry(DEFAULT_MAX_NUM_RETRIES-1) || defaultRetries[0].IsRetry(DEFAULT_MAX_NUM_RETRIES) {
+		t.Errorf("Expected %d retries by default, but got %v", DEFAULT_MAX_NUM_RETRIES, defaultRetries[0].MaxNumRetries)
+	}
+	expectedActions := map[int]string{
+		503: RETRY_LINEAR_BACKOFF,
+		404: STAGE_ERROR,
+		500: RETRY_EXPONENTIAL_BACKOFF,
+		429: RETRY_EXPONENTIAL_BACKOFF,
+		400: ERROR_RECORD,
+		302: ERROR_RECORD,
+	}
+	for statusCode, expectedAction := range expectedActions {
+		if action := GetResponseAction(statusCode, configuredActions).Action; action != expectedAction {
+			t.Errorf("Expected action %s for status code %d, but got %s", expectedAction, statusCode, action)
+		}
+	}
+
+	linearAction := GetResponseAction(503, configuredActions)
+	if !linearAction.IsRetry(1) || linearAction.IsRetry(2) {
+		t.Error("Expected 2 retries for the configured action")
+	}
+	if delay := linearAction.GetRetryDelay(1, nil); delay != 200*time.Millisecond {
+		t.Errorf("Expected linear backoff of 200ms, but got %s", delay)
+	}
+	exponentialAction := GetResponseAction(500, configuredActions)
+	if delay := exponentialAction.GetRetryDelay(2, nil); delay != 4*DEFAULT_BACKOFF_INTERVAL*time.Millisecond {
+		t.Errorf("Expected exponential backoff of 4s, but got %s", delay)
+	}
+	if GetResponseAction(400, configuredActions).IsRetry(0) {
+		t.Error("Expected no retry for error records")
+	}
+
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set(HEADER_RETRY_AFTER, "7")
+	if delay := exponentialAction.GetRetryDelay(0, resp); delay != 7*time.Second {
+		t.Errorf("Expected Retry-After delay of 7s, but got %s", delay)
+	}
+	resp.Header.Set(HEADER_RETRY_AFTER, time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	if delay := exponentialAction.GetRetryDelay(0, resp); delay != 0 {
+		t.Errorf("Expected no delay for a past Retry-After date, but got %s", delay)
+	}
+	resp.Header.Set(HEADER_RETRY_AFTER, "86400")
+	if delay := exponentialAction.GetRetryDelay(0, resp); delay != MAX_RETRY_DELAY {
+		t.Errorf("Expected Retry-After delay capped at %s, but got %s", MAX_RETRY_DELAY, delay)
+	}
+	if delay := exponentialAction.GetRetryDelay(40, nil); delay != MAX_RETRY_DELAY {
+		t.Errorf("Expected exponential backoff capped at %s, but got %s", MAX_RETRY_DELAY, delay)
+	}
+	resp.Header.Set(HEADER_RETRY_AFTER, "soon")
+	if delay := exponentialAction.GetRetryDelay(0, resp); delay != DEFAULT_BACKOFF_INTERVAL*time.Millisecond {
+		t.Errorf("Expected backoff delay for an invalid Retry-After, but got %s", delay)
+	}
+}
+
+func TestClient_Auth(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get(HEADER_AUTHORIZATION)
+	}))
+	defer server.Close()
+
+	configs := map[string]ClientConfigBean{
+		"": {},
+		"Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret")): {
+			AuthType:  AUTH_BASIC,
+			BasicAuth: BasicAuthConfigBean{Username: "user", Password: "secret"},
+		},
+		"Bearer token1": {
+			AuthType:   AUTH_BEARER,
+			BearerAuth: BearerAuthConfigBean{Token: "token1"},
+		},
+	}
+	for expectedAuthorization, config := range configs {
+		client, err := config.NewClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if authorization != expectedAuthorization {
+			t.Errorf("Expected authorization '%s', but got '%s'", expectedAuthorization, authorization)
+		}
+	}
+
+	invalidConfigs := []ClientConfigBean{
+		{AuthType: "DIGEST"},
+		{UseOAuth2: true},
+		{UseOAuth2: true, OAuth2: OAuth2ConfigBean{TokenUrl: server.URL, CredentialsGrantType: "JWT"}},
+		{UseProxy: true, Proxy: ProxyConfigBean{Uri: "no proxy"}},
+		{TlsConfig: tlsconfig.TlsConfigBean{TlsEnabled: true, TrustStoreFilePath: "/does/not/exist.pem"}},
+	}
+	for _, invalidConfig := range invalidConfigs {
+		if _, err := invalidConfig.NewClient(); err == nil {
+			t.Errorf("Expected an error for invalid configuration %+v", invalidConfig)
+		}
+	}
+}
+
+func TestClient_OAuth2(t *testing.T) {
+	var tokenRequests int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequest := atomic.AddInt32(&tokenRequests, 1)
+		clientId, clientSecret, _ := r.BasicAuth()
+		r.ParseForm()
+		if clientId != "edge" || clientSecret != "secret" || r.Form.Get("grant_type") != "client_credentials" ||
+			r.Form.Get("scope") != "write" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token%d","token_type":"bearer","expires_in":3600}`, tokenRequest)
+	}))
+	defer tokenServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first token is revoked
+		if r.Header.Get(HEADER_AUTHORIZATION) != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	config := ClientConfigBean{
+		UseOAuth2: true,
+		OAuth2: OAuth2ConfigBean{
+			CredentialsGrantType: CLIENT_CREDENTIALS,
+			TokenUrl:             tokenServer.URL,
+			ClientId:             "edge",
+			ClientSecret:         "secret",
+			AdditionalValues:     map[string]string{"scope": "write"},
+		},
+	}
+	client, err := config.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedStatusCodes := []int{http.StatusUnauthorized, http.StatusOK, http.StatusOK}
+	for i, expectedStatusCode := range expectedStatusCodes {
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("data"))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expectedStatusCode {
+			t.Errorf("Expected status %d for request %d, but got %d", expectedStatusCode, i, resp.StatusCode)
+		}
+	}
+	if tokenRequests != 2 {
+		t.Errorf("Expected the token to be requested again once rejected, but got %d token requests", tokenRequests)
+	}
+
+	config.OAuth2.ClientSecret = "wrong"
+	client, _ = config.NewClient()
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("data"))
+	if _, err := client.Do(req); err == nil {
+		t.Error("Expected an error for invalid client credentials")
+	}
+}
+
+func TestClient_ProxyAndTimeouts(t *testing.T) {
+	var proxiedHost, proxyAuthorization string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost = r.URL.Host
+		proxyAuthorization = r.Header.Get("Proxy-Authorization")
+	}))
+	defer proxy.Close()
+
+	client, err := (&ClientConfigBean{
+		UseProxy: true,
+		Proxy:    ProxyConfigBean{Uri: proxy.URL, Username: "proxyUser", Password: "proxyPassword"},
+	}).NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://remote.example.com:8080/data", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxiedHost != "remote.example.com:8080" {
+		t.Errorf("Expected the request to go through the proxy, but proxy got host '%s'", proxiedHost)
+	}
+	if proxyAuthorization != "Basic "+base64.StdEncoding.EncodeToString([]byte("proxyUser:proxyPassword")) {
+		t.Errorf("Unexpected proxy authorization '%s'", proxyAuthorization)
+	}
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slowServer.Close()
+
+	client, _ = (&ClientConfigBean{ReadTimeoutMillis: 50}).NewClient()
+	req, _ = http.NewRequest(http.MethodGet, slowServer.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("Expected the read timeout to expire")
+	}
+}
+
+func TestClient_DoWithRetriesStop(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set(HEADER_RETRY_AFTER, "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := (&ClientConfigBean{}).NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() { close(stop) })
+
+	start := time.Now()
+	_, err = client.DoWithRetries(func() (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, server.URL, nil)
+	}, nil, stop)
+	if err == nil {
+		t.Fatal("Expected the request to fail once stopped")
+	}
+	if _, ok := err.(*ResponseError); ok {
+		t.Errorf("Expected the stop not to be reported as a response error, but got %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the retry wait to be interrupted, but it took %s", elapsed)
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Expected 1 request, but got %d", atomic.LoadInt32(&requests))
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpcommon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RETRY_IMMEDIATELY         = "RETRY_IMMEDIATELY"
+	RETRY_LINEAR_BACKOFF      = "RETRY_LINEAR_BACKOFF"
+	RETRY_EXPONENTIAL_BACKOFF = "RETRY_EXPONENTIAL_BACKOFF"
+	ERROR_RECORD              = "ERROR_RECORD"
+	STAGE_ERROR               = "STAGE_ERROR"
+
+	HEADER_RETRY_AFTER = "Retry-After"
+
+	DEFAULT_MAX_NUM_RETRIES  = 3
+	DEFAULT_BACKOFF_INTERVAL = 1000
+
+	// the delay before a retry never exceeds this limit, whatever the server requests in Retry-After
+	MAX_RETRY_DELAY = 5 * time.Minute
+)
+
+// HttpResponseActionConfigBean tells what to do with the responses of a status code, the backoff interval is
+// in milliseconds
+type HttpResponseActionConfigBean struct {
+	StatusCode      float64 `ConfigDef:"type=NUMBER,required=true"`
+	Action          string  `ConfigDef:"type=STRING,required=true"`
+	MaxNumRetries   float64 `ConfigDef:"type=NUMBER,required=false"`
+	BackoffInterval float64 `ConfigDef:"type=NUMBER,required=false"`
+}
+
+// ValidateResponseActions returns an error when an action is not supported, retry actions without a number
+// of retries get the default one
+func ValidateResponseActions(responseActions []HttpResponseActionConfigBean) error {
+	for i, responseAction := range responseActions {
+		switch responseAction.Action {
+		case RETRY_IMMEDIATELY, RETRY_LINEAR_BACKOFF, RETRY_EXPONENTIAL_BACKOFF:
+			if responseAction.MaxNumRetries <= 0 {
+				responseActions[i].MaxNumRetries = DEFAULT_MAX_NUM_RETRIES
+			}
+		case ERROR_RECORD, STAGE_ERROR:
+		default:
+			return errors.New(fmt.Sprintf(
+				"Unsupported action '%s' for status code %d",
+				responseAction.Action,
+				int(responseAction.StatusCode),
+			))
+		}
+	}
+	return nil
+}
+
+// GetResponseAction returns the action configured for the status code of a failed response. Without one,
+// 429 and 5xx responses are retried with exponential backoff and the other ones produce error records.
+func GetResponseAction(
+	statusCode int,
+	responseActions []HttpResponseActionConfigBean,
+) HttpResponseActionConfigBean {
+	for _, responseAction := range responseActions {
+		if int(responseAction.StatusCode) == statusCode {
+			return responseAction
+		}
+	}
+	responseAction := HttpResponseActionConfigBean{
+		StatusCode:      float64(statusCode),
+		Action:          ERROR_RECORD,
+		MaxNumRetries:   DEFAULT_MAX_NUM_RETRIES,
+		BackoffInterval: DEFAULT_BACKOFF_INTERVAL,
+	}
+	if statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError {
+		responseAction.Action = RETRY_EXPONENTIAL_BACKOFF
+	}
+	return responseAction
+}
+
+// IsRetry tells whether the request is retried after the given number of retries
+func (r HttpResponseActionConfigBean) IsRetry(retries int) bool {
+	switch r.Action {
+	case RETRY_IMMEDIATELY, RETRY_LINEAR_BACKOFF, RETRY_EXPONENTIAL_BACKOFF:
+		return retries < int(r.MaxNumRetries)
+	}
+	return false
+}
+
+// GetRetryDelay returns the delay before the next retry, the delay requested by the server in the
+// Retry-After header of the response takes precedence over the backoff of the action. The delay is capped
+// at MAX_RETRY_DELAY.
+func (r HttpResponseActionConfigBean) GetRetryDelay(retries int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get(HEADER_RETRY_AFTER)); ok {
+			return capRetryDelay(delay)
+		}
+	}
+	backoff := time.Duration(r.BackoffInterval) * time.Millisecond
+	switch r.Action {
+	case RETRY_LINEAR_BACKOFF:
+		return capRetryDelay(backoff * time.Duration(retries+1))
+	case RETRY_EXPONENTIAL_BACKOFF:
+		if retries >= 32 {
+			return MAX_RETRY_DELAY
+		}
+		return capRetryDelay(backoff * time.Duration(1<<uint(retries)))
+	}
+	return 0
+}
+
+func capRetryDelay(delay time.Duration) time.Duration {
+	if delay > MAX_RETRY_DELAY || delay < 0 {
+		return MAX_RETRY_DELAY
+	}
+	return delay
+}
+
+func parseRetryAfter(retryAfter string) (time.Duration, bool) {
+	retryAfter = strings.TrimSpace(retryAfter)
+	if len(retryAfter) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int64(MAX_RETRY_DELAY/time.Second) {
+			return MAX_RETRY_DELAY, true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if retryTime, err := http.ParseTime(retryAfter); err == nil {
+		delay := time.Until(retryTime)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
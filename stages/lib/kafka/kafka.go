@@ -0,0 +1,157 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/streamsets/datacollector-edge/stages/lib/tlsconfig"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CLIENT_ID               = "client.id"
+	SECURITY_PROTOCOL       = "security.protocol"
+	SASL_MECHANISM          = "sasl.mechanism"
+	SASL_USERNAME           = "sasl.username"
+	SASL_PASSWORD           = "sasl.password"
+	SSL_TRUSTSTORE_LOCATION = "ssl.truststore.location"
+	SSL_KEYSTORE_LOCATION   = "ssl.keystore.location"
+	SSL_KEY_LOCATION        = "ssl.key.location"
+	REQUEST_TIMEOUT_MS      = "request.timeout.ms"
+	KAFKA_VERSION           = "kafka.version"
+
+	PLAINTEXT      = "PLAINTEXT"
+	SSL            = "SSL"
+	SASL_PLAINTEXT = "SASL_PLAINTEXT"
+	SASL_SSL       = "SASL_SSL"
+	SASL_PLAIN     = "PLAIN"
+
+	DEFAULT_CLIENT_ID = "sdc-edge"
+)
+
+// NewClientConfig returns the client config of the Kafka stages, built from the Kafka client properties
+// shared by producers and consumers. The properties handled by the calling stage are passed in ignoredKeys,
+// the other unsupported properties are logged and ignored.
+//
+// Brokers with TLS take PEM encoded files in ssl.truststore.location, ssl.keystore.location and
+// ssl.key.location, SASL/PLAIN credentials are set with sasl.username and sasl.password.
+func NewClientConfig(kafkaConfigs map[string]string, ignoredKeys ...string) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = DEFAULT_CLIENT_ID
+
+	ignored := make(map[string]bool, len(ignoredKeys))
+	for _, key := range ignoredKeys {
+		ignored[key] = true
+	}
+
+	tlsConfigBean := tlsconfig.TlsConfigBean{}
+	for key, value := range kafkaConfigs {
+		var err error
+		switch key {
+		case CLIENT_ID:
+			config.ClientID = value
+		case SECURITY_PROTOCOL:
+			switch strings.ToUpper(value) {
+			case PLAINTEXT:
+			case SSL:
+				config.Net.TLS.Enable = true
+			case SASL_PLAINTEXT:
+				config.Net.SASL.Enable = true
+			case SASL_SSL:
+				config.Net.TLS.Enable = true
+				config.Net.SASL.Enable = true
+			default:
+				err = errors.New(fmt.Sprintf("Unsupported security protocol: %s", value))
+			}
+		case SASL_MECHANISM:
+			if strings.ToUpper(value) != SASL_PLAIN {
+				err = errors.New(fmt.Sprintf("Unsupported SASL mechanism: %s", value))
+			}
+		case SASL_USERNAME:
+			config.Net.SASL.User = value
+		case SASL_PASSWORD:
+			config.Net.SASL.Password = value
+		case SSL_TRUSTSTORE_LOCATION:
+			tlsConfigBean.TrustStoreFilePath = value
+		case SSL_KEYSTORE_LOCATION:
+			tlsConfigBean.KeyStoreFilePath = value
+		case SSL_KEY_LOCATION:
+			tlsConfigBean.PrivateKeyFilePath = value
+		case REQUEST_TIMEOUT_MS:
+			var timeout time.Duration
+			if timeout, err = GetDurationMillis(key, value); err == nil {
+				config.Net.ReadTimeout = timeout
+				config.Net.WriteTimeout = timeout
+			}
+		case KAFKA_VERSION:
+			if value = strings.TrimSpace(value); len(value) == 0 {
+				err = errors.New("Kafka version must not be empty")
+			} else {
+				config.Version, err = sarama.ParseKafkaVersion(value)
+			}
+		default:
+			if !ignored[key] {
+				log.Printf("[WARN] Ignoring unsupported Kafka configuration '%s'", key)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Net.TLS.Enable {
+		tlsConfig, err := tlsConfigBean.NewClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Config = tlsConfig
+	}
+	return config, nil
+}
+
+// GetBrokerList returns the addresses of a comma separated host:port list
+func GetBrokerList(metadataBrokerList string) []string {
+	brokerList := make([]string, 0)
+	for _, broker := range strings.Split(metadataBrokerList, ",") {
+		if broker = strings.TrimSpace(broker); len(broker) > 0 {
+			brokerList = append(brokerList, broker)
+		}
+	}
+	return brokerList
+}
+
+// GetInt returns the integer value of a Kafka configuration
+func GetInt(key string, value string) (int, error) {
+	intValue, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("Invalid value '%s' for Kafka configuration '%s'", value, key))
+	}
+	return intValue, nil
+}
+
+// GetDurationMillis returns the duration of a Kafka configuration expressed in milliseconds
+func GetDurationMillis(key string, value string) (time.Duration, error) {
+	millis, err := GetInt(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(millis) * time.Millisecond, nil
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"testing"
+	"time"
+)
+
+func TestNewClientConfig(t *testing.T) {
+	config, err := NewClientConfig(map[string]string{
+		CLIENT_ID:          "edge",
+		SECURITY_PROTOCOL:  SASL_SSL,
+		SASL_MECHANISM:     "plain",
+		SASL_USERNAME:      "user",
+		SASL_PASSWORD:      "password",
+		REQUEST_TIMEOUT_MS: "5000",
+		KAFKA_VERSION:      "0.10.2.0",
+		"acks":             "all",
+	}, "acks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ClientID != "edge" {
+		t.Errorf("Expected client id edge, but got %s", config.ClientID)
+	}
+	if !config.Net.TLS.Enable || config.Net.TLS.Config == nil {
+		t.Error("Expected TLS to be enabled")
+	}
+	if !config.Net.SASL.Enable || config.Net.SASL.User != "user" || config.Net.SASL.Password != "password" {
+		t.Error("Expected SASL to be enabled with the configured credentials")
+	}
+	if config.Net.ReadTimeout != 5*time.Second || config.Net.WriteTimeout != 5*time.Second {
+		t.Errorf("Expected a timeout of 5s, but got %v", config.Net.ReadTimeout)
+	}
+	if config.Version != sarama.V0_10_2_0 {
+		t.Errorf("Expected version 0.10.2.0, but got %s", config.Version)
+	}
+
+	config, err = NewClientConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientID != DEFAULT_CLIENT_ID || config.Net.TLS.Enable || config.Net.SASL.Enable {
+		t.Error("Unexpected default configuration")
+	}
+}
+
+func TestNewClientConfig_Invalid(t *testing.T) {
+	invalidConfigs := []map[string]string{
+		{SECURITY_PROTOCOL: "KERBEROS"},
+		{SASL_MECHANISM: "GSSAPI"},
+		{REQUEST_TIMEOUT_MS: "1s"},
+		{KAFKA_VERSION: "latest"},
+		{KAFKA_VERSION: ""},
+		{SECURITY_PROTOCOL: SSL, SSL_TRUSTSTORE_LOCATION: "/no/such/file.pem"},
+	}
+	for _, invalidConfig := range invalidConfigs {
+		if _, err := NewClientConfig(invalidConfig); err == nil {
+			t.Errorf("Expected an error for %v", invalidConfig)
+		}
+	}
+}
+
+func TestGetBrokerList(t *testing.T) {
+	brokerList := GetBrokerList(" broker1:9092, broker2:9092,,")
+	if len(brokerList) != 2 || brokerList[0] != "broker1:9092" || brokerList[1] != "broker2:9092" {
+		t.Errorf("Unexpected broker list: %v", brokerList)
+	}
+}
@@ -18,6 +18,8 @@ package mqtt
 import (
 	"errors"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/streamsets/datacollector-edge/stages/lib/tlsconfig"
+	"time"
 )
 
 const (
@@ -26,10 +28,23 @@ const (
 	ExactlyOnce = "EXACTLY_ONCE"
 )
 
+// MqttClientConfigBean holds the connection settings shared by the MQTT origin and destination.
+// KeepAlive is in seconds, the client default applies when it is not set. CleanSession is only applied by the
+// origin, the destination always connects with a clean session.
 type MqttClientConfigBean struct {
-	BrokerUrl string `ConfigDef:"type=STRING,required=true"`
-	ClientId  string `ConfigDef:"type=STRING,required=true"`
-	Qos       string `ConfigDef:"type=STRING,required=true"`
+	BrokerUrl        string                  `ConfigDef:"type=STRING,required=true"`
+	ClientId         string                  `ConfigDef:"type=STRING,required=true"`
+	Qos              string                  `ConfigDef:"type=STRING,required=true"`
+	KeepAlive        float64                 `ConfigDef:"type=NUMBER,required=false"`
+	CleanSession     bool                    `ConfigDef:"type=BOOLEAN,required=false"`
+	UseAuth          bool                    `ConfigDef:"type=BOOLEAN,required=false"`
+	Username         string                  `ConfigDef:"type=STRING,required=false"`
+	Password         string                  `ConfigDef:"type=STRING,required=false"`
+	LastWillTopic    string                  `ConfigDef:"type=STRING,required=false"`
+	LastWillPayload  string                  `ConfigDef:"type=STRING,required=false"`
+	LastWillQos      string                  `ConfigDef:"type=STRING,required=false"`
+	LastWillRetained bool                    `ConfigDef:"type=BOOLEAN,required=false"`
+	TlsConfig        tlsconfig.TlsConfigBean `ConfigDefBean:"tlsConfig"`
 }
 
 type MqttConnector struct {
@@ -38,11 +53,24 @@ type MqttConnector struct {
 }
 
 func (m *MqttConnector) InitializeClient(commonConf MqttClientConfigBean) error {
+	return m.InitializeClientWithOptions(commonConf, func(opts *MQTT.ClientOptions) {})
+}
+
+// InitializeClientWithOptions connects the client, letting the stage adjust the client options built from
+// the common config before connecting.
+func (m *MqttConnector) InitializeClientWithOptions(
+	commonConf MqttClientConfigBean,
+	setOptions func(opts *MQTT.ClientOptions),
+) error {
 	var err error
-	if m.Qos, err = m.getQosFromString(commonConf.Qos); err != nil {
+	if m.Qos, err = m.GetQosFromString(commonConf.Qos); err != nil {
+		return err
+	}
+	opts, err := m.newClientOptions(commonConf)
+	if err != nil {
 		return err
 	}
-	opts := MQTT.NewClientOptions().AddBroker(commonConf.BrokerUrl).SetClientID(commonConf.ClientId)
+	setOptions(opts)
 	m.Client = MQTT.NewClient(opts)
 	if token := m.Client.Connect(); token.Wait() && token.Error() != nil {
 		return token.Error()
@@ -50,7 +78,47 @@ func (m *MqttConnector) InitializeClient(commonConf MqttClientConfigBean) error
 	return nil
 }
 
-func (m *MqttConnector) getQosFromString(qosString string) (float64, error) {
+func (m *MqttConnector) newClientOptions(commonConf MqttClientConfigBean) (*MQTT.ClientOptions, error) {
+	opts := MQTT.NewClientOptions().
+		AddBroker(commonConf.BrokerUrl).
+		SetClientID(commonConf.ClientId)
+
+	if commonConf.KeepAlive > 0 {
+		opts.SetKeepAlive(time.Duration(commonConf.KeepAlive * float64(time.Second)))
+	}
+
+	if commonConf.UseAuth {
+		opts.SetUsername(commonConf.Username).SetPassword(commonConf.Password)
+	}
+
+	if commonConf.LastWillTopic != "" {
+		lastWillQos := float64(0)
+		if commonConf.LastWillQos != "" {
+			var err error
+			if lastWillQos, err = m.GetQosFromString(commonConf.LastWillQos); err != nil {
+				return nil, err
+			}
+		}
+		opts.SetWill(
+			commonConf.LastWillTopic,
+			commonConf.LastWillPayload,
+			byte(lastWillQos),
+			commonConf.LastWillRetained,
+		)
+	}
+
+	if commonConf.TlsConfig.TlsEnabled {
+		tlsConfig, err := commonConf.TlsConfig.NewClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return opts, nil
+}
+
+// GetQosFromString returns the QoS level of the given AT_MOST_ONCE, AT_LEAST_ONCE or EXACTLY_ONCE value
+func (m *MqttConnector) GetQosFromString(qosString string) (float64, error) {
 	switch qosString {
 	case AtMostOnce:
 		return float64(0), nil
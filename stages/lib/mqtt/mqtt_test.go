@@ -0,0 +1,119 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package mqtt
+
+import (
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/streamsets/datacollector-edge/stages/lib/mqtt/mqtttest"
+	"testing"
+	"time"
+)
+
+func TestMqttConnector_InitializeClient(t *testing.T) {
+	broker, err := mqtttest.NewBroker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+	broker.Username = "edge"
+	broker.Password = "secret"
+
+	commonConf := MqttClientConfigBean{
+		BrokerUrl:        broker.Url(),
+		ClientId:         "edgeClient",
+		Qos:              AtLeastOnce,
+		KeepAlive:        10,
+		CleanSession:     false,
+		UseAuth:          true,
+		Username:         "edge",
+		Password:         "invalid",
+		LastWillTopic:    "edge/status",
+		LastWillPayload:  "offline",
+		LastWillQos:      AtLeastOnce,
+		LastWillRetained: true,
+	}
+
+	mqttConnector := &MqttConnector{}
+	if err = mqttConnector.InitializeClient(commonConf); err == nil {
+		t.Fatal("Expected the connection with invalid credentials to fail")
+	}
+
+	commonConf.Password = "secret"
+	if err = mqttConnector.InitializeClient(commonConf); err != nil {
+		t.Fatal(err)
+	}
+
+	connectPackets := broker.GetConnectPackets()
+	if len(connectPackets) != 1 {
+		t.Fatalf("Expected 1 connection, but got %d", len(connectPackets))
+	}
+	connectPacket := connectPackets[0]
+	if connectPacket.ClientIdentifier != "edgeClient" || !connectPacket.CleanSession ||
+		connectPacket.Keepalive != 10 || connectPacket.Username != "edge" {
+		t.Errorf("Unexpected connection settings: %s", connectPacket.String())
+	}
+	if !connectPacket.WillFlag || connectPacket.WillTopic != "edge/status" ||
+		string(connectPacket.WillMessage) != "offline" || connectPacket.WillQos != 1 || !connectPacket.WillRetain {
+		t.Errorf("Unexpected last will: %s", connectPacket.String())
+	}
+
+	// last will is published when the connection is lost
+	broker.DropClients()
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.GetRetainedMessage("edge/status") == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lastWill := broker.GetRetainedMessage("edge/status"); lastWill == nil || string(lastWill.Payload) != "offline" {
+		t.Errorf("Expected the last will to be published, but got %v", lastWill)
+	}
+	mqttConnector.Client.Disconnect(0)
+}
+
+func TestMqttConnector_InitializeClientWithOptions(t *testing.T) {
+	broker, err := mqtttest.NewBroker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	mqttConnector := &MqttConnector{}
+	err = mqttConnector.InitializeClientWithOptions(
+		MqttClientConfigBean{BrokerUrl: broker.Url(), ClientId: "edgeClient", Qos: ExactlyOnce},
+		func(opts *MQTT.ClientOptions) {
+			opts.SetClientID("overridden").SetCleanSession(false)
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mqttConnector.Client.Disconnect(0)
+
+	if mqttConnector.Qos != 2 {
+		t.Errorf("Expected QoS 2, but got %v", mqttConnector.Qos)
+	}
+	if connectPackets := broker.GetConnectPackets(); connectPackets[0].ClientIdentifier != "overridden" ||
+		connectPackets[0].CleanSession {
+		t.Errorf("Unexpected connection settings: %s", connectPackets[0].String())
+	}
+}
+
+func TestMqttConnector_InvalidQos(t *testing.T) {
+	mqttConnector := &MqttConnector{}
+	err := mqttConnector.InitializeClient(MqttClientConfigBean{BrokerUrl: "tcp://localhost:1", Qos: "INVALID"})
+	if err == nil {
+		t.Error("Expected an error for an unsupported QoS")
+	}
+}
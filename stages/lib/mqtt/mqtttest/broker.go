@@ -0,0 +1,302 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package mqtttest provides a minimal MQTT 3.1.1 broker for the tests of the MQTT stages.
+package mqtttest
+
+import (
+	"crypto/tls"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Broker routes the published messages to the matching subscriptions of the connected clients, keeps the
+// retained messages and publishes the last will of the clients that disconnect without a DISCONNECT packet.
+// Sessions are not persisted, whatever the clean session flag of the clients.
+type Broker struct {
+	// Username and Password, when set, are required from the clients
+	Username     string
+	Password     string
+	listener     net.Listener
+	scheme       string
+	mutex        sync.Mutex
+	clients      map[*brokerClient]bool
+	connects     []*packets.ConnectPacket
+	retained     map[string]*packets.PublishPacket
+	acknowledged int
+}
+
+type brokerClient struct {
+	broker        *Broker
+	conn          net.Conn
+	writeMutex    sync.Mutex
+	subscriptions map[string]byte
+	will          *packets.PublishPacket
+	nextMessageId uint16
+}
+
+// NewBroker starts a broker listening on a random local port, over TLS when a TLS config is given
+func NewBroker(tlsConfig *tls.Config) (*Broker, error) {
+	var listener net.Listener
+	var err error
+	scheme := "tcp"
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+		scheme = "ssl"
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		return nil, err
+	}
+	broker := &Broker{
+		listener: listener,
+		scheme:   scheme,
+		clients:  make(map[*brokerClient]bool),
+		retained: make(map[string]*packets.PublishPacket),
+	}
+	go broker.accept()
+	return broker, nil
+}
+
+// Url returns the broker URL the clients connect to
+func (b *Broker) Url() string {
+	return b.scheme + "://" + b.listener.Addr().String()
+}
+
+// Close stops the broker and drops the connections of all the clients, without publishing their last will
+func (b *Broker) Close() {
+	b.listener.Close()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for client := range b.clients {
+		client.will = nil
+		client.conn.Close()
+	}
+}
+
+// DropClients closes the connections of all the clients as if they were lost, publishing their last will
+func (b *Broker) DropClients() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for client := range b.clients {
+		client.conn.Close()
+	}
+}
+
+// GetConnectPackets returns the CONNECT packets received so far
+func (b *Broker) GetConnectPackets() []*packets.ConnectPacket {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]*packets.ConnectPacket{}, b.connects...)
+}
+
+// GetRetainedMessage returns the message retained for the topic, nil if there is none
+func (b *Broker) GetRetainedMessage(topic string) *packets.PublishPacket {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.retained[topic]
+}
+
+// GetAcknowledgedCount returns the number of QoS 1 and 2 messages the subscribers acknowledged
+func (b *Broker) GetAcknowledgedCount() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.acknowledged
+}
+
+func (b *Broker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		client := &brokerClient{broker: b, conn: conn, subscriptions: make(map[string]byte)}
+		go client.run()
+	}
+}
+
+func (b *Broker) publish(publish *packets.PublishPacket) {
+	b.mutex.Lock()
+	if publish.Retain {
+		if len(publish.Payload) == 0 {
+			delete(b.retained, publish.TopicName)
+		} else {
+			b.retained[publish.TopicName] = publish.Copy()
+		}
+	}
+	subscribers := make(map[*brokerClient]byte)
+	for client := range b.clients {
+		for topicFilter, qos := range client.subscriptions {
+			if matchTopic(topicFilter, publish.TopicName) {
+				subscribers[client] = qos
+			}
+		}
+	}
+	b.mutex.Unlock()
+
+	for client, qos := range subscribers {
+		// the retain flag is only kept for the retained messages sent on subscription
+		client.send(publish, qos, false)
+	}
+}
+
+func (c *brokerClient) run() {
+	b := c.broker
+	disconnected := false
+	defer func() {
+		c.conn.Close()
+		b.mutex.Lock()
+		delete(b.clients, c)
+		b.mutex.Unlock()
+		if !disconnected && c.will != nil {
+			b.publish(c.will)
+		}
+	}()
+
+	for {
+		controlPacket, err := packets.ReadPacket(c.conn)
+		if err != nil {
+			return
+		}
+		switch packet := controlPacket.(type) {
+		case *packets.ConnectPacket:
+			connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			if (b.Username != "" || b.Password != "") &&
+				(packet.Username != b.Username || string(packet.Password) != b.Password) {
+				connack.ReturnCode = packets.ErrRefusedNotAuthorised
+				c.write(connack)
+				return
+			}
+			b.mutex.Lock()
+			b.connects = append(b.connects, packet)
+			b.clients[c] = true
+			b.mutex.Unlock()
+			if packet.WillFlag {
+				c.will = packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				c.will.TopicName = packet.WillTopic
+				c.will.Payload = packet.WillMessage
+				c.will.Qos = packet.WillQos
+				c.will.Retain = packet.WillRetain
+			}
+			c.write(connack)
+		case *packets.SubscribePacket:
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = packet.MessageID
+			suback.ReturnCodes = packet.Qoss
+			retained := make(map[*packets.PublishPacket]byte)
+			b.mutex.Lock()
+			for i, topicFilter := range packet.Topics {
+				c.subscriptions[topicFilter] = packet.Qoss[i]
+				for topic, publish := range b.retained {
+					if matchTopic(topicFilter, topic) {
+						retained[publish] = packet.Qoss[i]
+					}
+				}
+			}
+			b.mutex.Unlock()
+			c.write(suback)
+			for publish, qos := range retained {
+				c.send(publish, qos, true)
+			}
+		case *packets.UnsubscribePacket:
+			b.mutex.Lock()
+			for _, topicFilter := range packet.Topics {
+				delete(c.subscriptions, topicFilter)
+			}
+			b.mutex.Unlock()
+			unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			unsuback.MessageID = packet.MessageID
+			c.write(unsuback)
+		case *packets.PublishPacket:
+			// deliver before acknowledging, so a published message is visible once the publisher is acked
+			b.publish(packet)
+			switch packet.Qos {
+			case 1:
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = packet.MessageID
+				c.write(puback)
+			case 2:
+				pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				pubrec.MessageID = packet.MessageID
+				c.write(pubrec)
+			}
+		case *packets.PubrelPacket:
+			pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			pubcomp.MessageID = packet.MessageID
+			c.write(pubcomp)
+		case *packets.PubackPacket:
+			b.mutex.Lock()
+			b.acknowledged++
+			b.mutex.Unlock()
+		case *packets.PubrecPacket:
+			b.mutex.Lock()
+			b.acknowledged++
+			b.mutex.Unlock()
+			pubrel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+			pubrel.MessageID = packet.MessageID
+			c.write(pubrel)
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			disconnected = true
+			return
+		}
+	}
+}
+
+// send delivers the message to the client with the lowest of the message and subscription QoS
+func (c *brokerClient) send(publish *packets.PublishPacket, subscriptionQos byte, retain bool) {
+	packet := publish.Copy()
+	packet.Qos = publish.Qos
+	if subscriptionQos < packet.Qos {
+		packet.Qos = subscriptionQos
+	}
+	packet.Retain = retain
+	if packet.Qos > 0 {
+		c.writeMutex.Lock()
+		c.nextMessageId++
+		if c.nextMessageId == 0 {
+			c.nextMessageId++
+		}
+		packet.MessageID = c.nextMessageId
+		c.writeMutex.Unlock()
+	}
+	c.write(packet)
+}
+
+func (c *brokerClient) write(packet packets.ControlPacket) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	packet.Write(c.conn)
+}
+
+// matchTopic tells whether the topic name matches the topic filter, supporting the + and # wildcards
+func matchTopic(topicFilter string, topicName string) bool {
+	filterLevels := strings.Split(topicFilter, "/")
+	topicLevels := strings.Split(topicName, "/")
+	for i, filterLevel := range filterLevels {
+		if filterLevel == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (filterLevel != "+" && filterLevel != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
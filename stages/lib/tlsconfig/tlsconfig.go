@@ -0,0 +1,90 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// TlsConfigBean holds the PEM encoded files stages use to set up TLS connections.
+// KeyStoreFilePath holds the certificate chain of the stage, PrivateKeyFilePath its private key and
+// TrustStoreFilePath the CA certificates trusted to verify the peers.
+type TlsConfigBean struct {
+	TlsEnabled         bool   `ConfigDef:"type=BOOLEAN,required=true"`
+	KeyStoreFilePath   string `ConfigDef:"type=STRING,required=false"`
+	PrivateKeyFilePath string `ConfigDef:"type=STRING,required=false"`
+	TrustStoreFilePath string `ConfigDef:"type=STRING,required=false"`
+}
+
+// NewServerConfig returns the TLS config of a server stage, a trust store enables client certificate
+// authentication.
+func (t *TlsConfigBean) NewServerConfig() (*tls.Config, error) {
+	if len(t.KeyStoreFilePath) == 0 || len(t.PrivateKeyFilePath) == 0 {
+		return nil, errors.New("TLS requires a key store file and a private key file")
+	}
+	certificate, err := tls.LoadX509KeyPair(t.KeyStoreFilePath, t.PrivateKeyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if len(t.TrustStoreFilePath) > 0 {
+		if tlsConfig.ClientCAs, err = t.loadTrustStore(); err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// NewClientConfig returns the TLS config of a client stage, the system CA certificates are trusted when no
+// trust store is set and a key store enables client certificate authentication.
+func (t *TlsConfigBean) NewClientConfig() (*tls.Config, error) {
+	var err error
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(t.TrustStoreFilePath) > 0 {
+		if tlsConfig.RootCAs, err = t.loadTrustStore(); err != nil {
+			return nil, err
+		}
+	}
+	if len(t.KeyStoreFilePath) > 0 {
+		certificate, err := tls.LoadX509KeyPair(t.KeyStoreFilePath, t.PrivateKeyFilePath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+func (t *TlsConfigBean) loadTrustStore() (*x509.CertPool, error) {
+	trustedCertificates, err := ioutil.ReadFile(t.TrustStoreFilePath)
+	if err != nil {
+		return nil, err
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(trustedCertificates) {
+		return nil, errors.New("No valid certificates found in trust store file " + t.TrustStoreFilePath)
+	}
+	return certPool, nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createCertificateFiles writes a self signed certificate for 127.0.0.1 and its private key to the given directory
+func createCertificateFiles(t *testing.T, dir string) (string, string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTlsConfigBean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestTlsConfigBean")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := createCertificateFiles(t, dir)
+
+	// mutual TLS, both sides use the same self signed certificate
+	tlsConfigBean := TlsConfigBean{
+		TlsEnabled:         true,
+		KeyStoreFilePath:   certFile,
+		PrivateKeyFilePath: keyFile,
+		TrustStoreFilePath: certFile,
+	}
+	serverConfig, err := tlsConfigBean.NewServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := tlsConfigBean.NewClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, len(r.TLS.PeerCertificates))
+	}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "1" {
+		t.Errorf("Expected the client certificate to be verified, but got %s", string(body))
+	}
+
+	// client without a certificate is rejected
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: clientConfig.RootCAs}}}
+	if _, err = client.Get(server.URL); err == nil {
+		t.Error("Expected the request without client certificate to fail")
+	}
+}
+
+func TestTlsConfigBean_InvalidConfig(t *testing.T) {
+	tlsConfigBean := TlsConfigBean{TlsEnabled: true}
+	if _, err := tlsConfigBean.NewServerConfig(); err == nil {
+		t.Error("Expected an error for a server without key store")
+	}
+
+	tlsConfigBean.TrustStoreFilePath = "/does/not/exist.pem"
+	if _, err := tlsConfigBean.NewClientConfig(); err == nil {
+		t.Error("Expected an error for a missing trust store")
+	}
+}
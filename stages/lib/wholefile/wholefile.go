@@ -0,0 +1,196 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package wholefile
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"github.com/streamsets/datacollector-edge/container/util"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	WHOLE_FILE = "WHOLE_FILE"
+
+	FILE_REF_FIELD_PATH  = "/fileRef"
+	FILE_INFO_FIELD_PATH = "/fileInfo"
+	FILE_REF             = "fileRef"
+	FILE_INFO            = "fileInfo"
+	FILE                 = "file"
+	FILE_NAME            = "filename"
+	SIZE                 = "size"
+	LAST_MODIFIED_TIME   = "lastModifiedTime"
+	PERMISSIONS          = "permissions"
+
+	MD5    = "MD5"
+	SHA1   = "SHA1"
+	SHA256 = "SHA256"
+	SHA512 = "SHA512"
+
+	TO_ERROR  = "TO_ERROR"
+	OVERWRITE = "OVERWRITE"
+
+	WHOLE_FILE_PROCESSED_EVENT         = "wholeFileProcessed"
+	WHOLE_FILE_PROCESSED_EVENT_VERSION = 1
+	SOURCE_FILE_INFO                   = "sourceFileInfo"
+	TARGET_FILE_INFO                   = "targetFileInfo"
+	CHECKSUM                           = "checksum"
+	CHECKSUM_ALGORITHM                 = "checksumAlgorithm"
+)
+
+// LocalFileRef references a file of the local file system
+type LocalFileRef struct {
+	filePath string
+}
+
+func NewLocalFileRef(filePath string) *LocalFileRef {
+	return &LocalFileRef{filePath: filePath}
+}
+
+func (f *LocalFileRef) GetReader() (io.ReadCloser, error) {
+	return os.Open(f.filePath)
+}
+
+func (f *LocalFileRef) GetFilePath() string {
+	return f.filePath
+}
+
+// CreateRecord returns the whole file record of a local file, the record holds the file reference in /fileRef
+// and the file metadata in /fileInfo
+func CreateRecord(stageContext api.StageContext, filePath string) (api.Record, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.IsDir() {
+		return nil, errors.New(fmt.Sprintf("'%s' is a directory", filePath))
+	}
+	return stageContext.CreateRecord(filePath, map[string]interface{}{
+		FILE_REF: NewLocalFileRef(filePath),
+		FILE_INFO: map[string]interface{}{
+			FILE:               filePath,
+			FILE_NAME:          filepath.Base(filePath),
+			SIZE:               fileInfo.Size(),
+			LAST_MODIFIED_TIME: util.ConvertTimeToLong(fileInfo.ModTime()),
+			PERMISSIONS:        fileInfo.Mode().Perm().String(),
+		},
+	})
+}
+
+// GetFileRef returns the file reference of a whole file record
+func GetFileRef(record api.Record) (api.FileRef, error) {
+	field, err := record.Get(FILE_REF_FIELD_PATH)
+	if err != nil {
+		return nil, err
+	}
+	if field == nil || field.Type != fieldtype.FILE_REF {
+		return nil, errors.New(fmt.Sprintf("Record '%s' is not a whole file record, '%s' must be a file reference",
+			record.GetHeader().GetSourceId(), FILE_REF_FIELD_PATH))
+	}
+	return field.Value.(api.FileRef), nil
+}
+
+// ValidateChecksumAlgorithm returns an error when the checksum algorithm is not supported
+func ValidateChecksumAlgorithm(algorithm string) error {
+	_, err := newHash(algorithm)
+	return err
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case MD5:
+		return md5.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported checksum algorithm: %s", algorithm))
+}
+
+// ChecksumReader computes the checksum of the content read through it
+type ChecksumReader struct {
+	reader    io.Reader
+	hash      hash.Hash
+	algorithm string
+}
+
+func NewChecksumReader(reader io.Reader, algorithm string) (*ChecksumReader, error) {
+	h, err := newHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &ChecksumReader{reader: io.TeeReader(reader, h), hash: h, algorithm: strings.ToUpper(algorithm)}, nil
+}
+
+func (c *ChecksumReader) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// GetChecksum returns the hex encoded checksum of the content read so far
+func (c *ChecksumReader) GetChecksum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+func (c *ChecksumReader) GetAlgorithm() string {
+	return c.algorithm
+}
+
+// ToWholeFileProcessedEvent produces the event of a whole file written by a destination, the event holds the
+// file metadata of the record, the target information and the checksum when checksumReader is not nil
+func ToWholeFileProcessedEvent(
+	stageContext api.StageContext,
+	record api.Record,
+	targetFileInfo map[string]interface{},
+	checksumReader *ChecksumReader,
+) error {
+	value := map[string]interface{}{
+		TARGET_FILE_INFO: targetFileInfo,
+	}
+	if checksumReader != nil {
+		value[CHECKSUM] = checksumReader.GetChecksum()
+		value[CHECKSUM_ALGORITHM] = checksumReader.GetAlgorithm()
+	}
+	eventRecord, err := stageContext.CreateEventRecord(
+		record.GetHeader().GetSourceId(),
+		value,
+		WHOLE_FILE_PROCESSED_EVENT,
+		WHOLE_FILE_PROCESSED_EVENT_VERSION,
+	)
+	if err != nil {
+		return err
+	}
+	if fileInfo, err := record.Get(FILE_INFO_FIELD_PATH); err == nil && fileInfo != nil {
+		if _, err = eventRecord.SetField("/"+SOURCE_FILE_INFO, fileInfo.Clone()); err != nil {
+			return err
+		}
+	}
+	stageContext.ToEvent(eventRecord)
+	return nil
+}
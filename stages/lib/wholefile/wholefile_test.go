@@ -0,0 +1,105 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package wholefile
+
+import (
+	"bytes"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumReader(t *testing.T) {
+	checksums := map[string]string{
+		MD5:    "9e107d9d372bb6826bd81d3542a419d6",
+		SHA1:   "2fd4e1c67a2d28fced849ee1bb76e7391b93eb12",
+		SHA256: "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592",
+	}
+	for algorithm, expected := range checksums {
+		checksumReader, err := NewChecksumReader(
+			bytes.NewBufferString("The quick brown fox jumps over the lazy dog"),
+			algorithm,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(checksumReader); err != nil {
+			t.Fatal(err)
+		}
+		if checksumReader.GetChecksum() != expected {
+			t.Errorf("Expected %s checksum '%s', but received: %s", algorithm, expected, checksumReader.GetChecksum())
+		}
+	}
+
+	if _, err := NewChecksumReader(bytes.NewBufferString(""), "CRC32"); err == nil {
+		t.Error("Expected an error for an unsupported checksum algorithm")
+	}
+}
+
+func TestCreateRecord(t *testing.T) {
+	testDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	filePath := filepath.Join(testDir, "data.bin")
+	if err := ioutil.WriteFile(filePath, []byte("content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	stageContext := &common.StageContextImpl{StageConfig: common.StageConfiguration{InstanceName: "spooler"}}
+	record, err := CreateRecord(stageContext, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileRef, err := GetFileRef(record.Clone())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileRef.(*LocalFileRef).GetFilePath() != filePath {
+		t.Errorf("Expected file reference to '%s', but received: %s", filePath, fileRef.(*LocalFileRef).GetFilePath())
+	}
+
+	fileInfo, _ := record.Get(FILE_INFO_FIELD_PATH)
+	if fileInfo == nil || fileInfo.Type != fieldtype.MAP {
+		t.Fatalf("Expected file information map, but received: %v", fileInfo)
+	}
+	fileInfoFields := fileInfo.Value.(map[string]*api.Field)
+	if fileInfoFields[FILE_NAME].Value.(string) != "data.bin" {
+		t.Errorf("Expected file name 'data.bin', but received: %v", fileInfoFields[FILE_NAME].Value)
+	}
+	if fileInfoFields[SIZE].Value.(int64) != 7 {
+		t.Errorf("Expected size 7, but received: %v", fileInfoFields[SIZE].Value)
+	}
+	if fileInfoFields[PERMISSIONS].Value.(string) != "-rw-r-----" {
+		t.Errorf("Expected permissions '-rw-r-----', but received: %v", fileInfoFields[PERMISSIONS].Value)
+	}
+
+	if _, err := CreateRecord(stageContext, testDir); err == nil {
+		t.Error("Expected an error for a directory")
+	}
+
+	textRecord, _ := stageContext.CreateRecord("text", "content")
+	if _, err := GetFileRef(textRecord); err == nil {
+		t.Error("Expected an error for a record without file reference")
+	}
+}
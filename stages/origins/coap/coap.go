@@ -0,0 +1,286 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package coap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/dustin/go-coap"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/stages/lib/dataparser"
+	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LIBRARY                    = "streamsets-datacollector-basic-lib"
+	STAGE_NAME                 = "com_streamsets_pipeline_stage_origin_coapserver_CoapServerDPushSource"
+	DEFAULT_MAX_WAIT_TIME_SECS = 1
+)
+
+// optionNames holds the record header attribute names of the CoAP options
+var optionNames = map[coap.OptionID]string{
+	coap.IfMatch:       "If-Match",
+	coap.URIHost:       "Uri-Host",
+	coap.ETag:          "ETag",
+	coap.IfNoneMatch:   "If-None-Match",
+	coap.Observe:       "Observe",
+	coap.URIPort:       "Uri-Port",
+	coap.LocationPath:  "Location-Path",
+	coap.URIPath:       "Uri-Path",
+	coap.ContentFormat: "Content-Format",
+	coap.MaxAge:        "Max-Age",
+	coap.URIQuery:      "Uri-Query",
+	coap.Accept:        "Accept",
+	coap.LocationQuery: "Location-Query",
+	coap.ProxyURI:      "Proxy-Uri",
+	coap.ProxyScheme:   "Proxy-Scheme",
+	coap.Size1:         "Size1",
+}
+
+type CoapServerOrigin struct {
+	*common.BaseStage
+	CoAPServerConfigs CoapServerConfigs                 `ConfigDefBean:"coAPServerConfigs"`
+	DataFormat        string                            `ConfigDef:"type=STRING,required=true"`
+	DataFormatConfig  dataparser.DataParserFormatConfig `ConfigDefBean:"dataFormatConfig"`
+	listener          *net.UDPConn
+	incomingMessages  chan *coapMessage
+	destroyed         chan struct{}
+	inFlightMessages  map[string]bool
+	messageCounter    int64
+	batchCounter      int64
+	stopped           bool
+	mutex             sync.Mutex
+}
+
+type CoapServerConfigs struct {
+	Port            float64 `ConfigDef:"type=NUMBER,required=true"`
+	ResourceName    string  `ConfigDef:"type=STRING,required=true"`
+	MaxWaitTimeSecs float64 `ConfigDef:"type=NUMBER,required=false"`
+}
+
+// coapMessage holds the records parsed from a message payload
+type coapMessage struct {
+	records []api.Record
+}
+
+func init() {
+	stagelibrary.SetCreator(LIBRARY, STAGE_NAME, func() api.Stage {
+		return &CoapServerOrigin{BaseStage: &common.BaseStage{}}
+	})
+}
+
+func (c *CoapServerOrigin) Init(stageContext api.StageContext) error {
+	if err := c.BaseStage.Init(stageContext); err != nil {
+		return err
+	}
+	log.Println("[DEBUG] CoapServerOrigin Init method")
+	if err := c.DataFormatConfig.Init(c.DataFormat); err != nil {
+		return err
+	}
+	resourceName := strings.Trim(c.CoAPServerConfigs.ResourceName, "/")
+	if len(resourceName) == 0 {
+		return errors.New("Resource name must not be empty")
+	}
+	if c.CoAPServerConfigs.MaxWaitTimeSecs <= 0 {
+		c.CoAPServerConfigs.MaxWaitTimeSecs = DEFAULT_MAX_WAIT_TIME_SECS
+	}
+	c.incomingMessages = make(chan *coapMessage)
+	c.destroyed = make(chan struct{})
+	c.inFlightMessages = make(map[string]bool)
+
+	// listen right away, so that a port already in use fails the pipeline start
+	var err error
+	c.listener, err = net.ListenUDP("udp", &net.UDPAddr{Port: int(c.CoAPServerConfigs.Port)})
+	if err != nil {
+		return err
+	}
+	mux := coap.NewServeMux()
+	mux.Handle(resourceName, c)
+	go func() {
+		log.Printf("[DEBUG] CoAP Server - Running on %s, resource '%s'", c.listener.LocalAddr(), resourceName)
+		if err := coap.Serve(c.listener, mux); err != nil && !c.isStopped() {
+			log.Printf("[ERROR] CoAP Server: Serve() error: %s", err)
+			c.GetStageContext().ReportError(err)
+		}
+	}()
+	return nil
+}
+
+func (c *CoapServerOrigin) Destroy() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.stopped || c.listener == nil {
+		return nil
+	}
+	c.stopped = true
+	close(c.destroyed)
+	log.Println("[DEBUG] CoAP Server - server shutdown")
+	return c.listener.Close()
+}
+
+func (c *CoapServerOrigin) isStopped() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stopped
+}
+
+// Produce adds the records of the incoming messages to the batch until it holds maxBatchSize records or the
+// max wait time elapsed. The records of a message are never split across batches.
+func (c *CoapServerOrigin) Produce(
+	lastSourceOffset string,
+	maxBatchSize int,
+	batchMaker api.BatchMaker,
+) (string, error) {
+	recordCount := 0
+	timeout := time.After(time.Duration(c.CoAPServerConfigs.MaxWaitTimeSecs * float64(time.Second)))
+	end := false
+	for !end && recordCount < maxBatchSize {
+		select {
+		case message := <-c.incomingMessages:
+			for _, record := range message.records {
+				batchMaker.AddRecord(record)
+			}
+			recordCount += len(message.records)
+		case <-timeout:
+			end = true
+		case <-c.destroyed:
+			end = true
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.batchCounter++
+	return strconv.FormatInt(c.batchCounter, 10), nil
+}
+
+// ServeCOAP parses the records of the message, confirmable messages are acknowledged once their records have been
+// added to a batch. Retransmissions of a message waiting to be batched are ignored.
+func (c *CoapServerOrigin) ServeCOAP(l *net.UDPConn, a *net.UDPAddr, m *coap.Message) *coap.Message {
+	messageKey := a.String() + "::" + strconv.Itoa(int(m.MessageID))
+	c.mutex.Lock()
+	if c.inFlightMessages[messageKey] {
+		c.mutex.Unlock()
+		log.Printf("[DEBUG] CoAP Server - Ignoring retransmitted message %s", messageKey)
+		return nil
+	}
+	c.inFlightMessages[messageKey] = true
+	c.messageCounter++
+	sourceIdPrefix := a.String() + "::" + strconv.FormatInt(c.messageCounter, 10)
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.inFlightMessages, messageKey)
+		c.mutex.Unlock()
+	}()
+
+	records, err := c.parseRecords(sourceIdPrefix, m)
+	if err != nil {
+		log.Printf("[ERROR] CoAP Server - Failed to parse message payload: %s", err.Error())
+		c.GetStageContext().ReportError(err)
+		return acknowledgement(m, coap.BadRequest)
+	}
+
+	// the message is handed over once Produce adds its records to the batch
+	select {
+	case c.incomingMessages <- &coapMessage{records: records}:
+		return acknowledgement(m, coap.Changed)
+	case <-c.destroyed:
+		return acknowledgement(m, coap.ServiceUnavailable)
+	}
+}
+
+// acknowledgement returns the acknowledgement of confirmable messages, non-confirmable messages are not answered
+func acknowledgement(m *coap.Message, code coap.COAPCode) *coap.Message {
+	if !m.IsConfirmable() {
+		return nil
+	}
+	return &coap.Message{
+		Type:      coap.Acknowledgement,
+		Code:      code,
+		MessageID: m.MessageID,
+		Token:     m.Token,
+	}
+}
+
+func (c *CoapServerOrigin) parseRecords(sourceIdPrefix string, m *coap.Message) ([]api.Record, error) {
+	recordReader, err := c.DataFormatConfig.RecordReaderFactory.CreateReader(
+		c.GetStageContext(),
+		bytes.NewReader(m.Payload),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer recordReader.Close()
+
+	attributes := getOptionAttributes(m)
+	records := make([]api.Record, 0)
+	for {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			break
+		}
+		record.GetHeader().(*common.HeaderImpl).SetSourceId(sourceIdPrefix + "::" + strconv.Itoa(len(records)))
+		for name, value := range attributes {
+			record.GetHeader().SetAttribute(name, value)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// getOptionAttributes returns the options of the message by name, repeated options are joined with '/' for
+// Uri-Path and Location-Path, with '&' for Uri-Query and Location-Query and with ',' otherwise. Opaque values
+// are hex encoded.
+func getOptionAttributes(m *coap.Message) map[string]string {
+	attributes := make(map[string]string)
+	for optionId, name := range optionNames {
+		values := m.Options(optionId)
+		if len(values) == 0 {
+			continue
+		}
+		stringValues := make([]string, len(values))
+		for i, value := range values {
+			if bytesValue, ok := value.([]byte); ok {
+				stringValues[i] = hex.EncodeToString(bytesValue)
+			} else {
+				stringValues[i] = fmt.Sprint(value)
+			}
+		}
+		separator := ","
+		switch optionId {
+		case coap.URIPath, coap.LocationPath:
+			separator = "/"
+		case coap.URIQuery, coap.LocationQuery:
+			separator = "&"
+		}
+		attributes[name] = strings.Join(stringValues, separator)
+	}
+	return attributes
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package coap
+
+import (
+	"github.com/dustin/go-coap"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func getStageContext(port float64, resourceName string) *common.StageContextImpl {
+	stageConfig := common.StageConfiguration{}
+	stageConfig.Library = LIBRARY
+	stageConfig.StageName = STAGE_NAME
+	stageConfig.Configuration = []common.Config{
+		{Name: "coAPServerConfigs.port", Value: port},
+		{Name: "coAPServerConfigs.resourceName", Value: resourceName},
+		{Name: "coAPServerConfigs.maxWaitTimeSecs", Value: float64(1)},
+		{Name: "dataFormat", Value: "JSON"},
+	}
+	return &common.StageContextImpl{
+		StageConfig: stageConfig,
+		ErrorSink:   common.NewErrorSink(),
+	}
+}
+
+func getFreePort(t *testing.T) float64 {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return float64(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func createOrigin(t *testing.T, port float64) *CoapServerOrigin {
+	stageContext := getStageContext(port, "/sensors")
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := stageBean.Stage.(*CoapServerOrigin)
+	if err = origin.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	return origin
+}
+
+func send(t *testing.T, port float64, message coap.Message) chan *coap.Message {
+	conn, err := coap.Dial("udp", "127.0.0.1:"+strconv.FormatFloat(port, 'f', -1, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := make(chan *coap.Message, 1)
+	go func() {
+		response, err := conn.Send(message)
+		if err != nil {
+			t.Log(err)
+		}
+		responses <- response
+	}()
+	return responses
+}
+
+func TestCoapServerOrigin_Init(t *testing.T) {
+	origin := createOrigin(t, getFreePort(t))
+	defer origin.Destroy()
+
+	if origin.CoAPServerConfigs.ResourceName != "/sensors" {
+		t.Error("Failed to inject config value for resource name")
+	}
+	if origin.DataFormat != "JSON" {
+		t.Error("Failed to inject config value for data format")
+	}
+}
+
+func TestCoapServerOrigin_ConfirmableMessage(t *testing.T) {
+	port := getFreePort(t)
+	origin := createOrigin(t, port)
+	defer origin.Destroy()
+
+	message := coap.Message{
+		Type:      coap.Confirmable,
+		Code:      coap.POST,
+		MessageID: 12345,
+		Token:     []byte("token"),
+		Payload:   []byte(`{"temperature": 21} {"temperature": 22}`),
+	}
+	message.SetPathString("/sensors")
+	message.AddOption(coap.URIQuery, "id=1")
+	message.AddOption(coap.URIQuery, "unit=c")
+	message.AddOption(coap.ContentFormat, coap.AppJSON)
+	responses := send(t, port, message)
+
+	// the message is acknowledged once it is added to a batch
+	select {
+	case <-responses:
+		t.Fatal("Message acknowledged before it was added to a batch")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := origin.Produce("", 1000, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	response := <-responses
+	if response == nil {
+		t.Fatal("Expected an acknowledgement")
+	}
+	if response.Type != coap.Acknowledgement || response.Code != coap.Changed || response.MessageID != 12345 {
+		t.Errorf("Unexpected acknowledgement: %v", response)
+	}
+	if string(response.Token) != "token" {
+		t.Errorf("Expected the message token in the acknowledgement, got '%s'", response.Token)
+	}
+
+	records := batchMaker.GetStageOutput()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	field, _ := records[1].Get("/temperature")
+	if field.Value != float64(22) {
+		t.Errorf("Unexpected record value: %v", field.Value)
+	}
+	attributes := records[0].GetHeader().GetAttributes()
+	expectedAttributes := map[string]string{"Uri-Path": "sensors", "Uri-Query": "id=1&unit=c", "Content-Format": "50"}
+	for name, value := range expectedAttributes {
+		if attributes[name] != value {
+			t.Errorf("Expected attribute %s '%s', got '%s'", name, value, attributes[name])
+		}
+	}
+}
+
+func TestCoapServerOrigin_NonConfirmableMessage(t *testing.T) {
+	port := getFreePort(t)
+	origin := createOrigin(t, port)
+	defer origin.Destroy()
+
+	message := coap.Message{
+		Type:      coap.NonConfirmable,
+		Code:      coap.POST,
+		MessageID: 1,
+		Payload:   []byte(`{"temperature": 21}`),
+	}
+	message.SetPathString("/sensors")
+	if response := <-send(t, port, message); response != nil {
+		t.Errorf("Unexpected response to a non-confirmable message: %v", response)
+	}
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := origin.Produce("", 1000, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 1 {
+		t.Errorf("Expected 1 record, got %d", len(batchMaker.GetStageOutput()))
+	}
+}
+
+func TestCoapServerOrigin_UnknownResource(t *testing.T) {
+	port := getFreePort(t)
+	origin := createOrigin(t, port)
+	defer origin.Destroy()
+
+	message := coap.Message{
+		Type:      coap.Confirmable,
+		Code:      coap.POST,
+		MessageID: 1,
+		Payload:   []byte(`{"temperature": 21}`),
+	}
+	message.SetPathString("/unknown")
+	response := <-send(t, port, message)
+	if response == nil || response.Code != coap.NotFound {
+		t.Errorf("Expected a NotFound response, got %v", response)
+	}
+}
@@ -0,0 +1,636 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/api/fieldtype"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/el"
+	"github.com/streamsets/datacollector-edge/stages/lib/dataparser"
+	"github.com/streamsets/datacollector-edge/stages/lib/httpcommon"
+	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LIBRARY    = "streamsets-datacollector-basic-lib"
+	STAGE_NAME = "com_streamsets_pipeline_stage_origin_http_HttpClientDSource"
+
+	POLLING   = "POLLING"
+	STREAMING = "STREAMING"
+
+	NONE        = "NONE"
+	LINK_HEADER = "LINK_HEADER"
+	LINK_FIELD  = "LINK_FIELD"
+	BY_PAGE     = "BY_PAGE"
+	BY_OFFSET   = "BY_OFFSET"
+
+	START_AT_VAR = "${startAt}"
+
+	RESOURCE_URL   = "resourceUrl"
+	HEADERS        = "headers"
+	REQUEST_BODY   = "requestBody"
+	STOP_CONDITION = "stopCondition"
+
+	DEFAULT_POLLING_INTERVAL = 5000
+	DEFAULT_MAX_WAIT_TIME    = 2000
+
+	PAGE_POSITION_SEPARATOR = "::"
+)
+
+var linkHeaderNextRegex = regexp.MustCompile(`<([^>]*)>[^,]*;\s*rel="?next"?`)
+
+type HttpClientOrigin struct {
+	*common.BaseStage
+	Conf            HttpClientConfigBean `ConfigDefBean:"conf"`
+	client          *httpcommon.Client
+	lastPollTime    time.Time
+	streamRecords   chan *streamRecord
+	streamResponse  *http.Response
+	streamRequested bool
+	currentPage     *page
+	destroyed       chan struct{}
+	stopped         bool
+	mutex           sync.Mutex
+}
+
+// page holds the records of the page read at the offset which were not all produced yet
+type page struct {
+	offset     string
+	records    []api.Record
+	nextOffset string
+}
+
+// HttpClientConfigBean holds the settings of the origin. ResourceUrl, the header values and RequestBody are
+// evaluated for every request, ResourceUrl may refer to the page number or offset of the BY_PAGE and BY_OFFSET
+// pagination modes with ${startAt}.
+type HttpClientConfigBean struct {
+	ResourceUrl                 string                                    `ConfigDef:"type=STRING,required=true,evaluation=EXPLICIT"`
+	Headers                     map[string]string                         `ConfigDef:"type=MAP,required=true,evaluation=EXPLICIT"`
+	HttpMethod                  string                                    `ConfigDef:"type=STRING,required=false"`
+	RequestBody                 string                                    `ConfigDef:"type=STRING,required=false,evaluation=EXPLICIT"`
+	HttpMode                    string                                    `ConfigDef:"type=STRING,required=true"`
+	PollingInterval             float64                                   `ConfigDef:"type=NUMBER,required=false"`
+	Basic                       BasicConfigBean                           `ConfigDefBean:"basic"`
+	Pagination                  PaginationConfigBean                      `ConfigDefBean:"pagination"`
+	Client                      httpcommon.ClientConfigBean               `ConfigDefBean:"client"`
+	ResponseStatusActionConfigs []httpcommon.HttpResponseActionConfigBean `ConfigDef:"type=MODEL" ListBeanModel:"name=responseStatusActionConfigs"`
+	DataFormat                  string                                    `ConfigDef:"type=STRING,required=true"`
+	DataFormatConfig            dataparser.DataParserFormatConfig         `ConfigDefBean:"dataFormatConfig"`
+}
+
+type BasicConfigBean struct {
+	MaxBatchSize float64 `ConfigDef:"type=NUMBER,required=false"`
+	MaxWaitTime  float64 `ConfigDef:"type=NUMBER,required=false"`
+}
+
+// PaginationConfigBean holds how the next page is requested. LINK_HEADER follows the next link of the Link
+// response header, LINK_FIELD the URL in NextPageFieldPath of the response. BY_PAGE and BY_OFFSET substitute
+// ${startAt} in the resource URL, starting at StartAt and incremented by one page or by the number of records of
+// the page. The records of a page are read from the list at ResultFieldPath, pagination ends when there is no
+// next page, a page has no results or StopCondition evaluates to true for the response record.
+type PaginationConfigBean struct {
+	Mode              string  `ConfigDef:"type=STRING,required=false"`
+	StartAt           float64 `ConfigDef:"type=NUMBER,required=false"`
+	ResultFieldPath   string  `ConfigDef:"type=STRING,required=false"`
+	NextPageFieldPath string  `ConfigDef:"type=STRING,required=false"`
+	StopCondition     string  `ConfigDef:"type=STRING,required=false,evaluation=EXPLICIT"`
+}
+
+// streamRecord is a record, or the error, read from the response of the streaming mode
+type streamRecord struct {
+	record api.Record
+	err    error
+}
+
+func init() {
+	stagelibrary.SetCreator(LIBRARY, STAGE_NAME, func() api.Stage {
+		return &HttpClientOrigin{BaseStage: &common.BaseStage{}}
+	})
+}
+
+func (h *HttpClientOrigin) Init(stageContext api.StageContext) error {
+	var err error
+	if err = h.BaseStage.Init(stageContext); err != nil {
+		return err
+	}
+	log.Println("[DEBUG] HttpClientOrigin Init method")
+
+	switch h.Conf.HttpMethod {
+	case "":
+		h.Conf.HttpMethod = http.MethodGet
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodHead:
+	default:
+		return errors.New(fmt.Sprintf("Unsupported HTTP method: %s", h.Conf.HttpMethod))
+	}
+
+	switch h.Conf.HttpMode {
+	case POLLING:
+		if err = h.validatePagination(); err != nil {
+			return err
+		}
+	case STREAMING:
+		if h.Conf.Pagination.Mode != "" && h.Conf.Pagination.Mode != NONE {
+			return errors.New("Pagination is not supported in streaming mode")
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unsupported HTTP mode: %s", h.Conf.HttpMode))
+	}
+
+	if h.Conf.PollingInterval <= 0 {
+		h.Conf.PollingInterval = DEFAULT_POLLING_INTERVAL
+	}
+	if h.Conf.Basic.MaxWaitTime <= 0 {
+		h.Conf.Basic.MaxWaitTime = DEFAULT_MAX_WAIT_TIME
+	}
+	if err = httpcommon.ValidateResponseActions(h.Conf.ResponseStatusActionConfigs); err != nil {
+		return err
+	}
+	if h.client, err = h.Conf.Client.NewClient(); err != nil {
+		return err
+	}
+	h.streamRecords = make(chan *streamRecord)
+	h.destroyed = make(chan struct{})
+	return h.Conf.DataFormatConfig.Init(h.Conf.DataFormat)
+}
+
+func (h *HttpClientOrigin) validatePagination() error {
+	pagination := &h.Conf.Pagination
+	switch pagination.Mode {
+	case "":
+		pagination.Mode = NONE
+	case NONE, LINK_HEADER:
+	case LINK_FIELD:
+		if len(pagination.NextPageFieldPath) == 0 {
+			return errors.New("Next page link field path must not be empty")
+		}
+	case BY_PAGE, BY_OFFSET:
+		if !strings.Contains(h.Conf.ResourceUrl, START_AT_VAR) {
+			return errors.New(fmt.Sprintf("Resource URL must contain %s with %s pagination", START_AT_VAR, pagination.Mode))
+		}
+	default:
+		return errors.New(fmt.Sprintf("Unsupported pagination mode: %s", pagination.Mode))
+	}
+	return nil
+}
+
+func (h *HttpClientOrigin) Destroy() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.stopped || h.destroyed == nil {
+		return nil
+	}
+	h.stopped = true
+	close(h.destroyed)
+	if h.streamResponse != nil {
+		h.streamResponse.Body.Close()
+	}
+	return nil
+}
+
+func (h *HttpClientOrigin) Produce(
+	lastSourceOffset string,
+	maxBatchSize int,
+	batchMaker api.BatchMaker,
+) (string, error) {
+	if h.Conf.Basic.MaxBatchSize > 0 && int(h.Conf.Basic.MaxBatchSize) < maxBatchSize {
+		maxBatchSize = int(h.Conf.Basic.MaxBatchSize)
+	}
+	if h.Conf.HttpMode == STREAMING {
+		return lastSourceOffset, h.produceStream(maxBatchSize, batchMaker)
+	}
+	return h.producePage(lastSourceOffset, maxBatchSize, batchMaker)
+}
+
+// producePage adds up to maxBatchSize records of the page at the offset to the batch. The offset of a page holds
+// the URL of the page with link pagination, the ${startAt} value with page and offset pagination, it is empty
+// for the first page of a poll. When the page holds more records than fit in the batch, the offset is the page
+// offset followed by PAGE_POSITION_SEPARATOR and the number of records of the page already produced, the rest of
+// the page is produced from the page kept in memory, or from the page requested again after a restart.
+func (h *HttpClientOrigin) producePage(
+	lastSourceOffset string,
+	maxBatchSize int,
+	batchMaker api.BatchMaker,
+) (string, error) {
+	pageOffset, position := splitPageOffset(lastSourceOffset)
+	if h.currentPage == nil || h.currentPage.offset != pageOffset || position == 0 {
+		currentPage, err := h.readPage(lastSourceOffset, pageOffset)
+		if err != nil || currentPage == nil {
+			return lastSourceOffset, err
+		}
+		h.currentPage = currentPage
+	}
+
+	records := h.currentPage.records
+	if position > len(records) {
+		position = len(records)
+	}
+	end := len(records)
+	if maxBatchSize > 0 && position+maxBatchSize < end {
+		end = position + maxBatchSize
+	}
+	for _, record := range records[position:end] {
+		batchMaker.AddRecord(record)
+	}
+	if end < len(records) {
+		return pageOffset + PAGE_POSITION_SEPARATOR + strconv.Itoa(end), nil
+	}
+	nextOffset := h.currentPage.nextOffset
+	h.currentPage = nil
+	return nextOffset, nil
+}
+
+// splitPageOffset returns the offset of the page and the number of records of the page already produced
+func splitPageOffset(offset string) (string, int) {
+	if index := strings.LastIndex(offset, PAGE_POSITION_SEPARATOR); index >= 0 {
+		position, err := strconv.Atoi(offset[index+len(PAGE_POSITION_SEPARATOR):])
+		if err == nil && position >= 0 {
+			return offset[:index], position
+		}
+	}
+	return offset, 0
+}
+
+// readPage requests the page at the page offset and returns its records along with the offset of the next page,
+// no page is returned while waiting for the next poll
+func (h *HttpClientOrigin) readPage(lastSourceOffset string, pageOffset string) (*page, error) {
+	if len(lastSourceOffset) == 0 && !h.lastPollTime.IsZero() {
+		// wait for the next poll, returning an empty batch after the max wait time
+		wait := h.lastPollTime.Add(millis(h.Conf.PollingInterval)).Sub(time.Now())
+		maxWait := millis(h.Conf.Basic.MaxWaitTime)
+		if wait > maxWait {
+			h.sleep(maxWait)
+			return nil, nil
+		}
+		if !h.sleep(wait) {
+			return nil, nil
+		}
+	}
+	if len(pageOffset) == 0 {
+		h.lastPollTime = time.Now()
+	}
+
+	pageUrl, startAt, err := h.getPageUrl(pageOffset)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.sendRequest(pageUrl)
+	if err != nil {
+		return nil, h.handleRequestError(err)
+	}
+	defer resp.Body.Close()
+
+	pageRecord, records, err := h.parsePage(pageUrl, resp)
+	if err != nil {
+		return nil, err
+	}
+	nextOffset, err := h.getNextOffset(pageUrl, startAt, resp, pageRecord, len(records))
+	if err != nil {
+		return nil, err
+	}
+	return &page{offset: pageOffset, records: records, nextOffset: nextOffset}, nil
+}
+
+// getPageUrl returns the URL of the page at the offset and its ${startAt} value
+func (h *HttpClientOrigin) getPageUrl(offset string) (string, int64, error) {
+	pagination := h.Conf.Pagination
+	switch pagination.Mode {
+	case LINK_HEADER, LINK_FIELD:
+		if len(offset) > 0 {
+			return offset, 0, nil
+		}
+	case BY_PAGE, BY_OFFSET:
+		startAt := int64(pagination.StartAt)
+		if len(offset) > 0 {
+			var err error
+			if startAt, err = strconv.ParseInt(offset, 10, 64); err != nil {
+				return "", 0, errors.New(fmt.Sprintf("Invalid offset '%s': %s", offset, err.Error()))
+			}
+		}
+		pageUrl := strings.Replace(h.Conf.ResourceUrl, START_AT_VAR, strconv.FormatInt(startAt, 10), -1)
+		pageUrl, err := h.evaluate(pageUrl, RESOURCE_URL)
+		return pageUrl, startAt, err
+	}
+	pageUrl, err := h.evaluate(h.Conf.ResourceUrl, RESOURCE_URL)
+	return pageUrl, 0, err
+}
+
+// getNextOffset returns the offset of the page following the page read, or an empty offset when it was the last
+func (h *HttpClientOrigin) getNextOffset(
+	pageUrl string,
+	startAt int64,
+	resp *http.Response,
+	pageRecord api.Record,
+	recordCount int,
+) (string, error) {
+	pagination := h.Conf.Pagination
+	if pagination.Mode == NONE || pageRecord == nil {
+		return "", nil
+	}
+	if len(pagination.StopCondition) > 0 {
+		recordContext := context.WithValue(context.Background(), el.RECORD_CONTEXT_VAR, pageRecord)
+		stop, err := h.GetStageContext().Evaluate(pagination.StopCondition, STOP_CONDITION, recordContext)
+		if err != nil {
+			return "", err
+		}
+		if stop == true {
+			return "", nil
+		}
+	}
+
+	switch pagination.Mode {
+	case LINK_HEADER:
+		return getNextLink(pageUrl, resp.Header[http.CanonicalHeaderKey("Link")])
+	case LINK_FIELD:
+		field, err := pageRecord.Get(pagination.NextPageFieldPath)
+		if err != nil || field == nil || field.Value == nil {
+			return "", nil
+		}
+		next, ok := field.Value.(string)
+		if !ok || len(next) == 0 {
+			return "", nil
+		}
+		return resolveUrl(pageUrl, next)
+	case BY_PAGE:
+		if recordCount == 0 {
+			return "", nil
+		}
+		return strconv.FormatInt(startAt+1, 10), nil
+	case BY_OFFSET:
+		if recordCount == 0 {
+			return "", nil
+		}
+		return strconv.FormatInt(startAt+int64(recordCount), 10), nil
+	}
+	return "", nil
+}
+
+// getNextLink returns the URL with the next relation in the Link headers, relative URLs are resolved against
+// the page URL
+func getNextLink(pageUrl string, linkHeaders []string) (string, error) {
+	for _, linkHeader := range linkHeaders {
+		if match := linkHeaderNextRegex.FindStringSubmatch(linkHeader); match != nil {
+			return resolveUrl(pageUrl, strings.TrimSpace(match[1]))
+		}
+	}
+	return "", nil
+}
+
+func resolveUrl(baseUrl string, reference string) (string, error) {
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(reference)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Invalid next page link '%s': %s", reference, err.Error()))
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// parsePage returns the first record parsed from the response and the records of the page, read from the
+// list at the result field path of every parsed record when it is set
+func (h *HttpClientOrigin) parsePage(pageUrl string, resp *http.Response) (api.Record, []api.Record, error) {
+	recordReader, err := h.Conf.DataFormatConfig.RecordReaderFactory.CreateReader(h.GetStageContext(), resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer recordReader.Close()
+
+	var pageRecord api.Record
+	records := make([]api.Record, 0)
+	for {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			return nil, nil, err
+		}
+		if record == nil {
+			break
+		}
+		if pageRecord == nil {
+			pageRecord = record
+		}
+
+		if len(h.Conf.Pagination.ResultFieldPath) == 0 {
+			h.setHeader(record, pageUrl+"::"+strconv.Itoa(len(records)), resp)
+			records = append(records, record)
+			continue
+		}
+
+		results, err := record.Get(h.Conf.Pagination.ResultFieldPath)
+		if err != nil || results == nil || results.Value == nil {
+			continue
+		}
+		if results.Type != fieldtype.LIST {
+			return nil, nil, errors.New(fmt.Sprintf(
+				"Result field path '%s' is not a list: %s",
+				h.Conf.Pagination.ResultFieldPath,
+				results.Type,
+			))
+		}
+		for _, result := range results.Value.([]*api.Field) {
+			resultRecord, err := h.GetStageContext().CreateRecord("", nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			resultRecord.Set(result)
+			h.setHeader(resultRecord, pageUrl+"::"+strconv.Itoa(len(records)), resp)
+			records = append(records, resultRecord)
+		}
+	}
+	return pageRecord, records, nil
+}
+
+// setHeader sets the source id of the record and the response headers as record header attributes
+func (h *HttpClientOrigin) setHeader(record api.Record, sourceId string, resp *http.Response) {
+	record.GetHeader().(*common.HeaderImpl).SetSourceId(sourceId)
+	for name, values := range resp.Header {
+		if len(values) > 0 {
+			record.GetHeader().SetAttribute(name, values[0])
+		}
+	}
+}
+
+// produceStream adds the records read from the streamed response to the batch until it holds maxBatchSize
+// records or the max wait time elapsed. The stream is requested again after the polling interval once it ends.
+func (h *HttpClientOrigin) produceStream(maxBatchSize int, batchMaker api.BatchMaker) error {
+	h.mutex.Lock()
+	if !h.streamRequested && !h.stopped &&
+		time.Now().Sub(h.lastPollTime) >= millis(h.Conf.PollingInterval) {
+		h.streamRequested = true
+		h.lastPollTime = time.Now()
+		go h.readStream()
+	}
+	h.mutex.Unlock()
+
+	recordCount := 0
+	timeout := time.After(millis(h.Conf.Basic.MaxWaitTime))
+	for recordCount < maxBatchSize {
+		select {
+		case streamRecord := <-h.streamRecords:
+			if streamRecord.err != nil {
+				return h.handleRequestError(streamRecord.err)
+			}
+			batchMaker.AddRecord(streamRecord.record)
+			recordCount++
+		case <-timeout:
+			return nil
+		case <-h.destroyed:
+			return nil
+		}
+	}
+	return nil
+}
+
+// readStream sends the records of the streamed response to Produce until the response ends or the origin is
+// destroyed
+func (h *HttpClientOrigin) readStream() {
+	defer func() {
+		h.mutex.Lock()
+		h.streamRequested = false
+		h.streamResponse = nil
+		h.mutex.Unlock()
+	}()
+
+	send := func(record *streamRecord) bool {
+		select {
+		case h.streamRecords <- record:
+			return true
+		case <-h.destroyed:
+			return false
+		}
+	}
+
+	resourceUrl, err := h.evaluate(h.Conf.ResourceUrl, RESOURCE_URL)
+	if err != nil {
+		send(&streamRecord{err: err})
+		return
+	}
+	resp, err := h.sendRequest(resourceUrl)
+	if err != nil {
+		send(&streamRecord{err: err})
+		return
+	}
+	defer resp.Body.Close()
+
+	h.mutex.Lock()
+	if h.stopped {
+		h.mutex.Unlock()
+		return
+	}
+	h.streamResponse = resp
+	h.mutex.Unlock()
+
+	recordReader, err := h.Conf.DataFormatConfig.RecordReaderFactory.CreateReader(h.GetStageContext(), resp.Body)
+	if err != nil {
+		send(&streamRecord{err: err})
+		return
+	}
+	defer recordReader.Close()
+
+	for recordCount := 0; ; recordCount++ {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			select {
+			case <-h.destroyed:
+			default:
+				send(&streamRecord{err: err})
+			}
+			return
+		}
+		if record == nil {
+			log.Printf("[DEBUG] HttpClientOrigin stream from '%s' ended", resourceUrl)
+			return
+		}
+		h.setHeader(record, resourceUrl+"::"+strconv.Itoa(recordCount), resp)
+		if !send(&streamRecord{record: record}) {
+			return
+		}
+	}
+}
+
+// sendRequest sends the request to the URL, the body of the successful response is closed by the caller
+func (h *HttpClientOrigin) sendRequest(requestUrl string) (*http.Response, error) {
+	headers := make(map[string]string, len(h.Conf.Headers))
+	for key, value := range h.Conf.Headers {
+		var err error
+		if headers[key], err = h.evaluate(value, HEADERS); err != nil {
+			return nil, err
+		}
+	}
+	requestBody, err := h.evaluate(h.Conf.RequestBody, REQUEST_BODY)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.client.DoWithRetries(func() (*http.Request, error) {
+		var body io.Reader
+		if len(requestBody) > 0 {
+			body = strings.NewReader(requestBody)
+		}
+		req, err := http.NewRequest(h.Conf.HttpMethod, requestUrl, body)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		return req, nil
+	}, h.Conf.ResponseStatusActionConfigs, h.GetStageContext().GetStopChannel())
+}
+
+// handleRequestError reports the requests which failed because of the response as stage errors, unless the
+// action of the response status code is to fail the stage. Other errors fail the batch.
+func (h *HttpClientOrigin) handleRequestError(err error) error {
+	if respErr, ok := err.(*httpcommon.ResponseError); ok && respErr.Action != httpcommon.STAGE_ERROR {
+		log.Printf("[ERROR] %s", respErr.Error())
+		h.GetStageContext().ReportError(respErr)
+		return nil
+	}
+	return err
+}
+
+func (h *HttpClientOrigin) evaluate(template string, configName string) (string, error) {
+	return el.EvaluateTemplate(template, func(expression string) (interface{}, error) {
+		return h.GetStageContext().Evaluate(expression, configName, context.Background())
+	})
+}
+
+// sleep waits for the duration, it returns false when the origin was destroyed meanwhile
+func (h *HttpClientOrigin) sleep(duration time.Duration) bool {
+	select {
+	case <-time.After(duration):
+		return true
+	case <-h.destroyed:
+		return false
+	}
+}
+
+func millis(value float64) time.Duration {
+	return time.Duration(value) * time.Millisecond
+}
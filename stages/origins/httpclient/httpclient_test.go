@@ -0,0 +1,394 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package httpclient
+
+import (
+	"fmt"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func getStageContext(configs []common.Config, parameters map[string]interface{}) *common.StageContextImpl {
+	stageConfig := common.StageConfiguration{}
+	stageConfig.Library = LIBRARY
+	stageConfig.StageName = STAGE_NAME
+	stageConfig.Configuration = append([]common.Config{
+		{Name: "conf.dataFormat", Value: "JSON"},
+		{Name: "conf.headers", Value: []interface{}{}},
+	}, configs...)
+	return &common.StageContextImpl{
+		StageConfig: stageConfig,
+		Parameters:  parameters,
+		ErrorSink:   common.NewErrorSink(),
+	}
+}
+
+func createOrigin(t *testing.T, stageContext *common.StageContextImpl) *HttpClientOrigin {
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := stageBean.Stage.(*HttpClientOrigin)
+	if err = origin.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	return origin
+}
+
+func produce(t *testing.T, origin *HttpClientOrigin, offset string) (string, []api.Record) {
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	nextOffset, err := origin.Produce(offset, 1000, batchMaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nextOffset, batchMaker.GetStageOutput()
+}
+
+func checkValues(t *testing.T, records []api.Record, fieldPath string, expected ...string) {
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		field, err := record.Get(fieldPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(field.Value) != expected[i] {
+			t.Errorf("Expected '%s' for record %d, got '%v'", expected[i], i, field.Value)
+		}
+	}
+}
+
+func TestHttpClientOrigin_Init(t *testing.T) {
+	stageContext := getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: "http://localhost:9999/items"},
+		{Name: "conf.httpMode", Value: POLLING},
+		{Name: "conf.pollingInterval", Value: float64(1000)},
+		{Name: "conf.pagination.mode", Value: LINK_FIELD},
+		{Name: "conf.pagination.nextPageFieldPath", Value: "/next"},
+	}, nil)
+	origin := createOrigin(t, stageContext)
+
+	if origin.Conf.ResourceUrl != "http://localhost:9999/items" {
+		t.Error("Failed to inject config value for resource URL")
+	}
+	if origin.Conf.HttpMethod != http.MethodGet {
+		t.Errorf("Expected default method GET, got %s", origin.Conf.HttpMethod)
+	}
+	if origin.Conf.PollingInterval != 1000 {
+		t.Error("Failed to inject config value for polling interval")
+	}
+	if origin.Conf.Pagination.NextPageFieldPath != "/next" {
+		t.Error("Failed to inject config value for next page field path")
+	}
+}
+
+func TestHttpClientOrigin_InvalidConfig(t *testing.T) {
+	invalidConfigs := [][]common.Config{
+		{{Name: "conf.httpMode", Value: "INVALID"}},
+		{{Name: "conf.httpMode", Value: POLLING}, {Name: "conf.httpMethod", Value: "INVALID"}},
+		{{Name: "conf.httpMode", Value: POLLING}, {Name: "conf.pagination.mode", Value: "INVALID"}},
+		{{Name: "conf.httpMode", Value: POLLING}, {Name: "conf.pagination.mode", Value: BY_PAGE}},
+		{{Name: "conf.httpMode", Value: POLLING}, {Name: "conf.pagination.mode", Value: LINK_FIELD}},
+		{{Name: "conf.httpMode", Value: STREAMING}, {Name: "conf.pagination.mode", Value: LINK_HEADER}},
+	}
+	for _, configs := range invalidConfigs {
+		stageContext := getStageContext(
+			append(configs, common.Config{Name: "conf.resourceUrl", Value: "http://localhost:9999/items"}),
+			nil,
+		)
+		stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stageBean.Stage.Init(stageContext); err == nil {
+			t.Errorf("Expected an error for configs %v", configs)
+		}
+	}
+}
+
+func TestHttpClientOrigin_LinkHeaderPagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", `</items?page=2>; rel="next", </items?page=2>; rel="last"`)
+			fmt.Fprint(w, `{"id": 1} {"id": 2}`)
+		case "2":
+			fmt.Fprint(w, `{"id": 3}`)
+		}
+	}))
+	defer server.Close()
+
+	stageContext := getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: "${baseUrl}/items"},
+		{Name: "conf.httpMode", Value: POLLING},
+		{Name: "conf.pagination.mode", Value: LINK_HEADER},
+	}, map[string]interface{}{"baseUrl": server.URL})
+	origin := createOrigin(t, stageContext)
+	defer origin.Destroy()
+
+	offset, records := produce(t, origin, "")
+	checkValues(t, records, "/id", "1", "2")
+	if offset != server.URL+"/items?page=2" {
+		t.Errorf("Expected the next page URL as offset, got '%s'", offset)
+	}
+
+	// a restarted origin resumes from the page of the offset
+	restarted := createOrigin(t, getStageContext(stageContext.StageConfig.Configuration, stageContext.Parameters))
+	defer restarted.Destroy()
+	offset, records = produce(t, restarted, offset)
+	checkValues(t, records, "/id", "3")
+	if offset != "" {
+		t.Errorf("Expected an empty offset after the last page, got '%s'", offset)
+	}
+}
+
+func TestHttpClientOrigin_LinkFieldPagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/items" {
+			fmt.Fprint(w, `{"results": [{"id": 1}, {"id": 2}], "next": "/items/2"}`)
+		} else {
+			fmt.Fprint(w, `{"results": [{"id": 3}], "next": null}`)
+		}
+	}))
+	defer server.Close()
+
+	origin := createOrigin(t, getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: server.URL + "/items"},
+		{Name: "conf.httpMode", Value: POLLING},
+		{Name: "conf.pagination.mode", Value: LINK_FIELD},
+		{Name: "conf.pagination.resultFieldPath", Value: "/results"},
+		{Name: "conf.pagination.nextPageFieldPath", Value: "/next"},
+	}, nil))
+	defer origin.Destroy()
+
+	offset, records := produce(t, origin, "")
+	checkValues(t, records, "/id", "1", "2")
+	if offset != server.URL+"/items/2" {
+		t.Errorf("Expected the next page URL as offset, got '%s'", offset)
+	}
+	offset, records = produce(t, origin, offset)
+	checkValues(t, records, "/id", "3")
+	if offset != "" {
+		t.Errorf("Expected an empty offset after the last page, got '%s'", offset)
+	}
+}
+
+func TestHttpClientOrigin_ByPagePagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		fmt.Fprintf(w, `{"results": [{"page": %d}], "hasMore": %t}`, page, page < 2)
+	}))
+	defer server.Close()
+
+	origin := createOrigin(t, getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: server.URL + "/items?page=${startAt}"},
+		{Name: "conf.httpMode", Value: POLLING},
+		{Name: "conf.pagination.mode", Value: BY_PAGE},
+		{Name: "conf.pagination.startAt", Value: float64(1)},
+		{Name: "conf.pagination.resultFieldPath", Value: "/results"},
+		{Name: "conf.pagination.stopCondition", Value: "${record:value('/hasMore') == false}"},
+	}, nil))
+	defer origin.Destroy()
+
+	offset, records := produce(t, origin, "")
+	checkValues(t, records, "/page", "1")
+	if offset != "2" {
+		t.Errorf("Expected offset '2', got '%s'", offset)
+	}
+	offset, records = produce(t, origin, offset)
+	checkValues(t, records, "/page", "2")
+	if offset != "" {
+		t.Errorf("Expected an empty offset once the stop condition is met, got '%s'", offset)
+	}
+}
+
+func TestHttpClientOrigin_ByOffsetPagination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("offset") {
+		case "0":
+			fmt.Fprint(w, `{"results": [{"id": 1}, {"id": 2}]}`)
+		case "2":
+			fmt.Fprint(w, `{"results": [{"id": 3}]}`)
+		default:
+			fmt.Fprint(w, `{"results": []}`)
+		}
+	}))
+	defer server.Close()
+
+	origin := createOrigin(t, getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: server.URL + "/items?offset=${startAt}"},
+		{Name: "conf.httpMode", Value: POLLING},
+		{Name: "conf.pagination.mode", Value: BY_OFFSET},
+		{Name: "conf.pagination.resultFieldPath", Value: "/results"},
+	}, nil))
+	defer origin.Destroy()
+
+	expectedOffsets := []string{"2", "3", ""}
+	expectedRecords := [][]string{{"1", "2"}, {"3"}, {}}
+	offset := ""
+	var records []api.Record
+	for i := range expectedOffsets {
+		offset, records = produce(t, origin, offset)
+		checkValues(t, records, "/id", expectedRecords[i]...)
+		if offset != expectedOffsets[i] {
+			t.Errorf("Expected offset '%s', got '%s'", expectedOffsets[i], offset)
+		}
+	}
+}
+
+func TestHttpClientOrigin_MaxBatchSize(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Query().Get("offset") {
+		case "0":
+			fmt.Fprint(w, `{"results": [{"id": 1}, {"id": 2}, {"id": 3}]}`)
+		default:
+			fmt.Fprint(w, `{"results": []}`)
+		}
+	}))
+	defer server.Close()
+
+	stageContext := getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: server.URL + "/items?offset=${startAt}"},
+		{Name: "conf.httpMode", Value: POLLING},
+		{Name: "conf.basic.maxBatchSize", Value: float64(2)},
+		{Name: "conf.pagination.mode", Value: BY_OFFSET},
+		{Name: "conf.pagination.resultFieldPath", Value: "/results"},
+	}, nil)
+	origin := createOrigin(t, stageContext)
+	defer origin.Destroy()
+
+	offset, records := produce(t, origin, "")
+	checkValues(t, records, "/id", "1", "2")
+	if offset != PAGE_POSITION_SEPARATOR+"2" {
+		t.Errorf("Expected the offset to keep the rest of the page, got '%s'", offset)
+	}
+	nextOffset, records := produce(t, origin, offset)
+	checkValues(t, records, "/id", "3")
+	if nextOffset != "3" || requests != 1 {
+		t.Errorf("Expected offset '3' after a single request, got '%s' after %d requests", nextOffset, requests)
+	}
+
+	// after a restart the page is requested again and the records already produced are skipped
+	restartedOrigin := createOrigin(t, stageContext)
+	defer restartedOrigin.Destroy()
+	nextOffset, records = produce(t, restartedOrigin, offset)
+	checkValues(t, records, "/id", "3")
+	if nextOffset != "3" || requests != 2 {
+		t.Errorf("Expected offset '3' after requesting the page again, got '%s' after %d requests", nextOffset, requests)
+	}
+}
+
+func TestHttpClientOrigin_PollingInterval(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"request": %d}`, requests)
+	}))
+	defer server.Close()
+
+	origin := createOrigin(t, getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: server.URL},
+		{Name: "conf.httpMode", Value: POLLING},
+		{Name: "conf.pollingInterval", Value: float64(300)},
+		{Name: "conf.basic.maxWaitTime", Value: float64(100)},
+	}, nil))
+	defer origin.Destroy()
+
+	_, records := produce(t, origin, "")
+	checkValues(t, records, "/request", "1")
+
+	// batches are empty until the polling interval elapsed
+	_, records = produce(t, origin, "")
+	checkValues(t, records, "/request")
+
+	time.Sleep(300 * time.Millisecond)
+	_, records = produce(t, origin, "")
+	checkValues(t, records, "/request", "2")
+}
+
+func TestHttpClientOrigin_Streaming(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id": 1} {"id": 2}`)
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, `{"id": 3}`)
+	}))
+	defer server.Close()
+
+	origin := createOrigin(t, getStageContext([]common.Config{
+		{Name: "conf.resourceUrl", Value: server.URL},
+		{Name: "conf.httpMode", Value: STREAMING},
+		{Name: "conf.basic.maxWaitTime", Value: float64(500)},
+	}, nil))
+	defer origin.Destroy()
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := origin.Produce("", 2, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, batchMaker.GetStageOutput(), "/id", "1", "2")
+
+	close(release)
+	_, records := produce(t, origin, "")
+	checkValues(t, records, "/id", "3")
+}
+
+func TestHttpClientOrigin_ResponseActions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	configs := []common.Config{
+		{Name: "conf.resourceUrl", Value: server.URL},
+		{Name: "conf.httpMode", Value: POLLING},
+	}
+	stageContext := getStageContext(configs, nil)
+	origin := createOrigin(t, stageContext)
+	defer origin.Destroy()
+
+	if _, records := produce(t, origin, ""); len(records) != 0 {
+		t.Errorf("Expected no records, got %d", len(records))
+	}
+	if stageContext.ErrorSink.GetTotalErrorMessages() != 1 {
+		t.Errorf("Expected 1 stage error, got %d", stageContext.ErrorSink.GetTotalErrorMessages())
+	}
+
+	stageContext = getStageContext(append(configs, common.Config{
+		Name: "conf.responseStatusActionConfigs",
+		Value: []interface{}{
+			map[string]interface{}{"statusCode": float64(400), "action": "STAGE_ERROR"},
+		},
+	}), nil)
+	origin = createOrigin(t, stageContext)
+	defer origin.Destroy()
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := origin.Produce("", 1000, batchMaker); err == nil {
+		t.Error("Expected the batch to fail")
+	}
+}
@@ -16,31 +16,69 @@
 package httpserver
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/stages/lib/dataparser"
+	"github.com/streamsets/datacollector-edge/stages/lib/tlsconfig"
 	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 )
 
 const (
-	LIBRARY    = "streamsets-datacollector-basic-lib"
-	STAGE_NAME = "com_streamsets_pipeline_stage_origin_httpserver_HttpServerDPushSource"
+	LIBRARY                    = "streamsets-datacollector-basic-lib"
+	STAGE_NAME                 = "com_streamsets_pipeline_stage_origin_httpserver_HttpServerDPushSource"
+	HEADER_APPLICATION_ID      = "X-SDC-APPLICATION-ID"
+	QUERY_PARAM_APPLICATION_ID = "sdcApplicationId"
+	DEFAULT_MAX_WAIT_TIME_SECS = 1
+	SHUTDOWN_TIMEOUT           = 5 * time.Second
+	PROCESSING_TIMEOUT         = 5 * time.Minute
 )
 
 type HttpServerOrigin struct {
 	*common.BaseStage
-	HttpConfigs  RawHttpConfigs `ConfigDefBean:"name=httpConfigs"`
-	httpServer   *http.Server
-	incomingData chan interface{}
+	HttpConfigs      RawHttpConfigs                    `ConfigDefBean:"name=httpConfigs"`
+	MaxRequestSizeMB float64                           `ConfigDef:"type=NUMBER,required=false"`
+	DataFormat       string                            `ConfigDef:"type=STRING,required=true"`
+	DataFormatConfig dataparser.DataParserFormatConfig `ConfigDefBean:"dataFormatConfig"`
+	httpServer       *http.Server
+	incomingRequests chan *httpRequest
+	destroyed        chan struct{}
+	requestCounter   int64
+	batchCounter     int64
+	pendingBatches   []*pendingBatch
+	stopped          bool
+	mutex            sync.Mutex
 }
 
 type RawHttpConfigs struct {
-	Port  float64 `ConfigDef:"type=NUMBER,required=true"`
-	AppId string  `ConfigDef:"type=STRING,required=true"`
+	Port                      float64                 `ConfigDef:"type=NUMBER,required=true"`
+	AppId                     string                  `ConfigDef:"type=STRING,required=true"`
+	AppIdViaQueryParamAllowed bool                    `ConfigDef:"type=BOOLEAN,required=false"`
+	MaxWaitTimeSecs           float64                 `ConfigDef:"type=NUMBER,required=false"`
+	TlsConfigBean             tlsconfig.TlsConfigBean `ConfigDefBean:"tlsConfigBean"`
+}
+
+// httpRequest holds the records parsed from a request body, the request is answered once the batch holding
+// them has been processed or the origin is destroyed
+type httpRequest struct {
+	records []api.Record
+	done    chan bool
+}
+
+// pendingBatch holds the requests of a batch until its offset is committed or rejected
+type pendingBatch struct {
+	batchNumber int64
+	requests    []*httpRequest
 }
 
 func init() {
@@ -53,55 +91,259 @@ func (h *HttpServerOrigin) Init(stageContext api.StageContext) error {
 	if err := h.BaseStage.Init(stageContext); err != nil {
 		return err
 	}
-	h.httpServer = h.startHttpServer()
-	h.incomingData = make(chan interface{})
-	return nil
+	if h.DataFormat != "" {
+		if err := h.DataFormatConfig.Init(h.DataFormat); err != nil {
+			return err
+		}
+	}
+	if h.HttpConfigs.MaxWaitTimeSecs <= 0 {
+		h.HttpConfigs.MaxWaitTimeSecs = DEFAULT_MAX_WAIT_TIME_SECS
+	}
+	h.incomingRequests = make(chan *httpRequest)
+	h.destroyed = make(chan struct{})
+	h.pendingBatches = make([]*pendingBatch, 0)
+	var err error
+	h.httpServer, err = h.startHttpServer()
+	return err
 }
 
 func (h *HttpServerOrigin) Destroy() error {
-	if err := h.httpServer.Shutdown(nil); err != nil {
+	h.mutex.Lock()
+	if h.stopped || h.httpServer == nil {
+		h.mutex.Unlock()
+		return nil
+	}
+	h.stopped = true
+	close(h.destroyed)
+	// requests of batches that were not processed are answered with an error so that the clients retry them
+	for _, batch := range h.pendingBatches {
+		failRequests(batch.requests)
+	}
+	h.pendingBatches = nil
+	h.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := h.httpServer.Shutdown(ctx); err != nil {
 		return err
 	}
 	log.Println("[DEBUG] HTTP Server - server shutdown successfully")
 	return nil
 }
 
+// Produce adds the records of the incoming requests to the batch until it holds maxBatchSize records or the
+// max wait time elapsed. The records of a request are never split across batches.
 func (h *HttpServerOrigin) Produce(
 	lastSourceOffset string,
 	maxBatchSize int,
 	batchMaker api.BatchMaker,
 ) (string, error) {
 	log.Println("[DEBUG] HTTP Server - Produce method")
-	value := <-h.incomingData
-	log.Println("[DEBUG] Incoming Data: ", value)
-	record, _ := h.GetStageContext().CreateRecord(time.Now().String(), value)
-	batchMaker.AddRecord(record)
-	return "", nil
+	requests := make([]*httpRequest, 0)
+	recordCount := 0
+	timeout := time.After(time.Duration(h.HttpConfigs.MaxWaitTimeSecs * float64(time.Second)))
+	end := false
+	for !end && recordCount < maxBatchSize {
+		select {
+		case request := <-h.incomingRequests:
+			for _, record := range request.records {
+				batchMaker.AddRecord(record)
+			}
+			recordCount += len(request.records)
+			requests = append(requests, request)
+		case <-timeout:
+			end = true
+		case <-h.destroyed:
+			end = true
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.batchCounter++
+	offset := strconv.FormatInt(h.batchCounter, 10)
+	if h.stopped {
+		failRequests(requests)
+	} else if len(requests) > 0 {
+		h.pendingBatches = append(h.pendingBatches, &pendingBatch{batchNumber: h.batchCounter, requests: requests})
+	}
+	return offset, nil
+}
+
+// Commit answers the requests of the committed batch, and of the batches produced before it that are still
+// pending
+func (h *HttpServerOrigin) Commit(offset string) error {
+	for _, batch := range h.takePendingBatches(offset) {
+		for _, request := range batch.requests {
+			request.done <- true
+		}
+	}
+	return nil
+}
+
+// Reject answers the requests of the batch that was not committed with an error, so that the clients retry them
+func (h *HttpServerOrigin) Reject(offset string) error {
+	for _, batch := range h.takePendingBatches(offset) {
+		failRequests(batch.requests)
+	}
+	return nil
+}
+
+// takePendingBatches removes the batches produced up to the given offset from the pending ones, batches are
+// committed and rejected in the order they were produced
+func (h *HttpServerOrigin) takePendingBatches(offset string) []*pendingBatch {
+	batchNumber, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	count := 0
+	for count < len(h.pendingBatches) && h.pendingBatches[count].batchNumber <= batchNumber {
+		count++
+	}
+	batches := h.pendingBatches[:count]
+	h.pendingBatches = h.pendingBatches[count:]
+	return batches
+}
+
+func failRequests(requests []*httpRequest) {
+	for _, request := range requests {
+		request.done <- false
+	}
 }
 
 func (h *HttpServerOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.isValidAppId(r) {
+		log.Printf("[WARN] HTTP Server - Request from %s with an invalid application id", r.RemoteAddr)
+		http.Error(w, "Invalid application id", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		// lets clients check the server is up and the application id is valid
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if h.MaxRequestSizeMB > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(h.MaxRequestSizeMB*1024*1024))
+	}
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Println("[DEBUG] HTTP Server error reading request body : ", err)
 		h.GetStageContext().ReportError(err)
-	} else {
-		h.incomingData <- string(body)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	records, err := h.parseRecords(r, body)
+	if err != nil {
+		log.Printf("[ERROR] HTTP Server - Failed to parse request body: %s", err.Error())
+		h.GetStageContext().ReportError(err)
+		http.Error(w, "Failed to parse request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request := &httpRequest{records: records, done: make(chan bool, 1)}
+	select {
+	case h.incomingRequests <- request:
+	case <-h.destroyed:
+		http.Error(w, "Pipeline is not running", http.StatusServiceUnavailable)
+		return
 	}
+
+	select {
+	case done := <-request.done:
+		if done {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			http.Error(w, "Failed to process the request", http.StatusServiceUnavailable)
+		}
+	case <-time.After(PROCESSING_TIMEOUT):
+		// the request stays in its batch, the client may send it again
+		log.Printf("[WARN] HTTP Server - Request from %s not processed in %s", r.RemoteAddr, PROCESSING_TIMEOUT)
+		http.Error(w, "Timed out processing the request", http.StatusServiceUnavailable)
+	}
+}
+
+func (h *HttpServerOrigin) isValidAppId(r *http.Request) bool {
+	appId := r.Header.Get(HEADER_APPLICATION_ID)
+	if appId == "" && h.HttpConfigs.AppIdViaQueryParamAllowed {
+		appId = r.URL.Query().Get(QUERY_PARAM_APPLICATION_ID)
+	}
+	return subtle.ConstantTimeCompare([]byte(appId), []byte(h.HttpConfigs.AppId)) == 1
 }
 
-func (h *HttpServerOrigin) startHttpServer() *http.Server {
+func (h *HttpServerOrigin) parseRecords(r *http.Request, body []byte) ([]api.Record, error) {
+	h.mutex.Lock()
+	h.requestCounter++
+	sourceIdPrefix := r.RemoteAddr + "::" + strconv.FormatInt(h.requestCounter, 10)
+	h.mutex.Unlock()
+
+	if h.DataFormatConfig.RecordReaderFactory == nil {
+		record, err := h.GetStageContext().CreateRecord(sourceIdPrefix, string(body))
+		if err != nil {
+			return nil, err
+		}
+		return []api.Record{record}, nil
+	}
+
+	recordReader, err := h.DataFormatConfig.RecordReaderFactory.CreateReader(
+		h.GetStageContext(),
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer recordReader.Close()
+
+	records := make([]api.Record, 0)
+	for {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			break
+		}
+		record.GetHeader().(*common.HeaderImpl).SetSourceId(sourceIdPrefix + "::" + strconv.Itoa(len(records)))
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (h *HttpServerOrigin) startHttpServer() (*http.Server, error) {
 	srv := &http.Server{
-		Addr:    ":" + strconv.FormatFloat(h.HttpConfigs.Port, 'E', -1, 64),
+		Addr:    ":" + strconv.FormatFloat(h.HttpConfigs.Port, 'f', -1, 64),
 		Handler: h,
 	}
 
+	// listen right away, so that a port already in use fails the pipeline start
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http"
+	if h.HttpConfigs.TlsConfigBean.TlsEnabled {
+		tlsConfig, err := h.HttpConfigs.TlsConfigBean.NewServerConfig()
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		srv.TLSConfig = tlsConfig
+		listener = tls.NewListener(listener, tlsConfig)
+		scheme = "https"
+	}
+
 	go func() {
-		log.Println("[DEBUG] HTTP Server - Running on URI : http://localhost:", h.HttpConfigs.Port)
-		if err := srv.ListenAndServe(); err != nil {
-			log.Printf("[ERROR] Httpserver: ListenAndServe() error: %s", err)
+		log.Printf("[DEBUG] HTTP Server - Running on URI : %s://localhost%s", scheme, srv.Addr)
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERROR] Httpserver: Serve() error: %s", err)
 			h.GetStageContext().ReportError(err)
 		}
 	}()
 
-	return srv
+	return srv, nil
 }
@@ -16,11 +16,74 @@
 package httpserver
 
 import (
+	"bytes"
+	"github.com/rcrowley/go-metrics"
+	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	_ "github.com/streamsets/datacollector-edge/stages/destinations/trash"
+	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+const (
+	TEST_LIBRARY                = "test-lib"
+	SLOW_DESTINATION_STAGE_NAME = "test_slow_destination"
+)
+
+// slowDestination takes long to write the first batch holding records, so that the batches following it are done
+// before it
+type slowDestination struct {
+	*common.BaseStage
+}
+
+var slowBatches int32 = 1
+
+func (d *slowDestination) Write(batch api.Batch) error {
+	if len(batch.GetRecords()) > 0 && atomic.CompareAndSwapInt32(&slowBatches, 1, 0) {
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+func init() {
+	stagelibrary.SetCreator(TEST_LIBRARY, SLOW_DESTINATION_STAGE_NAME, func() api.Stage {
+		return &slowDestination{BaseStage: &common.BaseStage{}}
+	})
+}
+
+// testOffsetTracker keeps the committed offset in memory
+type testOffsetTracker struct {
+	offset string
+}
+
+func (o *testOffsetTracker) IsFinished() bool {
+	return false
+}
+
+func (o *testOffsetTracker) SetOffset(newOffset string) {
+	o.offset = newOffset
+}
+
+func (o *testOffsetTracker) CommitOffset() error {
+	return nil
+}
+
+func (o *testOffsetTracker) GetOffset() string {
+	return o.offset
+}
+
+func (o *testOffsetTracker) GetLastBatchTime() time.Time {
+	return time.Now()
+}
+
 func getStageContext(portNumber float64, appId string, parameters map[string]interface{}) *common.StageContextImpl {
 	stageConfig := common.StageConfiguration{}
 	stageConfig.Library = LIBRARY
@@ -63,3 +126,233 @@ func TestHttpServerOrigin_Init(t *testing.T) {
 		t.Error("Failed to inject config value for port number")
 	}
 }
+
+func getFreePort(t *testing.T) float64 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return float64(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func createHttpServerOrigin(t *testing.T, stageContext *common.StageContextImpl) *HttpServerOrigin {
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage.(*HttpServerOrigin)
+	if err = stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	return stageInstance
+}
+
+func sendRequest(t *testing.T, port float64, appId string, body string) chan int {
+	statusCodes := make(chan int, 1)
+	go func() {
+		url := "http://127.0.0.1:" + strconv.FormatFloat(port, 'f', -1, 64)
+		request, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		request.Header.Set(HEADER_APPLICATION_ID, appId)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Error(err)
+			statusCodes <- 0
+			return
+		}
+		response.Body.Close()
+		statusCodes <- response.StatusCode
+	}()
+	return statusCodes
+}
+
+func TestHttpServerOrigin_Produce(t *testing.T) {
+	portNumber := getFreePort(t)
+	stageContext := getStageContext(portNumber, "edge", nil)
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: "dataFormat", Value: "JSON"},
+		common.Config{Name: "httpConfigs.maxWaitTimeSecs", Value: float64(1)},
+	)
+	stageInstance := createHttpServerOrigin(t, stageContext)
+	defer stageInstance.Destroy()
+
+	if statusCode := <-sendRequest(t, portNumber, "invalid", `{"a":1}`); statusCode != http.StatusForbidden {
+		t.Errorf("Expected status code %d for an invalid application id, but got %d", http.StatusForbidden, statusCode)
+	}
+
+	statusCodes := sendRequest(t, portNumber, "edge", `{"a":1} {"a":2}`)
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	offset, err := stageInstance.Produce("", 2, batchMaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := batchMaker.GetStageOutput()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but got %d", len(records))
+	}
+	for i, record := range records {
+		field, _ := record.Get("/a")
+		if field == nil || field.Value != float64(i+1) {
+			t.Errorf("Unexpected value for record %d: %v", i, field)
+		}
+	}
+
+	select {
+	case statusCode := <-statusCodes:
+		t.Fatalf("Expected the response to wait for the batch to be processed, but got %d", statusCode)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err = stageInstance.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+	if statusCode := <-statusCodes; statusCode != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, statusCode)
+	}
+
+	// empty batch after max wait time
+	batchMaker = runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err = stageInstance.Produce(offset, 2, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 0 {
+		t.Errorf("Expected an empty batch, but got %d records", len(batchMaker.GetStageOutput()))
+	}
+}
+
+func TestHttpServerOrigin_Destroy(t *testing.T) {
+	portNumber := getFreePort(t)
+	stageContext := getStageContext(portNumber, "edge", nil)
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: "dataFormat", Value: "TEXT"},
+	)
+	stageInstance := createHttpServerOrigin(t, stageContext)
+
+	statusCodes := sendRequest(t, portNumber, "edge", "line 1\nline 2")
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := stageInstance.Produce("", 1000, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 2 {
+		t.Fatalf("Expected 2 records, but got %d", len(batchMaker.GetStageOutput()))
+	}
+
+	// batch was not processed, the client should retry
+	stageInstance.Destroy()
+	if statusCode := <-statusCodes; statusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, but got %d", http.StatusServiceUnavailable, statusCode)
+	}
+}
+
+func TestHttpServerOrigin_CommitAndReject(t *testing.T) {
+	portNumber := getFreePort(t)
+	stageContext := getStageContext(portNumber, "edge", nil)
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: "dataFormat", Value: "TEXT"},
+	)
+	stageInstance := createHttpServerOrigin(t, stageContext)
+	defer stageInstance.Destroy()
+
+	offsets := make([]string, 3)
+	statusCodes := make([]chan int, 3)
+	for i := range offsets {
+		statusCodes[i] = sendRequest(t, portNumber, "edge", "line "+strconv.Itoa(i))
+		batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+		offset, err := stageInstance.Produce("", 1, batchMaker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batchMaker.GetStageOutput()) != 1 {
+			t.Fatalf("Expected 1 record, but got %d", len(batchMaker.GetStageOutput()))
+		}
+		offsets[i] = offset
+	}
+
+	// committing the second batch answers the requests of the first one as well
+	if err := stageInstance.Commit(offsets[1]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if statusCode := <-statusCodes[i]; statusCode != http.StatusOK {
+			t.Errorf("Expected status code %d for request %d, but got %d", http.StatusOK, i, statusCode)
+		}
+	}
+
+	// the requests of a batch that was not committed are answered with an error, so that the client retries them
+	if err := stageInstance.Reject(offsets[2]); err != nil {
+		t.Fatal(err)
+	}
+	if statusCode := <-statusCodes[2]; statusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d, but got %d", http.StatusServiceUnavailable, statusCode)
+	}
+}
+
+func TestHttpServerOrigin_MultipleRunners(t *testing.T) {
+	portNumber := getFreePort(t)
+	originConfig := getStageContext(portNumber, "edge", nil).StageConfig
+	originConfig.InstanceName = "httpserver"
+	originConfig.Configuration = append(
+		originConfig.Configuration,
+		common.Config{Name: "dataFormat", Value: "TEXT"},
+		common.Config{Name: "httpConfigs.maxWaitTimeSecs", Value: float64(0.2)},
+	)
+	originConfig.UiInfo = map[string]interface{}{creation.STAGE_TYPE: creation.SOURCE}
+	originConfig.OutputLanes = []string{"httpserverOutputLane"}
+	destinationConfig := common.StageConfiguration{
+		InstanceName: "destination",
+		Library:      TEST_LIBRARY,
+		StageName:    SLOW_DESTINATION_STAGE_NAME,
+		UiInfo:       map[string]interface{}{creation.STAGE_TYPE: creation.TARGET},
+		InputLanes:   []string{"httpserverOutputLane"},
+	}
+	pipelineConfig := common.PipelineConfiguration{
+		Configuration: []common.Config{{Name: "maxRunners", Value: float64(2)}},
+		Stages:        []common.StageConfiguration{originConfig, destinationConfig},
+		ErrorStage:    creation.GetTrashErrorStageInstance(),
+	}
+
+	pipeline, err := runner.NewPipeline(
+		execution.NewConfig(),
+		"httpServerPipeline",
+		pipelineConfig,
+		&testOffsetTracker{},
+		nil,
+		metrics.NewRegistry(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issues := pipeline.Init(); len(issues) > 0 {
+		t.Fatalf("Unexpected issues: %v", issues)
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- pipeline.Run()
+	}()
+	defer func() {
+		pipeline.Stop()
+		if err := <-runErr; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the batch of the first request is done long after the batches following it, every request must be
+	// answered once its batch is committed along with them
+	statusCodes := []chan int{sendRequest(t, portNumber, "edge", "request 1")}
+	time.Sleep(500 * time.Millisecond)
+	statusCodes = append(statusCodes, sendRequest(t, portNumber, "edge", "request 2"))
+	for i, statusCode := range statusCodes {
+		select {
+		case code := <-statusCode:
+			if code != http.StatusOK {
+				t.Errorf("Expected status code %d for request %d, but got %d", http.StatusOK, i+1, code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Expected request %d to be answered once its batch is committed", i+1)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/stages/lib/dataparser"
+	kafkalib "github.com/streamsets/datacollector-edge/stages/lib/kafka"
+	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LIBRARY                  = "streamsets-datacollector-apache-kafka_1_0-lib"
+	STAGE_NAME               = "com_streamsets_pipeline_stage_origin_multikafka_MultiKafkaDSource"
+	DEFAULT_BATCH_WAIT_TIME  = 2000
+	CONSUME_RETRY_INTERVAL   = 5 * time.Second
+	TOPIC_ATTRIBUTE          = "topic"
+	PARTITION_ATTRIBUTE      = "partition"
+	OFFSET_ATTRIBUTE         = "offset"
+	AUTO_OFFSET_RESET        = "auto.offset.reset"
+	AUTO_COMMIT_INTERVAL_MS  = "auto.commit.interval.ms"
+	SESSION_TIMEOUT_MS       = "session.timeout.ms"
+	HEARTBEAT_INTERVAL_MS    = "heartbeat.interval.ms"
+	FETCH_MIN_BYTES          = "fetch.min.bytes"
+	FETCH_MAX_WAIT_MS        = "fetch.max.wait.ms"
+	MAX_PARTITION_FETCH_SIZE = "max.partition.fetch.bytes"
+)
+
+type KafkaSource struct {
+	*common.BaseStage
+	Conf             KafkaSourceConfig `ConfigDefBean:"conf"`
+	consumerGroup    sarama.ConsumerGroup
+	cancelConsumer   context.CancelFunc
+	consumerDone     chan struct{}
+	incomingMessages chan *kafkaMessage
+	batchCounter     int64
+	pendingBatches   []*pendingBatch
+	mutex            sync.Mutex
+}
+
+// KafkaSourceConfig holds the settings of the origin, BatchWaitTime is expressed in milliseconds.
+//
+// KafkaOptions takes the Kafka consumer properties like auto.offset.reset, session.timeout.ms,
+// security.protocol or sasl.username.
+type KafkaSourceConfig struct {
+	MetadataBrokerList string                            `ConfigDef:"type=STRING,required=true"`
+	ConsumerGroup      string                            `ConfigDef:"type=STRING,required=true"`
+	TopicList          []string                          `ConfigDef:"type=LIST,required=true"`
+	MaxBatchSize       float64                           `ConfigDef:"type=NUMBER,required=false"`
+	BatchWaitTime      float64                           `ConfigDef:"type=NUMBER,required=false"`
+	KafkaOptions       map[string]string                 `ConfigDef:"type=MAP,required=false"`
+	DataFormat         string                            `ConfigDef:"type=STRING,required=true"`
+	DataFormatConfig   dataparser.DataParserFormatConfig `ConfigDefBean:"dataFormatConfig"`
+}
+
+// kafkaMessage holds a consumed message with the session of the consumer group it was consumed in, its offset
+// is marked for commit once the batch holding its records has been committed
+type kafkaMessage struct {
+	message *sarama.ConsumerMessage
+	session sarama.ConsumerGroupSession
+}
+
+// pendingBatch holds the messages of a batch until its offset is committed or rejected
+type pendingBatch struct {
+	batchNumber int64
+	messages    []*kafkaMessage
+}
+
+func init() {
+	stagelibrary.SetCreator(LIBRARY, STAGE_NAME, func() api.Stage {
+		return &KafkaSource{BaseStage: &common.BaseStage{}}
+	})
+}
+
+func (k *KafkaSource) Init(stageContext api.StageContext) error {
+	log.Println("[DEBUG] KafkaSource Init method")
+	if err := k.BaseStage.Init(stageContext); err != nil {
+		return err
+	}
+
+	if err := k.Conf.DataFormatConfig.Init(k.Conf.DataFormat); err != nil {
+		return err
+	}
+
+	if k.Conf.BatchWaitTime <= 0 {
+		k.Conf.BatchWaitTime = DEFAULT_BATCH_WAIT_TIME
+	}
+	k.incomingMessages = make(chan *kafkaMessage)
+	k.pendingBatches = make([]*pendingBatch, 0)
+
+	if len(k.Conf.ConsumerGroup) == 0 {
+		return errors.New("Consumer group must not be empty")
+	}
+	if len(k.Conf.TopicList) == 0 {
+		return errors.New("Topic list must not be empty")
+	}
+	brokerList := kafkalib.GetBrokerList(k.Conf.MetadataBrokerList)
+	if len(brokerList) == 0 {
+		return errors.New("Metadata broker list must not be empty")
+	}
+
+	config, err := k.getConsumerConfig()
+	if err != nil {
+		return err
+	}
+	if k.consumerGroup, err = sarama.NewConsumerGroup(brokerList, k.Conf.ConsumerGroup, config); err != nil {
+		return err
+	}
+
+	var ctx context.Context
+	ctx, k.cancelConsumer = context.WithCancel(context.Background())
+	k.consumerDone = make(chan struct{})
+	go k.consume(ctx)
+	return nil
+}
+
+func (k *KafkaSource) getConsumerConfig() (*sarama.Config, error) {
+	config, err := kafkalib.NewClientConfig(
+		k.Conf.KafkaOptions,
+		AUTO_OFFSET_RESET,
+		AUTO_COMMIT_INTERVAL_MS,
+		SESSION_TIMEOUT_MS,
+		HEARTBEAT_INTERVAL_MS,
+		FETCH_MIN_BYTES,
+		FETCH_MAX_WAIT_MS,
+		MAX_PARTITION_FETCH_SIZE,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// consumer groups were introduced with Kafka 0.10.2
+	if _, ok := k.Conf.KafkaOptions[kafkalib.KAFKA_VERSION]; !ok {
+		config.Version = sarama.V0_10_2_0
+	}
+
+	for key, value := range k.Conf.KafkaOptions {
+		switch key {
+		case AUTO_OFFSET_RESET:
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "earliest":
+				config.Consumer.Offsets.Initial = sarama.OffsetOldest
+			case "latest":
+				config.Consumer.Offsets.Initial = sarama.OffsetNewest
+			default:
+				err = errors.New(fmt.Sprintf("Unsupported value '%s' for Kafka configuration '%s'", value, key))
+			}
+		case AUTO_COMMIT_INTERVAL_MS:
+			config.Consumer.Offsets.CommitInterval, err = kafkalib.GetDurationMillis(key, value)
+		case SESSION_TIMEOUT_MS:
+			config.Consumer.Group.Session.Timeout, err = kafkalib.GetDurationMillis(key, value)
+		case HEARTBEAT_INTERVAL_MS:
+			config.Consumer.Group.Heartbeat.Interval, err = kafkalib.GetDurationMillis(key, value)
+		case FETCH_MIN_BYTES:
+			var fetchMinBytes int
+			if fetchMinBytes, err = kafkalib.GetInt(key, value); err == nil {
+				config.Consumer.Fetch.Min = int32(fetchMinBytes)
+			}
+		case FETCH_MAX_WAIT_MS:
+			config.Consumer.MaxWaitTime, err = kafkalib.GetDurationMillis(key, value)
+		case MAX_PARTITION_FETCH_SIZE:
+			var fetchSize int
+			if fetchSize, err = kafkalib.GetInt(key, value); err == nil {
+				config.Consumer.Fetch.Default = int32(fetchSize)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config, config.Validate()
+}
+
+// consume joins the consumer group until the origin is destroyed, a new session starts after every rebalance
+func (k *KafkaSource) consume(ctx context.Context) {
+	defer close(k.consumerDone)
+	for {
+		if err := k.consumerGroup.Consume(ctx, k.Conf.TopicList, k); err != nil {
+			log.Printf("[ERROR] Kafka consumer group '%s' failed: %s", k.Conf.ConsumerGroup, err)
+			k.GetStageContext().ReportError(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(CONSUME_RETRY_INTERVAL):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (k *KafkaSource) Setup(session sarama.ConsumerGroupSession) error {
+	log.Printf("[DEBUG] KafkaSource - Assigned partitions %v", session.Claims())
+	return nil
+}
+
+func (k *KafkaSource) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim hands over the messages of an assigned partition to Produce
+func (k *KafkaSource) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for message := range claim.Messages() {
+		select {
+		case k.incomingMessages <- &kafkaMessage{message: message, session: session}:
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// Produce adds the records parsed from the consumed messages to the batch until it holds maxBatchSize records
+// or the batch wait time elapsed.
+func (k *KafkaSource) Produce(
+	lastSourceOffset string,
+	maxBatchSize int,
+	batchMaker api.BatchMaker,
+) (string, error) {
+	log.Println("[DEBUG] KafkaSource - Produce method")
+	if k.Conf.MaxBatchSize > 0 && int(k.Conf.MaxBatchSize) < maxBatchSize {
+		maxBatchSize = int(k.Conf.MaxBatchSize)
+	}
+
+	messages := make([]*kafkaMessage, 0)
+	recordCount := 0
+	timeout := time.After(time.Duration(k.Conf.BatchWaitTime) * time.Millisecond)
+	end := false
+	for !end && recordCount < maxBatchSize {
+		select {
+		case message := <-k.incomingMessages:
+			// messages that can't be parsed are committed with the batch, so that they are not consumed again
+			messages = append(messages, message)
+			records, err := k.parseRecords(message.message)
+			if err != nil {
+				log.Printf("[ERROR] Failed to parse Kafka message: %s", err)
+				k.GetStageContext().ReportError(err)
+				continue
+			}
+			for _, record := range records {
+				batchMaker.AddRecord(record)
+			}
+			recordCount += len(records)
+		case <-timeout:
+			end = true
+		case <-k.consumerDone:
+			end = true
+		}
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.batchCounter++
+	offset := strconv.FormatInt(k.batchCounter, 10)
+	if len(messages) > 0 {
+		k.pendingBatches = append(k.pendingBatches, &pendingBatch{batchNumber: k.batchCounter, messages: messages})
+	}
+	return offset, nil
+}
+
+func (k *KafkaSource) parseRecords(message *sarama.ConsumerMessage) ([]api.Record, error) {
+	sourceIdPrefix := message.Topic + "::" + strconv.FormatInt(int64(message.Partition), 10) + "::" +
+		strconv.FormatInt(message.Offset, 10)
+
+	recordReader, err := k.Conf.DataFormatConfig.RecordReaderFactory.CreateReader(
+		k.GetStageContext(),
+		bytes.NewReader(message.Value),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer recordReader.Close()
+
+	records := make([]api.Record, 0)
+	for {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			break
+		}
+		header := record.GetHeader().(*common.HeaderImpl)
+		header.SetSourceId(sourceIdPrefix + "::" + strconv.Itoa(len(records)))
+		header.SetAttribute(TOPIC_ATTRIBUTE, message.Topic)
+		header.SetAttribute(PARTITION_ATTRIBUTE, strconv.FormatInt(int64(message.Partition), 10))
+		header.SetAttribute(OFFSET_ATTRIBUTE, strconv.FormatInt(message.Offset, 10))
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Commit marks the offsets of the messages of the committed batch, and of the batches produced before it that are
+// still pending. The consumer group commits the marked offsets to Kafka periodically and when the partitions are
+// released.
+func (k *KafkaSource) Commit(offset string) error {
+	for _, batch := range k.takePendingBatches(offset) {
+		for _, message := range batch.messages {
+			message.session.MarkMessage(message.message, "")
+		}
+	}
+	return nil
+}
+
+// Reject drops the messages of the batch that was not committed without marking their offsets
+func (k *KafkaSource) Reject(offset string) error {
+	k.takePendingBatches(offset)
+	return nil
+}
+
+// takePendingBatches removes the batches produced up to the given offset from the pending ones, batches are
+// committed and rejected in the order they were produced
+func (k *KafkaSource) takePendingBatches(offset string) []*pendingBatch {
+	batchNumber, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return nil
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	count := 0
+	for count < len(k.pendingBatches) && k.pendingBatches[count].batchNumber <= batchNumber {
+		count++
+	}
+	batches := k.pendingBatches[:count]
+	k.pendingBatches = k.pendingBatches[count:]
+	return batches
+}
+
+// Destroy leaves the consumer group, the offsets of the messages of uncommitted batches are not marked so that
+// they are consumed again on the next run.
+func (k *KafkaSource) Destroy() error {
+	log.Println("[DEBUG] KafkaSource - Destroy method")
+	if k.cancelConsumer == nil {
+		return nil
+	}
+	k.cancelConsumer()
+	err := k.consumerGroup.Close()
+	<-k.consumerDone
+
+	k.mutex.Lock()
+	k.pendingBatches = make([]*pendingBatch, 0)
+	k.mutex.Unlock()
+	return err
+}
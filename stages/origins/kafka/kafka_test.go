@@ -0,0 +1,310 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package kafka
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSession struct {
+	ctx    context.Context
+	marked []*sarama.ConsumerMessage
+	mutex  sync.Mutex
+}
+
+func (s *testSession) Claims() map[string][]int32               { return map[string][]int32{"sdc": {0}} }
+func (s *testSession) MemberID() string                         { return "member" }
+func (s *testSession) GenerationID() int32                      { return 1 }
+func (s *testSession) MarkOffset(string, int32, int64, string)  {}
+func (s *testSession) ResetOffset(string, int32, int64, string) {}
+func (s *testSession) Context() context.Context                 { return s.ctx }
+func (s *testSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.marked = append(s.marked, msg)
+}
+
+func (s *testSession) getMarked() []*sarama.ConsumerMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.marked
+}
+
+type testClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *testClaim) Topic() string                            { return "sdc" }
+func (c *testClaim) Partition() int32                         { return 0 }
+func (c *testClaim) InitialOffset() int64                     { return 0 }
+func (c *testClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *testClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func getStageContext(configuration map[string]interface{}) *common.StageContextImpl {
+	stageConfig := common.StageConfiguration{}
+	stageConfig.Library = LIBRARY
+	stageConfig.StageName = STAGE_NAME
+	stageConfig.InstanceName = "kafka"
+	stageConfig.Configuration = []common.Config{
+		{
+			Name:  "conf.metadataBrokerList",
+			Value: "localhost:9092",
+		},
+		{
+			Name:  "conf.consumerGroup",
+			Value: "sdc-edge",
+		},
+		{
+			Name:  "conf.topicList",
+			Value: []interface{}{"sdc"},
+		},
+		{
+			Name:  "conf.dataFormat",
+			Value: "JSON",
+		},
+	}
+	for name, value := range configuration {
+		stageConfig.Configuration = append(stageConfig.Configuration, common.Config{Name: name, Value: value})
+	}
+	return &common.StageContextImpl{
+		StageConfig: stageConfig,
+		ErrorSink:   common.NewErrorSink(),
+	}
+}
+
+func createStage(t *testing.T, stageContext *common.StageContextImpl) *KafkaSource {
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stageBean.Stage.(*KafkaSource)
+}
+
+// createKafkaSource creates the origin with the message handling of Init but without joining a consumer group
+func createKafkaSource(t *testing.T) *KafkaSource {
+	stageContext := getStageContext(map[string]interface{}{"conf.batchWaitTime": float64(200)})
+	stageInstance := createStage(t, stageContext)
+	stageInstance.BaseStage.Init(stageContext)
+	if err := stageInstance.Conf.DataFormatConfig.Init(stageInstance.Conf.DataFormat); err != nil {
+		t.Fatal(err)
+	}
+	stageInstance.incomingMessages = make(chan *kafkaMessage)
+	stageInstance.pendingBatches = make([]*pendingBatch, 0)
+	stageInstance.consumerDone = make(chan struct{})
+	return stageInstance
+}
+
+func TestKafkaSource_Init(t *testing.T) {
+	stageContext := getStageContext(map[string]interface{}{
+		"conf.maxBatchSize":  float64(100),
+		"conf.batchWaitTime": float64(500),
+		"conf.kafkaOptions": []interface{}{
+			map[string]interface{}{"key": AUTO_OFFSET_RESET, "value": "earliest"},
+			map[string]interface{}{"key": SESSION_TIMEOUT_MS, "value": "20000"},
+		},
+	})
+	stageInstance := createStage(t, stageContext)
+
+	if stageInstance.Conf.MetadataBrokerList != "localhost:9092" {
+		t.Error("Failed to inject config value for metadataBrokerList")
+	}
+	if stageInstance.Conf.ConsumerGroup != "sdc-edge" {
+		t.Error("Failed to inject config value for consumerGroup")
+	}
+	if len(stageInstance.Conf.TopicList) != 1 || stageInstance.Conf.TopicList[0] != "sdc" {
+		t.Error("Failed to inject config value for topicList")
+	}
+	if stageInstance.Conf.MaxBatchSize != 100 || stageInstance.Conf.BatchWaitTime != 500 {
+		t.Error("Failed to inject config value for maxBatchSize and batchWaitTime")
+	}
+
+	config, err := stageInstance.getConsumerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Consumer.Offsets.Initial != sarama.OffsetOldest {
+		t.Error("Expected the consumer to start from the oldest offset")
+	}
+	if config.Consumer.Group.Session.Timeout != 20*time.Second {
+		t.Errorf("Expected a session timeout of 20s, but got %v", config.Consumer.Group.Session.Timeout)
+	}
+	if config.Version != sarama.V0_10_2_0 {
+		t.Errorf("Expected Kafka version 0.10.2.0, but got %s", config.Version)
+	}
+}
+
+func TestKafkaSource_InvalidConfig(t *testing.T) {
+	invalidConfigs := []map[string]interface{}{
+		{"conf.consumerGroup": ""},
+		{"conf.topicList": []interface{}{}},
+		{"conf.metadataBrokerList": " "},
+		{
+			"conf.kafkaOptions": []interface{}{
+				map[string]interface{}{"key": AUTO_OFFSET_RESET, "value": "none"},
+			},
+		},
+	}
+
+	for _, invalidConfig := range invalidConfigs {
+		stageContext := getStageContext(invalidConfig)
+		stageInstance := createStage(t, stageContext)
+		if err := stageInstance.Init(stageContext); err == nil {
+			t.Errorf("Expected an error for invalid configuration %v", invalidConfig)
+			stageInstance.Destroy()
+		}
+	}
+}
+
+func TestKafkaSource_Commit(t *testing.T) {
+	stageInstance := createKafkaSource(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &testSession{ctx: ctx}
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "sdc", Partition: 0, Offset: 10, Value: []byte(`{"a": 1}`)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "sdc", Partition: 0, Offset: 11, Value: []byte(`{"a": `)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "sdc", Partition: 0, Offset: 12, Value: []byte(`{"a": 3}`)}
+
+	claimDone := make(chan struct{})
+	go func() {
+		stageInstance.ConsumeClaim(session, claim)
+		close(claimDone)
+	}()
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	offset, err := stageInstance.Produce("", 10, batchMaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := batchMaker.GetStageOutput()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, but got %d", len(records))
+	}
+	attributes := records[1].GetHeader().GetAttributes()
+	if attributes[TOPIC_ATTRIBUTE] != "sdc" || attributes[PARTITION_ATTRIBUTE] != "0" ||
+		attributes[OFFSET_ATTRIBUTE] != "12" {
+		t.Errorf("Unexpected record header attributes: %v", attributes)
+	}
+
+	if len(session.getMarked()) != 0 {
+		t.Error("Expected the offsets to be marked only once the batch is committed")
+	}
+	if err = stageInstance.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+	if marked := session.getMarked(); len(marked) != 3 || marked[2].Offset != 12 {
+		t.Errorf("Expected the offsets of the 3 messages to be marked, but got %d", len(marked))
+	}
+
+	// the claims are closed when the session ends
+	cancel()
+	close(claim.messages)
+	select {
+	case <-claimDone:
+	case <-time.After(time.Second):
+		t.Error("Expected the claim to be released once the session is done")
+	}
+}
+
+func TestKafkaSource_MaxBatchSize(t *testing.T) {
+	stageInstance := createKafkaSource(t)
+	stageInstance.Conf.MaxBatchSize = 1
+
+	session := &testSession{ctx: context.Background()}
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "sdc", Offset: 1, Value: []byte(`{"a": 1}`)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: "sdc", Offset: 2, Value: []byte(`{"a": 2}`)}
+	close(claim.messages)
+	go stageInstance.ConsumeClaim(session, claim)
+
+	for i := 1; i <= 2; i++ {
+		batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+		offset, err := stageInstance.Produce("", 10, batchMaker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batchMaker.GetStageOutput()) != 1 {
+			t.Fatalf("Expected 1 record, but got %d", len(batchMaker.GetStageOutput()))
+		}
+		stageInstance.Commit(offset)
+		if marked := session.getMarked(); len(marked) != i || marked[i-1].Offset != int64(i) {
+			t.Errorf("Expected offset %d to be marked", i)
+		}
+	}
+
+	// uncommitted batches are dropped on destroy
+	close(stageInstance.consumerDone)
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := stageInstance.Produce("", 10, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 0 {
+		t.Errorf("Expected an empty batch, but got %d records", len(batchMaker.GetStageOutput()))
+	}
+}
+
+func TestKafkaSource_CommitAndReject(t *testing.T) {
+	stageInstance := createKafkaSource(t)
+	stageInstance.Conf.MaxBatchSize = 1
+
+	session := &testSession{ctx: context.Background()}
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	for i := 1; i <= 3; i++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "sdc", Offset: int64(i), Value: []byte(`{"a": 1}`)}
+	}
+	close(claim.messages)
+	go stageInstance.ConsumeClaim(session, claim)
+
+	offsets := make([]string, 3)
+	for i := range offsets {
+		batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+		offset, err := stageInstance.Produce("", 10, batchMaker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(batchMaker.GetStageOutput()) != 1 {
+			t.Fatalf("Expected 1 record, but got %d", len(batchMaker.GetStageOutput()))
+		}
+		offsets[i] = offset
+	}
+
+	// committing the second batch marks the messages of the first one as well
+	if err := stageInstance.Commit(offsets[1]); err != nil {
+		t.Fatal(err)
+	}
+	if marked := session.getMarked(); len(marked) != 2 || marked[0].Offset != 1 || marked[1].Offset != 2 {
+		t.Errorf("Expected offsets 1 and 2 to be marked, but got %d offsets", len(marked))
+	}
+
+	// the messages of a batch that was not committed are never marked
+	if err := stageInstance.Reject(offsets[2]); err != nil {
+		t.Fatal(err)
+	}
+	if marked := session.getMarked(); len(marked) != 2 {
+		t.Errorf("Expected the offset of the rejected batch not to be marked, but got %d offsets", len(marked))
+	}
+	if len(stageInstance.pendingBatches) != 0 {
+		t.Errorf("Expected no pending batches, but got %d", len(stageInstance.pendingBatches))
+	}
+}
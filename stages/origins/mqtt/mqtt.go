@@ -23,24 +23,52 @@ import (
 	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
 	"log"
 	"strconv"
+	"sync"
+	"time"
 )
 
 const (
-	LIBRARY    = "streamsets-datacollector-basic-lib"
-	STAGE_NAME = "com_streamsets_pipeline_stage_origin_mqtt_MqttClientDSource"
+	LIBRARY                    = "streamsets-datacollector-basic-lib"
+	STAGE_NAME                 = "com_streamsets_pipeline_stage_origin_mqtt_MqttClientDSource"
+	DEFAULT_MAX_WAIT_TIME_SECS = 1
+	DISCONNECT_QUIESCE         = 250
 )
 
 type MqttClientSource struct {
 	*common.BaseStage
 	*mqttlib.MqttConnector
-	CommonConf      mqttlib.MqttClientConfigBean `ConfigDefBean:"commonConf"`
-	SubscriberConf  MqttClientSourceConfigBean   `ConfigDefBean:"subscriberConf"`
-	incomingRecords chan api.Record
+	CommonConf       mqttlib.MqttClientConfigBean `ConfigDefBean:"commonConf"`
+	SubscriberConf   MqttClientSourceConfigBean   `ConfigDefBean:"subscriberConf"`
+	incomingMessages chan *mqttMessage
+	destroyed        chan struct{}
+	batchCounter     int64
+	pendingBatches   []*pendingBatch
+	rejectedMessages []*mqttMessage
+	stopped          bool
+	mutex            sync.Mutex
 }
 
+// MqttClientSourceConfigBean holds the subscriptions of the origin, TopicQos overrides the QoS of the common
+// config for the topic filters it lists.
 type MqttClientSourceConfigBean struct {
-	TopicFilters []string `ConfigDef:"type=LIST,required=true"`
-	DataFormat   string   `ConfigDef:"type=STRING,required=true"`
+	TopicFilters    []string          `ConfigDef:"type=LIST,required=true"`
+	TopicQos        map[string]string `ConfigDef:"type=MAP,required=false"`
+	DataFormat      string            `ConfigDef:"type=STRING,required=true"`
+	MaxWaitTimeSecs float64           `ConfigDef:"type=NUMBER,required=false"`
+}
+
+// mqttMessage holds the record created from a message, the message is acknowledged to the broker once the
+// batch holding the record has been committed
+type mqttMessage struct {
+	message MQTT.Message
+	record  api.Record
+	done    chan bool
+}
+
+// pendingBatch holds the messages of a batch until its offset is committed or rejected
+type pendingBatch struct {
+	batchNumber int64
+	messages    []*mqttMessage
 }
 
 func init() {
@@ -49,12 +77,19 @@ func init() {
 	})
 }
 
-func (ms *MqttClientSource) getTopicFilterAndQosMap() map[string]byte {
+func (ms *MqttClientSource) getTopicFilterAndQosMap() (map[string]byte, error) {
 	topicFilters := make(map[string]byte, len(ms.SubscriberConf.TopicFilters))
 	for _, topicFilter := range ms.SubscriberConf.TopicFilters {
-		topicFilters[topicFilter] = byte(ms.Qos)
+		qos := ms.Qos
+		if topicQos, ok := ms.SubscriberConf.TopicQos[topicFilter]; ok {
+			var err error
+			if qos, err = ms.GetQosFromString(topicQos); err != nil {
+				return nil, err
+			}
+		}
+		topicFilters[topicFilter] = byte(qos)
 	}
-	return topicFilters
+	return topicFilters, nil
 }
 
 func (ms *MqttClientSource) Init(stageContext api.StageContext) error {
@@ -63,12 +98,27 @@ func (ms *MqttClientSource) Init(stageContext api.StageContext) error {
 		return err
 	}
 
-	ms.incomingRecords = make(chan api.Record)
+	if ms.SubscriberConf.MaxWaitTimeSecs <= 0 {
+		ms.SubscriberConf.MaxWaitTimeSecs = DEFAULT_MAX_WAIT_TIME_SECS
+	}
+	ms.incomingMessages = make(chan *mqttMessage)
+	ms.destroyed = make(chan struct{})
+	ms.pendingBatches = make([]*pendingBatch, 0)
+	ms.rejectedMessages = make([]*mqttMessage, 0)
 
-	err := ms.InitializeClient(ms.CommonConf)
+	err := ms.InitializeClientWithOptions(ms.CommonConf, func(opts *MQTT.ClientOptions) {
+		// a persistent session lets the broker redeliver the messages not acknowledged before a restart
+		opts.SetCleanSession(ms.CommonConf.CleanSession)
+		// messages are handled concurrently as every handler waits for its batch to be committed
+		opts.SetOrderMatters(false)
+	})
+	if err != nil {
+		return err
+	}
+	topicFilters, err := ms.getTopicFilterAndQosMap()
 	if err == nil {
 		if token := ms.Client.SubscribeMultiple(
-			ms.getTopicFilterAndQosMap(),
+			topicFilters,
 			ms.MessageHandler,
 		); token.Wait() && token.Error() != nil {
 			err = token.Error()
@@ -77,30 +127,151 @@ func (ms *MqttClientSource) Init(stageContext api.StageContext) error {
 	return err
 }
 
+// Produce adds the records of the messages of rejected batches first, and then the records of the incoming
+// messages to the batch until it holds maxBatchSize records or the max wait time elapsed.
 func (ms *MqttClientSource) Produce(
 	lastSourceOffset string,
 	maxBatchSize int,
 	batchMaker api.BatchMaker,
 ) (string, error) {
 	log.Println("[DEBUG] MqttClientSource - Produce method")
-	record := <-ms.incomingRecords
-	batchMaker.AddRecord(record)
-	return "", nil
+	ms.mutex.Lock()
+	count := len(ms.rejectedMessages)
+	if count > maxBatchSize {
+		count = maxBatchSize
+	}
+	messages := append(make([]*mqttMessage, 0, count), ms.rejectedMessages[:count]...)
+	ms.rejectedMessages = ms.rejectedMessages[count:]
+	ms.mutex.Unlock()
+	for _, message := range messages {
+		batchMaker.AddRecord(message.record)
+	}
+
+	timeout := time.After(time.Duration(ms.SubscriberConf.MaxWaitTimeSecs * float64(time.Second)))
+	end := false
+	for !end && len(messages) < maxBatchSize {
+		select {
+		case message := <-ms.incomingMessages:
+			batchMaker.AddRecord(message.record)
+			messages = append(messages, message)
+		case <-timeout:
+			end = true
+		case <-ms.destroyed:
+			end = true
+		}
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.batchCounter++
+	offset := strconv.FormatInt(ms.batchCounter, 10)
+	if ms.stopped {
+		releaseMessages(messages, false)
+	} else if len(messages) > 0 {
+		ms.pendingBatches = append(ms.pendingBatches, &pendingBatch{batchNumber: ms.batchCounter, messages: messages})
+	}
+	return offset, nil
+}
+
+// Commit acknowledges the messages of the committed batch to the broker, and of the batches produced before it
+// that are still pending
+func (ms *MqttClientSource) Commit(offset string) error {
+	for _, batch := range ms.takePendingBatches(offset) {
+		releaseMessages(batch.messages, true)
+	}
+	return nil
+}
+
+// Reject delivers the messages of the batch that was not committed again in the next batches, they are
+// acknowledged to the broker once a batch holding them is committed
+func (ms *MqttClientSource) Reject(offset string) error {
+	for _, batch := range ms.takePendingBatches(offset) {
+		for _, message := range batch.messages {
+			// the records of the rejected batch may have been changed by the processors
+			message.record = ms.createRecord(message.message)
+		}
+		ms.mutex.Lock()
+		if ms.stopped {
+			releaseMessages(batch.messages, false)
+		} else {
+			ms.rejectedMessages = append(ms.rejectedMessages, batch.messages...)
+		}
+		ms.mutex.Unlock()
+	}
+	return nil
+}
+
+// takePendingBatches removes the batches produced up to the given offset from the pending ones, batches are
+// committed and rejected in the order they were produced
+func (ms *MqttClientSource) takePendingBatches(offset string) []*pendingBatch {
+	batchNumber, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return nil
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	count := 0
+	for count < len(ms.pendingBatches) && ms.pendingBatches[count].batchNumber <= batchNumber {
+		count++
+	}
+	batches := ms.pendingBatches[:count]
+	ms.pendingBatches = ms.pendingBatches[count:]
+	return batches
 }
 
 func (ms *MqttClientSource) Destroy() error {
 	log.Println("[DEBUG] MqttClientSource - Destroy method")
-	ms.Client.Unsubscribe(ms.SubscriberConf.TopicFilters...).Wait()
-	ms.Client.Disconnect(250)
-	//Close channel after unsubscribe and disconnect
-	close(ms.incomingRecords)
+	ms.mutex.Lock()
+	if ms.stopped || ms.destroyed == nil {
+		ms.mutex.Unlock()
+		return nil
+	}
+	ms.stopped = true
+	ms.mutex.Unlock()
+
+	// Don't unsubscribe, so that with a persistent session (clean session disabled) the broker keeps the
+	// messages published until the pipeline runs again. Disconnecting before releasing the handlers of the
+	// messages not committed yet keeps them from being acknowledged, the broker delivers them again on the
+	// next connection.
+	if ms.Client != nil {
+		ms.Client.Disconnect(DISCONNECT_QUIESCE)
+	}
+
+	ms.mutex.Lock()
+	close(ms.destroyed)
+	for _, batch := range ms.pendingBatches {
+		releaseMessages(batch.messages, false)
+	}
+	ms.pendingBatches = nil
+	releaseMessages(ms.rejectedMessages, false)
+	ms.rejectedMessages = nil
+	ms.mutex.Unlock()
 	return nil
 }
 
-func (md *MqttClientSource) MessageHandler(client MQTT.Client, msg MQTT.Message) {
-	value := string(msg.Payload())
+// MessageHandler waits for the batch holding the record created from the message to be committed, the client
+// acknowledges QoS 1 and 2 messages once the handler returns.
+func (ms *MqttClientSource) MessageHandler(client MQTT.Client, msg MQTT.Message) {
+	log.Println("[DEBUG] Incoming Data: ", string(msg.Payload()))
+	message := &mqttMessage{message: msg, record: ms.createRecord(msg), done: make(chan bool, 1)}
+	select {
+	case ms.incomingMessages <- message:
+	case <-ms.destroyed:
+		return
+	}
+	if !<-message.done {
+		log.Printf("[DEBUG] MqttClientSource - Message %d not acknowledged", msg.MessageID())
+	}
+}
+
+func (ms *MqttClientSource) createRecord(msg MQTT.Message) api.Record {
 	msgId := strconv.FormatUint(uint64(msg.MessageID()), 10)
-	log.Println("[DEBUG] Incoming Data: ", value)
-	record, _ := md.GetStageContext().CreateRecord(msgId, value)
-	md.incomingRecords <- record
+	record, _ := ms.GetStageContext().CreateRecord(msgId, string(msg.Payload()))
+	return record
+}
+
+func releaseMessages(messages []*mqttMessage, committed bool) {
+	for _, message := range messages {
+		message.done <- committed
+	}
 }
@@ -16,11 +16,28 @@
 package mqtt
 
 import (
+	MQTT "github.com/eclipse/paho.mqtt.golang"
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"github.com/streamsets/datacollector-edge/stages/lib/mqtt/mqtttest"
 	"testing"
+	"time"
 )
 
+type testMessage struct {
+	id      uint16
+	payload string
+}
+
+func (m *testMessage) Duplicate() bool   { return false }
+func (m *testMessage) Qos() byte         { return 1 }
+func (m *testMessage) Retained() bool    { return false }
+func (m *testMessage) Topic() string     { return "Sample/Topic" }
+func (m *testMessage) MessageID() uint16 { return m.id }
+func (m *testMessage) Payload() []byte   { return []byte(m.payload) }
+func (m *testMessage) Ack()              {}
+
 func getStageContext(
 	brokerUrl string,
 	clientId string,
@@ -105,3 +122,228 @@ func TestMqttClientSource_Init(t *testing.T) {
 		t.Error("Failed to inject config value for dataFormat")
 	}
 }
+
+// createMqttClientSource creates the origin with the message handling of Init but without a broker connection
+func createMqttClientSource(t *testing.T) *MqttClientSource {
+	stageContext := getStageContext("tcp://test:1883", "clientId", "AT_LEAST_ONCE", []string{"Sample/Topic"}, "TEXT", nil)
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage.(*MqttClientSource)
+	stageInstance.BaseStage.Init(stageContext)
+	stageInstance.SubscriberConf.MaxWaitTimeSecs = 0.5
+	stageInstance.incomingMessages = make(chan *mqttMessage)
+	stageInstance.destroyed = make(chan struct{})
+	stageInstance.pendingBatches = make([]*pendingBatch, 0)
+	stageInstance.rejectedMessages = make([]*mqttMessage, 0)
+	return stageInstance
+}
+
+func handleMessage(stageInstance *MqttClientSource, id uint16, payload string) chan bool {
+	handled := make(chan bool, 1)
+	go func() {
+		stageInstance.MessageHandler(nil, &testMessage{id: id, payload: payload})
+		handled <- true
+	}()
+	return handled
+}
+
+func TestMqttClientSource_Commit(t *testing.T) {
+	stageInstance := createMqttClientSource(t)
+	defer stageInstance.Destroy()
+
+	handled1 := handleMessage(stageInstance, 1, "message 1")
+	handled2 := handleMessage(stageInstance, 2, "message 2")
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	offset, err := stageInstance.Produce("", 10, batchMaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 2 {
+		t.Fatalf("Expected 2 records, but got %d", len(batchMaker.GetStageOutput()))
+	}
+
+	select {
+	case <-handled1:
+		t.Fatal("Expected the message to be acknowledged only once the batch is committed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err = stageInstance.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+	<-handled1
+	<-handled2
+}
+
+func produceMessages(t *testing.T, stageInstance *MqttClientSource, expected ...string) string {
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	offset, err := stageInstance.Produce("", 10, batchMaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := batchMaker.GetStageOutput()
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, but got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		if field, _ := record.Get(); field.Value != expected[i] {
+			t.Errorf("Expected record value '%s', but got '%v'", expected[i], field.Value)
+		}
+	}
+	return offset
+}
+
+func TestMqttClientSource_Reject(t *testing.T) {
+	stageInstance := createMqttClientSource(t)
+	defer stageInstance.Destroy()
+
+	handled1 := handleMessage(stageInstance, 1, "message 1")
+	offset1 := produceMessages(t, stageInstance, "message 1")
+	handled2 := handleMessage(stageInstance, 2, "message 2")
+	produceMessages(t, stageInstance, "message 2")
+
+	// the message of the rejected batch is not acknowledged, it is delivered again in the next batch
+	if err := stageInstance.Reject(offset1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled1:
+		t.Fatal("Expected the message of the rejected batch not to be acknowledged")
+	case <-time.After(100 * time.Millisecond):
+	}
+	offset3 := produceMessages(t, stageInstance, "message 1")
+
+	// committing the last batch acknowledges the messages of the batches produced before it as well
+	if err := stageInstance.Commit(offset3); err != nil {
+		t.Fatal(err)
+	}
+	for _, handled := range []chan bool{handled1, handled2} {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Error("Expected the messages to be acknowledged once the last batch is committed")
+		}
+	}
+}
+
+func TestMqttClientSource_Destroy(t *testing.T) {
+	stageInstance := createMqttClientSource(t)
+
+	handled := handleMessage(stageInstance, 1, "message 1")
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := stageInstance.Produce("", 1, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 1 {
+		t.Fatalf("Expected 1 record, but got %d", len(batchMaker.GetStageOutput()))
+	}
+
+	stageInstance.Destroy()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Error("Expected the handlers of uncommitted messages to be released on destroy")
+	}
+
+	// empty batch once destroyed
+	batchMaker = runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := stageInstance.Produce("", 1, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 0 {
+		t.Errorf("Expected an empty batch, but got %d records", len(batchMaker.GetStageOutput()))
+	}
+}
+
+func TestMqttClientSource_TopicQos(t *testing.T) {
+	stageContext := getStageContext(
+		"tcp://test:1883",
+		"clientId",
+		"AT_MOST_ONCE",
+		[]string{"Sample/Topic", "Sample/Other"},
+		"TEXT",
+		nil,
+	)
+	stageContext.StageConfig.Configuration = append(stageContext.StageConfig.Configuration, common.Config{
+		Name: "subscriberConf.topicQos",
+		Value: []interface{}{
+			map[string]interface{}{"key": "Sample/Other", "value": "EXACTLY_ONCE"},
+		},
+	})
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage.(*MqttClientSource)
+	stageInstance.Qos = 0
+
+	topicFilters, err := stageInstance.getTopicFilterAndQosMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topicFilters["Sample/Topic"] != 0 || topicFilters["Sample/Other"] != 2 {
+		t.Errorf("Unexpected topic filters: %v", topicFilters)
+	}
+
+	stageInstance.SubscriberConf.TopicQos["Sample/Other"] = "INVALID"
+	if _, err = stageInstance.getTopicFilterAndQosMap(); err == nil {
+		t.Error("Expected an error for an unsupported QoS")
+	}
+}
+
+func TestMqttClientSource_Broker(t *testing.T) {
+	broker, err := mqtttest.NewBroker(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broker.Close()
+
+	stageContext := getStageContext(broker.Url(), "edgeSource", "AT_LEAST_ONCE", []string{"Sample/#"}, "TEXT", nil)
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stageInstance := stageBean.Stage.(*MqttClientSource)
+	if err = stageInstance.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	defer stageInstance.Destroy()
+	if connectPackets := broker.GetConnectPackets(); connectPackets[0].CleanSession {
+		t.Error("Expected the origin to connect with a persistent session")
+	}
+
+	publisher := MQTT.NewClient(MQTT.NewClientOptions().AddBroker(broker.Url()).SetClientID("publisher"))
+	if token := publisher.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer publisher.Disconnect(0)
+	if token := publisher.Publish("Sample/Topic", 1, false, "message 1"); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	offset, err := stageInstance.Produce("", 1, batchMaker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batchMaker.GetStageOutput()) != 1 {
+		t.Fatalf("Expected 1 record, but got %d", len(batchMaker.GetStageOutput()))
+	}
+	if broker.GetAcknowledgedCount() != 0 {
+		t.Error("Expected the message to be acknowledged only once the batch is committed")
+	}
+
+	if err = stageInstance.Commit(offset); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.GetAcknowledgedCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.GetAcknowledgedCount() != 1 {
+		t.Errorf("Expected 1 acknowledged message, but got %d", broker.GetAcknowledgedCount())
+	}
+}
@@ -16,11 +16,15 @@
 package origins
 
 import (
+	_ "github.com/streamsets/datacollector-edge/stages/origins/coap"
 	_ "github.com/streamsets/datacollector-edge/stages/origins/dev_random"
 	_ "github.com/streamsets/datacollector-edge/stages/origins/filetail"
+	_ "github.com/streamsets/datacollector-edge/stages/origins/httpclient"
 	_ "github.com/streamsets/datacollector-edge/stages/origins/httpserver"
+	_ "github.com/streamsets/datacollector-edge/stages/origins/kafka"
 	_ "github.com/streamsets/datacollector-edge/stages/origins/mqtt"
 	_ "github.com/streamsets/datacollector-edge/stages/origins/sensor_reader"
 	_ "github.com/streamsets/datacollector-edge/stages/origins/spooler"
+	_ "github.com/streamsets/datacollector-edge/stages/origins/websocket"
 	_ "github.com/streamsets/datacollector-edge/stages/origins/windows"
 )
@@ -20,6 +20,9 @@ import (
 	"errors"
 	"github.com/streamsets/datacollector-edge/api"
 	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/recordio"
+	"github.com/streamsets/datacollector-edge/stages/lib/dataparser"
+	"github.com/streamsets/datacollector-edge/stages/lib/wholefile"
 	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
 	"io"
 	"log"
@@ -45,30 +48,55 @@ const (
 	PROCESS_SUB_DIRECTORIES = "conf.processSubdirectories"
 	FILE_PATTERN            = "conf.filePattern"
 	PATH_MATHER_MODE        = "conf.pathMatcherMode"
+	DATA_FORMAT             = "conf.dataFormat"
 
 	FILE      = "file"
 	FILE_NAME = "filename"
 	OFFSET    = "offset"
 	GLOB      = "GLOB"
 	REGEX     = "REGEX"
+
+	TEXT      = "TEXT"
+	DELIMITED = "DELIMITED"
+
+	NEW_FILE_EVENT      = "new-file"
+	FINISHED_FILE_EVENT = "finished-file"
+	FILE_EVENT_VERSION  = 1
+	FILE_PATH_FIELD     = "filepath"
+	RECORD_COUNT_FIELD  = "record-count"
+	ERROR_COUNT_FIELD   = "error-count"
 )
 
 type SpoolDirSource struct {
 	*common.BaseStage
-	Conf      SpoolDirConfigBean `ConfigDefBean:"conf"`
-	spooler   *DirectorySpooler
-	bufReader *bufio.Reader
-	file      *os.File
+	Conf         SpoolDirConfigBean `ConfigDefBean:"conf"`
+	spooler      *DirectorySpooler
+	bufReader    *bufio.Reader
+	recordReader offsetRecordReader
+	file         *os.File
+	// records and errors of the current file, reported by the finished file event
+	fileRecordCount int64
+	fileErrorCount  int64
+}
+
+// offsetRecordReader is a record reader which can resume reading from the byte offset
+// of a previously read record, used for data formats other than text.
+type offsetRecordReader interface {
+	recordio.RecordReader
+	GetOffset() int64
+	SkipToOffset(offset int64) error
 }
 
 type SpoolDirConfigBean struct {
-	SpoolDir              string  `ConfigDef:"type=STRING,required=true"`
-	UseLastModified       string  `ConfigDef:"type=STRING,required=true"`
-	PoolingTimeoutSecs    float64 `ConfigDef:"type=NUMBER,required=true"`
-	InitialFileToProcess  string  `ConfigDef:"type=STRING,required=true"`
-	ProcessSubdirectories bool    `ConfigDef:"type=BOOLEAN,required=true"`
-	FilePattern           string  `ConfigDef:"type=STRING,required=true"`
-	PathMatcherMode       string  `ConfigDef:"type=STRING,required=true"`
+	SpoolDir              string                            `ConfigDef:"type=STRING,required=true"`
+	UseLastModified       string                            `ConfigDef:"type=STRING,required=true"`
+	PoolingTimeoutSecs    float64                           `ConfigDef:"type=NUMBER,required=true"`
+	InitialFileToProcess  string                            `ConfigDef:"type=STRING,required=true"`
+	ProcessSubdirectories bool                              `ConfigDef:"type=BOOLEAN,required=true"`
+	FilePattern           string                            `ConfigDef:"type=STRING,required=true"`
+	PathMatcherMode       string                            `ConfigDef:"type=STRING,required=true"`
+	DataFormat            string                            `ConfigDef:"type=STRING,required=true"`
+	DataFormatConfig      dataparser.DataParserFormatConfig `ConfigDefBean:"dataFormatConfig"`
 }
 
 func init() {
@@ -94,6 +122,16 @@ func (s *SpoolDirSource) Init(stageContext api.StageContext) error {
 		return errors.New("Unsupported Path Matcher mode :" + s.spooler.pathMatcherMode)
 	}
 
+	switch s.Conf.DataFormat {
+	case "", TEXT, wholefile.WHOLE_FILE:
+	case DELIMITED:
+		if err := s.Conf.DataFormatConfig.Init(s.Conf.DataFormat); err != nil {
+			return err
+		}
+	default:
+		return errors.New("Unsupported Data Format for Directory Spooler :" + s.Conf.DataFormat)
+	}
+
 	s.spooler.Init()
 	var err error = nil
 	if s.Conf.InitialFileToProcess != "" {
@@ -119,6 +157,9 @@ func (s *SpoolDirSource) initializeBuffReaderIfNeeded() error {
 			return err
 		}
 		s.file = f
+		if s.Conf.DataFormat == DELIMITED {
+			return s.initializeRecordReader(fInfo.getOffsetToRead())
+		}
 		if _, err := s.file.Seek(fInfo.getOffsetToRead(), 0); err != nil {
 			return err
 		}
@@ -127,6 +168,15 @@ func (s *SpoolDirSource) initializeBuffReaderIfNeeded() error {
 	return nil
 }
 
+func (s *SpoolDirSource) initializeRecordReader(offset int64) error {
+	recordReader, err := s.Conf.DataFormatConfig.RecordReaderFactory.CreateReader(s.GetStageContext(), s.file)
+	if err != nil {
+		return err
+	}
+	s.recordReader = recordReader.(offsetRecordReader)
+	return s.recordReader.SkipToOffset(offset)
+}
+
 func (s *SpoolDirSource) initCurrentFileIfNeeded(lastSourceOffset string) (bool, error) {
 	currentFilePath, currentStartOffset, modTime, err := parseLastOffset(lastSourceOffset)
 
@@ -169,6 +219,11 @@ func (s *SpoolDirSource) initCurrentFileIfNeeded(lastSourceOffset string) (bool,
 			log.Println("[DEBUG] No more files to process")
 			return false, nil
 		}
+		s.fileRecordCount = 0
+		s.fileErrorCount = 0
+		s.toFileEvent(NEW_FILE_EVENT, map[string]interface{}{
+			FILE_PATH_FIELD: nextFileInfoToProcess.getFullPath(),
+		})
 	}
 	return true, nil
 }
@@ -194,13 +249,54 @@ func (s *SpoolDirSource) createRecordAndAddToBatch(line_bytes []byte, batchMaker
 		)
 
 		batchMaker.AddRecord(record)
+		s.fileRecordCount++
 	}
 }
 
+func (s *SpoolDirSource) readAndCreateRecordsWithReader(
+	maxBatchSize int,
+	batchMaker api.BatchMaker,
+) (int64, error) {
+	fInfo := s.spooler.getCurrentFileInfo()
+	startOffsetForBatch := fInfo.getOffsetToRead()
+
+	for recordCnt := 0; recordCnt < maxBatchSize; recordCnt++ {
+		recordOffset := strconv.FormatInt(s.recordReader.GetOffset(), 10)
+		record, err := s.recordReader.ReadRecord()
+		if err != nil {
+			log.Printf("[ERROR] Error happened When reading file '%s' : %s", fInfo.getFullPath(), err.Error())
+			return startOffsetForBatch, err
+		}
+
+		if record == nil {
+			log.Printf("[DEBUG] Reached End of File '%s'", fInfo.getFullPath())
+			fInfo.setOffsetToRead(EOF_OFFSET)
+			s.resetFileAndBuffReader()
+			s.finishFile(fInfo)
+			break
+		}
+
+		record.GetHeader().(*common.HeaderImpl).SetSourceId(fInfo.getFullPath() + "::" + recordOffset)
+		record.GetHeader().SetAttribute(FILE, fInfo.getFullPath())
+		record.GetHeader().SetAttribute(FILE_NAME, fInfo.getName())
+		record.GetHeader().SetAttribute(OFFSET, recordOffset)
+		batchMaker.AddRecord(record)
+		s.fileRecordCount++
+
+		fInfo.setOffsetToRead(s.recordReader.GetOffset())
+	}
+
+	return fInfo.getOffsetToRead(), nil
+}
+
 func (s *SpoolDirSource) readAndCreateRecords(
 	maxBatchSize int,
 	batchMaker api.BatchMaker,
 ) (int64, error) {
+	if s.recordReader != nil {
+		return s.readAndCreateRecordsWithReader(maxBatchSize, batchMaker)
+	}
+
 	isEof := false
 
 	startOffsetForBatch := s.spooler.getCurrentFileInfo().getOffsetToRead()
@@ -223,6 +319,7 @@ func (s *SpoolDirSource) readAndCreateRecords(
 			log.Printf("[DEBUG] Reached End of File '%s'", s.spooler.getCurrentFileInfo().getFullPath())
 			s.spooler.getCurrentFileInfo().setOffsetToRead(EOF_OFFSET)
 			s.resetFileAndBuffReader()
+			s.finishFile(s.spooler.getCurrentFileInfo())
 			break
 		}
 		s.spooler.getCurrentFileInfo().incOffsetToRead(int64(bytesRead))
@@ -231,6 +328,49 @@ func (s *SpoolDirSource) readAndCreateRecords(
 	return s.spooler.getCurrentFileInfo().getOffsetToRead(), nil
 }
 
+// createWholeFileRecordAndAddToBatch adds a single record referencing the current file, the file content is
+// read by the destination
+func (s *SpoolDirSource) createWholeFileRecordAndAddToBatch(batchMaker api.BatchMaker) {
+	fInfo := s.spooler.getCurrentFileInfo()
+	record, err := wholefile.CreateRecord(s.GetStageContext(), fInfo.getFullPath())
+	if err != nil {
+		log.Printf("[ERROR] Error creating whole file record for file '%s' : %s", fInfo.getFullPath(), err.Error())
+		s.GetStageContext().ReportError(err)
+		s.fileErrorCount++
+	} else {
+		record.GetHeader().SetAttribute(FILE, fInfo.getFullPath())
+		record.GetHeader().SetAttribute(FILE_NAME, fInfo.getName())
+		record.GetHeader().SetAttribute(OFFSET, "0")
+		batchMaker.AddRecord(record)
+		s.fileRecordCount++
+	}
+	fInfo.setOffsetToRead(EOF_OFFSET)
+	s.finishFile(fInfo)
+}
+
+// finishFile produces the finished file event of a file read up to its end
+func (s *SpoolDirSource) finishFile(fInfo *AtomicFileInformation) {
+	s.toFileEvent(FINISHED_FILE_EVENT, map[string]interface{}{
+		FILE_PATH_FIELD:    fInfo.getFullPath(),
+		RECORD_COUNT_FIELD: s.fileRecordCount,
+		ERROR_COUNT_FIELD:  s.fileErrorCount,
+	})
+}
+
+func (s *SpoolDirSource) toFileEvent(eventType string, value map[string]interface{}) {
+	eventRecord, err := s.GetStageContext().CreateEventRecord(
+		value[FILE_PATH_FIELD].(string),
+		value,
+		eventType,
+		FILE_EVENT_VERSION,
+	)
+	if err != nil {
+		log.Printf("[ERROR] Error creating %s event : %s", eventType, err.Error())
+		return
+	}
+	s.GetStageContext().ToEvent(eventRecord)
+}
+
 func parseLastOffset(offsetString string) (string, int64, time.Time, error) {
 	if offsetString == "" {
 		return "", INVALID_OFFSET, time.Now(), nil
@@ -265,6 +405,11 @@ func (s *SpoolDirSource) Produce(
 			return lastSourceOffset, nil
 		}
 
+		if s.Conf.DataFormat == wholefile.WHOLE_FILE {
+			s.createWholeFileRecordAndAddToBatch(batchMaker)
+			return s.spooler.getCurrentFileInfo().createOffset(), nil
+		}
+
 		err = s.initializeBuffReaderIfNeeded()
 
 		if err != nil {
@@ -296,6 +441,7 @@ func (s *SpoolDirSource) resetFileAndBuffReader() {
 		s.file = nil
 	}
 	s.bufReader = nil
+	s.recordReader = nil
 }
 
 func (s *SpoolDirSource) Destroy() error {
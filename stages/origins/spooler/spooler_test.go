@@ -21,6 +21,7 @@ import (
 	"github.com/streamsets/datacollector-edge/container/common"
 	"github.com/streamsets/datacollector-edge/container/creation"
 	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"github.com/streamsets/datacollector-edge/stages/lib/wholefile"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -521,3 +522,167 @@ func TestReadingFileAcrossBatches(t *testing.T) {
 		t.Fatal("Read more number of records than expected")
 	}
 }
+
+func TestDelimitedDataFormat(t *testing.T) {
+	testDir := createTestDirectory(t)
+
+	defer deleteTestDirectory(t, testDir)
+
+	createFileAndWriteContents(
+		t,
+		filepath.Join(testDir, "a.csv"),
+		"name,city\nalice,\"San Francisco, CA\"\nbob,Austin\ncarol,\"New\nYork\"\n",
+	)
+
+	stageContext := createStageContext(testDir, false, GLOB, "*.csv", false, "", 1)
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: DATA_FORMAT, Value: DELIMITED},
+		common.Config{Name: "conf.dataFormatConfig.csvFileFormat", Value: "CSV"},
+		common.Config{Name: "conf.dataFormatConfig.csvHeader", Value: "WITH_HEADER"},
+		common.Config{Name: "conf.dataFormatConfig.csvRecordType", Value: "LIST_MAP"},
+	)
+
+	offset, records := createSpoolerAndRun(t, stageContext, "", 2)
+
+	if len(records) != 2 {
+		t.Fatalf("Wrong number of records, Actual : %d, Expected : %d ", len(records), 2)
+	}
+
+	checkDelimitedRecord(t, records[0], "alice", "San Francisco, CA", "10")
+	checkDelimitedRecord(t, records[1], "bob", "Austin", "36")
+
+	offset, records = createSpoolerAndRun(t, stageContext, offset, 2)
+
+	if len(records) != 1 {
+		t.Fatalf("Wrong number of records, Actual : %d, Expected : %d ", len(records), 1)
+	}
+
+	checkDelimitedRecord(t, records[0], "carol", "New\nYork", "47")
+
+	if filePath, fileOffset, _, _ := parseLastOffset(offset); filePath != filepath.Join(testDir, "a.csv") ||
+		fileOffset != EOF_OFFSET {
+		t.Errorf("Expected end of file offset for '%s', but received: %s", filePath, offset)
+	}
+}
+
+func checkDelimitedRecord(t *testing.T, record api.Record, name string, city string, offset string) {
+	nameField, _ := record.Get("/name")
+	cityField, _ := record.Get("/city")
+	if nameField == nil || nameField.Value.(string) != name {
+		t.Errorf("Expected name '%s', but received: %v", name, nameField)
+	}
+	if cityField == nil || cityField.Value.(string) != city {
+		t.Errorf("Expected city '%s', but received: %v", city, cityField)
+	}
+	if actualOffset := record.GetHeader().GetAttributes()[OFFSET]; actualOffset != offset {
+		t.Errorf("Expected offset '%s', but received: %s", offset, actualOffset)
+	}
+}
+
+func TestWholeFileDataFormat(t *testing.T) {
+	testDir := createTestDirectory(t)
+
+	defer deleteTestDirectory(t, testDir)
+
+	createFileAndWriteContents(t, filepath.Join(testDir, "a.bin"), "first file content")
+	createFileAndWriteContents(t, filepath.Join(testDir, "b.bin"), "second")
+
+	stageContext := createStageContext(testDir, false, GLOB, "*.bin", false, "", 1)
+	stageContext.StageConfig.Configuration = append(
+		stageContext.StageConfig.Configuration,
+		common.Config{Name: DATA_FORMAT, Value: wholefile.WHOLE_FILE},
+	)
+
+	offset, records := createSpoolerAndRun(t, stageContext, "", 10)
+
+	if len(records) != 1 {
+		t.Fatalf("Wrong number of records, Actual : %d, Expected : %d ", len(records), 1)
+	}
+	checkWholeFileRecord(t, records[0], filepath.Join(testDir, "a.bin"), "first file content")
+
+	if filePath, fileOffset, _, _ := parseLastOffset(offset); filePath != filepath.Join(testDir, "a.bin") ||
+		fileOffset != EOF_OFFSET {
+		t.Errorf("Expected end of file offset for '%s', but received: %s", filePath, offset)
+	}
+
+	_, records = createSpoolerAndRun(t, stageContext, offset, 10)
+
+	if len(records) != 1 {
+		t.Fatalf("Wrong number of records, Actual : %d, Expected : %d ", len(records), 1)
+	}
+	checkWholeFileRecord(t, records[0], filepath.Join(testDir, "b.bin"), "second")
+}
+
+func checkWholeFileRecord(t *testing.T, record api.Record, filePath string, content string) {
+	fileRef, err := wholefile.GetFileRef(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := fileRef.GetReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != content {
+		t.Errorf("Expected content '%s', but received: '%s' %v", content, string(data), err)
+	}
+
+	fileField, _ := record.Get(wholefile.FILE_INFO_FIELD_PATH + "/" + wholefile.FILE)
+	if fileField == nil || fileField.Value.(string) != filePath {
+		t.Errorf("Expected file '%s', but received: %v", filePath, fileField)
+	}
+	sizeField, _ := record.Get(wholefile.FILE_INFO_FIELD_PATH + "/" + wholefile.SIZE)
+	if sizeField == nil || sizeField.Value.(int64) != int64(len(content)) {
+		t.Errorf("Expected size %d, but received: %v", len(content), sizeField)
+	}
+	if actualFile := record.GetHeader().GetAttributes()[FILE]; actualFile != filePath {
+		t.Errorf("Expected file attribute '%s', but received: %s", filePath, actualFile)
+	}
+}
+
+func TestFileEvents(t *testing.T) {
+	testDir := createTestDirectory(t)
+
+	defer deleteTestDirectory(t, testDir)
+
+	createFileAndWriteContents(t, filepath.Join(testDir, "a.txt"), "line1\nline2\nline3")
+
+	stageContext := createStageContext(testDir, false, GLOB, "*.txt", false, "", 1)
+	stageContext.StageConfig.InstanceName = "spooler"
+	stageContext.StageConfig.EventLanes = []string{"spoolerEvents"}
+	stageContext.EventSink = common.NewEventSink()
+
+	offset, records := createSpoolerAndRun(t, stageContext, "", 2)
+	if len(records) != 2 {
+		t.Fatalf("Wrong number of records, Actual : %d, Expected : %d ", len(records), 2)
+	}
+	events := stageContext.EventSink.DrainStageEvents("spooler")
+	if len(events) != 1 {
+		t.Fatalf("Wrong number of events, Actual : %d, Expected : %d ", len(events), 1)
+	}
+	checkFileEvent(t, events[0], NEW_FILE_EVENT, filepath.Join(testDir, "a.txt"))
+
+	_, records = createSpoolerAndRun(t, stageContext, offset, 2)
+	if len(records) != 1 {
+		t.Fatalf("Wrong number of records, Actual : %d, Expected : %d ", len(records), 1)
+	}
+	events = stageContext.EventSink.DrainStageEvents("spooler")
+	if len(events) != 1 {
+		t.Fatalf("Wrong number of events, Actual : %d, Expected : %d ", len(events), 1)
+	}
+	checkFileEvent(t, events[0], FINISHED_FILE_EVENT, filepath.Join(testDir, "a.txt"))
+	// the record count restarts from the offset of the file when the origin is created again
+	if recordCount, _ := events[0].Get("/" + RECORD_COUNT_FIELD); recordCount == nil || recordCount.Value != int64(1) {
+		t.Errorf("Expected record count 1, but received: %v", recordCount)
+	}
+}
+
+func checkFileEvent(t *testing.T, event api.Record, eventType string, filePath string) {
+	if actualType := event.GetHeader().GetAttributes()[common.EVENT_TYPE_ATTRIBUTE]; actualType != eventType {
+		t.Errorf("Expected event type '%s', but received: %s", eventType, actualType)
+	}
+	if filePathField, _ := event.Get("/" + FILE_PATH_FIELD); filePathField == nil || filePathField.Value != filePath {
+		t.Errorf("Expected file path '%s', but received: %v", filePath, filePathField)
+	}
+}
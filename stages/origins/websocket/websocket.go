@@ -0,0 +1,357 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/satori/go.uuid"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/stages/lib/dataparser"
+	"github.com/streamsets/datacollector-edge/stages/lib/tlsconfig"
+	"github.com/streamsets/datacollector-edge/stages/stagelibrary"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	LIBRARY    = "streamsets-datacollector-basic-lib"
+	STAGE_NAME = "com_streamsets_pipeline_stage_origin_websocket_WebSocketDSource"
+
+	CLIENT = "CLIENT"
+	SERVER = "SERVER"
+
+	CONNECTION_ID_ATTRIBUTE  = "connectionId"
+	REMOTE_ADDRESS_ATTRIBUTE = "remoteAddress"
+
+	DEFAULT_MAX_WAIT_TIME         = 2000
+	DEFAULT_RECONNECT_BACKOFF     = 1000
+	DEFAULT_MAX_RECONNECT_BACKOFF = 60000
+	SHUTDOWN_TIMEOUT              = 5 * time.Second
+)
+
+type WebSocketOrigin struct {
+	*common.BaseStage
+	Conf           WebSocketSourceConfig `ConfigDefBean:"conf"`
+	incomingFrames chan *frame
+	pendingRecords []api.Record
+	httpServer     *http.Server
+	connections    map[*websocket.Conn]bool
+	destroyed      chan struct{}
+	stopped        bool
+	mutex          sync.Mutex
+}
+
+// WebSocketSourceConfig holds the settings of the origin. In CLIENT mode the origin connects to ResourceUrl and
+// reconnects when the connection is lost, doubling the delay between attempts from ReconnectBackoffMillis up to
+// MaxReconnectBackoffMillis. In SERVER mode it accepts connections on Port.
+type WebSocketSourceConfig struct {
+	Mode                      string                            `ConfigDef:"type=STRING,required=true"`
+	ResourceUrl               string                            `ConfigDef:"type=STRING,required=false"`
+	Headers                   map[string]string                 `ConfigDef:"type=MAP,required=false"`
+	ReconnectBackoffMillis    float64                           `ConfigDef:"type=NUMBER,required=false"`
+	MaxReconnectBackoffMillis float64                           `ConfigDef:"type=NUMBER,required=false"`
+	Port                      float64                           `ConfigDef:"type=NUMBER,required=false"`
+	TlsConfig                 tlsconfig.TlsConfigBean           `ConfigDefBean:"tlsConfig"`
+	Basic                     BasicConfigBean                   `ConfigDefBean:"basic"`
+	DataFormat                string                            `ConfigDef:"type=STRING,required=true"`
+	DataFormatConfig          dataparser.DataParserFormatConfig `ConfigDefBean:"dataFormatConfig"`
+}
+
+type BasicConfigBean struct {
+	MaxBatchSize float64 `ConfigDef:"type=NUMBER,required=false"`
+	MaxWaitTime  float64 `ConfigDef:"type=NUMBER,required=false"`
+}
+
+// frame holds the records parsed from a text or binary frame, or the error parsing it
+type frame struct {
+	records []api.Record
+	err     error
+}
+
+func init() {
+	stagelibrary.SetCreator(LIBRARY, STAGE_NAME, func() api.Stage {
+		return &WebSocketOrigin{BaseStage: &common.BaseStage{}}
+	})
+}
+
+func (w *WebSocketOrigin) Init(stageContext api.StageContext) error {
+	if err := w.BaseStage.Init(stageContext); err != nil {
+		return err
+	}
+	log.Println("[DEBUG] WebSocketOrigin Init method")
+	if err := w.Conf.DataFormatConfig.Init(w.Conf.DataFormat); err != nil {
+		return err
+	}
+	if w.Conf.Basic.MaxWaitTime <= 0 {
+		w.Conf.Basic.MaxWaitTime = DEFAULT_MAX_WAIT_TIME
+	}
+	if w.Conf.ReconnectBackoffMillis <= 0 {
+		w.Conf.ReconnectBackoffMillis = DEFAULT_RECONNECT_BACKOFF
+	}
+	if w.Conf.MaxReconnectBackoffMillis < w.Conf.ReconnectBackoffMillis {
+		w.Conf.MaxReconnectBackoffMillis = DEFAULT_MAX_RECONNECT_BACKOFF
+	}
+	w.incomingFrames = make(chan *frame)
+	w.connections = make(map[*websocket.Conn]bool)
+	w.destroyed = make(chan struct{})
+
+	switch w.Conf.Mode {
+	case CLIENT:
+		if len(w.Conf.ResourceUrl) == 0 {
+			return errors.New("Resource URL must not be empty in client mode")
+		}
+		dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: 45 * time.Second}
+		if w.Conf.TlsConfig.TlsEnabled {
+			tlsConfig, err := w.Conf.TlsConfig.NewClientConfig()
+			if err != nil {
+				return err
+			}
+			dialer.TLSClientConfig = tlsConfig
+		}
+		go w.runClient(dialer)
+		return nil
+	case SERVER:
+		var err error
+		w.httpServer, err = w.startServer()
+		return err
+	}
+	return errors.New(fmt.Sprintf("Unsupported WebSocket mode: %s", w.Conf.Mode))
+}
+
+func (w *WebSocketOrigin) Destroy() error {
+	w.mutex.Lock()
+	if w.stopped || w.destroyed == nil {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.stopped = true
+	close(w.destroyed)
+	for conn := range w.connections {
+		conn.Close()
+	}
+	w.mutex.Unlock()
+
+	if w.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := w.httpServer.Shutdown(ctx); err != nil {
+			return err
+		}
+		log.Println("[DEBUG] WebSocket Server - server shutdown successfully")
+	}
+	return nil
+}
+
+// Produce adds the records of the incoming frames to the batch until it holds maxBatchSize records or the max
+// wait time elapsed. The records of a frame exceeding the batch size are added to the next batch.
+func (w *WebSocketOrigin) Produce(
+	lastSourceOffset string,
+	maxBatchSize int,
+	batchMaker api.BatchMaker,
+) (string, error) {
+	if w.Conf.Basic.MaxBatchSize > 0 && int(w.Conf.Basic.MaxBatchSize) < maxBatchSize {
+		maxBatchSize = int(w.Conf.Basic.MaxBatchSize)
+	}
+
+	recordCount := w.addPendingRecords(maxBatchSize, batchMaker)
+	timeout := time.After(time.Duration(w.Conf.Basic.MaxWaitTime) * time.Millisecond)
+	for recordCount < maxBatchSize {
+		select {
+		case incomingFrame := <-w.incomingFrames:
+			if incomingFrame.err != nil {
+				log.Printf("[ERROR] WebSocket - Failed to parse frame: %s", incomingFrame.err.Error())
+				w.GetStageContext().ReportError(incomingFrame.err)
+				continue
+			}
+			w.pendingRecords = incomingFrame.records
+			recordCount += w.addPendingRecords(maxBatchSize-recordCount, batchMaker)
+		case <-timeout:
+			return lastSourceOffset, nil
+		case <-w.destroyed:
+			return lastSourceOffset, nil
+		}
+	}
+	return lastSourceOffset, nil
+}
+
+// addPendingRecords adds up to maxRecords pending records to the batch and returns the number of records added
+func (w *WebSocketOrigin) addPendingRecords(maxRecords int, batchMaker api.BatchMaker) int {
+	count := len(w.pendingRecords)
+	if count > maxRecords {
+		count = maxRecords
+	}
+	for _, record := range w.pendingRecords[:count] {
+		batchMaker.AddRecord(record)
+	}
+	w.pendingRecords = w.pendingRecords[count:]
+	return count
+}
+
+// runClient connects to the resource URL and reads its frames, reconnecting with an exponential backoff until
+// the origin is destroyed
+func (w *WebSocketOrigin) runClient(dialer *websocket.Dialer) {
+	requestHeader := http.Header{}
+	for key, value := range w.Conf.Headers {
+		requestHeader.Set(key, value)
+	}
+
+	backoff := time.Duration(w.Conf.ReconnectBackoffMillis) * time.Millisecond
+	maxBackoff := time.Duration(w.Conf.MaxReconnectBackoffMillis) * time.Millisecond
+	for {
+		conn, _, err := dialer.Dial(w.Conf.ResourceUrl, requestHeader)
+		if err == nil {
+			log.Printf("[DEBUG] WebSocket Client - Connected to %s", w.Conf.ResourceUrl)
+			backoff = time.Duration(w.Conf.ReconnectBackoffMillis) * time.Millisecond
+			w.readFrames(conn, map[string]string{CONNECTION_ID_ATTRIBUTE: uuid.NewV4().String()})
+		} else {
+			log.Printf("[WARN] WebSocket Client - Failed to connect to %s: %s", w.Conf.ResourceUrl, err.Error())
+		}
+
+		log.Printf("[DEBUG] WebSocket Client - Reconnecting in %s", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-w.destroyed:
+			return
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (w *WebSocketOrigin) startServer() (*http.Server, error) {
+	srv := &http.Server{
+		Addr:    ":" + strconv.FormatFloat(w.Conf.Port, 'f', -1, 64),
+		Handler: w,
+	}
+
+	// listen right away, so that a port already in use fails the pipeline start
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if w.Conf.TlsConfig.TlsEnabled {
+		tlsConfig, err := w.Conf.TlsConfig.NewServerConfig()
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		srv.TLSConfig = tlsConfig
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go func() {
+		log.Printf("[DEBUG] WebSocket Server - Running on %s", listener.Addr())
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERROR] WebSocket Server: Serve() error: %s", err)
+			w.GetStageContext().ReportError(err)
+		}
+	}()
+	return srv, nil
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and reads its frames until it is closed
+func (w *WebSocketOrigin) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		log.Printf("[WARN] WebSocket Server - Failed to upgrade connection from %s: %s", r.RemoteAddr, err.Error())
+		return
+	}
+	log.Printf("[DEBUG] WebSocket Server - Connection from %s", r.RemoteAddr)
+	w.readFrames(conn, map[string]string{
+		CONNECTION_ID_ATTRIBUTE:  uuid.NewV4().String(),
+		REMOTE_ADDRESS_ATTRIBUTE: r.RemoteAddr,
+	})
+}
+
+// readFrames sends the records parsed from the frames of the connection to Produce until the connection is
+// closed or the origin is destroyed. The attributes are set in the header of every record.
+func (w *WebSocketOrigin) readFrames(conn *websocket.Conn, attributes map[string]string) {
+	w.mutex.Lock()
+	if w.stopped {
+		w.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	w.connections[conn] = true
+	w.mutex.Unlock()
+
+	defer func() {
+		w.mutex.Lock()
+		delete(w.connections, conn)
+		w.mutex.Unlock()
+		conn.Close()
+	}()
+
+	connectionId := attributes[CONNECTION_ID_ATTRIBUTE]
+	for frameCount := 0; ; frameCount++ {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("[DEBUG] WebSocket - Connection %s closed: %s", connectionId, err.Error())
+			return
+		}
+
+		records, err := w.parseRecords(connectionId+"::"+strconv.Itoa(frameCount), payload, attributes)
+		select {
+		case w.incomingFrames <- &frame{records: records, err: err}:
+		case <-w.destroyed:
+			return
+		}
+	}
+}
+
+func (w *WebSocketOrigin) parseRecords(
+	sourceIdPrefix string,
+	payload []byte,
+	attributes map[string]string,
+) ([]api.Record, error) {
+	recordReader, err := w.Conf.DataFormatConfig.RecordReaderFactory.CreateReader(
+		w.GetStageContext(),
+		bytes.NewReader(payload),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer recordReader.Close()
+
+	records := make([]api.Record, 0)
+	for {
+		record, err := recordReader.ReadRecord()
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			break
+		}
+		record.GetHeader().(*common.HeaderImpl).SetSourceId(sourceIdPrefix + "::" + strconv.Itoa(len(records)))
+		for name, value := range attributes {
+			record.GetHeader().SetAttribute(name, value)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2017 StreamSets Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package websocket
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/streamsets/datacollector-edge/api"
+	"github.com/streamsets/datacollector-edge/container/common"
+	"github.com/streamsets/datacollector-edge/container/creation"
+	"github.com/streamsets/datacollector-edge/container/execution/runner"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func getStageContext(configs ...common.Config) *common.StageContextImpl {
+	stageConfig := common.StageConfiguration{}
+	stageConfig.Library = LIBRARY
+	stageConfig.StageName = STAGE_NAME
+	stageConfig.Configuration = append([]common.Config{
+		{Name: "conf.dataFormat", Value: "JSON"},
+		{Name: "conf.basic.maxWaitTime", Value: float64(500)},
+	}, configs...)
+	return &common.StageContextImpl{
+		StageConfig: stageConfig,
+		ErrorSink:   common.NewErrorSink(),
+	}
+}
+
+func createOrigin(t *testing.T, configs ...common.Config) *WebSocketOrigin {
+	stageContext := getStageContext(configs...)
+	stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin := stageBean.Stage.(*WebSocketOrigin)
+	if err = origin.Init(stageContext); err != nil {
+		t.Fatal(err)
+	}
+	return origin
+}
+
+func produce(t *testing.T, origin *WebSocketOrigin, maxBatchSize int) []api.Record {
+	batchMaker := runner.NewBatchMakerImpl(runner.StagePipe{})
+	if _, err := origin.Produce("", maxBatchSize, batchMaker); err != nil {
+		t.Fatal(err)
+	}
+	return batchMaker.GetStageOutput()
+}
+
+func checkValues(t *testing.T, records []api.Record, expected ...float64) {
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, got %d", len(expected), len(records))
+	}
+	for i, record := range records {
+		field, err := record.Get("/id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if field.Value != expected[i] {
+			t.Errorf("Expected id %v for record %d, got %v", expected[i], i, field.Value)
+		}
+	}
+}
+
+func getFreePort(t *testing.T) float64 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return float64(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestWebSocketOrigin_InvalidConfig(t *testing.T) {
+	invalidConfigs := [][]common.Config{
+		{{Name: "conf.mode", Value: "INVALID"}},
+		{{Name: "conf.mode", Value: CLIENT}},
+	}
+	for _, configs := range invalidConfigs {
+		stageContext := getStageContext(configs...)
+		stageBean, err := creation.NewStageBean(stageContext.StageConfig, stageContext.Parameters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stageBean.Stage.Init(stageContext); err == nil {
+			t.Errorf("Expected an error for configs %v", configs)
+		}
+	}
+}
+
+func TestWebSocketOrigin_ServerMode(t *testing.T) {
+	port := getFreePort(t)
+	origin := createOrigin(
+		t,
+		common.Config{Name: "conf.mode", Value: SERVER},
+		common.Config{Name: "conf.port", Value: port},
+	)
+	defer origin.Destroy()
+
+	url := "ws://127.0.0.1:" + strconv.FormatFloat(port, 'f', -1, 64) + "/records"
+	var conn *websocket.Conn
+	var err error
+	for i := 0; i < 10; i++ {
+		if conn, _, err = websocket.DefaultDialer.Dial(url, nil); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = conn.WriteMessage(websocket.TextMessage, []byte(`{"id": 1} {"id": 2} {"id": 3}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.WriteMessage(websocket.BinaryMessage, []byte(`{"id": 4}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the records of a frame exceeding the batch size are added to the next batch
+	records := produce(t, origin, 2)
+	checkValues(t, records, 1, 2)
+	connectionId := records[0].GetHeader().GetAttributes()[CONNECTION_ID_ATTRIBUTE]
+	if connectionId == "" {
+		t.Error("Expected the connection id header attribute")
+	}
+	if !strings.HasPrefix(records[0].GetHeader().GetAttributes()[REMOTE_ADDRESS_ATTRIBUTE], "127.0.0.1:") {
+		t.Errorf("Unexpected remote address: %v", records[0].GetHeader().GetAttributes())
+	}
+
+	records = produce(t, origin, 1000)
+	checkValues(t, records, 3, 4)
+	if records[1].GetHeader().GetAttributes()[CONNECTION_ID_ATTRIBUTE] != connectionId {
+		t.Error("Expected the same connection id for the frames of a connection")
+	}
+
+	// empty batch after the max wait time
+	records = produce(t, origin, 1000)
+	checkValues(t, records)
+}
+
+func TestWebSocketOrigin_ClientModeReconnects(t *testing.T) {
+	connections := make(chan int, 2)
+	connectionCount := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		count := int(atomic.AddInt32(&connectionCount, 1))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"id": `+strconv.Itoa(count)+`}`))
+		// the first connection is closed by the server, the origin reconnects
+		if count == 1 {
+			conn.Close()
+		}
+		connections <- count
+	}))
+	defer server.Close()
+
+	origin := createOrigin(
+		t,
+		common.Config{Name: "conf.mode", Value: CLIENT},
+		common.Config{Name: "conf.resourceUrl", Value: "ws" + strings.TrimPrefix(server.URL, "http")},
+		common.Config{Name: "conf.headers", Value: []interface{}{
+			map[string]interface{}{"key": "X-Token", "value": "secret"},
+		}},
+		common.Config{Name: "conf.reconnectBackoffMillis", Value: float64(10)},
+	)
+	defer origin.Destroy()
+
+	first := produce(t, origin, 1)
+	checkValues(t, first, 1)
+	second := produce(t, origin, 1)
+	checkValues(t, second, 2)
+	if <-connections != 1 || <-connections != 2 {
+		t.Error("Expected the origin to reconnect")
+	}
+
+	firstId := first[0].GetHeader().GetAttributes()[CONNECTION_ID_ATTRIBUTE]
+	secondId := second[0].GetHeader().GetAttributes()[CONNECTION_ID_ATTRIBUTE]
+	if firstId == "" || firstId == secondId {
+		t.Errorf("Expected a connection id per connection, got '%s' and '%s'", firstId, secondId)
+	}
+}